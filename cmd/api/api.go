@@ -1,11 +1,21 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
+	"go-stripe/internal/audit"
+	"go-stripe/internal/cards"
 	"go-stripe/internal/driver"
+	"go-stripe/internal/logging"
+	"go-stripe/internal/metrics"
+	"go-stripe/internal/migrate"
 	"go-stripe/internal/models"
-	"log"
+	"go-stripe/internal/pricing"
+	"go-stripe/internal/ratelimit"
+	"go-stripe/internal/tracing"
+	"go-stripe/migrations"
+	"log/slog"
 	"net/http"
 	"os"
 	"time"
@@ -14,23 +24,64 @@ import (
 const version = "1.0.0"
 
 type config struct {
-	port int
-	env  string
-	db   struct {
-		dsn string
+	port     int
+	env      string
+	logLevel slog.Level
+	db       struct {
+		dsn      string
+		migrate  bool
+		timeouts models.Timeouts
+	}
+	trace struct {
+		exporter string
+		endpoint string
 	}
 	stripe struct {
-		secret string
-		key    string
+		secret        string
+		key           string
+		webhookSecret string
+		timeout       time.Duration
+	}
+	seller struct {
+		name    string
+		address string
+		taxID   string
+		email   string
+	}
+	tax struct {
+		rules   string
+		country string
 	}
+	manualCapture bool
+	disputeSync   time.Duration
+	payoutSync    time.Duration
+	rateLimits    string
+	lockout       string
+	web           string
+	corsOrigins   string
+	reconcile     struct {
+		every    time.Duration
+		dir      string
+		backfill bool
+	}
+	metricsToken string
 }
 
 type application struct {
 	config   config
-	infoLog  *log.Logger
-	errorLog *log.Logger
+	logger   *slog.Logger
 	version  string
-	DB       models.DBModel
+	Models   models.Models
+	pricing  *pricing.Engine
+	auditLog *audit.Logger
+	// metrics are served at /metrics to scrapers with the metrics token,
+	// stripeMetrics are the ones every cards.Card records to
+	metrics       *metrics.Registry
+	stripeMetrics *cards.Metrics
+	// limiter limits the public endpoints per client, lockout turns away
+	// the clients, emails and cards that keep being declined
+	limiter *ratelimit.Limiter
+	lockout *ratelimit.Lockout
 }
 
 func (app *application) serve() error {
@@ -43,7 +94,7 @@ func (app *application) serve() error {
 		WriteTimeout:      5 * time.Second,
 	}
 
-	app.infoLog.Println(fmt.Sprintf("Starting Back end server in %s mode on port %d", app.config.env, app.config.port))
+	app.logger.Info("starting back end server", "env", app.config.env, "port", app.config.port)
 
 	return srv.ListenAndServe()
 }
@@ -53,30 +104,127 @@ func main() {
 
 	flag.IntVar(&cfg.port, "port", 4001, "Server port to listen on")
 	flag.StringVar(&cfg.env, "env", "development", "Application enviornment {development|production|maintenance}")
-	flag.StringVar(&cfg.db.dsn, "dsn", "root:mysql@tcp(localhost:3306)/go_stripe?parseTime=true&tls=false", "DSN")
+	flag.TextVar(&cfg.logLevel, "log-level", slog.LevelInfo, "Least severe level to log {debug|info|warn|error}")
+	flag.StringVar(&cfg.db.dsn, "dsn", "root:mysql@tcp(localhost:3306)/go_stripe?parseTime=true&tls=false", "DSN, for mysql, or a postgres:// or sqlite: URL")
+	flag.BoolVar(&cfg.db.migrate, "migrate", false, "Apply pending database migrations before starting")
+	flag.DurationVar(&cfg.db.timeouts.Query, "db-timeout", 3*time.Second, "How long a single database query may take")
+	flag.DurationVar(&cfg.db.timeouts.Report, "db-report-timeout", 10*time.Second, "How long a report query may take")
+	flag.DurationVar(&cfg.db.timeouts.Import, "db-import-timeout", 30*time.Second, "How long a bulk import may take")
+	flag.DurationVar(&cfg.db.timeouts.Export, "db-export-timeout", 5*time.Minute, "How long an export may take to stream")
+	flag.DurationVar(&cfg.stripe.timeout, "stripe-timeout", 30*time.Second, "How long a single call to stripe may take")
+	flag.StringVar(&cfg.seller.name, "seller-name", "PT Widget Indonesia", "Company name printed on invoices")
+	flag.StringVar(&cfg.seller.address, "seller-address", "Jl. Sudirman No. 1;Jakarta 10220;Indonesia", "Company address printed on invoices, lines separated by ;")
+	flag.StringVar(&cfg.seller.taxID, "seller-tax-id", "", "Company tax ID (NPWP) printed on invoices")
+	flag.StringVar(&cfg.seller.email, "seller-email", "billing@widgets.com", "Billing contact printed on invoices")
+	flag.StringVar(&cfg.tax.rules, "tax-rules", "ID:11:exclusive", "Tax rules as COUNTRY:RATE:inclusive|exclusive, comma separated")
+	flag.StringVar(&cfg.tax.country, "tax-country", "ID", "Country whose tax applies when the buyer doesn't give one")
+	flag.BoolVar(&cfg.manualCapture, "manual-capture", false, "Only authorize cards at checkout and capture them when the order ships")
+	flag.DurationVar(&cfg.reconcile.every, "reconcile-every", 0, "How often to reconcile yesterday's transactions with stripe, 0 to turn off")
+	flag.StringVar(&cfg.reconcile.dir, "reconcile-dir", "./tmp/reconcile", "Directory reconcile reports are written to")
+	flag.BoolVar(&cfg.reconcile.backfill, "reconcile-backfill", false, "Write transactions for payment intents that are missing them when reconciling")
+	flag.DurationVar(&cfg.disputeSync, "dispute-sync", time.Hour, "How often to pull disputes from stripe, 0 to rely on webhooks only")
+	flag.DurationVar(&cfg.payoutSync, "payout-sync", 6*time.Hour, "How often to pull payouts and their balance transactions from stripe, 0 to turn off")
+	flag.StringVar(&cfg.web, "web", "http://localhost:4000", "URL of the web front end")
+	flag.StringVar(&cfg.corsOrigins, "cors-origins", "", "Origins allowed to call the api from a browser, comma separated, the -web URL when empty")
+	flag.StringVar(&cfg.rateLimits, "rate-limits", "/api/payment-intent=10/1m,/api/authenticate=5/1m,/api/widget/{id}=120/1m,/api/receipts/{token}/invoice.pdf=30/1m", "Requests allowed per client as route=N/duration, comma separated")
+	flag.StringVar(&cfg.lockout, "decline-lockout", "5/1h", "Declined payments allowed per client, email or card as N/duration before they are locked out")
+	flag.StringVar(&cfg.trace.exporter, "trace-exporter", "none", "Where to send spans {none|stdout|otlp}")
+	flag.StringVar(&cfg.trace.endpoint, "trace-endpoint", "", "OTLP collector URL, such as http://localhost:4318")
 	flag.Parse()
 
 	cfg.stripe.key = os.Getenv("STRIPE_KEY")
 	cfg.stripe.secret = os.Getenv("STRIPE_SECRET")
+	cfg.stripe.webhookSecret = os.Getenv("STRIPE_WEBHOOK_SECRET")
+	cfg.metricsToken = os.Getenv("METRICS_TOKEN")
+
+	logger := logging.New(os.Stdout, cfg.env, cfg.logLevel)
 
-	infoLog := log.New(os.Stdout, "INFO\t", log.Ldate|log.Ltime)
-	errorLog := log.New(os.Stdout, "ERROR\t", log.Ldate|log.Ltime|log.Lshortfile)
+	shutdown, err := tracing.Setup(context.Background(), tracing.Config{
+		Service:  "go-stripe-api",
+		Version:  version,
+		Exporter: cfg.trace.exporter,
+		Endpoint: cfg.trace.endpoint,
+	})
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+	defer shutdown(context.Background())
+
+	taxRules, err := pricing.ParseTaxRules(cfg.tax.rules)
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+	limits, err := ratelimit.ParseLimits(cfg.rateLimits)
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+	lockout, err := ratelimit.ParseLimit(cfg.lockout)
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
 	conn, err := driver.OpenDB(cfg.db.dsn)
 	if err != nil {
-		errorLog.Fatal(err)
+		logger.Error(err.Error())
+		os.Exit(1)
 	}
 	defer conn.Close()
+	dialect := driver.DialectOf(cfg.db.dsn)
+
+	if cfg.db.migrate {
+		fsys, err := migrations.For(dialect)
+		if err != nil {
+			logger.Error(err.Error())
+			os.Exit(1)
+		}
+		m, err := migrate.New(conn, dialect, fsys, slog.NewLogLogger(logger.Handler(), slog.LevelInfo))
+		if err != nil {
+			logger.Error(err.Error())
+			os.Exit(1)
+		}
+		n, err := m.Up(context.Background())
+		if err != nil {
+			logger.Error(err.Error())
+			os.Exit(1)
+		}
+		logger.Info("applied migrations", "count", n)
+	}
 
+	db := &models.DBModel{DB: conn, Dialect: dialect, Timeouts: cfg.db.timeouts, Logger: logger}
 	app := &application{
-		config:   cfg,
-		infoLog:  infoLog,
-		errorLog: errorLog,
-		version:  version,
-		DB:       models.DBModel{DB: conn},
+		config:  cfg,
+		logger:  logger,
+		version: version,
+		Models:  db.Models(),
+		pricing: &pricing.Engine{
+			Rules:          taxRules,
+			DefaultCountry: cfg.tax.country,
+		},
+	}
+	store := &ratelimit.MemoryStore{}
+	app.limiter = &ratelimit.Limiter{Store: store, Limits: limits, Logger: logger}
+	app.lockout = &ratelimit.Lockout{Store: store, Limit: lockout}
+	app.auditLog = &audit.Logger{DB: app.Models.Audit}
+	app.metrics = metrics.NewRegistry()
+	app.metrics.DBStats(conn)
+	app.stripeMetrics = cards.NewMetrics(app.metrics)
+
+	if cfg.disputeSync > 0 {
+		go app.runDisputeSync(cfg.disputeSync)
+	}
+	if cfg.payoutSync > 0 {
+		go app.runPayoutSync(cfg.payoutSync)
+	}
+	if cfg.reconcile.every > 0 {
+		go app.runReconcile(cfg.reconcile.every)
 	}
 
 	err = app.serve()
 	if err != nil {
-		log.Fatal(err)
+		logger.Error(err.Error())
+		os.Exit(1)
 	}
 }
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"go-stripe/internal/audit"
+	"go-stripe/internal/inventory"
+	"go-stripe/internal/models"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// UpdateWidget changes a widget's details and price
+func (app *application) UpdateWidget(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		Name           string `json:"name"`
+		Description    string `json:"description"`
+		InventoryLevel int    `json:"inventory_level"`
+		Price          int    `json:"price"`
+		Image          string `json:"image"`
+	}
+	if err := app.readJSON(w, r, &payload); err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	widgetID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		app.errorJSON(w, errors.New("invalid widget id"))
+		return
+	}
+
+	payload.Name = strings.TrimSpace(payload.Name)
+	if payload.Name == "" {
+		app.errorJSON(w, errors.New("a widget needs a name"))
+		return
+	}
+	if payload.Price < 1 {
+		app.errorJSON(w, errors.New("price must be at least 1"))
+		return
+	}
+	if payload.InventoryLevel < 0 {
+		app.errorJSON(w, errors.New("inventory level can't be negative"))
+		return
+	}
+
+	before, err := app.Models.Widgets.GetWidget(r.Context(), widgetID)
+	if errors.Is(err, sql.ErrNoRows) {
+		app.errorJSON(w, errors.New("widget not found"), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		app.logger.ErrorContext(r.Context(), err.Error())
+		app.errorJSON(w, errors.New("could not load widget"), http.StatusInternalServerError)
+		return
+	}
+
+	after := before
+	after.Name = payload.Name
+	after.Description = payload.Description
+	after.InventoryLevel = payload.InventoryLevel
+	after.Price = payload.Price
+	after.Image = payload.Image
+
+	err = app.Models.Widgets.UpdateWidget(r.Context(), after)
+	if err != nil {
+		app.logger.ErrorContext(r.Context(), err.Error())
+		app.errorJSON(w, errors.New("could not update widget"), http.StatusInternalServerError)
+		return
+	}
+
+	app.recordAudit(r, audit.Event{
+		Action:     audit.ActionWidgetUpdate,
+		EntityType: audit.EntityWidget,
+		EntityID:   widgetID,
+		Before:     before,
+		After:      after,
+	})
+
+	app.writeJSON(w, http.StatusOK, after)
+}
+
+// TerminalCharges returns a page of the charges taken on the virtual terminal
+func (app *application) TerminalCharges(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	pageSize, _ := strconv.Atoi(q.Get("page_size"))
+	page, _ := strconv.Atoi(q.Get("page"))
+	if pageSize < 1 || pageSize > 100 {
+		pageSize = 20
+	}
+	if page < 1 {
+		page = 1
+	}
+
+	charges, lastPage, totalRecords, err := app.Models.Transactions.GetTerminalChargesPaginated(r.Context(), pageSize, page)
+	if err != nil {
+		app.logger.ErrorContext(r.Context(), err.Error())
+		app.errorJSON(w, errors.New("could not load terminal charges"), http.StatusInternalServerError)
+		return
+	}
+
+	var resp struct {
+		CurrentPage  int                      `json:"current_page"`
+		PageSize     int                      `json:"page_size"`
+		LastPage     int                      `json:"last_page"`
+		TotalRecords int                      `json:"total_records"`
+		Charges      []*models.TerminalCharge `json:"charges"`
+	}
+	resp.CurrentPage = page
+	resp.PageSize = pageSize
+	resp.LastPage = lastPage
+	resp.TotalRecords = totalRecords
+	resp.Charges = charges
+
+	app.writeJSON(w, http.StatusOK, resp)
+}
+
+// AuditLog returns a page of the audit log, filtered by the action, actor,
+// entity_type, entity_id, from and to query parameters. from and to are
+// dates as YYYY-MM-DD and to is inclusive
+func (app *application) AuditLog(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	pageSize, _ := strconv.Atoi(q.Get("page_size"))
+	page, _ := strconv.Atoi(q.Get("page"))
+	if pageSize < 1 || pageSize > 100 {
+		pageSize = 20
+	}
+	if page < 1 {
+		page = 1
+	}
+
+	filter := models.AuditFilter{
+		Action:     q.Get("action"),
+		ActorEmail: q.Get("actor"),
+		EntityType: q.Get("entity_type"),
+	}
+	if id := q.Get("entity_id"); id != "" {
+		entityID, err := strconv.Atoi(id)
+		if err != nil {
+			app.errorJSON(w, errors.New("invalid entity id"))
+			return
+		}
+		filter.EntityID = entityID
+	}
+	if from := q.Get("from"); from != "" {
+		t, err := time.Parse("2006-01-02", from)
+		if err != nil {
+			app.errorJSON(w, errors.New("from must be a date like 2024-10-25"))
+			return
+		}
+		filter.From = t
+	}
+	if to := q.Get("to"); to != "" {
+		t, err := time.Parse("2006-01-02", to)
+		if err != nil {
+			app.errorJSON(w, errors.New("to must be a date like 2024-10-25"))
+			return
+		}
+		filter.To = t.AddDate(0, 0, 1)
+	}
+
+	entries, lastPage, totalRecords, err := app.Models.Audit.GetAuditEntries(r.Context(), filter, pageSize, page)
+	if err != nil {
+		app.logger.ErrorContext(r.Context(), err.Error())
+		app.errorJSON(w, errors.New("could not load audit log"), http.StatusInternalServerError)
+		return
+	}
+
+	var resp struct {
+		CurrentPage  int                  `json:"current_page"`
+		PageSize     int                  `json:"page_size"`
+		LastPage     int                  `json:"last_page"`
+		TotalRecords int                  `json:"total_records"`
+		Entries      []*models.AuditEntry `json:"entries"`
+	}
+	resp.CurrentPage = page
+	resp.PageSize = pageSize
+	resp.LastPage = lastPage
+	resp.TotalRecords = totalRecords
+	resp.Entries = entries
+
+	app.writeJSON(w, http.StatusOK, resp)
+}
+
+// maxImportFile is the largest widget import file accepted
+const maxImportFile = 2 << 20
+
+// ImportWidgets creates and updates widgets from a csv file, uploaded as the
+// file field of a multipart form or sent as the request body. With dry_run
+// set, nothing is saved and the response shows what the import would do.
+// Nothing is saved either if any row has an error
+func (app *application) ImportWidgets(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportFile+65536)
+
+	var file io.Reader = r.Body
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		if err := r.ParseMultipartForm(maxImportFile); err != nil {
+			app.errorJSON(w, errors.New("import files must be smaller than 2MB"))
+			return
+		}
+		f, _, err := r.FormFile("file")
+		if err != nil {
+			app.errorJSON(w, errors.New("no file uploaded"))
+			return
+		}
+		defer f.Close()
+		file = f
+	}
+	dryRun, _ := strconv.ParseBool(r.FormValue("dry_run"))
+
+	rows, err := inventory.ReadWidgets(file)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+	if len(rows) == 0 {
+		app.errorJSON(w, errors.New("the file has no rows to import"))
+		return
+	}
+
+	results, applied, err := app.Models.Imports.ImportWidgets(r.Context(), rows, dryRun)
+	if err != nil {
+		app.logger.ErrorContext(r.Context(), err.Error())
+		app.errorJSON(w, errors.New("could not import widgets"), http.StatusInternalServerError)
+		return
+	}
+
+	if applied {
+		for _, res := range results {
+			if res.Action != models.ImportCreate && res.Action != models.ImportUpdate {
+				continue
+			}
+			e := audit.Event{
+				Action:     audit.ActionWidgetImport,
+				EntityType: audit.EntityWidget,
+				EntityID:   res.WidgetID,
+				After:      res.After,
+			}
+			if res.Before != nil {
+				e.Before = res.Before
+			}
+			app.recordAudit(r, e)
+		}
+	}
+
+	var resp struct {
+		OK      bool                        `json:"ok"`
+		Message string                      `json:"message"`
+		DryRun  bool                        `json:"dry_run"`
+		Applied bool                        `json:"applied"`
+		Counts  map[string]int              `json:"counts"`
+		Rows    []models.WidgetImportResult `json:"rows"`
+	}
+	resp.OK = true
+	resp.DryRun = dryRun
+	resp.Applied = applied
+	resp.Counts = models.ImportWidgetsSummary(results)
+	resp.Rows = results
+
+	switch {
+	case resp.Counts[models.ImportError] > 0:
+		resp.OK = false
+		resp.Message = fmt.Sprintf("%d rows have errors, nothing was imported", resp.Counts[models.ImportError])
+	case dryRun:
+		resp.Message = fmt.Sprintf("dry run: %d widgets would be created and %d updated",
+			resp.Counts[models.ImportCreate], resp.Counts[models.ImportUpdate])
+	default:
+		resp.Message = fmt.Sprintf("%d widgets created and %d updated",
+			resp.Counts[models.ImportCreate], resp.Counts[models.ImportUpdate])
+	}
+
+	status := http.StatusOK
+	if !resp.OK {
+		status = http.StatusUnprocessableEntity
+	}
+	app.writeJSON(w, status, resp)
+}
@@ -1,16 +1,39 @@
 package main
 
 import (
+	"context"
+	"database/sql"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"github.com/go-chi/chi/v5"
+	"github.com/stripe/stripe-go/v72"
+	"go-stripe/internal/audit"
 	"go-stripe/internal/cards"
+	"go-stripe/internal/invoice"
+	"go-stripe/internal/logging"
+	"go-stripe/internal/mailer"
+	"go-stripe/internal/models"
+	"go-stripe/internal/pricing"
+	"go-stripe/internal/ratelimit"
+	"io"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
 )
 
 type stripePayload struct {
-	Currency string `json:"currency"`
-	Amount   string `json:"amount"`
+	Currency   string `json:"currency"`
+	Amount     string `json:"amount"`
+	ProductID  string `json:"product_id"`
+	Quantity   string `json:"quantity"`
+	CouponCode string `json:"coupon_code"`
+	Country    string `json:"country"`
+	// Email and PaymentMethod are who is paying and with which card, so
+	// each can be limited and locked out on its own
+	Email         string `json:"email"`
+	PaymentMethod string `json:"payment_method"`
 }
 
 type jsonResponse struct {
@@ -20,41 +43,121 @@ type jsonResponse struct {
 	ID      int    `json:"id,omitempty"`
 }
 
+type paymentIntentResponse struct {
+	ClientSecret string             `json:"client_secret"`
+	Breakdown    *pricing.Breakdown `json:"breakdown,omitempty"`
+}
+
+// GetPaymentIntent starts a checkout payment for a widget. Anyone can call
+// it, so the amount is always the server's price for the widget
 func (app *application) GetPaymentIntent(w http.ResponseWriter, r *http.Request) {
 	var payload stripePayload
 
 	err := json.NewDecoder(r.Body).Decode(&payload)
 	if err != nil {
-		app.errorLog.Println(err)
+		app.logger.ErrorContext(r.Context(), err.Error())
 		return
 	}
 
-	amount, err := strconv.Atoi(payload.Amount)
+	if payload.ProductID == "" {
+		app.writeJSON(w, http.StatusOK, jsonResponse{OK: false, Message: "invalid product"})
+		return
+	}
+	b, err := app.priceProduct(r.Context(), payload)
+	if err != nil {
+		app.writeJSON(w, http.StatusOK, jsonResponse{OK: false, Message: err.Error()})
+		return
+	}
+
+	opts := cards.ChargeOptions{
+		Metadata:      b.Metadata(),
+		ManualCapture: app.config.manualCapture,
+	}
+	app.createPaymentIntent(w, r, payload, b.Total, opts, &b)
+}
+
+// TerminalPaymentIntent starts a virtual terminal charge for the amount the
+// logged in operator typed in
+func (app *application) TerminalPaymentIntent(w http.ResponseWriter, r *http.Request) {
+	var payload stripePayload
+
+	err := json.NewDecoder(r.Body).Decode(&payload)
 	if err != nil {
-		app.errorLog.Println(err)
+		app.logger.ErrorContext(r.Context(), err.Error())
 		return
 	}
 
+	amount, err := strconv.Atoi(payload.Amount)
+	if err != nil || amount < 1 {
+		app.writeJSON(w, http.StatusOK, jsonResponse{OK: false, Message: "invalid amount"})
+		return
+	}
+	app.createPaymentIntent(w, r, payload, amount, cards.ChargeOptions{}, nil)
+}
+
+// createPaymentIntent creates the payment intent for amount with the card in
+// payload, unless the client, email or card is over its limits, and answers
+// with its client secret and the breakdown of the amount if there is one
+func (app *application) createPaymentIntent(w http.ResponseWriter, r *http.Request, payload stripePayload, amount int, opts cards.ChargeOptions, breakdown *pricing.Breakdown) {
+	// the receipt goes to this address, so one we couldn't send it to is
+	// turned away before the customer is charged
+	if payload.Email != "" {
+		if _, err := mailer.ParseAddress(payload.Email); err != nil {
+			app.writeJSON(w, http.StatusOK, jsonResponse{OK: false, Message: "invalid email address"})
+			return
+		}
+	}
+
 	card := cards.Card{
 		Secret:   app.config.stripe.secret,
 		Key:      app.config.stripe.key,
 		Currency: payload.Currency,
+		Timeout:  app.config.stripe.timeout,
+		Logger:   app.logger,
+		Metrics:  app.stripeMetrics,
+	}
+
+	keys, err := app.paymentKeys(r, &card, payload)
+	if err != nil {
+		app.logger.ErrorContext(r.Context(), err.Error())
+		app.writeJSON(w, http.StatusOK, jsonResponse{OK: false, Message: "Invalid card"})
+		return
+	}
+	if !app.allowPayment(w, r, keys) {
+		return
+	}
+	opts.PaymentMethod = payload.PaymentMethod
+	if opts.Metadata == nil {
+		opts.Metadata = make(map[string]string)
+	}
+	// for the webhook to count a decline against the same client and email
+	opts.Metadata[metadataClientIP] = ratelimit.ClientIP(r)
+	if email := normalizeEmail(payload.Email); email != "" {
+		opts.Metadata[metadataEmail] = email
 	}
 
 	okay := true
 
-	pi, msg, err := card.Charge(payload.Currency, amount)
+	pi, msg, err := card.CreatePaymentIntentWithOptions(r.Context(), payload.Currency, amount, opts)
 	if err != nil {
 		okay = false
+		if cards.IsDecline(err) {
+			app.countDecline(r.Context(), keys)
+		}
+	}
+	if okay && breakdown != nil && breakdown.CouponCode != "" && !app.reserveCoupon(r, &card, breakdown.CouponCode, pi.ID) {
+		app.writeJSON(w, http.StatusOK, jsonResponse{OK: false, Message: pricing.ErrCouponUsedUp.Error()})
+		return
 	}
 
 	if okay {
-		response := map[string]string{
-			"client_secret": pi.ClientSecret,
+		response := paymentIntentResponse{
+			ClientSecret: pi.ClientSecret,
+			Breakdown:    breakdown,
 		}
 		out, err := json.MarshalIndent(response, "", "   ")
 		if err != nil {
-			app.errorLog.Println(err)
+			app.logger.ErrorContext(r.Context(), err.Error())
 			return
 		}
 
@@ -69,7 +172,7 @@ func (app *application) GetPaymentIntent(w http.ResponseWriter, r *http.Request)
 
 		out, err := json.MarshalIndent(j, "", "   ")
 		if err != nil {
-			app.errorLog.Println(err)
+			app.logger.ErrorContext(r.Context(), err.Error())
 		}
 
 		w.Header().Set("Content-Type", "application/json")
@@ -77,20 +180,459 @@ func (app *application) GetPaymentIntent(w http.ResponseWriter, r *http.Request)
 	}
 }
 
+// couponHold is how long a checkout holds a use of its coupon for, so that
+// customers paying at once can't use it more times than it allows. A
+// checkout that is abandoned gives its use back once the hold runs out
+const couponHold = time.Hour
+
+// reserveCoupon holds a use of code for the payment intent just created with
+// card, and reports whether it could. Without one the payment intent is
+// cancelled, so the customer can't pay with the discount
+func (app *application) reserveCoupon(r *http.Request, card *cards.Card, code, paymentIntent string) bool {
+	ctx := context.WithoutCancel(r.Context())
+	err := app.Models.Coupons.ReserveCoupon(ctx, code, paymentIntent, time.Now().Add(couponHold))
+	if err == nil {
+		return true
+	}
+	if !errors.Is(err, models.ErrCouponUnavailable) {
+		app.logger.ErrorContext(r.Context(), err.Error())
+	}
+	if _, err := card.Cancel(ctx, paymentIntent, ""); err != nil {
+		app.logger.ErrorContext(r.Context(), "could not cancel payment intent", "payment_intent", paymentIntent, "err", err)
+	}
+	return false
+}
+
+// the payment intent metadata a decline reported by webhook is counted by
+const (
+	metadataClientIP = "client_ip"
+	metadataEmail    = "email"
+)
+
+// paymentKeys are what a payment is limited and locked out by: the client's
+// address, and the email and card when the browser sent them. The card is
+// known by its fingerprint, which is the same for every payment method made
+// from one card number
+func (app *application) paymentKeys(r *http.Request, card *cards.Card, payload stripePayload) ([]string, error) {
+	keys := []string{"ip:" + ratelimit.ClientIP(r)}
+	if email := normalizeEmail(payload.Email); email != "" {
+		keys = append(keys, "email:"+email)
+	}
+	if payload.PaymentMethod != "" {
+		pm, err := card.GetPaymentMethod(r.Context(), payload.PaymentMethod)
+		if err != nil {
+			return nil, err
+		}
+		if pm.Card != nil && pm.Card.Fingerprint != "" {
+			keys = append(keys, "card:"+pm.Card.Fingerprint)
+		}
+	}
+	return keys, nil
+}
+
+// allowPayment turns the payment away with a 429 when any of keys is locked
+// out for too many declines, or the email or card has made too many
+// payments. The client's address is limited by the route's middleware
+func (app *application) allowPayment(w http.ResponseWriter, r *http.Request, keys []string) bool {
+	locked, retryAfter, err := app.lockout.Locked(r.Context(), keys...)
+	if err != nil {
+		// as with the rate limits, a broken store doesn't stop payments
+		app.logger.ErrorContext(r.Context(), "could not check declines", "err", err)
+	}
+	if locked {
+		app.logger.WarnContext(r.Context(), "locked out for declines", "keys", strings.Join(keys, " "), "retry_after", retryAfter)
+		ratelimit.Deny(w, retryAfter, "Too many declined payments, please try again later")
+		return false
+	}
+
+	route := logging.Route(r)
+	for _, key := range keys[1:] {
+		if ok, retryAfter := app.limiter.Allow(r.Context(), route, key); !ok {
+			ratelimit.Deny(w, retryAfter, "Too many requests, please try again later")
+			return false
+		}
+	}
+	return true
+}
+
+// countDecline counts a declined payment against keys towards their lockout
+func (app *application) countDecline(ctx context.Context, keys []string) {
+	if err := app.lockout.Decline(ctx, keys...); err != nil {
+		app.logger.ErrorContext(ctx, "could not count decline", "err", err)
+	}
+}
+
+// declineKeys are the keys the payment intent in a payment failed webhook
+// was made with, for its decline to be counted against
+func declineKeys(pi *stripe.PaymentIntent) []string {
+	var keys []string
+	if ip := pi.Metadata[metadataClientIP]; ip != "" {
+		keys = append(keys, "ip:"+ip)
+	}
+	if email := pi.Metadata[metadataEmail]; email != "" {
+		keys = append(keys, "email:"+email)
+	}
+	if e := pi.LastPaymentError; e != nil && e.PaymentMethod != nil && e.PaymentMethod.Card != nil && e.PaymentMethod.Card.Fingerprint != "" {
+		keys = append(keys, "card:"+e.PaymentMethod.Card.Fingerprint)
+	}
+	return keys
+}
+
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// priceProduct works out what to charge for the widget in payload, including
+// tax and any coupon
+func (app *application) priceProduct(ctx context.Context, payload stripePayload) (pricing.Breakdown, error) {
+	widgetID, err := strconv.Atoi(payload.ProductID)
+	if err != nil {
+		return pricing.Breakdown{}, errors.New("invalid product")
+	}
+	quantity := 1
+	if payload.Quantity != "" {
+		quantity, err = strconv.Atoi(payload.Quantity)
+		if err != nil {
+			return pricing.Breakdown{}, pricing.ErrInvalidQuantity
+		}
+	}
+
+	widget, err := app.Models.Widgets.GetWidget(ctx, widgetID)
+	if err != nil {
+		app.logger.ErrorContext(ctx, err.Error())
+		return pricing.Breakdown{}, errors.New("invalid product")
+	}
+
+	var coupon *models.Coupon
+	if code := strings.TrimSpace(payload.CouponCode); code != "" {
+		c, err := app.Models.Coupons.GetCouponByCode(ctx, code)
+		if err != nil {
+			if !errors.Is(err, sql.ErrNoRows) {
+				app.logger.ErrorContext(ctx, err.Error())
+			}
+			return pricing.Breakdown{}, pricing.ErrCouponInvalid
+		}
+		coupon = &c
+	}
+
+	// widget prices are whole rupiah, stripe wants the smallest unit
+	b, err := app.pricing.Compute(widget.Price*100, quantity, payload.Country, coupon, time.Now())
+	if err != nil {
+		return b, err
+	}
+	b.WidgetID = widget.ID
+	return b, nil
+}
+
 func (app *application) GetWidgetByID(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "id")
 	widgetID, _ := strconv.Atoi(id)
 
-	widget, err := app.DB.GetWidget(widgetID)
+	widget, err := app.Models.Widgets.GetWidget(r.Context(), widgetID)
 	if err != nil {
-		app.errorLog.Println(err)
+		app.logger.ErrorContext(r.Context(), err.Error())
 		return
 	}
 	out, err := json.MarshalIndent(widget, "", "   ")
 	if err != nil {
-		app.errorLog.Println(err)
+		app.logger.ErrorContext(r.Context(), err.Error())
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
 	w.Write(out)
 }
+
+// GetOrderInvoice renders the PDF invoice for an order, for the admin
+func (app *application) GetOrderInvoice(w http.ResponseWriter, r *http.Request) {
+	orderID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		app.errorJSON(w, errors.New("invalid order id"))
+		return
+	}
+	app.writeInvoice(w, r, orderID)
+}
+
+// GetReceiptInvoice renders the PDF invoice for the order a receipt token
+// belongs to, for the customer. Order ids are sequential, so customers only
+// ever get at their invoice through the token on their receipt
+func (app *application) GetReceiptInvoice(w http.ResponseWriter, r *http.Request) {
+	order, err := app.Models.Orders.GetOrderByReceiptToken(r.Context(), chi.URLParam(r, "token"))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			app.errorJSON(w, errors.New("order not found"), http.StatusNotFound)
+			return
+		}
+		app.logger.ErrorContext(r.Context(), err.Error())
+		app.errorJSON(w, errors.New("could not load invoice"), http.StatusInternalServerError)
+		return
+	}
+	app.writeInvoice(w, r, order.ID)
+}
+
+// writeInvoice renders the PDF invoice for orderID, issuing its number the
+// first time
+func (app *application) writeInvoice(w http.ResponseWriter, r *http.Request, orderID int) {
+	data, err := invoice.Load(r.Context(), app.Models, app.seller(), orderID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			app.errorJSON(w, errors.New("order not found"), http.StatusNotFound)
+			return
+		}
+		app.logger.ErrorContext(r.Context(), err.Error())
+		app.errorJSON(w, errors.New("could not load invoice"), http.StatusInternalServerError)
+		return
+	}
+
+	pdf, err := invoice.Render(data)
+	if err != nil {
+		app.logger.ErrorContext(r.Context(), err.Error())
+		app.errorJSON(w, errors.New("could not render invoice"), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`inline; filename="%s"`, data.Filename()))
+	w.Write(pdf)
+}
+
+// seller returns our company details for invoices
+func (app *application) seller() invoice.Seller {
+	return invoice.Seller{
+		Name:    app.config.seller.name,
+		Address: app.config.seller.address,
+		TaxID:   app.config.seller.taxID,
+		Email:   app.config.seller.email,
+	}
+}
+
+// CreateAuthToken checks an email and password and issues an authentication token
+func (app *application) CreateAuthToken(w http.ResponseWriter, r *http.Request) {
+	var userInput struct {
+		Email    string `json:"email"`
+		Password string `json:"password"`
+	}
+
+	err := app.readJSON(w, r, &userInput)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	userID, err := app.Models.Users.Authenticate(r.Context(), userInput.Email, userInput.Password)
+	if err != nil {
+		if !errors.Is(err, models.ErrInvalidCredentials) {
+			app.logger.ErrorContext(r.Context(), err.Error())
+		}
+		app.recordAudit(r, audit.Event{
+			Action: audit.ActionLoginFailed,
+			Actor:  audit.Actor{Email: userInput.Email},
+		})
+		app.invalidCredentials(w)
+		return
+	}
+
+	user, err := app.Models.Users.GetUserByEmail(r.Context(), userInput.Email)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	token, err := models.GenerateToken(userID, 24*time.Hour, models.ScopeAuthentication)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	err = app.Models.Users.InsertToken(r.Context(), token, user)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+	app.recordAudit(r, audit.Event{
+		Action:     audit.ActionLogin,
+		Actor:      audit.Actor{ID: user.ID, Email: user.Email},
+		EntityType: audit.EntityUser,
+		EntityID:   user.ID,
+	})
+
+	var payload struct {
+		OK      bool          `json:"ok"`
+		Message string        `json:"message"`
+		Token   *models.Token `json:"authentication_token"`
+	}
+	payload.OK = true
+	payload.Message = fmt.Sprintf("token for %s created", userInput.Email)
+	payload.Token = token
+
+	app.writeJSON(w, http.StatusOK, payload)
+}
+
+// authorizationResponse describes the state of an order's card authorization
+type authorizationResponse struct {
+	OK             bool      `json:"ok"`
+	Message        string    `json:"message"`
+	OrderID        int       `json:"order_id"`
+	Status         string    `json:"status"`
+	AmountCaptured int       `json:"amount_captured,omitempty"`
+	ExpiresAt      time.Time `json:"expires_at"`
+}
+
+// openAuthorization loads the order in the url and its transaction, and
+// checks that the card authorization can still be captured or voided
+func (app *application) openAuthorization(r *http.Request) (models.Order, models.Transaction, int, error) {
+	var order models.Order
+	var txn models.Transaction
+
+	orderID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		return order, txn, http.StatusBadRequest, errors.New("invalid order id")
+	}
+
+	order, err = app.Models.Orders.GetOrder(r.Context(), orderID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return order, txn, http.StatusNotFound, errors.New("order not found")
+	}
+	if err != nil {
+		app.logger.ErrorContext(r.Context(), err.Error())
+		return order, txn, http.StatusInternalServerError, errors.New("could not load order")
+	}
+
+	txn, err = app.Models.Transactions.GetTransaction(r.Context(), order.TransactionID)
+	if err != nil {
+		app.logger.ErrorContext(r.Context(), err.Error())
+		return order, txn, http.StatusInternalServerError, errors.New("could not load transaction")
+	}
+
+	if txn.TransactionStatusID != models.TransactionStatusAuthorized {
+		return order, txn, http.StatusConflict, errors.New("order has no open card authorization")
+	}
+	if time.Now().After(txn.CreatedAt.Add(cards.AuthorizationWindow)) {
+		return order, txn, http.StatusConflict, errors.New("card authorization has expired")
+	}
+
+	return order, txn, http.StatusOK, nil
+}
+
+// CaptureOrder takes the money for an order that was only authorized at
+// checkout. An amount smaller than the authorization captures part of it,
+// and no amount captures all of it
+func (app *application) CaptureOrder(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		Amount *int `json:"amount"`
+	}
+	// an empty body captures everything, however it was sent
+	if err := app.readJSON(w, r, &payload); err != nil && !errors.Is(err, io.EOF) {
+		app.errorJSON(w, err)
+		return
+	}
+
+	order, txn, status, err := app.openAuthorization(r)
+	if err != nil {
+		app.errorJSON(w, err, status)
+		return
+	}
+
+	amount := txn.Amount
+	if payload.Amount != nil {
+		amount = *payload.Amount
+	}
+	if amount < 1 || amount > txn.Amount {
+		app.errorJSON(w, fmt.Errorf("amount must be between 1 and %d", txn.Amount))
+		return
+	}
+
+	card := cards.Card{
+		Secret:  app.config.stripe.secret,
+		Key:     app.config.stripe.key,
+		Timeout: app.config.stripe.timeout,
+		Logger:  app.logger,
+		Metrics: app.stripeMetrics,
+	}
+	pi, err := card.Capture(r.Context(), txn.PaymentIntent, amount, idempotencyKey(order.ID, fmt.Sprintf("capture-%d", amount)))
+	if err != nil {
+		app.logger.ErrorContext(r.Context(), err.Error())
+		app.errorJSON(w, errors.New("stripe could not capture the payment"), http.StatusBadGateway)
+		return
+	}
+
+	// the money has moved, and our records have to follow it. A client that
+	// disconnects cancels r.Context(), which would abandon the writes below
+	// half done, so from here on the work runs on a context that keeps the
+	// request's values but not its cancellation. Each query still has its own
+	// timeout. The other handlers that record what stripe has already done
+	// follow the same rule
+	ctx := context.WithoutCancel(r.Context())
+
+	amount = int(pi.AmountReceived)
+	err = app.Models.Orders.CaptureOrder(ctx, order, amount, cards.ChargeID(pi))
+	if err != nil {
+		app.logger.ErrorContext(r.Context(), err.Error())
+		app.errorJSON(w, errors.New("payment captured but could not be saved"), http.StatusInternalServerError)
+		return
+	}
+	app.recordTransactionChange(r, audit.ActionCapture, txn)
+
+	app.writeJSON(w, http.StatusOK, authorizationResponse{
+		OK:             true,
+		Message:        "payment captured",
+		OrderID:        order.ID,
+		Status:         "captured",
+		AmountCaptured: amount,
+		ExpiresAt:      txn.CreatedAt.Add(cards.AuthorizationWindow),
+	})
+}
+
+// VoidOrder releases the card authorization for an order and cancels it
+func (app *application) VoidOrder(w http.ResponseWriter, r *http.Request) {
+	order, txn, status, err := app.openAuthorization(r)
+	if err != nil {
+		app.errorJSON(w, err, status)
+		return
+	}
+
+	card := cards.Card{
+		Secret:  app.config.stripe.secret,
+		Key:     app.config.stripe.key,
+		Timeout: app.config.stripe.timeout,
+		Logger:  app.logger,
+		Metrics: app.stripeMetrics,
+	}
+	// the same key as cancelling the order, so the two can't both void it
+	_, err = card.Cancel(r.Context(), txn.PaymentIntent, idempotencyKey(order.ID, models.OrderStatusName(models.OrderStatusCancelled)))
+	if err != nil {
+		app.logger.ErrorContext(r.Context(), err.Error())
+		app.errorJSON(w, errors.New("stripe could not void the payment"), http.StatusBadGateway)
+		return
+	}
+
+	// the authorization is gone, so record that
+	ctx := context.WithoutCancel(r.Context())
+
+	err = app.Models.Transactions.UpdateTransactionStatus(ctx, txn.ID, models.TransactionStatusVoided, txn.BankReturnCode)
+	if err != nil {
+		app.logger.ErrorContext(r.Context(), err.Error())
+		app.errorJSON(w, errors.New("payment voided but could not be saved"), http.StatusInternalServerError)
+		return
+	}
+	app.recordTransactionChange(r, audit.ActionVoid, txn)
+
+	err = app.Models.Orders.ChangeOrderStatus(ctx, order.ID, models.OrderChange{
+		FromStatusID: order.StatusID,
+		ToStatusID:   models.OrderStatusCancelled,
+		Note:         "card authorization voided",
+		UserID:       currentUser(r).ID,
+	})
+	if err != nil {
+		app.logger.ErrorContext(r.Context(), err.Error())
+	} else {
+		app.recordOrderChange(r, order)
+	}
+
+	app.writeJSON(w, http.StatusOK, authorizationResponse{
+		OK:        true,
+		Message:   "authorization voided",
+		OrderID:   order.ID,
+		Status:    "voided",
+		ExpiresAt: txn.CreatedAt.Add(cards.AuthorizationWindow),
+	})
+}
@@ -0,0 +1,659 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/stripe/stripe-go/v72"
+	"go-stripe/internal/models"
+	"go-stripe/internal/pricing"
+	"go-stripe/internal/ratelimit"
+	"go-stripe/internal/stripetest"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestGetPaymentIntent(t *testing.T) {
+	tests := []struct {
+		name          string
+		payload       stripePayload
+		manualCapture bool
+		fail          stripe.ErrorCode
+
+		wantOK      bool
+		wantMessage string
+		wantAmount  int64
+	}{
+		{
+			name:       "product priced by the server",
+			payload:    stripePayload{Currency: "idr", ProductID: "1", Quantity: "2", Amount: "1"},
+			wantOK:     true,
+			wantAmount: 222000000,
+		},
+		{
+			name:       "coupon",
+			payload:    stripePayload{Currency: "idr", ProductID: "1", CouponCode: "save10"},
+			wantOK:     true,
+			wantAmount: 99900000,
+		},
+		{
+			name:       "country without tax",
+			payload:    stripePayload{Currency: "idr", ProductID: "1", Country: "sg"},
+			wantOK:     true,
+			wantAmount: 100000000,
+		},
+		{
+			name:          "manual capture",
+			payload:       stripePayload{Currency: "idr", ProductID: "1"},
+			manualCapture: true,
+			wantOK:        true,
+			wantAmount:    111000000,
+		},
+		{
+			name:        "amount without a product",
+			payload:     stripePayload{Currency: "idr", Amount: "50000"},
+			wantMessage: "invalid product",
+		},
+		{
+			name:        "unknown product",
+			payload:     stripePayload{Currency: "idr", ProductID: "99"},
+			wantMessage: "invalid product",
+		},
+		{
+			name:        "unknown coupon",
+			payload:     stripePayload{Currency: "idr", ProductID: "1", CouponCode: "NOPE"},
+			wantMessage: pricing.ErrCouponInvalid.Error(),
+		},
+		{
+			name:        "bad quantity",
+			payload:     stripePayload{Currency: "idr", ProductID: "1", Quantity: "0"},
+			wantMessage: pricing.ErrInvalidQuantity.Error(),
+		},
+		{
+			name:        "email with a header in it",
+			payload:     stripePayload{Currency: "idr", ProductID: "1", Email: "ani@example.com\r\nBcc: all@example.com"},
+			wantMessage: "invalid email address",
+		},
+		{
+			name:        "declined by stripe",
+			payload:     stripePayload{Currency: "idr", ProductID: "1"},
+			fail:        stripe.ErrorCodeCardDeclined,
+			wantMessage: "Your cards was declined",
+		},
+		{
+			name:        "too small for stripe",
+			payload:     stripePayload{Currency: "idr", ProductID: "1"},
+			fail:        stripe.ErrorCodeAmountTooSmall,
+			wantMessage: "The amount is too small to charge to your cards",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ta := newTestApp(t)
+			ta.config.manualCapture = tt.manualCapture
+			if tt.fail != "" {
+				ta.stripe.FailNext(tt.fail)
+			}
+
+			var resp struct {
+				OK           *bool              `json:"ok"`
+				Message      string             `json:"message"`
+				ClientSecret string             `json:"client_secret"`
+				Breakdown    *pricing.Breakdown `json:"breakdown"`
+			}
+			res := ta.postJSON(t, "/api/payment-intent", tt.payload, &resp)
+			if res.StatusCode != http.StatusOK {
+				t.Fatalf("status = %d, want 200", res.StatusCode)
+			}
+
+			if !tt.wantOK {
+				if resp.OK == nil || *resp.OK || resp.Message != tt.wantMessage {
+					t.Fatalf("got ok %v message %q, want not ok with %q", resp.OK, resp.Message, tt.wantMessage)
+				}
+				if n := len(ta.stripe.PaymentIntents()); n != 0 {
+					t.Errorf("%d payment intents created, want none", n)
+				}
+				return
+			}
+
+			id, _, found := strings.Cut(resp.ClientSecret, "_secret_")
+			if !found {
+				t.Fatalf("client secret %q, message %q", resp.ClientSecret, resp.Message)
+			}
+			pi, ok := ta.stripe.PaymentIntent(id)
+			if !ok {
+				t.Fatalf("payment intent %s not found", id)
+			}
+			if pi.Amount != tt.wantAmount {
+				t.Errorf("charged %d, want %d", pi.Amount, tt.wantAmount)
+			}
+			if (pi.CaptureMethod == stripe.PaymentIntentCaptureMethodManual) != tt.manualCapture {
+				t.Errorf("capture method = %s, manual capture %v", pi.CaptureMethod, tt.manualCapture)
+			}
+
+			if resp.Breakdown == nil || int64(resp.Breakdown.Total) != tt.wantAmount {
+				t.Fatalf("breakdown = %+v, want total %d", resp.Breakdown, tt.wantAmount)
+			}
+			// whoever records the payment reads the breakdown back from stripe
+			b, ok := pricing.FromMetadata(pi.Metadata)
+			if !ok || b != *resp.Breakdown || b.WidgetID != 1 {
+				t.Errorf("metadata breakdown = %+v, want %+v", b, *resp.Breakdown)
+			}
+		})
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	ta := newTestApp(t)
+	ta.postJSON(t, "/api/payment-intent", stripePayload{Currency: "idr", ProductID: "1"}, nil)
+	ta.stripe.FailNext(stripe.ErrorCodeCardDeclined)
+	ta.postJSON(t, "/api/payment-intent", stripePayload{Currency: "idr", ProductID: "1"}, nil)
+
+	// metrics are only served to scrapers with the token
+	if resp, _ := ta.request(t, http.MethodGet, "/metrics", "", nil); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("metrics without a token set = %d, want 404", resp.StatusCode)
+	}
+	ta.config.metricsToken = "scrape-me"
+	if resp, _ := ta.request(t, http.MethodGet, "/metrics", "wrong", nil); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("metrics with the wrong token = %d, want 401", resp.StatusCode)
+	}
+	resp, b := ta.request(t, http.MethodGet, "/metrics", "scrape-me", nil)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("metrics = %d, want 200", resp.StatusCode)
+	}
+	body := string(b)
+	for _, line := range []string{
+		`http_requests_total{method="POST",route="/api/payment-intent",status="200"} 2`,
+		`payment_intents_created_total{outcome="created"} 1`,
+		`payment_intents_created_total{outcome="card_declined"} 1`,
+		`stripe_request_duration_seconds_count{op="payment_intent.create"} 2`,
+		`stripe_errors_total{op="payment_intent.create",type="card_error"} 1`,
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("no %s in\n%s", line, body)
+		}
+	}
+}
+
+func TestPaymentIntentLimits(t *testing.T) {
+	product := stripePayload{Currency: "idr", ProductID: "1"}
+
+	t.Run("per client", func(t *testing.T) {
+		ta := newTestApp(t)
+		ta.limiter.Limits = map[string]ratelimit.Limit{"/api/payment-intent": {N: 2, Per: time.Minute}}
+		for i := 0; i < 2; i++ {
+			if resp := ta.postJSON(t, "/api/payment-intent", product, nil); resp.StatusCode != http.StatusOK {
+				t.Fatalf("request %d = %d, want 200", i+1, resp.StatusCode)
+			}
+		}
+		var out jsonResponse
+		resp := ta.postJSON(t, "/api/payment-intent", product, &out)
+		if resp.StatusCode != http.StatusTooManyRequests || resp.Header.Get("Retry-After") != "30" || out.OK {
+			t.Errorf("third request = %d retry after %q %+v, want 429 after 30", resp.StatusCode, resp.Header.Get("Retry-After"), out)
+		}
+		if n := len(ta.stripe.PaymentIntents()); n != 2 {
+			t.Errorf("%d payment intents created, want 2", n)
+		}
+	})
+
+	t.Run("per card", func(t *testing.T) {
+		ta := newTestApp(t)
+		ta.limiter.Limits = map[string]ratelimit.Limit{"/api/payment-intent": {N: 1, Per: time.Minute}}
+		tests := []struct {
+			addr, paymentMethod string
+			wantStatus          int
+		}{
+			{"203.0.113.1:5000", stripetest.CardVisa, http.StatusOK},
+			// the same card from a new address
+			{"203.0.113.2:5000", stripetest.CardVisa, http.StatusTooManyRequests},
+			{"203.0.113.3:5000", stripetest.CardInsufficientFunds, http.StatusOK},
+		}
+		for _, tt := range tests {
+			p := product
+			p.PaymentMethod = tt.paymentMethod
+			b, _ := json.Marshal(p)
+			r := httptest.NewRequest(http.MethodPost, "/api/payment-intent", bytes.NewReader(b))
+			r.RemoteAddr = tt.addr
+			w := httptest.NewRecorder()
+			ta.routes().ServeHTTP(w, r)
+			if w.Code != tt.wantStatus {
+				t.Errorf("%s from %s = %d, want %d", tt.paymentMethod, tt.addr, w.Code, tt.wantStatus)
+			}
+		}
+		pis := ta.stripe.PaymentIntents()
+		if len(pis) != 2 || pis[0].PaymentMethod == nil || pis[0].PaymentMethod.ID != stripetest.CardVisa {
+			t.Errorf("payment intents = %+v, want one for each card", pis)
+		}
+	})
+
+	t.Run("declined at creation", func(t *testing.T) {
+		ta := newTestApp(t)
+		ta.lockout.Limit = ratelimit.Limit{N: 2, Per: time.Hour}
+		for i := 0; i < 2; i++ {
+			ta.stripe.FailNext(stripe.ErrorCodeCardDeclined)
+			ta.postJSON(t, "/api/payment-intent", product, nil)
+		}
+		var out jsonResponse
+		resp := ta.postJSON(t, "/api/payment-intent", product, &out)
+		if resp.StatusCode != http.StatusTooManyRequests || out.Message != "Too many declined payments, please try again later" {
+			t.Errorf("after 2 declines = %d %+v, want locked out", resp.StatusCode, out)
+		}
+	})
+
+	t.Run("declined by webhook", func(t *testing.T) {
+		ta := newTestApp(t)
+		ta.config.stripe.webhookSecret = "whsec_test"
+		ta.lockout.Limit = ratelimit.Limit{N: 1, Per: time.Hour}
+
+		declined := product
+		declined.Email = "Budi@Example.com"
+		declined.PaymentMethod = stripetest.CardDeclined
+		var created paymentIntentResponse
+		ta.postJSON(t, "/api/payment-intent", declined, &created)
+		id, _, _ := strings.Cut(created.ClientSecret, "_secret_")
+		pi, err := ta.stripe.Confirm(id, stripetest.CardDeclined)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if pi.Metadata["client_ip"] == "" || pi.Metadata["email"] != "budi@example.com" {
+			t.Fatalf("metadata = %v, want the client and email", pi.Metadata)
+		}
+		// from another client, so only the email and card are locked out
+		delete(pi.Metadata, "client_ip")
+		ta.webhook(t, "payment_intent.payment_failed", pi)
+
+		tests := []struct {
+			email, paymentMethod string
+			wantStatus           int
+		}{
+			{"budi@example.com", stripetest.CardVisa, http.StatusTooManyRequests},
+			{"siti@example.com", stripetest.CardDeclined, http.StatusTooManyRequests},
+			{"siti@example.com", stripetest.CardVisa, http.StatusOK},
+		}
+		for _, tt := range tests {
+			p := product
+			p.Email, p.PaymentMethod = tt.email, tt.paymentMethod
+			if resp := ta.postJSON(t, "/api/payment-intent", p, nil); resp.StatusCode != tt.wantStatus {
+				t.Errorf("%s with %s = %d, want %d", tt.email, tt.paymentMethod, resp.StatusCode, tt.wantStatus)
+			}
+		}
+	})
+}
+
+func TestCORS(t *testing.T) {
+	tests := []struct {
+		name        string
+		web         string
+		corsOrigins string
+		origin      string
+		want        string
+	}{
+		{"web front end", "http://localhost:4000/", "", "http://localhost:4000", "http://localhost:4000"},
+		{"anywhere else", "http://localhost:4000", "", "https://evil.example", ""},
+		{"configured", "http://localhost:4000", "https://shop.example.com, https://admin.example.com", "https://admin.example.com", "https://admin.example.com"},
+		{"configured replaces web", "http://localhost:4000", "https://shop.example.com", "http://localhost:4000", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ta := newTestApp(t)
+			ta.config.web = tt.web
+			ta.config.corsOrigins = tt.corsOrigins
+
+			req, _ := http.NewRequest(http.MethodOptions, ta.server.URL+"/api/payment-intent", nil)
+			req.Header.Set("Origin", tt.origin)
+			req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+			req.Header.Set("Access-Control-Request-Headers", "content-type,traceparent")
+			resp, err := ta.server.Client().Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if got := resp.Header.Get("Access-Control-Allow-Origin"); got != tt.want {
+				t.Errorf("Access-Control-Allow-Origin = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestInvoice(t *testing.T) {
+	ta := newTestApp(t)
+	order := ta.order(t, "pi_invoice", 1000050)
+	admin := ta.login(t)
+
+	tests := []struct {
+		name       string
+		path       string
+		token      string
+		wantStatus int
+	}{
+		{"receipt token", "/api/receipts/" + order.ReceiptToken + "/invoice.pdf", "", http.StatusOK},
+		{"unknown receipt token", "/api/receipts/nope/invoice.pdf", "", http.StatusNotFound},
+		{"order id", fmt.Sprintf("/api/orders/%d/invoice.pdf", order.ID), "", http.StatusNotFound},
+		{"admin without a token", fmt.Sprintf("/api/admin/orders/%d/invoice.pdf", order.ID), "", http.StatusUnauthorized},
+		{"admin", fmt.Sprintf("/api/admin/orders/%d/invoice.pdf", order.ID), admin, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, body := ta.request(t, http.MethodGet, tt.path, tt.token, nil)
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("GET %s = %d, want %d", tt.path, resp.StatusCode, tt.wantStatus)
+			}
+			if tt.wantStatus == http.StatusOK && !bytes.HasPrefix(body, []byte("%PDF")) {
+				t.Errorf("GET %s = %.20q, want a pdf", tt.path, body)
+			}
+		})
+	}
+}
+
+func TestSalesSummary(t *testing.T) {
+	ta := newTestApp(t)
+	ta.order(t, "pi_sale_1", 100000)
+	ta.order(t, "pi_sale_2", 300000)
+	declined := ta.order(t, "pi_sale_3", 500000)
+	err := ta.store.UpdateTransactionStatus(context.Background(), declined.TransactionID, models.TransactionStatusDeclined, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, body := ta.request(t, http.MethodGet, "/api/admin/reports/sales", ta.login(t), nil)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET /api/admin/reports/sales = %d %s, want 200", resp.StatusCode, body)
+	}
+	var got struct {
+		Summaries []models.SalesSummary `json:"summaries"`
+	}
+	if err = json.Unmarshal(body, &got); err != nil {
+		t.Fatal(err)
+	}
+	want := []models.SalesSummary{{Currency: "idr", Orders: 2, Gross: 400000, Net: 400000, AverageOrderValue: 200000}}
+	if fmt.Sprint(got.Summaries) != fmt.Sprint(want) {
+		t.Errorf("summaries = %+v, want %+v", got.Summaries, want)
+	}
+}
+
+func TestTerminalPaymentIntent(t *testing.T) {
+	tests := []struct {
+		name   string
+		login  bool
+		amount string
+
+		wantStatus  int
+		wantMessage string
+	}{
+		{name: "not logged in", amount: "5000000", wantStatus: http.StatusUnauthorized, wantMessage: "invalid authentication credentials"},
+		{name: "logged in", login: true, amount: "5000000", wantStatus: http.StatusOK},
+		{name: "no amount", login: true, amount: "0", wantStatus: http.StatusOK, wantMessage: "invalid amount"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ta := newTestApp(t)
+			var token string
+			if tt.login {
+				token = ta.login(t)
+			}
+			body, _ := json.Marshal(stripePayload{Currency: "idr", Amount: tt.amount})
+			resp, b := ta.request(t, http.MethodPost, "/api/admin/terminal/payment-intent", token, bytes.NewReader(body))
+			var out struct {
+				Message      string `json:"message"`
+				ClientSecret string `json:"client_secret"`
+			}
+			json.Unmarshal(b, &out)
+			if resp.StatusCode != tt.wantStatus || out.Message != tt.wantMessage {
+				t.Fatalf("got %d %q, want %d %q", resp.StatusCode, out.Message, tt.wantStatus, tt.wantMessage)
+			}
+
+			pis := ta.stripe.PaymentIntents()
+			if tt.wantMessage != "" {
+				if len(pis) != 0 {
+					t.Errorf("%d payment intents created, want none", len(pis))
+				}
+				return
+			}
+			if len(pis) != 1 || pis[0].Amount != 5000000 || !strings.HasPrefix(out.ClientSecret, pis[0].ID) {
+				t.Errorf("payment intents = %+v, want one for 5000000", pis)
+			}
+		})
+	}
+}
+
+func TestCouponReservation(t *testing.T) {
+	ta := newTestApp(t)
+	ta.config.stripe.webhookSecret = "whsec_test"
+	ctx := context.Background()
+	coupon := ta.seed.Coupon
+	coupon.Code, coupon.MaxUses = "ONCE", 1
+	ta.store.AddCoupon(coupon)
+	payload := stripePayload{Currency: "idr", ProductID: "1", CouponCode: "once"}
+	timesUsed := func() int {
+		c, err := ta.store.GetCouponByCode(ctx, "ONCE")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c.TimesUsed
+	}
+
+	// checkout creates a payment intent with the coupon, returning its id or
+	// "" when the coupon was refused
+	checkout := func() string {
+		t.Helper()
+		var out paymentIntentResponse
+		ta.postJSON(t, "/api/payment-intent", payload, &out)
+		if out.ClientSecret == "" {
+			return ""
+		}
+		pis := ta.stripe.PaymentIntents()
+		return pis[len(pis)-1].ID
+	}
+
+	// a payment stripe refuses never holds the coupon
+	ta.stripe.FailNext(stripe.ErrorCodeCardDeclined)
+	ta.postJSON(t, "/api/payment-intent", payload, nil)
+
+	first := checkout()
+	if first == "" || timesUsed() != 0 {
+		t.Fatalf("first checkout = %q with the coupon used %d times, want it held but not used", first, timesUsed())
+	}
+
+	// the only use is held before anyone has paid, and the payment intent
+	// made for the second checkout can't be paid with the discount
+	var second jsonResponse
+	ta.postJSON(t, "/api/payment-intent", payload, &second)
+	if second.OK || second.Message != pricing.ErrCouponUsedUp.Error() {
+		t.Errorf("second checkout = %+v, want the coupon used up", second)
+	}
+	pis := ta.stripe.PaymentIntents()
+	if last := pis[len(pis)-1]; last.ID == first || last.Status != stripe.PaymentIntentStatusCanceled {
+		t.Errorf("second payment intent %s is %s, want it cancelled", last.ID, last.Status)
+	}
+
+	// abandoned, so stripe cancels it and the hold is given back
+	ta.webhook(t, "payment_intent.canceled", stripe.PaymentIntent{ID: first})
+	third := checkout()
+	if third == "" {
+		t.Fatal("third checkout refused, want the cancelled hold given back")
+	}
+
+	// paid, so the hold becomes the coupon's use, once however often stripe
+	// sends the event
+	for i := 0; i < 2; i++ {
+		ta.webhook(t, "payment_intent.succeeded", stripe.PaymentIntent{ID: third})
+	}
+	if n := timesUsed(); n != 1 {
+		t.Errorf("coupon used %d times after payment, want 1", n)
+	}
+	ta.webhook(t, "payment_intent.canceled", stripe.PaymentIntent{ID: third})
+	if checkout() != "" {
+		t.Error("checkout after the coupon was used up succeeded")
+	}
+}
+
+func TestUpdateOrderStatus(t *testing.T) {
+	ta := newTestApp(t)
+	admin := ta.login(t)
+	// paid records an order for a card payment stripe has taken
+	paid := func(t *testing.T) models.Order {
+		t.Helper()
+		var out paymentIntentResponse
+		ta.postJSON(t, "/api/payment-intent", stripePayload{Currency: "idr", ProductID: "1"}, &out)
+		for _, pi := range ta.stripe.PaymentIntents() {
+			if strings.HasPrefix(out.ClientSecret, pi.ID) {
+				if _, err := ta.stripe.Confirm(pi.ID, stripetest.CardVisa); err != nil {
+					t.Fatal(err)
+				}
+				return ta.order(t, pi.ID, int(pi.Amount))
+			}
+		}
+		t.Fatalf("no payment intent for %+v", out)
+		return models.Order{}
+	}
+	status := func(order models.Order, name string) *http.Response {
+		body := strings.NewReader(`{"status":"` + name + `"}`)
+		resp, _ := ta.request(t, http.MethodPost, fmt.Sprintf("/api/admin/orders/%d/status", order.ID), admin, body)
+		return resp
+	}
+
+	t.Run("refunded twice at once", func(t *testing.T) {
+		order := paid(t)
+		codes := make(chan int, 2)
+		var wg sync.WaitGroup
+		for i := 0; i < 2; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				codes <- status(order, "refunded").StatusCode
+			}()
+		}
+		wg.Wait()
+		close(codes)
+
+		got := map[int]int{}
+		for code := range codes {
+			got[code]++
+		}
+		if got[http.StatusOK] != 1 || got[http.StatusConflict] != 1 {
+			t.Errorf("responses = %v, want one 200 and one 409", got)
+		}
+		if n := len(ta.stripe.Refunds()); n != 1 {
+			t.Errorf("%d refunds, want 1", n)
+		}
+	})
+
+	t.Run("payment declined", func(t *testing.T) {
+		order := paid(t)
+		err := ta.store.UpdateTransactionStatus(context.Background(), order.TransactionID, models.TransactionStatusDeclined, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp := status(order, "packed"); resp.StatusCode != http.StatusConflict {
+			t.Errorf("packing = %d, want 409", resp.StatusCode)
+		}
+		got, err := ta.store.GetOrder(context.Background(), order.ID)
+		if err != nil || got.StatusID != order.StatusID {
+			t.Errorf("order status = %d, %v, want it left at %d", got.StatusID, err, order.StatusID)
+		}
+	})
+
+	t.Run("stripe fails", func(t *testing.T) {
+		order := paid(t)
+		ta.stripe.FailNext(stripe.ErrorCodeProcessingError)
+		if resp := status(order, "cancelled"); resp.StatusCode != http.StatusBadGateway {
+			t.Fatalf("cancel = %d, want 502", resp.StatusCode)
+		}
+		got, err := ta.store.GetOrder(context.Background(), order.ID)
+		if err != nil || got.StatusID != order.StatusID {
+			t.Errorf("order status = %d, %v, want it back at %d", got.StatusID, err, order.StatusID)
+		}
+		events, _ := ta.store.GetOrderEvents(context.Background(), order.ID)
+		if len(events) != 2 || events[1].ToStatusID != order.StatusID {
+			t.Errorf("events = %+v, want the cancellation and its revert", events)
+		}
+	})
+
+	t.Run("stripe answers too late", func(t *testing.T) {
+		order := paid(t)
+		refunds := len(ta.stripe.Refunds())
+		ta.config.stripe.timeout = 50 * time.Millisecond
+		defer func() { ta.config.stripe.timeout = 0 }()
+		ta.stripe.DelayNext(time.Second)
+
+		if resp := status(order, "cancelled"); resp.StatusCode != http.StatusBadGateway {
+			t.Fatalf("cancel = %d, want 502", resp.StatusCode)
+		}
+		if n := len(ta.stripe.Refunds()); n != refunds+1 {
+			t.Fatalf("%d refunds, want %d", n, refunds+1)
+		}
+		// the refund went through, so the order mustn't go back to paid
+		got, err := ta.store.GetOrder(context.Background(), order.ID)
+		if err != nil || got.StatusID != models.OrderStatusCancelled {
+			t.Errorf("order status = %d, %v, want it left cancelled", got.StatusID, err)
+		}
+	})
+}
+
+func TestCaptureOrder(t *testing.T) {
+	ta := newTestApp(t)
+	ta.config.manualCapture = true
+	admin := ta.login(t)
+	ctx := context.Background()
+
+	// authorized records an order for a card stripe has only authorized
+	authorized := func(t *testing.T) models.Order {
+		t.Helper()
+		var out paymentIntentResponse
+		ta.postJSON(t, "/api/payment-intent", stripePayload{Currency: "idr", ProductID: "1"}, &out)
+		id, _, _ := strings.Cut(out.ClientSecret, "_secret_")
+		pi, err := ta.stripe.Confirm(id, stripetest.CardVisa)
+		if err != nil {
+			t.Fatal(err)
+		}
+		order := ta.order(t, pi.ID, int(pi.Amount))
+		if err = ta.store.UpdateTransactionStatus(ctx, order.TransactionID, models.TransactionStatusAuthorized, ""); err != nil {
+			t.Fatal(err)
+		}
+		return order
+	}
+	capture := func(order models.Order, body string) (*http.Response, authorizationResponse) {
+		var r io.Reader
+		if body != "" {
+			// sent chunked, with no length, as some clients do
+			r = io.MultiReader(strings.NewReader(body))
+		}
+		resp, b := ta.request(t, http.MethodPost, fmt.Sprintf("/api/admin/orders/%d/capture", order.ID), admin, r)
+		var out authorizationResponse
+		json.Unmarshal(b, &out)
+		return resp, out
+	}
+
+	order := authorized(t)
+	for _, body := range []string{`{"amount":0}`, `{"amount":-1}`, `{"amount":111000001}`} {
+		if _, out := capture(order, body); out.OK {
+			t.Errorf("capture %s = %+v, want refused", body, out)
+		}
+	}
+	if _, out := capture(order, ""); !out.OK || out.AmountCaptured != 111000000 {
+		t.Errorf("capture without an amount = %+v, want all 111000000", out)
+	}
+
+	order = authorized(t)
+	if _, out := capture(order, `{"amount":55500000}`); !out.OK || out.AmountCaptured != 55500000 {
+		t.Fatalf("partial capture = %+v, want 55500000", out)
+	}
+	got, err := ta.store.GetOrder(ctx, order.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	txn, err := ta.store.GetTransaction(ctx, order.TransactionID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Amount != 55500000 || txn.Amount != 55500000 || txn.TransactionStatusID != models.TransactionStatusCleared {
+		t.Errorf("order %d and transaction %d (status %d), want both 55500000 cleared", got.Amount, txn.Amount, txn.TransactionStatusID)
+	}
+}
@@ -0,0 +1,369 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"go-stripe/internal/audit"
+	"go-stripe/internal/cards"
+	"go-stripe/internal/models"
+	"io"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stripe/stripe-go/v72/webhook"
+)
+
+// disputeLookback is how far back the sync job asks stripe for disputes.
+// Cardholders can dispute a charge for up to 120 days
+const disputeLookback = 120 * 24 * time.Hour
+
+// maxEvidenceFile is the largest evidence file stripe accepts
+const maxEvidenceFile = 5 << 20
+
+// StripeWebhook receives events from stripe. Disputes are saved, failed
+// payments counted towards the decline lockout and the coupon a payment
+// held confirmed or given back once it is paid, fails or is cancelled. Other
+// events are acknowledged and ignored
+func (app *application) StripeWebhook(w http.ResponseWriter, r *http.Request) {
+	if app.config.stripe.webhookSecret == "" {
+		app.errorJSON(w, errors.New("webhooks are not configured"), http.StatusNotFound)
+		return
+	}
+
+	payload, err := io.ReadAll(http.MaxBytesReader(w, r.Body, 65536))
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	event, err := webhook.ConstructEvent(payload, r.Header.Get("Stripe-Signature"), app.config.stripe.webhookSecret)
+	if err != nil {
+		app.errorJSON(w, errors.New("invalid signature"))
+		return
+	}
+
+	if cards.IsDisputeEvent(event) {
+		d, err := cards.DisputeFromEvent(event)
+		if err != nil {
+			app.logger.ErrorContext(r.Context(), err.Error())
+			app.errorJSON(w, err)
+			return
+		}
+		if err = app.Models.Disputes.SaveDispute(r.Context(), cards.DisputeRecord(d)); err != nil {
+			app.logger.ErrorContext(r.Context(), err.Error())
+			// stripe retries the event until we answer with a 2xx
+			app.errorJSON(w, errors.New("could not save dispute"), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if cards.IsPaymentFailedEvent(event) || cards.IsPaymentSucceededEvent(event) || cards.IsPaymentCanceledEvent(event) {
+		pi, err := cards.PaymentIntentFromEvent(event)
+		if err != nil {
+			app.logger.ErrorContext(r.Context(), err.Error())
+			app.errorJSON(w, err)
+			return
+		}
+		if cards.IsPaymentFailedEvent(event) && pi.LastPaymentError != nil && cards.IsDecline(pi.LastPaymentError) {
+			app.countDecline(r.Context(), declineKeys(pi))
+		}
+
+		// the coupon the checkout held becomes a use once it is paid for, and
+		// is given back if it never will be
+		settle := app.Models.Coupons.ReleaseCoupon
+		if cards.IsPaymentSucceededEvent(event) {
+			settle = app.Models.Coupons.ConfirmCoupon
+		}
+		if err = settle(r.Context(), pi.ID); err != nil {
+			app.logger.ErrorContext(r.Context(), err.Error())
+			// stripe retries the event until we answer with a 2xx
+			app.errorJSON(w, errors.New("could not update coupon"), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	app.writeJSON(w, http.StatusOK, jsonResponse{OK: true})
+}
+
+// syncDisputes pulls recent disputes from stripe, for when webhooks are
+// missed or not set up. It returns how many disputes were saved
+func (app *application) syncDisputes(ctx context.Context) (int, error) {
+	card := cards.Card{
+		Secret:  app.config.stripe.secret,
+		Key:     app.config.stripe.key,
+		Timeout: app.config.stripe.timeout,
+		Logger:  app.logger,
+		Metrics: app.stripeMetrics,
+	}
+	disputes, err := card.ListDisputes(ctx, time.Now().Add(-disputeLookback))
+	if err != nil {
+		return 0, err
+	}
+
+	for i, d := range disputes {
+		if err = app.Models.Disputes.SaveDispute(ctx, cards.DisputeRecord(d)); err != nil {
+			return i, err
+		}
+	}
+	return len(disputes), nil
+}
+
+// runDisputeSync calls syncDisputes every interval until the program exits
+func (app *application) runDisputeSync(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		n, err := app.syncDisputes(context.Background())
+		if err != nil {
+			app.logger.Error("dispute sync failed", "err", err)
+		} else {
+			app.logger.Info("dispute sync", "checked", n)
+		}
+		<-ticker.C
+	}
+}
+
+// SyncDisputes pulls disputes from stripe now rather than waiting for the
+// next scheduled sync
+func (app *application) SyncDisputes(w http.ResponseWriter, r *http.Request) {
+	n, err := app.syncDisputes(r.Context())
+	if err != nil {
+		app.logger.ErrorContext(r.Context(), err.Error())
+		app.errorJSON(w, errors.New("could not sync disputes from stripe"), http.StatusBadGateway)
+		return
+	}
+	app.writeJSON(w, http.StatusOK, jsonResponse{
+		OK:      true,
+		Message: fmt.Sprintf("%d disputes checked", n),
+	})
+}
+
+// AllDisputes returns a page of disputes, the ones waiting on us first
+func (app *application) AllDisputes(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	pageSize, _ := strconv.Atoi(q.Get("page_size"))
+	page, _ := strconv.Atoi(q.Get("page"))
+	if pageSize < 1 || pageSize > 100 {
+		pageSize = 20
+	}
+	if page < 1 {
+		page = 1
+	}
+
+	disputes, lastPage, totalRecords, err := app.Models.Disputes.GetDisputesPaginated(r.Context(), q.Get("status"), pageSize, page)
+	if err != nil {
+		app.logger.ErrorContext(r.Context(), err.Error())
+		app.errorJSON(w, errors.New("could not load disputes"), http.StatusInternalServerError)
+		return
+	}
+
+	var resp struct {
+		CurrentPage  int               `json:"current_page"`
+		PageSize     int               `json:"page_size"`
+		LastPage     int               `json:"last_page"`
+		TotalRecords int               `json:"total_records"`
+		Disputes     []*models.Dispute `json:"disputes"`
+	}
+	resp.CurrentPage = page
+	resp.PageSize = pageSize
+	resp.LastPage = lastPage
+	resp.TotalRecords = totalRecords
+	resp.Disputes = disputes
+
+	app.writeJSON(w, http.StatusOK, resp)
+}
+
+// disputeDetailResponse is a dispute with the transaction it was raised against
+type disputeDetailResponse struct {
+	Dispute     *models.Dispute     `json:"dispute"`
+	Transaction *models.Transaction `json:"transaction,omitempty"`
+	FileFields  []string            `json:"file_fields"`
+}
+
+// OneDispute returns a dispute with its evidence for the admin dispute page
+func (app *application) OneDispute(w http.ResponseWriter, r *http.Request) {
+	d, status, err := app.loadDispute(r)
+	if err != nil {
+		app.errorJSON(w, err, status)
+		return
+	}
+
+	resp := disputeDetailResponse{
+		Dispute:    d,
+		FileFields: cards.EvidenceFileFields,
+	}
+	if d.TransactionID > 0 {
+		txn, err := app.Models.Transactions.GetTransaction(r.Context(), d.TransactionID)
+		if err != nil {
+			app.logger.ErrorContext(r.Context(), err.Error())
+		} else {
+			resp.Transaction = &txn
+		}
+	}
+	app.writeJSON(w, http.StatusOK, resp)
+}
+
+// loadDispute gets the dispute named in the url, along with the status code
+// to answer with if it can't
+func (app *application) loadDispute(r *http.Request) (*models.Dispute, int, error) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		return nil, http.StatusBadRequest, errors.New("invalid dispute id")
+	}
+	d, err := app.Models.Disputes.GetDispute(r.Context(), id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, http.StatusNotFound, errors.New("dispute not found")
+	}
+	if err != nil {
+		app.logger.ErrorContext(r.Context(), err.Error())
+		return nil, http.StatusInternalServerError, errors.New("could not load dispute")
+	}
+	return d, http.StatusOK, nil
+}
+
+// UpdateDisputeEvidence saves our written evidence for a dispute and sends it
+// to stripe along with any uploaded files. The evidence is staged unless
+// submit is true, after which it can't be changed
+func (app *application) UpdateDisputeEvidence(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		Evidence models.DisputeEvidence `json:"evidence"`
+		Submit   bool                   `json:"submit"`
+	}
+	if err := app.readJSON(w, r, &payload); err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	d, status, err := app.loadDispute(r)
+	if err != nil {
+		app.errorJSON(w, err, status)
+		return
+	}
+	if !d.NeedsResponse() {
+		app.errorJSON(w, fmt.Errorf("dispute is %s and no longer takes evidence", d.Status), http.StatusConflict)
+		return
+	}
+
+	card := cards.Card{
+		Secret:  app.config.stripe.secret,
+		Key:     app.config.stripe.key,
+		Timeout: app.config.stripe.timeout,
+		Logger:  app.logger,
+		Metrics: app.stripeMetrics,
+	}
+	sd, err := card.UpdateDisputeEvidence(r.Context(), d.StripeDisputeID, payload.Evidence, d.Files, payload.Submit)
+	if err != nil {
+		app.logger.ErrorContext(r.Context(), err.Error())
+		app.errorJSON(w, errors.New("stripe could not update the dispute"), http.StatusBadGateway)
+		return
+	}
+
+	// stripe has the evidence, so we keep our copy
+	ctx := context.WithoutCancel(r.Context())
+
+	err = app.Models.Disputes.SaveDisputeEvidence(ctx, d.ID, payload.Evidence, payload.Submit)
+	if err == nil {
+		err = app.Models.Disputes.SaveDispute(ctx, cards.DisputeRecord(sd))
+	}
+	if err != nil {
+		app.logger.ErrorContext(r.Context(), err.Error())
+		app.errorJSON(w, errors.New("evidence sent to stripe but could not be saved"), http.StatusInternalServerError)
+		return
+	}
+
+	after, err := app.Models.Disputes.GetDispute(ctx, d.ID)
+	if err != nil {
+		app.logger.ErrorContext(r.Context(), err.Error())
+		app.errorJSON(w, errors.New("could not load dispute"), http.StatusInternalServerError)
+		return
+	}
+	app.recordAudit(r, audit.Event{
+		Action:     audit.ActionDisputeUpdate,
+		EntityType: audit.EntityDispute,
+		EntityID:   d.ID,
+		Before:     d,
+		After:      after,
+	})
+
+	app.writeJSON(w, http.StatusOK, disputeDetailResponse{
+		Dispute:    after,
+		FileFields: cards.EvidenceFileFields,
+	})
+}
+
+// UploadDisputeFile uploads a file posted as multipart form data to stripe as
+// one piece of evidence for a dispute. It is sent to the bank with the rest
+// of the evidence by UpdateDisputeEvidence
+func (app *application) UploadDisputeFile(w http.ResponseWriter, r *http.Request) {
+	d, status, err := app.loadDispute(r)
+	if err != nil {
+		app.errorJSON(w, err, status)
+		return
+	}
+	if !d.NeedsResponse() {
+		app.errorJSON(w, fmt.Errorf("dispute is %s and no longer takes evidence", d.Status), http.StatusConflict)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxEvidenceFile+65536)
+	if err = r.ParseMultipartForm(maxEvidenceFile); err != nil {
+		app.errorJSON(w, errors.New("evidence files must be smaller than 5MB"))
+		return
+	}
+
+	field := r.FormValue("field")
+	if !cards.IsEvidenceFileField(field) {
+		app.errorJSON(w, cards.ErrUnknownEvidenceField)
+		return
+	}
+
+	f, header, err := r.FormFile("file")
+	if err != nil {
+		app.errorJSON(w, errors.New("no file uploaded"))
+		return
+	}
+	defer f.Close()
+
+	card := cards.Card{
+		Secret:  app.config.stripe.secret,
+		Key:     app.config.stripe.key,
+		Timeout: app.config.stripe.timeout,
+		Logger:  app.logger,
+		Metrics: app.stripeMetrics,
+	}
+	filename := filepath.Base(header.Filename)
+	sf, err := card.UploadEvidence(r.Context(), filename, f)
+	if err != nil {
+		app.logger.ErrorContext(r.Context(), err.Error())
+		app.errorJSON(w, errors.New("stripe could not accept the file"), http.StatusBadGateway)
+		return
+	}
+
+	file := models.DisputeFile{
+		DisputeID:    d.ID,
+		StripeFileID: sf.ID,
+		Field:        field,
+		Filename:     filename,
+		UserID:       currentUser(r).ID,
+	}
+	// stripe has the file, so it is recorded
+	file.ID, err = app.Models.Disputes.InsertDisputeFile(context.WithoutCancel(r.Context()), file)
+	if err != nil {
+		app.logger.ErrorContext(r.Context(), err.Error())
+		app.errorJSON(w, errors.New("file uploaded to stripe but could not be saved"), http.StatusInternalServerError)
+		return
+	}
+	app.recordAudit(r, audit.Event{
+		Action:     audit.ActionDisputeFile,
+		EntityType: audit.EntityDispute,
+		EntityID:   d.ID,
+		After:      file,
+	})
+
+	app.writeJSON(w, http.StatusOK, file)
+}
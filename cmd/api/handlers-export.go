@@ -0,0 +1,185 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"go-stripe/internal/export"
+	"go-stripe/internal/models"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// exportWriteTimeout replaces the server's write timeout for exports, which
+// stream for as long as there are rows to send
+const exportWriteTimeout = 5 * time.Minute
+
+// dateFilter reads the from and to query parameters as dates like
+// 2024-10-25. to is inclusive, so the time returned for it is midnight after
+// it. Either is zero when it isn't given
+func dateFilter(q url.Values) (from, to time.Time, err error) {
+	if s := q.Get("from"); s != "" {
+		from, err = time.Parse("2006-01-02", s)
+		if err != nil {
+			return from, to, errors.New("from must be a date like 2024-10-25")
+		}
+	}
+	if s := q.Get("to"); s != "" {
+		to, err = time.Parse("2006-01-02", s)
+		if err != nil {
+			return from, to, errors.New("to must be a date like 2024-10-25")
+		}
+		to = to.AddDate(0, 0, 1)
+	}
+	return from, to, nil
+}
+
+// startExport checks the format query parameter and sends the headers for a
+// download named after what is being exported. The writer it returns must be
+// closed once all rows are written
+func (app *application) startExport(w http.ResponseWriter, r *http.Request, name string) (export.Writer, error) {
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = export.CSV
+	}
+	if format != export.CSV && format != export.XLSX {
+		return nil, export.ErrUnknownFormat
+	}
+
+	// the server's write timeout is for ordinary responses
+	rc := http.NewResponseController(w)
+	if err := rc.SetWriteDeadline(time.Now().Add(exportWriteTimeout)); err != nil {
+		app.logger.ErrorContext(r.Context(), err.Error())
+	}
+
+	filename := fmt.Sprintf("%s-%s.%s", name, time.Now().Format("2006-01-02"), format)
+	w.Header().Set("Content-Type", export.ContentType(format))
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	w.WriteHeader(http.StatusOK)
+
+	return export.NewWriter(w, format, name)
+}
+
+// ExportOrders streams the orders matching the order list's filters as csv
+// or xlsx, chosen by the format query parameter
+func (app *application) ExportOrders(w http.ResponseWriter, r *http.Request) {
+	filter, err := orderFilter(r.URL.Query())
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	ew, err := app.startExport(w, r, "orders")
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	err = ew.Write([]any{"Order", "Date", "Status", "Customer", "Email", "Widget", "Quantity",
+		"Subtotal", "Discount", "Tax", "Amount", "Currency", "Coupon", "Card", "Payment Intent", "Charge",
+		"Carrier", "Tracking Number"})
+	if err == nil {
+		err = app.Models.Orders.EachOrder(r.Context(), filter, func(o *models.Order) error {
+			return ew.Write([]any{
+				o.ID,
+				o.CreatedAt,
+				o.Status,
+				strings.TrimSpace(o.Customer.FirstName + " " + o.Customer.LastName),
+				o.Customer.Email,
+				o.Widget.Name,
+				o.Quantity,
+				export.Money(o.Subtotal, o.Transaction.Currency),
+				export.Money(o.Discount, o.Transaction.Currency),
+				export.Money(o.Tax, o.Transaction.Currency),
+				export.Money(o.Amount, o.Transaction.Currency),
+				o.Transaction.Currency,
+				o.CouponCode,
+				o.Transaction.LastFour,
+				o.Transaction.PaymentIntent,
+				o.Transaction.BankReturnCode,
+				o.Carrier,
+				o.TrackingNumber,
+			})
+		})
+	}
+	if err == nil {
+		err = ew.Close()
+	}
+	if err != nil {
+		// the download has started so all we can do is cut it short
+		app.logger.ErrorContext(r.Context(), "export orders cut short", "err", err)
+	}
+}
+
+// ExportTransactions streams transactions as csv or xlsx, chosen by the
+// format query parameter. They can be narrowed down by the status id, source,
+// from and to query parameters
+func (app *application) ExportTransactions(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	var filter models.TransactionFilter
+	if s := q.Get("status"); s != "" {
+		id, err := strconv.Atoi(s)
+		if err != nil {
+			app.errorJSON(w, errors.New("invalid transaction status"))
+			return
+		}
+		filter.StatusID = id
+	}
+	switch s := q.Get("source"); s {
+	case "", models.TransactionSourceCheckout, models.TransactionSourceTerminal:
+		filter.Source = s
+	default:
+		app.errorJSON(w, errors.New("source must be checkout or terminal"))
+		return
+	}
+	var err error
+	filter.From, filter.To, err = dateFilter(q)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	ew, err := app.startExport(w, r, "transactions")
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	err = ew.Write([]any{"Transaction", "Date", "Source", "Status", "Amount", "Fee", "Net", "Currency",
+		"Customer", "Email", "Order", "Widget", "Quantity", "Card", "Payment Intent", "Charge", "Memo"})
+	if err == nil {
+		err = app.Models.Transactions.EachTransaction(r.Context(), filter, func(t *models.TransactionDetail) error {
+			var order any
+			if t.OrderID > 0 {
+				order = t.OrderID
+			}
+			return ew.Write([]any{
+				t.ID,
+				t.CreatedAt,
+				t.Source,
+				t.Status,
+				export.Money(t.Amount, t.Currency),
+				export.Money(t.Fee, t.Currency),
+				export.Money(t.Net, t.Currency),
+				t.Currency,
+				strings.TrimSpace(t.Customer.FirstName + " " + t.Customer.LastName),
+				t.Customer.Email,
+				order,
+				t.Widget.Name,
+				t.Quantity,
+				t.LastFour,
+				t.PaymentIntent,
+				t.BankReturnCode,
+				t.Memo,
+			})
+		})
+	}
+	if err == nil {
+		err = ew.Close()
+	}
+	if err != nil {
+		app.logger.ErrorContext(r.Context(), "export transactions cut short", "err", err)
+	}
+}
@@ -0,0 +1,309 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"go-stripe/internal/audit"
+	"go-stripe/internal/cards"
+	"go-stripe/internal/models"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// orderFilter reads the status, from and to query parameters that narrow
+// down the order list and export. from and to are dates as YYYY-MM-DD and to
+// is inclusive
+func orderFilter(q url.Values) (models.OrderFilter, error) {
+	var filter models.OrderFilter
+	if name := q.Get("status"); name != "" {
+		id, err := models.OrderStatusByName(name)
+		if err != nil {
+			return filter, err
+		}
+		filter.StatusID = id
+	}
+
+	var err error
+	filter.From, filter.To, err = dateFilter(q)
+	return filter, err
+}
+
+// AllOrders returns a page of orders for the admin order list
+func (app *application) AllOrders(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	pageSize, _ := strconv.Atoi(q.Get("page_size"))
+	page, _ := strconv.Atoi(q.Get("page"))
+	if pageSize < 1 || pageSize > 100 {
+		pageSize = 20
+	}
+	if page < 1 {
+		page = 1
+	}
+
+	filter, err := orderFilter(q)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	orders, lastPage, totalRecords, err := app.Models.Orders.GetAllOrdersPaginated(r.Context(), filter, pageSize, page)
+	if err != nil {
+		app.logger.ErrorContext(r.Context(), err.Error())
+		app.errorJSON(w, errors.New("could not load orders"), http.StatusInternalServerError)
+		return
+	}
+
+	var resp struct {
+		CurrentPage  int             `json:"current_page"`
+		PageSize     int             `json:"page_size"`
+		LastPage     int             `json:"last_page"`
+		TotalRecords int             `json:"total_records"`
+		Orders       []*models.Order `json:"orders"`
+	}
+	resp.CurrentPage = page
+	resp.PageSize = pageSize
+	resp.LastPage = lastPage
+	resp.TotalRecords = totalRecords
+	resp.Orders = orders
+
+	app.writeJSON(w, http.StatusOK, resp)
+}
+
+// orderDetailResponse is an order with its history and where it can go next
+type orderDetailResponse struct {
+	Order        *models.Order        `json:"order"`
+	Events       []*models.OrderEvent `json:"events"`
+	NextStatuses []string             `json:"next_statuses"`
+}
+
+// OneOrder returns an order with its history for the admin order page
+func (app *application) OneOrder(w http.ResponseWriter, r *http.Request) {
+	orderID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		app.errorJSON(w, errors.New("invalid order id"))
+		return
+	}
+
+	resp, status, err := app.orderDetail(r.Context(), orderID)
+	if err != nil {
+		app.errorJSON(w, err, status)
+		return
+	}
+	app.writeJSON(w, http.StatusOK, resp)
+}
+
+func (app *application) orderDetail(ctx context.Context, orderID int) (orderDetailResponse, int, error) {
+	var resp orderDetailResponse
+
+	order, err := app.Models.Orders.GetOrderDetail(ctx, orderID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return resp, http.StatusNotFound, errors.New("order not found")
+	}
+	if err != nil {
+		app.logger.ErrorContext(ctx, err.Error())
+		return resp, http.StatusInternalServerError, errors.New("could not load order")
+	}
+
+	events, err := app.Models.Orders.GetOrderEvents(ctx, orderID)
+	if err != nil {
+		app.logger.ErrorContext(ctx, err.Error())
+		return resp, http.StatusInternalServerError, errors.New("could not load order history")
+	}
+
+	resp.Order = order
+	resp.Events = events
+	resp.NextStatuses = models.NextOrderStatuses(order.StatusID)
+	return resp, http.StatusOK, nil
+}
+
+// UpdateOrderStatus moves an order along its fulfilment lifecycle. Shipping
+// captures a card that was only authorized, and cancelling or refunding gives
+// the customer their money back
+func (app *application) UpdateOrderStatus(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		Status         string `json:"status"`
+		TrackingNumber string `json:"tracking_number"`
+		Carrier        string `json:"carrier"`
+		Note           string `json:"note"`
+	}
+	if err := app.readJSON(w, r, &payload); err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	orderID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		app.errorJSON(w, errors.New("invalid order id"))
+		return
+	}
+
+	to, err := models.OrderStatusByName(payload.Status)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	order, err := app.Models.Orders.GetOrder(r.Context(), orderID)
+	if errors.Is(err, sql.ErrNoRows) {
+		app.errorJSON(w, errors.New("order not found"), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		app.logger.ErrorContext(r.Context(), err.Error())
+		app.errorJSON(w, errors.New("could not load order"), http.StatusInternalServerError)
+		return
+	}
+
+	change := models.OrderChange{
+		FromStatusID:   order.StatusID,
+		ToStatusID:     to,
+		TrackingNumber: payload.TrackingNumber,
+		Carrier:        payload.Carrier,
+		Note:           payload.Note,
+		UserID:         currentUser(r).ID,
+	}
+	if !models.CanTransition(change.FromStatusID, change.ToStatusID) {
+		app.errorJSON(w, models.ErrInvalidTransition, http.StatusConflict)
+		return
+	}
+	if to == models.OrderStatusShipped && (change.TrackingNumber == "" || change.Carrier == "") {
+		app.errorJSON(w, models.ErrTrackingRequired)
+		return
+	}
+
+	// the order follows whatever happens in stripe from here
+	ctx := context.WithoutCancel(r.Context())
+	// claim the move before any money moves, so two admins changing the
+	// same order can't both capture or refund it
+	err = app.Models.Orders.ChangeOrderStatus(ctx, order.ID, change)
+	if errors.Is(err, models.ErrOrderStatusConflict) || errors.Is(err, models.ErrInvalidTransition) || errors.Is(err, models.ErrOrderNotPaid) {
+		app.errorJSON(w, err, http.StatusConflict)
+		return
+	}
+	if err != nil {
+		app.logger.ErrorContext(r.Context(), err.Error())
+		app.errorJSON(w, errors.New("could not update order"), http.StatusInternalServerError)
+		return
+	}
+
+	moved, err := app.settlePayment(r, order, to)
+	if errors.Is(err, errStripeUnknown) {
+		// the money may have moved, so the order keeps its new status rather
+		// than going back to one that could be wrong
+		app.logger.ErrorContext(r.Context(), err.Error(), "order_id", order.ID)
+		app.errorJSON(w, err, http.StatusBadGateway)
+		return
+	}
+	if err != nil && !moved {
+		// nothing moved, so give the order back its old status
+		change.Note = "reverted: " + err.Error()
+		if rerr := app.Models.Orders.RevertOrderStatus(ctx, order.ID, change); rerr != nil {
+			app.logger.ErrorContext(r.Context(), rerr.Error(), "order_id", order.ID)
+		}
+		app.errorJSON(w, err, http.StatusBadGateway)
+		return
+	}
+	if err != nil {
+		// the money moved but our record of it didn't; the order keeps its
+		// new status and the reconciler picks up the transaction
+		app.logger.ErrorContext(r.Context(), err.Error(), "order_id", order.ID)
+		app.errorJSON(w, errors.New("could not record the payment"), http.StatusInternalServerError)
+		return
+	}
+	app.recordOrderChange(r, order)
+
+	resp, status, err := app.orderDetail(r.Context(), order.ID)
+	if err != nil {
+		app.errorJSON(w, err, status)
+		return
+	}
+	app.writeJSON(w, http.StatusOK, resp)
+}
+
+// settlePayment moves the money that goes with an order moving to status to:
+// an authorized card is captured when the order ships, and cancelling or
+// refunding voids the authorization or refunds the charge. When it fails,
+// moved reports whether stripe had already moved the money, and the error is
+// errStripeUnknown when stripe never said either way
+func (app *application) settlePayment(r *http.Request, order models.Order, to int) (moved bool, err error) {
+	txn, err := app.Models.Transactions.GetTransaction(r.Context(), order.TransactionID)
+	if err != nil {
+		app.logger.ErrorContext(r.Context(), err.Error())
+		return false, errors.New("could not load transaction")
+	}
+
+	card := cards.Card{
+		Secret:  app.config.stripe.secret,
+		Key:     app.config.stripe.key,
+		Timeout: app.config.stripe.timeout,
+		Logger:  app.logger,
+		Metrics: app.stripeMetrics,
+	}
+	// stripe gets its whole timeout to answer, and our records follow it
+	ctx := context.WithoutCancel(r.Context())
+	// a retry of the same move is answered with the first one's result
+	// rather than moving the money twice
+	key := idempotencyKey(order.ID, models.OrderStatusName(to))
+
+	switch {
+	case to == models.OrderStatusShipped && txn.TransactionStatusID == models.TransactionStatusAuthorized:
+		pi, err := card.Capture(ctx, txn.PaymentIntent, 0, key)
+		if err != nil {
+			app.logger.ErrorContext(ctx, err.Error())
+			return false, stripeError(err, "stripe could not capture the payment")
+		}
+		err = app.Models.Orders.CaptureOrder(ctx, order, int(pi.AmountReceived), cards.ChargeID(pi))
+		if err != nil {
+			return true, err
+		}
+		app.recordTransactionChange(r, audit.ActionCapture, txn)
+
+	case (to == models.OrderStatusCancelled || to == models.OrderStatusRefunded) && txn.TransactionStatusID == models.TransactionStatusAuthorized:
+		if _, err := card.Cancel(ctx, txn.PaymentIntent, key); err != nil {
+			app.logger.ErrorContext(ctx, err.Error())
+			return false, stripeError(err, "stripe could not void the payment")
+		}
+		err = app.Models.Transactions.UpdateTransactionStatus(ctx, txn.ID, models.TransactionStatusVoided, txn.BankReturnCode)
+		if err != nil {
+			return true, err
+		}
+		app.recordTransactionChange(r, audit.ActionVoid, txn)
+
+	case (to == models.OrderStatusCancelled || to == models.OrderStatusRefunded) && txn.TransactionStatusID == models.TransactionStatusCleared:
+		if _, err := card.Refund(ctx, txn.PaymentIntent, 0, key); err != nil {
+			app.logger.ErrorContext(ctx, err.Error())
+			return false, stripeError(err, "stripe could not refund the payment")
+		}
+		err = app.Models.Transactions.UpdateTransactionStatus(ctx, txn.ID, models.TransactionStatusRefunded, txn.BankReturnCode)
+		if err != nil {
+			return true, err
+		}
+		app.recordTransactionChange(r, audit.ActionRefund, txn)
+	}
+
+	return false, nil
+}
+
+// errStripeUnknown is a call to stripe that got no answer, so the money may
+// or may not have moved
+var errStripeUnknown = errors.New("stripe did not answer in time, check the payment in the stripe dashboard")
+
+// stripeError is the error for a call to stripe that failed with err: msg
+// when stripe turned the call down, errStripeUnknown when it didn't answer
+func stripeError(err error, msg string) error {
+	if cards.Answered(err) {
+		return errors.New(msg)
+	}
+	return errStripeUnknown
+}
+
+// idempotencyKey is the key stripe recognises a retry of action on an order
+// by, so the money for it only ever moves once
+func idempotencyKey(orderID int, action string) string {
+	return fmt.Sprintf("order-%d-%s", orderID, action)
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"go-stripe/internal/cards"
+	"go-stripe/internal/models"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// payoutLookback is how far back the sync job asks stripe for payouts. A
+// payout's status can still change for a few days after it is created
+const payoutLookback = 30 * 24 * time.Hour
+
+// syncPayouts pulls recent payouts from stripe along with the balance
+// transactions in each. It returns how many payouts were saved
+func (app *application) syncPayouts(ctx context.Context) (int, error) {
+	card := cards.Card{
+		Secret:  app.config.stripe.secret,
+		Key:     app.config.stripe.key,
+		Timeout: app.config.stripe.timeout,
+		Logger:  app.logger,
+		Metrics: app.stripeMetrics,
+	}
+	payouts, err := card.ListPayouts(ctx, time.Now().Add(-payoutLookback))
+	if err != nil {
+		return 0, err
+	}
+
+	for i, p := range payouts {
+		bts, err := card.PayoutBalanceTransactions(ctx, p.ID)
+		if err != nil {
+			return i, err
+		}
+		var lines []models.PayoutLine
+		for _, bt := range bts {
+			if line, ok := cards.PayoutLineRecord(bt); ok {
+				lines = append(lines, line)
+			}
+		}
+		if _, err = app.Models.Payouts.SavePayout(ctx, cards.PayoutRecord(p), lines); err != nil {
+			return i, err
+		}
+	}
+	return len(payouts), nil
+}
+
+// runPayoutSync calls syncPayouts every interval until the program exits
+func (app *application) runPayoutSync(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		n, err := app.syncPayouts(context.Background())
+		if err != nil {
+			app.logger.Error("payout sync failed", "err", err)
+		} else {
+			app.logger.Info("payout sync", "checked", n)
+		}
+		<-ticker.C
+	}
+}
+
+// SyncPayouts pulls payouts from stripe now rather than waiting for the next
+// scheduled sync
+func (app *application) SyncPayouts(w http.ResponseWriter, r *http.Request) {
+	n, err := app.syncPayouts(r.Context())
+	if err != nil {
+		app.logger.ErrorContext(r.Context(), err.Error())
+		app.errorJSON(w, errors.New("could not sync payouts from stripe"), http.StatusBadGateway)
+		return
+	}
+	app.writeJSON(w, http.StatusOK, jsonResponse{
+		OK:      true,
+		Message: fmt.Sprintf("%d payouts checked", n),
+	})
+}
+
+// AllPayouts returns a page of payouts, latest first
+func (app *application) AllPayouts(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	pageSize, _ := strconv.Atoi(q.Get("page_size"))
+	page, _ := strconv.Atoi(q.Get("page"))
+	if pageSize < 1 || pageSize > 100 {
+		pageSize = 20
+	}
+	if page < 1 {
+		page = 1
+	}
+
+	payouts, lastPage, totalRecords, err := app.Models.Payouts.GetPayoutsPaginated(r.Context(), pageSize, page)
+	if err != nil {
+		app.logger.ErrorContext(r.Context(), err.Error())
+		app.errorJSON(w, errors.New("could not load payouts"), http.StatusInternalServerError)
+		return
+	}
+
+	var resp struct {
+		CurrentPage  int              `json:"current_page"`
+		PageSize     int              `json:"page_size"`
+		LastPage     int              `json:"last_page"`
+		TotalRecords int              `json:"total_records"`
+		Payouts      []*models.Payout `json:"payouts"`
+	}
+	resp.CurrentPage = page
+	resp.PageSize = pageSize
+	resp.LastPage = lastPage
+	resp.TotalRecords = totalRecords
+	resp.Payouts = payouts
+
+	app.writeJSON(w, http.StatusOK, resp)
+}
+
+// OnePayout returns a payout broken down into the orders, refunds, fees and
+// disputes that made it up
+func (app *application) OnePayout(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		app.errorJSON(w, errors.New("invalid payout id"))
+		return
+	}
+
+	p, lines, totals, err := app.Models.Payouts.GetPayout(r.Context(), id)
+	if errors.Is(err, sql.ErrNoRows) {
+		app.errorJSON(w, errors.New("payout not found"), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		app.logger.ErrorContext(r.Context(), err.Error())
+		app.errorJSON(w, errors.New("could not load payout"), http.StatusInternalServerError)
+		return
+	}
+
+	var resp struct {
+		Payout *models.Payout       `json:"payout"`
+		Lines  []*models.PayoutLine `json:"lines"`
+		Totals []models.PayoutTotal `json:"totals"`
+	}
+	resp.Payout = p
+	resp.Lines = lines
+	resp.Totals = totals
+
+	app.writeJSON(w, http.StatusOK, resp)
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"errors"
+	"go-stripe/internal/models"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// defaultReportDays is how many days the sales reports cover when no date
+// range is given
+const defaultReportDays = 30
+
+// reportRange reads the from and to query parameters shared by the sales
+// reports. Both are dates as YYYY-MM-DD and to is inclusive, so the range
+// returned ends at midnight after it. Without them the range is the last 30
+// days up to and including today
+func reportRange(r *http.Request) (from, to time.Time, err error) {
+	q := r.URL.Query()
+	now := time.Now().UTC()
+	to = time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC).AddDate(0, 0, 1)
+	from = to.AddDate(0, 0, -defaultReportDays)
+
+	if s := q.Get("to"); s != "" {
+		t, err := time.Parse("2006-01-02", s)
+		if err != nil {
+			return from, to, errors.New("to must be a date like 2024-10-25")
+		}
+		to = t.AddDate(0, 0, 1)
+		from = to.AddDate(0, 0, -defaultReportDays)
+	}
+	if s := q.Get("from"); s != "" {
+		t, err := time.Parse("2006-01-02", s)
+		if err != nil {
+			return from, to, errors.New("from must be a date like 2024-10-25")
+		}
+		from = t
+	}
+	if !from.Before(to) {
+		return from, to, errors.New("from must not be after to")
+	}
+	return from, to, nil
+}
+
+// SalesSummary returns the headline sales figures for a date range, one set
+// for each currency sold in
+func (app *application) SalesSummary(w http.ResponseWriter, r *http.Request) {
+	from, to, err := reportRange(r)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	summaries, err := app.Models.Reports.GetSalesSummary(r.Context(), from, to)
+	if err != nil {
+		app.logger.ErrorContext(r.Context(), err.Error())
+		app.errorJSON(w, errors.New("could not load sales summary"), http.StatusInternalServerError)
+		return
+	}
+
+	var resp struct {
+		Summaries []models.SalesSummary `json:"summaries"`
+	}
+	resp.Summaries = summaries
+
+	app.writeJSON(w, http.StatusOK, resp)
+}
+
+// RevenueByPeriod returns sales for a date range grouped by the period query
+// parameter, which is day, week or month and defaults to day
+func (app *application) RevenueByPeriod(w http.ResponseWriter, r *http.Request) {
+	from, to, err := reportRange(r)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+	period := r.URL.Query().Get("period")
+	if period == "" {
+		period = models.PeriodDay
+	}
+
+	points, err := app.Models.Reports.GetRevenueByPeriod(r.Context(), from, to, period)
+	if errors.Is(err, models.ErrUnknownPeriod) {
+		app.errorJSON(w, err)
+		return
+	}
+	if err != nil {
+		app.logger.ErrorContext(r.Context(), err.Error())
+		app.errorJSON(w, errors.New("could not load revenue"), http.StatusInternalServerError)
+		return
+	}
+
+	var resp struct {
+		Period string                `json:"period"`
+		Points []models.RevenuePoint `json:"points"`
+	}
+	resp.Period = period
+	resp.Points = points
+
+	app.writeJSON(w, http.StatusOK, resp)
+}
+
+// SalesByWidget returns sales for a date range by widget
+func (app *application) SalesByWidget(w http.ResponseWriter, r *http.Request) {
+	from, to, err := reportRange(r)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	sales, err := app.Models.Reports.GetSalesByWidget(r.Context(), from, to)
+	if err != nil {
+		app.logger.ErrorContext(r.Context(), err.Error())
+		app.errorJSON(w, errors.New("could not load sales by widget"), http.StatusInternalServerError)
+		return
+	}
+
+	var resp struct {
+		Widgets []models.WidgetSales `json:"widgets"`
+	}
+	resp.Widgets = sales
+
+	app.writeJSON(w, http.StatusOK, resp)
+}
+
+// TopCustomers returns the customers who spent the most in a date range in
+// each currency. The limit query parameter defaults to 10 and is at most 100
+func (app *application) TopCustomers(w http.ResponseWriter, r *http.Request) {
+	from, to, err := reportRange(r)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	if limit < 1 || limit > 100 {
+		limit = 10
+	}
+
+	customers, err := app.Models.Reports.GetTopCustomers(r.Context(), from, to, limit)
+	if err != nil {
+		app.logger.ErrorContext(r.Context(), err.Error())
+		app.errorJSON(w, errors.New("could not load top customers"), http.StatusInternalServerError)
+		return
+	}
+
+	var resp struct {
+		Customers []models.CustomerSales `json:"customers"`
+	}
+	resp.Customers = customers
+
+	app.writeJSON(w, http.StatusOK, resp)
+}
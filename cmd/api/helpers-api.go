@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"go-stripe/internal/audit"
+	"go-stripe/internal/models"
+	"io"
+	"net/http"
+)
+
+// writeJSON writes data as json with the given status code
+func (app *application) writeJSON(w http.ResponseWriter, status int, data interface{}, headers ...http.Header) error {
+	out, err := json.MarshalIndent(data, "", "   ")
+	if err != nil {
+		return err
+	}
+
+	if len(headers) > 0 {
+		for k, v := range headers[0] {
+			w.Header()[k] = v
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(out)
+	return nil
+}
+
+// readJSON reads a single json value from the request body into data
+func (app *application) readJSON(w http.ResponseWriter, r *http.Request, data interface{}) error {
+	maxBytes := 1048576
+	r.Body = http.MaxBytesReader(w, r.Body, int64(maxBytes))
+
+	dec := json.NewDecoder(r.Body)
+	err := dec.Decode(data)
+	if err != nil {
+		return err
+	}
+
+	err = dec.Decode(&struct{}{})
+	if !errors.Is(err, io.EOF) {
+		return errors.New("body must only have a single JSON value")
+	}
+
+	return nil
+}
+
+// errorJSON sends a json error message, with status 400 unless told otherwise
+func (app *application) errorJSON(w http.ResponseWriter, err error, status ...int) error {
+	statusCode := http.StatusBadRequest
+	if len(status) > 0 {
+		statusCode = status[0]
+	}
+
+	payload := jsonResponse{
+		OK:      false,
+		Message: err.Error(),
+	}
+
+	return app.writeJSON(w, statusCode, payload)
+}
+
+// invalidCredentials sends a 401 with a json error message
+func (app *application) invalidCredentials(w http.ResponseWriter) error {
+	var payload jsonResponse
+	payload.OK = false
+	payload.Message = "invalid authentication credentials"
+
+	return app.writeJSON(w, http.StatusUnauthorized, payload)
+}
+
+// recordAudit appends e to the audit log, acting as the logged in user when
+// e has no actor. A failure is logged but doesn't fail the request, since the
+// change it describes has already happened
+func (app *application) recordAudit(r *http.Request, e audit.Event) {
+	if user := currentUser(r); user != nil && e.Actor.ID == 0 {
+		e.Actor = audit.Actor{ID: user.ID, Email: user.Email}
+	}
+	if err := app.auditLog.Record(r, e); err != nil {
+		app.logger.ErrorContext(r.Context(), "could not write audit log", "action", e.Action, "err", err)
+	}
+}
+
+// recordTransactionChange audits a change to txn, reading it back from the
+// database for the after snapshot
+func (app *application) recordTransactionChange(r *http.Request, action string, txn models.Transaction) {
+	e := audit.Event{
+		Action:     action,
+		EntityType: audit.EntityTransaction,
+		EntityID:   txn.ID,
+		Before:     txn,
+	}
+	if after, err := app.Models.Transactions.GetTransaction(context.WithoutCancel(r.Context()), txn.ID); err == nil {
+		e.After = after
+	}
+	app.recordAudit(r, e)
+}
+
+// recordOrderChange audits a change of status to order, reading it back from
+// the database for the after snapshot
+func (app *application) recordOrderChange(r *http.Request, order models.Order) {
+	e := audit.Event{
+		Action:     audit.ActionOrderStatus,
+		EntityType: audit.EntityOrder,
+		EntityID:   order.ID,
+		Before:     order,
+	}
+	if after, err := app.Models.Orders.GetOrder(context.WithoutCancel(r.Context()), order.ID); err == nil {
+		e.After = after
+	}
+	app.recordAudit(r, e)
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"go-stripe/internal/models"
+	"net/http"
+	"strings"
+)
+
+type contextKey string
+
+const userContextKey = contextKey("user")
+
+// Auth only lets requests with a valid bearer token through, and makes the
+// user the token belongs to available to the handler
+func (app *application) Auth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, err := app.authenticateToken(r)
+		if err != nil {
+			app.invalidCredentials(w)
+			return
+		}
+		ctx := context.WithValue(r.Context(), userContextKey, user)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// authenticateToken reads the Authorization header and looks up its user
+func (app *application) authenticateToken(r *http.Request) (*models.User, error) {
+	authorizationHeader := r.Header.Get("Authorization")
+	if authorizationHeader == "" {
+		return nil, errors.New("no authorization header received")
+	}
+
+	headerParts := strings.Split(authorizationHeader, " ")
+	if len(headerParts) != 2 || headerParts[0] != "Bearer" {
+		return nil, errors.New("no authorization header received")
+	}
+
+	token := headerParts[1]
+	if len(token) != 26 {
+		return nil, errors.New("authentication token wrong size")
+	}
+
+	user, err := app.Models.Users.GetUserForToken(r.Context(), token)
+	if err != nil {
+		return nil, errors.New("no matching user found")
+	}
+	return user, nil
+}
+
+// currentUser returns the user Auth put in the request context, or nil
+func currentUser(r *http.Request) *models.User {
+	user, _ := r.Context().Value(userContextKey).(*models.User)
+	return user
+}
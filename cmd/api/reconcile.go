@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"go-stripe/internal/cards"
+	"go-stripe/internal/reconcile"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// runReconcile compares yesterday's transactions with stripe every interval
+// and writes the report to the reconcile directory as json
+func (app *application) runReconcile(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := app.reconcileYesterday(); err != nil {
+			app.logger.Error("reconcile failed", "err", err)
+		}
+		<-ticker.C
+	}
+}
+
+func (app *application) reconcileYesterday() error {
+	now := time.Now()
+	to := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	from := to.AddDate(0, 0, -1)
+
+	rc := &reconcile.Reconciler{
+		Card: &cards.Card{
+			Secret:  app.config.stripe.secret,
+			Key:     app.config.stripe.key,
+			Timeout: app.config.stripe.timeout,
+			Logger:  app.logger,
+			Metrics: app.stripeMetrics,
+		},
+		Transactions: app.Models.Transactions,
+	}
+	report, err := rc.Run(context.Background(), from, to, app.config.reconcile.backfill)
+	if err != nil {
+		return err
+	}
+
+	if err = os.MkdirAll(app.config.reconcile.dir, 0755); err != nil {
+		return err
+	}
+	path := filepath.Join(app.config.reconcile.dir, fmt.Sprintf("reconcile-%s.json", from.Format("2006-01-02")))
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err = report.WriteJSON(f); err != nil {
+		return err
+	}
+
+	app.logger.Info("reconciled", "date", from.Format("2006-01-02"), "matched", report.Matched,
+		"discrepancies", len(report.Discrepancies), "backfilled", report.Backfilled, "report", path)
+	return nil
+}
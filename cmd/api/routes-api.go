@@ -2,23 +2,106 @@ package main
 
 import (
 	"net/http"
+	"net/url"
+	"strings"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/cors"
+	"go-stripe/internal/logging"
+	"go-stripe/internal/metrics"
+	"go-stripe/internal/tracing"
 )
 
 func (app *application) routes() http.Handler {
 	mux := chi.NewRouter()
+	mux.Use(logging.RequestID)
+	mux.Use(tracing.HTTP)
+	mux.Use(logging.AccessLog(app.logger))
+	mux.Use(metrics.HTTP(app.metrics))
 
 	mux.Use(cors.Handler(cors.Options{
-		AllowedOrigins:   []string{"https://*", "http://*"},
+		AllowedOrigins:   app.allowedOrigins(),
 		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token"},
+		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token", "traceparent", "tracestate"},
+		ExposedHeaders:   []string{"Content-Disposition"},
 		AllowCredentials: false,
 		MaxAge:           300,
 	}))
 
-	mux.Post("/api/payment-intent", app.GetPaymentIntent)
-	mux.Get("/api/widget/{id}", app.GetWidgetByID)
+	mux.Method(http.MethodGet, "/metrics", metrics.RequireToken(app.config.metricsToken, app.metrics.Handler()))
+
+	// anyone can call these, so each client is limited to its share
+	mux.Group(func(mux chi.Router) {
+		mux.Use(app.limiter.ByIP)
+
+		mux.Post("/api/payment-intent", app.GetPaymentIntent)
+		mux.Get("/api/widget/{id}", app.GetWidgetByID)
+		mux.Get("/api/receipts/{token}/invoice.pdf", app.GetReceiptInvoice)
+
+		mux.Post("/api/authenticate", app.CreateAuthToken)
+	})
+	mux.Post("/api/webhooks/stripe", app.StripeWebhook)
+
+	mux.Route("/api/admin", func(mux chi.Router) {
+		mux.Use(app.Auth)
+
+		mux.Get("/orders", app.AllOrders)
+		mux.Get("/orders/export", app.ExportOrders)
+		mux.Get("/orders/{id}", app.OneOrder)
+		mux.Get("/orders/{id}/invoice.pdf", app.GetOrderInvoice)
+		mux.Post("/orders/{id}/status", app.UpdateOrderStatus)
+		mux.Post("/orders/{id}/capture", app.CaptureOrder)
+		mux.Post("/orders/{id}/void", app.VoidOrder)
+
+		mux.Post("/terminal/payment-intent", app.TerminalPaymentIntent)
+		mux.Get("/terminal-charges", app.TerminalCharges)
+		mux.Get("/transactions/export", app.ExportTransactions)
+
+		mux.Get("/disputes", app.AllDisputes)
+		mux.Post("/disputes/sync", app.SyncDisputes)
+		mux.Get("/disputes/{id}", app.OneDispute)
+		mux.Post("/disputes/{id}/evidence", app.UpdateDisputeEvidence)
+		mux.Post("/disputes/{id}/files", app.UploadDisputeFile)
+
+		mux.Get("/payouts", app.AllPayouts)
+		mux.Post("/payouts/sync", app.SyncPayouts)
+		mux.Get("/payouts/{id}", app.OnePayout)
+
+		mux.Get("/reports/sales", app.SalesSummary)
+		mux.Get("/reports/revenue", app.RevenueByPeriod)
+		mux.Get("/reports/widgets", app.SalesByWidget)
+		mux.Get("/reports/customers", app.TopCustomers)
+
+		mux.Post("/widgets/import", app.ImportWidgets)
+		mux.Put("/widgets/{id}", app.UpdateWidget)
+
+		mux.Get("/audit", app.AuditLog)
+	})
 	return mux
 }
+
+// allowedOrigins are the origins browsers may call the api from: the ones
+// configured, or else the web front end's
+func (app *application) allowedOrigins() []string {
+	var origins []string
+	for _, o := range strings.Split(app.config.corsOrigins, ",") {
+		if o = strings.TrimSpace(o); o != "" {
+			origins = append(origins, origin(o))
+		}
+	}
+	if len(origins) == 0 {
+		// never left empty, which cors takes to mean every origin
+		origins = []string{origin(app.config.web)}
+	}
+	return origins
+}
+
+// origin is the scheme and host of u, which is what a browser sends as its
+// Origin, so a configured url with a path or trailing slash still matches
+func origin(u string) string {
+	parsed, err := url.Parse(u)
+	if err != nil || parsed.Host == "" {
+		return strings.TrimSuffix(u, "/")
+	}
+	return parsed.Scheme + "://" + parsed.Host
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"go-stripe/internal/audit"
+	"go-stripe/internal/cards"
+	"go-stripe/internal/logging"
+	"go-stripe/internal/metrics"
+	"go-stripe/internal/models"
+	"go-stripe/internal/pricing"
+	"go-stripe/internal/ratelimit"
+	"go-stripe/internal/stripetest"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// testApp is the api wired to a seeded in-memory store and a fake stripe,
+// served over http the way it is in production
+type testApp struct {
+	*application
+	store  *models.MemoryStore
+	seed   models.Seed
+	stripe *stripetest.Server
+	server *httptest.Server
+}
+
+func newTestApp(t *testing.T) *testApp {
+	t.Helper()
+
+	store := models.NewMemoryStore()
+	seed, err := store.Seed()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rules, err := pricing.ParseTaxRules("ID:11:exclusive")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var cfg config
+	cfg.env = "development"
+	cfg.stripe.secret = "sk_test_fake"
+	cfg.stripe.key = "pk_test_fake"
+
+	app := &application{
+		config:  cfg,
+		logger:  logging.Discard(),
+		version: version,
+		Models:  store.Models(),
+		pricing: &pricing.Engine{
+			Rules:          rules,
+			DefaultCountry: "ID",
+		},
+		auditLog: &audit.Logger{DB: store},
+		metrics:  metrics.NewRegistry(),
+	}
+	app.stripeMetrics = cards.NewMetrics(app.metrics)
+	limits := &ratelimit.MemoryStore{}
+	app.limiter = &ratelimit.Limiter{Store: limits, Logger: app.logger}
+	app.lockout = &ratelimit.Lockout{Store: limits}
+
+	ta := &testApp{
+		application: app,
+		store:       store,
+		seed:        seed,
+		stripe:      stripetest.NewServer(t),
+	}
+	// routes are built per request, so tests can change the config first
+	ta.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		app.routes().ServeHTTP(w, r)
+	}))
+	t.Cleanup(ta.server.Close)
+	return ta
+}
+
+// postJSON posts body as json to path and decodes the response into out
+func (ta *testApp) postJSON(t *testing.T, path string, body, out any) *http.Response {
+	t.Helper()
+	b, err := json.Marshal(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := ta.server.Client().Post(ta.server.URL+path, "application/json", bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if out != nil {
+		if err = json.NewDecoder(resp.Body).Decode(out); err != nil {
+			t.Fatalf("decoding response from %s: %v", path, err)
+		}
+	}
+	return resp
+}
+
+// webhook sends stripe's webhook event of type typ about obj, signed with
+// the configured secret
+func (ta *testApp) webhook(t *testing.T, typ string, obj any) {
+	t.Helper()
+	payload, signature, err := stripetest.Event(typ, obj, ta.config.stripe.webhookSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, _ := http.NewRequest(http.MethodPost, ta.server.URL+"/api/webhooks/stripe", bytes.NewReader(payload))
+	req.Header.Set("Stripe-Signature", signature)
+	resp, err := ta.server.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("webhook %s = %d, want 200", typ, resp.StatusCode)
+	}
+}
+
+// login issues an authentication token for the seeded admin
+func (ta *testApp) login(t *testing.T) string {
+	t.Helper()
+	token, err := models.GenerateToken(ta.seed.Admin.ID, time.Hour, models.ScopeAuthentication)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = ta.store.InsertToken(context.Background(), token, ta.seed.Admin); err != nil {
+		t.Fatal(err)
+	}
+	return token.PlainText
+}
+
+// request sends a request to path with body, as the holder of token when
+// there is one, and returns the response with its body read
+func (ta *testApp) request(t *testing.T, method, path, token string, body io.Reader) (*http.Response, []byte) {
+	t.Helper()
+	req, err := http.NewRequest(method, ta.server.URL+path, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := ta.server.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp, b
+}
+
+// order records a cleared order for the seeded widget paid with paymentIntent
+func (ta *testApp) order(t *testing.T, paymentIntent string, amount int) models.Order {
+	t.Helper()
+	ctx := context.Background()
+	customerID, err := ta.store.InsertCustomer(ctx, models.Customer{FirstName: "Ani", LastName: "Wijaya", Email: "ani@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	txnID, err := ta.store.InsertTransaction(ctx, models.Transaction{
+		Amount:              amount,
+		Currency:            "idr",
+		LastFour:            "4242",
+		PaymentIntent:       paymentIntent,
+		TransactionStatusID: models.TransactionStatusCleared,
+		CustomerID:          customerID,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	order := models.Order{
+		WidgetID:      ta.seed.Widget.ID,
+		TransactionID: txnID,
+		CustomerID:    customerID,
+		StatusID:      1,
+		Quantity:      1,
+		Amount:        amount,
+		ReceiptToken:  "receipt-" + paymentIntent,
+	}
+	if order.ID, err = ta.store.InsertOrder(ctx, order); err != nil {
+		t.Fatal(err)
+	}
+	return order
+}
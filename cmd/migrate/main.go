@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"go-stripe/internal/driver"
+	"go-stripe/internal/migrate"
+	"go-stripe/migrations"
+	"log"
+	"os"
+	"strconv"
+	"text/tabwriter"
+)
+
+const usage = `usage: migrate [-dsn DSN] command
+
+commands:
+  up           apply every migration that hasn't been applied
+  down [n]     roll back the last n migrations, 1 by default
+  status       list migrations and whether they have been applied
+  to VERSION   apply or roll back until VERSION is the newest applied, 0 rolls back everything
+`
+
+// migrate creates and updates the database schema from the migrations
+// embedded in the binary, e.g.
+//
+//	migrate up
+//	migrate -dsn "user:pass@tcp(db:3306)/go_stripe?parseTime=true" status
+//	migrate -dsn postgres://user:pass@db:5432/go_stripe to 20241029090000
+func main() {
+	var dsn string
+
+	flag.StringVar(&dsn, "dsn", "root:mysql@tcp(localhost:3306)/go_stripe?parseTime=true&tls=false", "DSN, for mysql, or a postgres:// or sqlite: URL")
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	infoLog := log.New(os.Stderr, "INFO\t", log.Ldate|log.Ltime)
+	errorLog := log.New(os.Stderr, "ERROR\t", log.Ldate|log.Ltime|log.Lshortfile)
+
+	args := flag.Args()
+	if len(args) == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	conn, err := driver.OpenDB(dsn)
+	if err != nil {
+		errorLog.Fatal(err)
+	}
+	defer conn.Close()
+
+	dialect := driver.DialectOf(dsn)
+	fsys, err := migrations.For(dialect)
+	if err != nil {
+		errorLog.Fatal(err)
+	}
+	m, err := migrate.New(conn, dialect, fsys, infoLog)
+	if err != nil {
+		errorLog.Fatal(err)
+	}
+	ctx := context.Background()
+
+	switch args[0] {
+	case "up":
+		n, err := m.Up(ctx)
+		if err != nil {
+			errorLog.Fatal(err)
+		}
+		infoLog.Printf("applied %d migrations", n)
+
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			steps, err = strconv.Atoi(args[1])
+			if err != nil || steps < 1 {
+				errorLog.Fatal("down takes a number of migrations to roll back")
+			}
+		}
+		n, err := m.Down(ctx, steps)
+		if err != nil {
+			errorLog.Fatal(err)
+		}
+		infoLog.Printf("rolled back %d migrations", n)
+
+	case "to":
+		if len(args) < 2 {
+			errorLog.Fatal("to needs a version")
+		}
+		version, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			errorLog.Fatal("invalid version: ", args[1])
+		}
+		n, err := m.To(ctx, version)
+		if err != nil {
+			errorLog.Fatal(err)
+		}
+		infoLog.Printf("ran %d migrations", n)
+
+	case "status":
+		statuses, err := m.Status(ctx)
+		if err != nil {
+			errorLog.Fatal(err)
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "VERSION\tNAME\tAPPLIED")
+		for _, s := range statuses {
+			applied := "pending"
+			if s.Applied {
+				applied = s.AppliedAt
+			}
+			fmt.Fprintf(tw, "%d\t%s\t%s\n", s.Version, s.Name, applied)
+		}
+		tw.Flush()
+
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+}
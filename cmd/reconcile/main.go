@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"go-stripe/internal/cards"
+	"go-stripe/internal/driver"
+	"go-stripe/internal/models"
+	"go-stripe/internal/reconcile"
+	"io"
+	"log"
+	"os"
+	"time"
+)
+
+// reconcile compares the transactions table with the payment intents in
+// stripe for a date range and reports where they disagree, e.g.
+//
+//	reconcile -from 2024-10-01 -to 2024-10-31 -format csv -out october.csv
+func main() {
+	var (
+		dsn      string
+		from     string
+		to       string
+		format   string
+		out      string
+		backfill bool
+	)
+
+	yesterday := time.Now().AddDate(0, 0, -1).Format("2006-01-02")
+	flag.StringVar(&dsn, "dsn", "root:mysql@tcp(localhost:3306)/go_stripe?parseTime=true&tls=false", "DSN, for mysql, or a postgres:// or sqlite: URL")
+	flag.StringVar(&from, "from", yesterday, "First day to check, as YYYY-MM-DD")
+	flag.StringVar(&to, "to", "", "Last day to check, as YYYY-MM-DD, defaults to -from")
+	flag.StringVar(&format, "format", "csv", "Report format {csv|json}")
+	flag.StringVar(&out, "out", "", "File to write the report to, defaults to stdout")
+	flag.BoolVar(&backfill, "backfill", false, "Write transactions for payment intents that are missing them")
+	flag.Parse()
+
+	infoLog := log.New(os.Stderr, "INFO\t", log.Ldate|log.Ltime)
+	errorLog := log.New(os.Stderr, "ERROR\t", log.Ldate|log.Ltime|log.Lshortfile)
+
+	if to == "" {
+		to = from
+	}
+	start, err := time.ParseInLocation("2006-01-02", from, time.Local)
+	if err != nil {
+		errorLog.Fatal("invalid -from date: ", err)
+	}
+	end, err := time.ParseInLocation("2006-01-02", to, time.Local)
+	if err != nil {
+		errorLog.Fatal("invalid -to date: ", err)
+	}
+	end = end.AddDate(0, 0, 1)
+	if !end.After(start) {
+		errorLog.Fatal("-to must not be before -from")
+	}
+	if format != "csv" && format != "json" {
+		errorLog.Fatal("-format must be csv or json")
+	}
+
+	secret := os.Getenv("STRIPE_SECRET")
+	if secret == "" {
+		errorLog.Fatal("STRIPE_SECRET is not set")
+	}
+
+	conn, err := driver.OpenDB(dsn)
+	if err != nil {
+		errorLog.Fatal(err)
+	}
+	defer conn.Close()
+
+	rc := &reconcile.Reconciler{
+		Card:         &cards.Card{Secret: secret, Key: os.Getenv("STRIPE_KEY")},
+		Transactions: &models.DBModel{DB: conn, Dialect: driver.DialectOf(dsn)},
+	}
+	report, err := rc.Run(context.Background(), start, end, backfill)
+	if err != nil {
+		errorLog.Fatal(err)
+	}
+
+	var w io.Writer = os.Stdout
+	if out != "" {
+		f, err := os.Create(out)
+		if err != nil {
+			errorLog.Fatal(err)
+		}
+		defer f.Close()
+		w = f
+	}
+
+	if format == "json" {
+		err = report.WriteJSON(w)
+	} else {
+		err = report.WriteCSV(w)
+	}
+	if err != nil {
+		errorLog.Fatal(err)
+	}
+
+	infoLog.Println(fmt.Sprintf("%s to %s: %d in stripe, %d local, %d matched, %d discrepancies, %d backfilled",
+		from, to, report.StripeCount, report.LocalCount, report.Matched, len(report.Discrepancies), report.Backfilled))
+}
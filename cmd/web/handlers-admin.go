@@ -0,0 +1,146 @@
+package main
+
+import (
+	"errors"
+	"go-stripe/internal/audit"
+	"go-stripe/internal/models"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// LoginPage displays the login page
+func (app *application) LoginPage(w http.ResponseWriter, r *http.Request) {
+	if err := app.renderTemplate(w, r, "login", &templateData{}); err != nil {
+		app.logger.ErrorContext(r.Context(), err.Error())
+	}
+}
+
+// PostLoginPage checks the credentials again on the web side and logs the
+// user into the session. The browser already holds an api token by now
+func (app *application) PostLoginPage(w http.ResponseWriter, r *http.Request) {
+	app.Session.RenewToken(r.Context())
+
+	err := r.ParseForm()
+	if err != nil {
+		app.logger.ErrorContext(r.Context(), err.Error())
+		return
+	}
+
+	email := r.Form.Get("email")
+	password := r.Form.Get("password")
+
+	id, err := app.Models.Users.Authenticate(r.Context(), email, password)
+	if err != nil {
+		if !errors.Is(err, models.ErrInvalidCredentials) {
+			app.logger.ErrorContext(r.Context(), err.Error())
+		}
+		app.recordAudit(r, audit.Event{
+			Action: audit.ActionLoginFailed,
+			Actor:  audit.Actor{Email: email},
+		})
+		app.Session.Put(r.Context(), "error", "Invalid login credentials")
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+
+	app.Session.Put(r.Context(), "userID", id)
+	app.Session.Put(r.Context(), "userEmail", email)
+	app.recordAudit(r, audit.Event{
+		Action:     audit.ActionLogin,
+		Actor:      audit.Actor{ID: id, Email: email},
+		EntityType: audit.EntityUser,
+		EntityID:   id,
+	})
+	http.Redirect(w, r, "/admin/orders", http.StatusSeeOther)
+}
+
+// Logout logs the user out of the session
+func (app *application) Logout(w http.ResponseWriter, r *http.Request) {
+	app.Session.Destroy(r.Context())
+	app.Session.RenewToken(r.Context())
+
+	http.Redirect(w, r, "/login", http.StatusSeeOther)
+}
+
+// AllOrders displays the admin order list
+func (app *application) AllOrders(w http.ResponseWriter, r *http.Request) {
+	if err := app.renderTemplate(w, r, "all-orders", &templateData{}); err != nil {
+		app.logger.ErrorContext(r.Context(), err.Error())
+	}
+}
+
+// ShowOrder displays one order with its history and the actions admins can take
+func (app *application) ShowOrder(w http.ResponseWriter, r *http.Request) {
+	data := make(map[string]any)
+	data["id"] = chi.URLParam(r, "id")
+	if err := app.renderTemplate(w, r, "order", &templateData{
+		Data: data,
+	}); err != nil {
+		app.logger.ErrorContext(r.Context(), err.Error())
+	}
+}
+
+// TerminalCharges displays the charges taken on the virtual terminal
+func (app *application) TerminalCharges(w http.ResponseWriter, r *http.Request) {
+	if err := app.renderTemplate(w, r, "terminal-charges", &templateData{}); err != nil {
+		app.logger.ErrorContext(r.Context(), err.Error())
+	}
+}
+
+// AllDisputes displays the disputes raised against our charges
+func (app *application) AllDisputes(w http.ResponseWriter, r *http.Request) {
+	if err := app.renderTemplate(w, r, "all-disputes", &templateData{}); err != nil {
+		app.logger.ErrorContext(r.Context(), err.Error())
+	}
+}
+
+// ShowDispute displays one dispute with a form for its evidence
+func (app *application) ShowDispute(w http.ResponseWriter, r *http.Request) {
+	data := make(map[string]any)
+	data["id"] = chi.URLParam(r, "id")
+	if err := app.renderTemplate(w, r, "dispute", &templateData{
+		Data: data,
+	}); err != nil {
+		app.logger.ErrorContext(r.Context(), err.Error())
+	}
+}
+
+// SalesDashboard displays revenue and sales figures for a date range
+func (app *application) SalesDashboard(w http.ResponseWriter, r *http.Request) {
+	if err := app.renderTemplate(w, r, "dashboard", &templateData{}); err != nil {
+		app.logger.ErrorContext(r.Context(), err.Error())
+	}
+}
+
+// AllPayouts displays the payouts stripe has sent to the bank
+func (app *application) AllPayouts(w http.ResponseWriter, r *http.Request) {
+	if err := app.renderTemplate(w, r, "all-payouts", &templateData{}); err != nil {
+		app.logger.ErrorContext(r.Context(), err.Error())
+	}
+}
+
+// ShowPayout displays one payout broken down into what made it up
+func (app *application) ShowPayout(w http.ResponseWriter, r *http.Request) {
+	data := make(map[string]any)
+	data["id"] = chi.URLParam(r, "id")
+	if err := app.renderTemplate(w, r, "payout", &templateData{
+		Data: data,
+	}); err != nil {
+		app.logger.ErrorContext(r.Context(), err.Error())
+	}
+}
+
+// ImportWidgets displays the form for importing widgets from a csv file
+func (app *application) ImportWidgets(w http.ResponseWriter, r *http.Request) {
+	if err := app.renderTemplate(w, r, "import-widgets", &templateData{}); err != nil {
+		app.logger.ErrorContext(r.Context(), err.Error())
+	}
+}
+
+// AuditLog displays the audit log with filters
+func (app *application) AuditLog(w http.ResponseWriter, r *http.Request) {
+	if err := app.renderTemplate(w, r, "audit", &templateData{}); err != nil {
+		app.logger.ErrorContext(r.Context(), err.Error())
+	}
+}
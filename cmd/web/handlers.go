@@ -1,9 +1,16 @@
 package main
 
 import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
 	"github.com/go-chi/chi/v5"
+	"go-stripe/internal/audit"
 	"go-stripe/internal/cards"
 	"go-stripe/internal/models"
+	"go-stripe/internal/pricing"
+	"log/slog"
 	"net/http"
 	"strconv"
 	"strings"
@@ -12,13 +19,13 @@ import (
 
 func (app *application) Home(w http.ResponseWriter, r *http.Request) {
 	if err := app.renderTemplate(w, r, "home", &templateData{}, "stripe-js"); err != nil {
-		app.errorLog.Println(err)
+		app.logger.ErrorContext(r.Context(), err.Error())
 	}
 }
 
 func (app *application) VirtualTerminal(w http.ResponseWriter, r *http.Request) {
 	if err := app.renderTemplate(w, r, "terminal", &templateData{}, "stripe-js"); err != nil {
-		app.errorLog.Println(err)
+		app.logger.ErrorContext(r.Context(), err.Error())
 	}
 }
 
@@ -34,12 +41,34 @@ type TransactionData struct {
 	ExpiryMonth     int
 	ExpiryYear      int
 	BankReturnCode  string
+	Breakdown       pricing.Breakdown
+	// TransactionStatusID is what Stripe says happened to the payment, see cards.TransactionStatus
+	TransactionStatusID int
+	// RedirectURL is set when the bank wants the customer to authenticate the payment
+	RedirectURL string
+	// Memo is the operator's reference for a terminal charge
+	Memo string
 }
 
 func (app *application) VirtualTerminalPaymentSucceeded(w http.ResponseWriter, r *http.Request) {
+	if app.redirectRecorded(w, r) {
+		return
+	}
+
 	txnData, err := app.GetTransactionData(r)
 	if err != nil {
-		app.errorLog.Println(err)
+		app.logger.ErrorContext(r.Context(), err.Error())
+		return
+	}
+
+	txnData.Memo = strings.TrimSpace(r.Form.Get("memo"))
+
+	// the card has been charged, so the charge is recorded regardless
+	ctx := context.WithoutCancel(r.Context())
+
+	customerID, err := app.linkCustomer(ctx, txnData.FirstName, txnData.LastName, txnData.Email)
+	if err != nil {
+		app.logger.ErrorContext(r.Context(), err.Error())
 		return
 	}
 
@@ -52,30 +81,58 @@ func (app *application) VirtualTerminalPaymentSucceeded(w http.ResponseWriter, r
 		PaymentIntent:       txnData.PaymentIntentID,
 		PaymentMethod:       txnData.PaymentMethodID,
 		BankReturnCode:      txnData.BankReturnCode,
-		TransactionStatusID: 2,
+		TransactionStatusID: txnData.TransactionStatusID,
+		Source:              models.TransactionSourceTerminal,
+		CustomerID:          customerID,
+		UserID:              app.Session.GetInt(r.Context(), "userID"),
+		Memo:                txnData.Memo,
 	}
 
-	_, err = app.SaveTransaction(txn)
+	txnID, err := app.SaveTransaction(ctx, txn)
 	if err != nil {
-		app.errorLog.Println(err)
+		if app.redirectRecorded(w, r) {
+			return
+		}
+		app.logger.ErrorContext(r.Context(), err.Error())
+		return
+	}
+	txn.ID = txnID
+	app.recordAudit(r, audit.Event{
+		Action:     audit.ActionTerminalCharge,
+		EntityType: audit.EntityTransaction,
+		EntityID:   txnID,
+		After:      txn,
+	})
+
+	if txnData.TransactionStatusID == models.TransactionStatusDeclined {
+		app.Session.Put(r.Context(), "error", "The card was declined")
+		http.Redirect(w, r, "/virtual-terminal", http.StatusSeeOther)
 		return
 	}
 
 	// redirect user to new page
 
 	app.Session.Put(r.Context(), "receipt", txnData)
+	if txnData.RedirectURL != "" {
+		http.Redirect(w, r, txnData.RedirectURL, http.StatusSeeOther)
+		return
+	}
 	http.Redirect(w, r, "/virtual-terminal-receipt", http.StatusSeeOther)
 }
 
 func (app *application) VirtualTerminalReceipt(w http.ResponseWriter, r *http.Request) {
-	txn := app.Session.Get(r.Context(), "receipt").(TransactionData)
+	txn, ok := app.Session.Get(r.Context(), "receipt").(TransactionData)
+	if !ok {
+		http.Redirect(w, r, "/virtual-terminal", http.StatusSeeOther)
+		return
+	}
 	data := make(map[string]any)
 	data["txn"] = txn
 	app.Session.Remove(r.Context(), "receipt")
 	if err := app.renderTemplate(w, r, "virtual-terminal-receipt", &templateData{
 		Data: data,
 	}); err != nil {
-		app.errorLog.Println(err)
+		app.logger.ErrorContext(r.Context(), err.Error())
 	}
 }
 
@@ -83,84 +140,116 @@ func (app *application) GetTransactionData(r *http.Request) (TransactionData, er
 	var txnData TransactionData
 	err := r.ParseForm()
 	if err != nil {
-		app.errorLog.Println(err)
+		app.logger.ErrorContext(r.Context(), err.Error())
 		return txnData, err
 	}
 	firstName := r.Form.Get("first_name")
 	lastName := r.Form.Get("last_name")
+	if firstName == "" && lastName == "" {
+		// the terminal only asks for the name on the card
+		firstName, lastName = splitName(r.Form.Get("cardholder_name"))
+	}
 	email := r.Form.Get("email")
 	paymentIntent := r.Form.Get("payment_intent")
 	paymentMethod := r.Form.Get("payment_method")
-	paymentAmount := r.Form.Get("payment_amount")
-	paymentCurrency := r.Form.Get("payment_currency")
 
 	card := cards.Card{
-		Secret: app.config.stripe.secret,
-		Key:    app.config.stripe.key,
+		Secret:  app.config.stripe.secret,
+		Key:     app.config.stripe.key,
+		Timeout: app.config.stripe.timeout,
+		Logger:  app.logger,
+		Metrics: app.stripeMetrics,
 	}
 
-	pi, err := card.RetrievePaymentIntent(paymentIntent)
+	pi, err := card.RetrievePaymentIntent(r.Context(), paymentIntent)
 	if err != nil {
-		app.errorLog.Println(err)
+		app.logger.ErrorContext(r.Context(), err.Error())
 		return txnData, err
 	}
 
-	pm, err := card.GetPaymentMethod(paymentMethod)
+	pm, err := card.GetPaymentMethod(r.Context(), paymentMethod)
 	if err != nil {
-		app.errorLog.Println(err)
+		app.logger.ErrorContext(r.Context(), err.Error())
 		return txnData, err
 	}
 
 	lastFour := pm.Card.Last4
 	expiryMonth := pm.Card.ExpMonth
 	expiryYear := pm.Card.ExpYear
-	// Format data and clear amount
-	cleanedAmount := strings.ReplaceAll(paymentAmount, "Rp", "")
-	cleanedAmount = strings.ReplaceAll(cleanedAmount, ".", "")
-	finalAmount, err := strconv.Atoi(strings.TrimSpace(cleanedAmount))
-	if err != nil {
-		app.errorLog.Println("Error converting amount:", err)
-		return txnData, err
-	}
+
+	// the amount and outcome come from stripe, never from the posted form
 	txnData = TransactionData{
 		FirstName:       firstName,
 		LastName:        lastName,
 		Email:           email,
 		PaymentIntentID: paymentIntent,
 		PaymentMethodID: paymentMethod,
-		PaymentAmount:   finalAmount,
-		PaymentCurrency: paymentCurrency,
+		PaymentAmount:   int(pi.Amount),
+		PaymentCurrency: string(pi.Currency),
 		LastFour:        lastFour,
 		ExpiryMonth:     int(expiryMonth),
 		ExpiryYear:      int(expiryYear),
-		BankReturnCode:  pi.Charges.Data[0].ID,
+		BankReturnCode:  cards.ChargeID(pi),
+
+		TransactionStatusID: cards.TransactionStatus(pi.Status),
+		RedirectURL:         cards.RedirectURL(pi),
 	}
+	if b, ok := pricing.FromMetadata(pi.Metadata); ok {
+		txnData.Breakdown = b
+	}
+
+	level := slog.LevelInfo
+	if txnData.TransactionStatusID == models.TransactionStatusDeclined {
+		level = slog.LevelWarn
+	}
+	app.logger.Log(r.Context(), level, "payment", "payment_intent", paymentIntent, "payment_method", paymentMethod,
+		"status", pi.Status, "amount", pi.Amount, "currency", pi.Currency)
 	return txnData, nil
 }
 
 func (app *application) PaymentSucceeded(w http.ResponseWriter, r *http.Request) {
 	err := r.ParseForm()
 	if err != nil {
-		app.errorLog.Println(err)
+		app.logger.ErrorContext(r.Context(), err.Error())
+		return
+	}
+	if app.redirectRecorded(w, r) {
 		return
 	}
 
 	// read posted data
 
-	widgetID, _ := strconv.Atoi(r.Form.Get("product_id"))
-
 	txnData, err := app.GetTransactionData(r)
 	if err != nil {
-		app.errorLog.Println(err)
+		app.logger.ErrorContext(r.Context(), err.Error())
+		return
+	}
+
+	// what was bought and for how much is what the api priced and wrote on
+	// the payment intent, never what the form says
+	widgetID := txnData.Breakdown.WidgetID
+	if widgetID == 0 {
+		app.logger.WarnContext(r.Context(), "payment intent was not priced for a widget", "payment_intent", txnData.PaymentIntentID)
+		app.Session.Put(r.Context(), "error", "We could not verify your payment")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
 		return
 	}
+	if txnData.TransactionStatusID == models.TransactionStatusDeclined {
+		app.settleCoupon(r.Context(), txnData.Breakdown.CouponCode, txnData.PaymentIntentID, false)
+		app.Session.Put(r.Context(), "error", "Your card was declined")
+		http.Redirect(w, r, fmt.Sprintf("/widget/%d", widgetID), http.StatusSeeOther)
+		return
+	}
+
+	// stripe has taken or is holding the payment, so the order is recorded
+	ctx := context.WithoutCancel(r.Context())
+
 	//create a new customer
-	customerID, err := app.SaveCustomer(txnData.FirstName, txnData.LastName, txnData.Email)
+	customerID, err := app.SaveCustomer(ctx, txnData.FirstName, txnData.LastName, txnData.Email)
 	if err != nil {
-		app.errorLog.Println(err)
+		app.logger.ErrorContext(r.Context(), err.Error())
 		return
 	}
-	app.infoLog.Print("Customer ID: ", customerID)
 	//create transaction
 	txn := models.Transaction{
 		Amount:              txnData.PaymentAmount,
@@ -171,57 +260,310 @@ func (app *application) PaymentSucceeded(w http.ResponseWriter, r *http.Request)
 		PaymentIntent:       txnData.PaymentIntentID,
 		PaymentMethod:       txnData.PaymentMethodID,
 		BankReturnCode:      txnData.BankReturnCode,
-		TransactionStatusID: 2,
+		TransactionStatusID: txnData.TransactionStatusID,
+		CustomerID:          customerID,
 	}
 
-	txnID, err := app.SaveTransaction(txn)
+	txnID, err := app.SaveTransaction(ctx, txn)
 	if err != nil {
-		app.errorLog.Println(err)
+		if app.redirectRecorded(w, r) {
+			return
+		}
+		app.logger.ErrorContext(r.Context(), err.Error())
 		return
 	}
 
 	// create a new order
+	token, err := generateToken()
+	if err != nil {
+		app.logger.ErrorContext(r.Context(), err.Error())
+		return
+	}
+	breakdown := txnData.Breakdown
 	order := models.Order{
 		WidgetID:      widgetID,
 		TransactionID: txnID,
 		CustomerID:    customerID,
-		StatusID:      1,
-		Quantity:      1,
+		StatusID:      models.OrderStatusCleared,
+		Quantity:      breakdown.Quantity,
 		Amount:        txnData.PaymentAmount,
+		Subtotal:      breakdown.Subtotal,
+		Discount:      breakdown.Discount,
+		Tax:           breakdown.Tax,
+		CouponCode:    breakdown.CouponCode,
+		ReceiptToken:  token,
 		CreatedAt:     time.Now(),
 		UpdatedAt:     time.Now(),
 	}
-	_, err = app.SaveOrder(order)
+	if txnData.TransactionStatusID == models.TransactionStatusPending {
+		order.StatusID = models.OrderStatusPending
+	}
+	orderID, err := app.SaveOrder(ctx, order)
 	if err != nil {
-		app.errorLog.Println(err)
+		app.logger.ErrorContext(r.Context(), err.Error())
 		return
 	}
-	// redirect user to new page
+	order.ID = orderID
 
-	app.Session.Put(r.Context(), "receipt", txnData)
-	http.Redirect(w, r, "/receipt", http.StatusSeeOther)
+	// the bank wants the customer to authenticate first. The order waits
+	// as pending and PaymentReturn settles it and sends the receipt once
+	// they come back
+	if txnData.RedirectURL != "" {
+		http.Redirect(w, r, txnData.RedirectURL, http.StatusSeeOther)
+		return
+	}
+
+	if txnData.TransactionStatusID == models.TransactionStatusCleared || txnData.TransactionStatusID == models.TransactionStatusAuthorized {
+		app.orderMetrics.orderPlaced(order, txnData.PaymentCurrency)
+		app.settleCoupon(ctx, order.CouponCode, txnData.PaymentIntentID, true)
+		widget, err := app.Models.Widgets.GetWidget(ctx, widgetID)
+		if err != nil {
+			app.logger.ErrorContext(r.Context(), err.Error())
+		}
+		app.sendReceipt(ctx, txnData, order, widget)
+	}
+
+	// redirect user to the receipt, which they can come back to later
+	http.Redirect(w, r, "/receipt/"+token, http.StatusSeeOther)
+}
+
+// settleCoupon makes the use of code the api held for a checkout final once
+// it is paid, or gives it back when the payment is declined. The webhook
+// does the same, whichever arrives first
+func (app *application) settleCoupon(ctx context.Context, code, paymentIntent string, paid bool) {
+	if code == "" {
+		return
+	}
+	settle := app.Models.Coupons.ReleaseCoupon
+	if paid {
+		settle = app.Models.Coupons.ConfirmCoupon
+	}
+	if err := settle(ctx, paymentIntent); err != nil {
+		app.logger.ErrorContext(ctx, "could not settle coupon", "coupon", code, "payment_intent", paymentIntent, "err", err)
+	}
+}
+
+// redirectRecorded sends the browser on to the receipt when the posted
+// payment intent has already been recorded, as it has when the page is
+// refreshed or the form posted twice, and reports whether it did
+func (app *application) redirectRecorded(w http.ResponseWriter, r *http.Request) bool {
+	paymentIntent := r.Form.Get("payment_intent")
+	if paymentIntent == "" {
+		return false
+	}
+	txn, err := app.Models.Transactions.GetTransactionByPaymentIntent(r.Context(), paymentIntent)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			app.logger.ErrorContext(r.Context(), err.Error())
+		}
+		return false
+	}
+
+	if txn.Source == models.TransactionSourceTerminal {
+		// terminal receipts only live in the session, which the first
+		// request has shown already
+		app.Session.Put(r.Context(), "flash", "This charge has already been recorded")
+		http.Redirect(w, r, "/admin/terminal-charges", http.StatusSeeOther)
+		return true
+	}
+	order, err := app.Models.Orders.GetOrderByTransactionID(r.Context(), txn.ID)
+	if err != nil {
+		// the first request is still recording the order
+		app.logger.WarnContext(r.Context(), "payment intent recorded without an order", "payment_intent", paymentIntent, "err", err)
+		http.Error(w, http.StatusText(http.StatusConflict), http.StatusConflict)
+		return true
+	}
+	http.Redirect(w, r, "/receipt/"+order.ReceiptToken, http.StatusSeeOther)
+	return true
 }
 
+// PaymentReturn is where stripe sends the customer back to after a 3-D Secure
+// redirect. It records the final outcome of the payment intent and shows the
+// matching receipt
+func (app *application) PaymentReturn(w http.ResponseWriter, r *http.Request) {
+	paymentIntent := r.URL.Query().Get("payment_intent")
+
+	txn, err := app.Models.Transactions.GetTransactionByPaymentIntent(r.Context(), paymentIntent)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			app.logger.ErrorContext(r.Context(), err.Error())
+		}
+		http.NotFound(w, r)
+		return
+	}
+
+	card := cards.Card{
+		Secret:  app.config.stripe.secret,
+		Key:     app.config.stripe.key,
+		Timeout: app.config.stripe.timeout,
+		Logger:  app.logger,
+		Metrics: app.stripeMetrics,
+	}
+	pi, err := card.RetrievePaymentIntent(r.Context(), paymentIntent)
+	if err != nil {
+		app.logger.ErrorContext(r.Context(), err.Error())
+		http.Error(w, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
+		return
+	}
+
+	// the receipt only goes out the first time the status moves on
+	ctx := context.WithoutCancel(r.Context())
+
+	statusID := cards.TransactionStatus(pi.Status)
+	if statusID != txn.TransactionStatusID {
+		err = app.Models.Transactions.UpdateTransactionStatus(ctx, txn.ID, statusID, cards.ChargeID(pi))
+		if err != nil {
+			app.logger.ErrorContext(r.Context(), err.Error())
+			return
+		}
+	}
+
+	order, err := app.Models.Orders.GetOrderByTransactionID(ctx, txn.ID)
+	if errors.Is(err, sql.ErrNoRows) {
+		// terminal charges have no order, their receipt is still in the session
+		if txnData, ok := app.Session.Get(r.Context(), "receipt").(TransactionData); ok {
+			txnData.TransactionStatusID = statusID
+			txnData.BankReturnCode = cards.ChargeID(pi)
+			app.Session.Put(r.Context(), "receipt", txnData)
+		}
+		if statusID == models.TransactionStatusDeclined {
+			app.Session.Put(r.Context(), "error", "The card could not be authenticated")
+			http.Redirect(w, r, "/virtual-terminal", http.StatusSeeOther)
+			return
+		}
+		http.Redirect(w, r, "/virtual-terminal-receipt", http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		app.logger.ErrorContext(r.Context(), err.Error())
+		return
+	}
+
+	paid := statusID == models.TransactionStatusCleared || statusID == models.TransactionStatusAuthorized
+	if order.StatusID == models.OrderStatusPending && (paid || statusID == models.TransactionStatusDeclined) {
+		// a refresh that lost the race to settle the order finds it moved on
+		err = app.Models.Orders.SettlePendingOrder(ctx, order.ID, paid)
+		if err != nil && !errors.Is(err, models.ErrOrderStatusConflict) {
+			app.logger.ErrorContext(r.Context(), err.Error(), "order_id", order.ID)
+		}
+		if err == nil && paid {
+			app.orderMetrics.orderPlaced(order, txn.Currency)
+		}
+		app.settleCoupon(ctx, order.CouponCode, paymentIntent, paid)
+	}
+
+	if statusID == models.TransactionStatusDeclined {
+		app.Session.Put(r.Context(), "error", "Your card could not be authenticated")
+		http.Redirect(w, r, fmt.Sprintf("/widget/%d", order.WidgetID), http.StatusSeeOther)
+		return
+	}
+
+	if txn.TransactionStatusID == models.TransactionStatusPending && paid {
+		txnData, err := app.receiptData(ctx, order)
+		if err != nil {
+			app.logger.ErrorContext(r.Context(), err.Error())
+		} else {
+			widget, err := app.Models.Widgets.GetWidget(ctx, order.WidgetID)
+			if err != nil {
+				app.logger.ErrorContext(r.Context(), err.Error())
+			}
+			app.sendReceipt(ctx, txnData, order, widget)
+		}
+	}
+
+	http.Redirect(w, r, "/receipt/"+order.ReceiptToken, http.StatusSeeOther)
+}
+
+// Receipt displays the receipt for the order a receipt token belongs to
 func (app *application) Receipt(w http.ResponseWriter, r *http.Request) {
-	txn := app.Session.Get(r.Context(), "receipt").(TransactionData)
+	token := chi.URLParam(r, "token")
+
+	order, err := app.Models.Orders.GetOrderByReceiptToken(r.Context(), token)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			app.logger.ErrorContext(r.Context(), err.Error())
+		}
+		http.NotFound(w, r)
+		return
+	}
+
+	txnData, err := app.receiptData(r.Context(), order)
+	if err != nil {
+		app.logger.ErrorContext(r.Context(), err.Error())
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
 	data := make(map[string]any)
-	data["txn"] = txn
-	app.Session.Remove(r.Context(), "receipt")
+	data["txn"] = txnData
+	data["token"] = order.ReceiptToken
 	if err := app.renderTemplate(w, r, "receipt", &templateData{
 		Data: data,
 	}); err != nil {
-		app.errorLog.Println(err)
+		app.logger.ErrorContext(r.Context(), err.Error())
+	}
+}
+
+// receiptData rebuilds what the customer saw at checkout from a stored order
+func (app *application) receiptData(ctx context.Context, order models.Order) (TransactionData, error) {
+	var txnData TransactionData
+
+	customer, err := app.Models.Customers.GetCustomer(ctx, order.CustomerID)
+	if err != nil {
+		return txnData, err
+	}
+	txn, err := app.Models.Transactions.GetTransaction(ctx, order.TransactionID)
+	if err != nil {
+		return txnData, err
+	}
+
+	txnData = TransactionData{
+		FirstName:       customer.FirstName,
+		LastName:        customer.LastName,
+		Email:           customer.Email,
+		PaymentIntentID: txn.PaymentIntent,
+		PaymentMethodID: txn.PaymentMethod,
+		PaymentAmount:   txn.Amount,
+		PaymentCurrency: txn.Currency,
+		LastFour:        txn.LastFour,
+		ExpiryMonth:     txn.ExpiryMonth,
+		ExpiryYear:      txn.ExpiryYear,
+		BankReturnCode:  txn.BankReturnCode,
+
+		TransactionStatusID: txn.TransactionStatusID,
+		Breakdown: pricing.Breakdown{
+			Quantity:   order.Quantity,
+			Subtotal:   order.Subtotal,
+			CouponCode: order.CouponCode,
+			Discount:   order.Discount,
+			Tax:        order.Tax,
+			Total:      order.Amount,
+		},
+	}
+	return txnData, nil
+}
+
+// linkCustomer returns the customer with email, creating one if we haven't
+// charged them before
+func (app *application) linkCustomer(ctx context.Context, firstName, lastName, email string) (int, error) {
+	customer, err := app.Models.Customers.GetCustomerByEmail(ctx, email)
+	if err == nil {
+		return customer.ID, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return 0, err
 	}
+	return app.SaveCustomer(ctx, firstName, lastName, strings.ToLower(email))
 }
 
 // SaveCustomer save customer return id
-func (app *application) SaveCustomer(firstName, lastName, email string) (int, error) {
+func (app *application) SaveCustomer(ctx context.Context, firstName, lastName, email string) (int, error) {
 	customer := models.Customer{
 		FirstName: firstName,
 		LastName:  lastName,
 		Email:     email,
 	}
-	id, err := app.DB.InsertCustomer(customer)
+	id, err := app.Models.Customers.InsertCustomer(ctx, customer)
 	if err != nil {
 		return 0, err
 	}
@@ -229,8 +571,8 @@ func (app *application) SaveCustomer(firstName, lastName, email string) (int, er
 }
 
 // SaveTransaction save Transaction return id
-func (app *application) SaveTransaction(txn models.Transaction) (int, error) {
-	id, err := app.DB.InsertTransaction(txn)
+func (app *application) SaveTransaction(ctx context.Context, txn models.Transaction) (int, error) {
+	id, err := app.Models.Transactions.InsertTransaction(ctx, txn)
 	if err != nil {
 		return 0, err
 	}
@@ -238,8 +580,8 @@ func (app *application) SaveTransaction(txn models.Transaction) (int, error) {
 }
 
 // SaveOrder save Order return id
-func (app *application) SaveOrder(order models.Order) (int, error) {
-	id, err := app.DB.InsertOrder(order)
+func (app *application) SaveOrder(ctx context.Context, order models.Order) (int, error) {
+	id, err := app.Models.Orders.InsertOrder(ctx, order)
 	if err != nil {
 		return 0, err
 	}
@@ -251,9 +593,9 @@ func (app *application) ChargeOnce(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "id")
 	widgetID, _ := strconv.Atoi(id)
 
-	widget, err := app.DB.GetWidget(widgetID)
+	widget, err := app.Models.Widgets.GetWidget(r.Context(), widgetID)
 	if err != nil {
-		app.errorLog.Println(err)
+		app.logger.ErrorContext(r.Context(), err.Error())
 		return
 	}
 
@@ -263,7 +605,7 @@ func (app *application) ChargeOnce(w http.ResponseWriter, r *http.Request) {
 	if err := app.renderTemplate(w, r, "buy-once", &templateData{
 		Data: data,
 	}, "stripe-js"); err != nil {
-		app.errorLog.Println(err)
+		app.logger.ErrorContext(r.Context(), err.Error())
 	}
 
 }
@@ -0,0 +1,391 @@
+package main
+
+import (
+	"context"
+	"go-stripe/internal/audit"
+	"go-stripe/internal/cards"
+	"go-stripe/internal/models"
+	"go-stripe/internal/pricing"
+	"go-stripe/internal/stripetest"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPaymentSucceeded(t *testing.T) {
+	tests := []struct {
+		name          string
+		paymentMethod string
+		manualCapture bool
+		coupon        bool
+		unpriced      bool
+
+		wantRedirect string // "receipt", "3ds" or a path
+		wantStatus   int    // of the transaction, 0 for none recorded
+		wantEmails   int
+	}{
+		{
+			name:          "charged",
+			paymentMethod: stripetest.CardVisa,
+			wantRedirect:  "receipt",
+			wantStatus:    models.TransactionStatusCleared,
+			wantEmails:    1,
+		},
+		{
+			name:          "charged with a coupon",
+			paymentMethod: stripetest.CardVisa,
+			coupon:        true,
+			wantRedirect:  "receipt",
+			wantStatus:    models.TransactionStatusCleared,
+			wantEmails:    1,
+		},
+		{
+			name:          "authorized for manual capture",
+			paymentMethod: stripetest.CardVisa,
+			manualCapture: true,
+			wantRedirect:  "receipt",
+			wantStatus:    models.TransactionStatusAuthorized,
+			wantEmails:    1,
+		},
+		{
+			name:          "declined",
+			paymentMethod: stripetest.CardDeclined,
+			wantRedirect:  "/widget/1",
+		},
+		{
+			name:          "insufficient funds",
+			paymentMethod: stripetest.CardInsufficientFunds,
+			wantRedirect:  "/widget/1",
+		},
+		{
+			name:          "needs 3-D Secure",
+			paymentMethod: stripetest.CardAuthenticationRequired,
+			wantRedirect:  "3ds",
+			wantStatus:    models.TransactionStatusPending,
+		},
+		{
+			name:          "amount not priced by the api",
+			paymentMethod: stripetest.CardVisa,
+			unpriced:      true,
+			wantRedirect:  "/",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ta := newTestApp(t)
+			ctx := context.Background()
+
+			var coupon *models.Coupon
+			if tt.coupon {
+				coupon = &ta.seed.Coupon
+			}
+			engine := pricing.Engine{Rules: pricing.TaxRules{"ID": {Rate: 1100}}, DefaultCountry: "ID"}
+			b, err := engine.Compute(ta.seed.Widget.Price*100, 2, "", coupon, ta.seed.Coupon.CreatedAt)
+			if err != nil {
+				t.Fatal(err)
+			}
+			b.WidgetID = ta.seed.Widget.ID
+			opts := cards.ChargeOptions{Metadata: b.Metadata(), ManualCapture: tt.manualCapture}
+			if tt.unpriced {
+				opts.Metadata = nil
+			}
+			pi := ta.pay(t, b.Total, opts, tt.paymentMethod)
+			if tt.coupon {
+				// as the api does when it creates the payment intent
+				if err := ta.store.ReserveCoupon(ctx, ta.seed.Coupon.Code, pi, time.Now().Add(time.Hour)); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			resp := ta.postForm(t, "/payment-succeeded", url.Values{
+				"product_id":     {strconv.Itoa(ta.seed.Widget.ID)},
+				"first_name":     {"Budi"},
+				"last_name":      {"Santoso"},
+				"email":          {"budi@example.com"},
+				"payment_intent": {pi},
+				"payment_method": {tt.paymentMethod},
+				// ignored, the amount comes from stripe
+				"payment_amount": {"1"},
+			})
+			if resp.StatusCode != http.StatusSeeOther {
+				t.Fatalf("status = %d, want 303", resp.StatusCode)
+			}
+
+			loc := ta.location(resp)
+			switch tt.wantRedirect {
+			case "receipt":
+				if !strings.HasPrefix(loc, "/receipt/") {
+					t.Errorf("redirected to %q, want the receipt", loc)
+				}
+			case "3ds":
+				if loc != ta.stripe.URL+"/3ds/"+pi {
+					t.Errorf("redirected to %q, want the bank's 3-D Secure page", loc)
+				}
+			default:
+				if loc != tt.wantRedirect {
+					t.Errorf("redirected to %q, want %q", loc, tt.wantRedirect)
+				}
+			}
+
+			txn, err := ta.store.GetTransactionByPaymentIntent(ctx, pi)
+			if tt.wantStatus == 0 {
+				if err == nil {
+					t.Errorf("transaction %d recorded, want none", txn.ID)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("transaction not recorded: %v", err)
+			}
+			if txn.TransactionStatusID != tt.wantStatus || txn.Amount != b.Total || txn.LastFour == "" {
+				t.Errorf("transaction = status %d amount %d last four %q, want status %d amount %d",
+					txn.TransactionStatusID, txn.Amount, txn.LastFour, tt.wantStatus, b.Total)
+			}
+
+			order, err := ta.store.GetOrderByTransactionID(ctx, txn.ID)
+			if err != nil {
+				t.Fatalf("order not recorded: %v", err)
+			}
+			if order.Quantity != 2 || order.Amount != b.Total || order.Discount != b.Discount || order.Tax != b.Tax {
+				t.Errorf("order = %+v, want the breakdown %+v", order, b)
+			}
+			// an order waiting on 3-D Secure is neither paid nor counted yet
+			wantOrderStatus, wantPlaced := models.OrderStatusCleared, 1
+			if tt.wantStatus == models.TransactionStatusPending {
+				wantOrderStatus, wantPlaced = models.OrderStatusPending, 0
+			}
+			if order.StatusID != wantOrderStatus {
+				t.Errorf("order status %d, want %d", order.StatusID, wantOrderStatus)
+			}
+			if n, amount := ta.orderMetrics.placed.Value("idr"), ta.orderMetrics.amount.Value("idr"); n != float64(wantPlaced) || amount != float64(wantPlaced*b.Total) {
+				t.Errorf("metrics counted %v orders for %v, want %d for %d", n, amount, wantPlaced, wantPlaced*b.Total)
+			}
+			if tt.wantRedirect == "receipt" && loc != "/receipt/"+order.ReceiptToken {
+				t.Errorf("redirected to %q, want the order's receipt", loc)
+			}
+			customer, err := ta.store.GetCustomer(ctx, order.CustomerID)
+			if err != nil || customer.Email != "budi@example.com" {
+				t.Errorf("customer = %+v, %v", customer, err)
+			}
+
+			// the use the api held for the payment is final once it is paid
+			wantUsed := 0
+			if tt.coupon {
+				wantUsed = 1
+			}
+			if c, _ := ta.store.GetCouponByCode(ctx, ta.seed.Coupon.Code); c.TimesUsed != wantUsed {
+				t.Errorf("coupon used %d times, want %d", c.TimesUsed, wantUsed)
+			}
+
+			msgs := ta.waitForMail(t, tt.wantEmails)
+			if tt.wantEmails > 0 && msgs[0].To != "budi@example.com" {
+				t.Errorf("receipt sent to %q", msgs[0].To)
+			}
+		})
+	}
+}
+
+func TestPaymentReturn(t *testing.T) {
+	for _, passed := range []bool{true, false} {
+		t.Run("authenticated "+strconv.FormatBool(passed), func(t *testing.T) {
+			ta := newTestApp(t)
+			b := pricing.Breakdown{WidgetID: ta.seed.Widget.ID, Quantity: 1, Subtotal: 100000000, Tax: 11000000, Total: 111000000}
+			pi := ta.pay(t, b.Total, cards.ChargeOptions{Metadata: b.Metadata()}, stripetest.CardAuthenticationRequired)
+			ta.postForm(t, "/payment-succeeded", url.Values{
+				"product_id":     {strconv.Itoa(ta.seed.Widget.ID)},
+				"email":          {"budi@example.com"},
+				"payment_intent": {pi},
+				"payment_method": {stripetest.CardAuthenticationRequired},
+			})
+
+			// nothing is paid until the customer has authenticated
+			ctx := context.Background()
+			txn, err := ta.store.GetTransactionByPaymentIntent(ctx, pi)
+			if err != nil {
+				t.Fatal(err)
+			}
+			order, err := ta.store.GetOrderByTransactionID(ctx, txn.ID)
+			if err != nil || order.StatusID != models.OrderStatusPending {
+				t.Fatalf("order status %d, %v, want pending", order.StatusID, err)
+			}
+
+			if _, err := ta.stripe.Authenticate(pi, passed); err != nil {
+				t.Fatal(err)
+			}
+			resp, _ := ta.get(t, "/payment-return?payment_intent="+pi)
+
+			txn, err = ta.store.GetTransactionByPaymentIntent(ctx, pi)
+			if err != nil {
+				t.Fatal(err)
+			}
+			order, err = ta.store.GetOrder(ctx, order.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			loc := ta.location(resp)
+			if passed {
+				if txn.TransactionStatusID != models.TransactionStatusCleared || !strings.HasPrefix(loc, "/receipt/") {
+					t.Errorf("status %d, redirected to %q, want cleared and the receipt", txn.TransactionStatusID, loc)
+				}
+				if order.StatusID != models.OrderStatusCleared {
+					t.Errorf("order status %d, want paid", order.StatusID)
+				}
+				ta.waitForMail(t, 1)
+
+				// the receipt can be viewed later with its token
+				resp, body := ta.get(t, loc)
+				if resp.StatusCode != http.StatusOK || !strings.Contains(body, "Rp1.110.000,00") {
+					t.Errorf("receipt status %d, want 200 showing the amount", resp.StatusCode)
+				}
+				return
+			}
+			if txn.TransactionStatusID != models.TransactionStatusDeclined || loc != "/widget/1" {
+				t.Errorf("status %d, redirected to %q, want declined and the widget page", txn.TransactionStatusID, loc)
+			}
+			if order.StatusID != models.OrderStatusCancelled {
+				t.Errorf("order status %d, want cancelled", order.StatusID)
+			}
+			ta.waitForMail(t, 0)
+		})
+	}
+}
+
+func TestVirtualTerminalPaymentSucceeded(t *testing.T) {
+	tests := []struct {
+		name          string
+		loggedIn      bool
+		paymentMethod string
+
+		wantRedirect string
+		wantStatus   int // of the transaction, 0 for none recorded
+	}{
+		{
+			name:          "not logged in",
+			paymentMethod: stripetest.CardVisa,
+			wantRedirect:  "/login",
+		},
+		{
+			name:          "charged",
+			loggedIn:      true,
+			paymentMethod: stripetest.CardVisa,
+			wantRedirect:  "/virtual-terminal-receipt",
+			wantStatus:    models.TransactionStatusCleared,
+		},
+		{
+			name:          "declined",
+			loggedIn:      true,
+			paymentMethod: stripetest.CardExpired,
+			wantRedirect:  "/virtual-terminal",
+			wantStatus:    models.TransactionStatusDeclined,
+		},
+		{
+			name:          "needs 3-D Secure",
+			loggedIn:      true,
+			paymentMethod: stripetest.CardAuthenticationRequired,
+			wantRedirect:  "3ds",
+			wantStatus:    models.TransactionStatusPending,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ta := newTestApp(t)
+			if tt.loggedIn {
+				ta.login(t)
+			}
+			pi := ta.pay(t, 5000000, cards.ChargeOptions{}, tt.paymentMethod)
+
+			resp := ta.postForm(t, "/virtual-terminal-payment-succeeded", url.Values{
+				"cardholder_name": {"Siti Nurhaliza Putri"},
+				"email":           {"Siti@Example.com"},
+				"memo":            {"  invoice 42  "},
+				"payment_intent":  {pi},
+				"payment_method":  {tt.paymentMethod},
+			})
+			want := tt.wantRedirect
+			if want == "3ds" {
+				want = ta.stripe.URL + "/3ds/" + pi
+			}
+			if loc := ta.location(resp); loc != want {
+				t.Errorf("redirected to %q, want %q", loc, want)
+			}
+
+			txn, err := ta.store.GetTransactionByPaymentIntent(context.Background(), pi)
+			if tt.wantStatus == 0 {
+				if err == nil {
+					t.Errorf("transaction %d recorded without a login", txn.ID)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("transaction not recorded: %v", err)
+			}
+			if txn.TransactionStatusID != tt.wantStatus || txn.Amount != 5000000 {
+				t.Errorf("transaction = status %d amount %d, want status %d amount 5000000", txn.TransactionStatusID, txn.Amount, tt.wantStatus)
+			}
+			if txn.Source != models.TransactionSourceTerminal || txn.UserID != ta.seed.Admin.ID || txn.Memo != "invoice 42" {
+				t.Errorf("transaction = source %q user %d memo %q, want a terminal charge by the admin", txn.Source, txn.UserID, txn.Memo)
+			}
+
+			customer, err := ta.store.GetCustomer(context.Background(), txn.CustomerID)
+			if err != nil || customer.FirstName != "Siti" || customer.LastName != "Nurhaliza Putri" || customer.Email != "siti@example.com" {
+				t.Errorf("customer = %+v, %v", customer, err)
+			}
+
+			var audited bool
+			for _, e := range ta.store.AuditEntries() {
+				if e.Action == audit.ActionTerminalCharge && e.EntityID == txn.ID && e.UserID == ta.seed.Admin.ID {
+					audited = true
+				}
+			}
+			if !audited {
+				t.Error("terminal charge not in the audit log")
+			}
+
+			// posting the charge again doesn't record it twice
+			resp = ta.postForm(t, "/virtual-terminal-payment-succeeded", url.Values{"payment_intent": {pi}, "payment_method": {tt.paymentMethod}})
+			if loc := ta.location(resp); loc != "/admin/terminal-charges" {
+				t.Errorf("posted again, redirected to %q, want the terminal charges", loc)
+			}
+
+			if tt.wantRedirect == "/virtual-terminal-receipt" {
+				resp, body := ta.get(t, tt.wantRedirect)
+				if resp.StatusCode != http.StatusOK || !strings.Contains(body, "Rp50.000,00") {
+					t.Errorf("receipt status %d, want 200 showing the amount", resp.StatusCode)
+				}
+			}
+		})
+	}
+}
+
+func TestPaymentSucceededTwice(t *testing.T) {
+	ta := newTestApp(t)
+	ctx := context.Background()
+	b := pricing.Breakdown{WidgetID: ta.seed.Widget.ID, Quantity: 1, Subtotal: 100000000, Tax: 11000000, Total: 111000000}
+	pi := ta.pay(t, b.Total, cards.ChargeOptions{Metadata: b.Metadata()}, stripetest.CardVisa)
+	form := url.Values{
+		"email":          {"budi@example.com"},
+		"payment_intent": {pi},
+		"payment_method": {stripetest.CardVisa},
+	}
+
+	first := ta.location(ta.postForm(t, "/payment-succeeded", form))
+	// a refresh posts the form again
+	second := ta.location(ta.postForm(t, "/payment-succeeded", form))
+	if !strings.HasPrefix(first, "/receipt/") || second != first {
+		t.Errorf("redirected to %q then %q, want the same receipt", first, second)
+	}
+	txn, err := ta.store.GetTransactionByPaymentIntent(ctx, pi)
+	if err != nil {
+		t.Fatal(err)
+	}
+	orders, _, _, err := ta.store.GetAllOrdersPaginated(ctx, models.OrderFilter{}, 10, 1)
+	if err != nil || len(orders) != 1 || orders[0].TransactionID != txn.ID {
+		t.Errorf("orders = %+v, %v, want the one order", orders, err)
+	}
+	ta.waitForMail(t, 1)
+}
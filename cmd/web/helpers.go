@@ -0,0 +1,43 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"go-stripe/internal/audit"
+	"net/http"
+	"strings"
+)
+
+// generateToken returns a random, url safe token that can't be guessed
+func generateToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// splitName splits a cardholder name into a first and last name. Everything
+// after the first word is the last name, and single names have no last name
+func splitName(name string) (string, string) {
+	fields := strings.Fields(name)
+	if len(fields) == 0 {
+		return "", ""
+	}
+	return fields[0], strings.Join(fields[1:], " ")
+}
+
+// recordAudit appends e to the audit log, acting as the user logged into the
+// session when e has no actor. A failure is logged but doesn't fail the
+// request, since the change it describes has already happened
+func (app *application) recordAudit(r *http.Request, e audit.Event) {
+	if e.Actor.ID == 0 && e.Actor.Email == "" {
+		e.Actor = audit.Actor{
+			ID:    app.Session.GetInt(r.Context(), "userID"),
+			Email: app.Session.GetString(r.Context(), "userEmail"),
+		}
+	}
+	if err := app.auditLog.Record(r, e); err != nil {
+		app.logger.ErrorContext(r.Context(), "could not write audit log", "action", e.Action, "err", err)
+	}
+}
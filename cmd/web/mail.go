@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"go-stripe/internal/invoice"
+	"go-stripe/internal/mailer"
+	"go-stripe/internal/models"
+	"html/template"
+	texttemplate "text/template"
+	"time"
+)
+
+// renderEmail renders templates/email/{name}.html.gohtml and
+// templates/email/{name}.plain.gohtml with data and returns both bodies
+func (app *application) renderEmail(name string, data map[string]any) (string, string, error) {
+	td := &templateData{Data: data}
+
+	htmlTmpl, err := template.New("email").Funcs(functions).ParseFS(tempateFs, fmt.Sprintf("templates/email/%s.html.gohtml", name))
+	if err != nil {
+		return "", "", err
+	}
+	var html bytes.Buffer
+	if err = htmlTmpl.ExecuteTemplate(&html, "body", td); err != nil {
+		return "", "", err
+	}
+
+	plainTmpl, err := texttemplate.New("email").Funcs(texttemplate.FuncMap(functions)).ParseFS(tempateFs, fmt.Sprintf("templates/email/%s.plain.gohtml", name))
+	if err != nil {
+		return "", "", err
+	}
+	var plain bytes.Buffer
+	if err = plainTmpl.ExecuteTemplate(&plain, "body", td); err != nil {
+		return "", "", err
+	}
+
+	return html.String(), plain.String(), nil
+}
+
+// sendEmail delivers msg in the background, retrying on failure, so a slow
+// or unavailable mail server never holds up the customer's request. ctx is
+// only for logging
+func (app *application) sendEmail(ctx context.Context, msg mailer.Message) {
+	msg.From = app.config.mail.from
+	msg.FromName = app.config.mail.fromName
+	go func() {
+		err := mailer.SendWithRetry(app.Mailer, msg, app.config.mail.attempts, 2*time.Second)
+		if err != nil {
+			app.logger.ErrorContext(ctx, "sending email failed", "email", msg.To, "subject", msg.Subject, "err", err)
+		}
+	}()
+}
+
+// sendReceipt emails the customer a receipt for a completed order
+func (app *application) sendReceipt(ctx context.Context, txnData TransactionData, order models.Order, widget models.Widget) {
+	if txnData.Email == "" {
+		return
+	}
+
+	data := map[string]any{
+		"txn":    txnData,
+		"order":  order,
+		"widget": widget,
+	}
+	html, plain, err := app.renderEmail("receipt", data)
+	if err != nil {
+		app.logger.ErrorContext(ctx, err.Error())
+		return
+	}
+
+	msg := mailer.Message{
+		To:      txnData.Email,
+		Subject: fmt.Sprintf("Your receipt for order #%d", order.ID),
+		HTML:    html,
+		Plain:   plain,
+	}
+
+	// the receipt still goes out if the invoice can't be produced
+	inv, err := invoice.Load(ctx, app.Models, app.seller(), order.ID)
+	if err == nil {
+		var pdf []byte
+		pdf, err = invoice.Render(inv)
+		if err == nil {
+			msg.Attachments = append(msg.Attachments, mailer.Attachment{
+				Filename:    inv.Filename(),
+				ContentType: "application/pdf",
+				Data:        pdf,
+			})
+		}
+	}
+	if err != nil {
+		app.logger.WarnContext(ctx, "could not attach invoice", "order_id", order.ID, "err", err)
+	}
+
+	app.sendEmail(ctx, msg)
+}
+
+// seller returns our company details for invoices
+func (app *application) seller() invoice.Seller {
+	return invoice.Seller{
+		Name:    app.config.seller.name,
+		Address: app.config.seller.address,
+		TaxID:   app.config.seller.taxID,
+		Email:   app.config.seller.email,
+	}
+}
@@ -1,14 +1,21 @@
 package main
 
 import (
+	"context"
 	"encoding/gob"
 	"flag"
 	"fmt"
 	"github.com/alexedwards/scs/v2"
+	"go-stripe/internal/audit"
+	"go-stripe/internal/cards"
 	"go-stripe/internal/driver"
+	"go-stripe/internal/logging"
+	"go-stripe/internal/mailer"
+	"go-stripe/internal/metrics"
 	"go-stripe/internal/models"
+	"go-stripe/internal/tracing"
 	"html/template"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
 	"time"
@@ -20,26 +27,55 @@ const cssVersion = "1"
 var session *scs.SessionManager
 
 type config struct {
-	port int
-	env  string
-	api  string
-	db   struct {
-		dsn string
+	port     int
+	env      string
+	logLevel slog.Level
+	api      string
+	db       struct {
+		dsn     string
+		timeout time.Duration
+	}
+	trace struct {
+		exporter string
+		endpoint string
 	}
 	stripe struct {
-		secret string
-		key    string
+		secret  string
+		key     string
+		timeout time.Duration
+	}
+	mail struct {
+		mailer   string
+		host     string
+		port     int
+		username string
+		password string
+		from     string
+		fromName string
+		dir      string
+		attempts int
+	}
+	seller struct {
+		name    string
+		address string
+		taxID   string
+		email   string
 	}
+	metricsToken string
 }
 
 type application struct {
 	config        config
-	infoLog       *log.Logger
-	errorLog      *log.Logger
+	logger        *slog.Logger
 	templateCache map[string]*template.Template
 	version       string
-	DB            models.DBModel
+	Models        models.Models
 	Session       *scs.SessionManager
+	Mailer        mailer.Mailer
+	auditLog      *audit.Logger
+	metrics       *metrics.Registry
+	stripeMetrics *cards.Metrics
+	orderMetrics  orderMetrics
 }
 
 func (app *application) serve() error {
@@ -51,7 +87,7 @@ func (app *application) serve() error {
 		ReadHeaderTimeout: 5 * time.Second,
 		WriteTimeout:      5 * time.Second,
 	}
-	app.infoLog.Println(fmt.Sprintf("Starting HTTP server in %s on port %d ", app.config.env, app.config.port))
+	app.logger.Info("starting HTTP server", "env", app.config.env, "port", app.config.port)
 	return srv.ListenAndServe()
 }
 func main() {
@@ -59,21 +95,58 @@ func main() {
 	var cfg config
 	flag.IntVar(&cfg.port, "port", 4000, "server port to listen on")
 	flag.StringVar(&cfg.env, "env", "development", "Application environtment {development|production}")
-	flag.StringVar(&cfg.db.dsn, "dsn", "root:mysql@tcp(localhost:3306)/go_stripe?parseTime=true&tls=false", "DSN")
+	flag.TextVar(&cfg.logLevel, "log-level", slog.LevelInfo, "Least severe level to log {debug|info|warn|error}")
+	flag.StringVar(&cfg.db.dsn, "dsn", "root:mysql@tcp(localhost:3306)/go_stripe?parseTime=true&tls=false", "DSN, for mysql, or a postgres:// or sqlite: URL")
+	flag.DurationVar(&cfg.db.timeout, "db-timeout", 3*time.Second, "How long a single database query may take")
+	flag.DurationVar(&cfg.stripe.timeout, "stripe-timeout", 30*time.Second, "How long a single call to stripe may take")
 	flag.StringVar(&cfg.api, "api", "http://localhost:4001", "URL to api")
+	flag.StringVar(&cfg.mail.mailer, "mailer", "file", "How to deliver email {smtp|file|memory}")
+	flag.StringVar(&cfg.mail.host, "smtp-host", "localhost", "SMTP host")
+	flag.IntVar(&cfg.mail.port, "smtp-port", 1025, "SMTP port")
+	flag.StringVar(&cfg.mail.from, "mail-from", "receipts@widgets.com", "Address receipts are sent from")
+	flag.StringVar(&cfg.mail.fromName, "mail-from-name", "Widgets", "Name receipts are sent from")
+	flag.StringVar(&cfg.mail.dir, "mail-dir", "./tmp/mail", "Directory the file mailer writes to")
+	flag.IntVar(&cfg.mail.attempts, "mail-attempts", 3, "How many times to try sending an email")
+	flag.StringVar(&cfg.seller.name, "seller-name", "PT Widget Indonesia", "Company name printed on invoices")
+	flag.StringVar(&cfg.seller.address, "seller-address", "Jl. Sudirman No. 1;Jakarta 10220;Indonesia", "Company address printed on invoices, lines separated by ;")
+	flag.StringVar(&cfg.seller.taxID, "seller-tax-id", "", "Company tax ID (NPWP) printed on invoices")
+	flag.StringVar(&cfg.seller.email, "seller-email", "billing@widgets.com", "Billing contact printed on invoices")
+	flag.StringVar(&cfg.trace.exporter, "trace-exporter", "none", "Where to send spans {none|stdout|otlp}")
+	flag.StringVar(&cfg.trace.endpoint, "trace-endpoint", "", "OTLP collector URL, such as http://localhost:4318")
 	flag.Parse()
 
 	cfg.stripe.key = os.Getenv("STRIPE_KEY")
 	cfg.stripe.secret = os.Getenv("STRIPE_SECRET")
+	cfg.mail.username = os.Getenv("SMTP_USERNAME")
+	cfg.mail.password = os.Getenv("SMTP_PASSWORD")
+	cfg.metricsToken = os.Getenv("METRICS_TOKEN")
 
-	infoLog := log.New(os.Stdout, "INFO\t", log.Ldate|log.Ltime)
-	errorLog := log.New(os.Stdout, "ERROR\t", log.Ldate|log.Ltime|log.Lshortfile)
+	logger := logging.New(os.Stdout, cfg.env, cfg.logLevel)
+
+	shutdown, err := tracing.Setup(context.Background(), tracing.Config{
+		Service:  "go-stripe-web",
+		Version:  version,
+		Exporter: cfg.trace.exporter,
+		Endpoint: cfg.trace.endpoint,
+	})
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+	defer shutdown(context.Background())
 
 	conn, err := driver.OpenDB(cfg.db.dsn)
 	if err != nil {
-		errorLog.Fatal(err)
+		logger.Error(err.Error())
+		os.Exit(1)
 	}
 	defer conn.Close()
+	db := &models.DBModel{
+		DB:       conn,
+		Dialect:  driver.DialectOf(cfg.db.dsn),
+		Timeouts: models.Timeouts{Query: cfg.db.timeout},
+		Logger:   logger,
+	}
 
 	// set up session
 	session = scs.New()
@@ -81,21 +154,38 @@ func main() {
 
 	tc := make(map[string]*template.Template)
 
+	var m mailer.Mailer
+	switch cfg.mail.mailer {
+	case "smtp":
+		m = &mailer.SMTPMailer{
+			Host:     cfg.mail.host,
+			Port:     cfg.mail.port,
+			Username: cfg.mail.username,
+			Password: cfg.mail.password,
+		}
+	case "memory":
+		m = &mailer.MemoryMailer{}
+	default:
+		m = &mailer.FileMailer{Dir: cfg.mail.dir}
+	}
+
 	app := &application{
 		config:        cfg,
-		infoLog:       infoLog,
-		errorLog:      errorLog,
+		logger:        logger,
 		templateCache: tc,
 		version:       version,
-		DB: models.DBModel{
-			DB: conn,
-		},
-		Session: session,
+		Models:        db.Models(),
+		Session:       session,
+		Mailer:        m,
+		auditLog:      &audit.Logger{DB: db},
 	}
 
+	app.initMetrics()
+	app.metrics.DBStats(conn)
+
 	err = app.serve()
 	if err != nil {
-		app.errorLog.Println(err)
-		log.Fatal(err)
+		logger.Error(err.Error())
+		os.Exit(1)
 	}
 }
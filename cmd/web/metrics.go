@@ -0,0 +1,30 @@
+package main
+
+import (
+	"go-stripe/internal/cards"
+	"go-stripe/internal/metrics"
+	"go-stripe/internal/models"
+)
+
+// orderMetrics count the orders placed through checkout and what they came to
+type orderMetrics struct {
+	placed *metrics.Counter
+	amount *metrics.Counter
+}
+
+// initMetrics sets up the metrics served at /metrics, other than the
+// database pool's, which main adds once it has a connection
+func (app *application) initMetrics() {
+	app.metrics = metrics.NewRegistry()
+	app.stripeMetrics = cards.NewMetrics(app.metrics)
+	app.orderMetrics = orderMetrics{
+		placed: app.metrics.Counter("orders_placed_total", "Orders placed through checkout, by currency.", "currency"),
+		amount: app.metrics.Counter("order_amount_total", "What the orders placed came to in the currency's smallest unit, tax included, by currency.", "currency"),
+	}
+}
+
+// orderPlaced counts order, paid in currency
+func (m orderMetrics) orderPlaced(order models.Order, currency string) {
+	m.placed.Inc(currency)
+	m.amount.Add(float64(order.Amount), currency)
+}
@@ -1,9 +1,84 @@
 package main
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
 	"net/http"
+	"net/url"
+	"strings"
 )
 
 func SessionLoad(next http.Handler) http.Handler {
 	return session.LoadAndSave(next)
 }
+
+// Auth sends visitors who aren't logged in to the login page
+func (app *application) Auth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !app.Session.Exists(r.Context(), "userID") {
+			http.Redirect(w, r, "/login", http.StatusTemporaryRedirect)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+type contextKey string
+
+// cspNonceKey is where SecureHeaders puts the request's script nonce
+const cspNonceKey = contextKey("cspNonce")
+
+// SecureHeaders sets the headers that keep the pages from being framed,
+// sniffed or made to run scripts they didn't ship with. Inline scripts run
+// only with the request's nonce, which the templates add to their script
+// tags
+func (app *application) SecureHeaders(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b := make([]byte, 16)
+		if _, err := rand.Read(b); err != nil {
+			app.logger.ErrorContext(r.Context(), err.Error())
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		nonce := base64.RawURLEncoding.EncodeToString(b)
+
+		h := w.Header()
+		h.Set("Content-Security-Policy", contentSecurityPolicy(nonce, app.config.api))
+		h.Set("X-Frame-Options", "DENY")
+		h.Set("X-Content-Type-Options", "nosniff")
+		h.Set("Referrer-Policy", "strict-origin-when-cross-origin")
+		if app.config.env == "production" {
+			h.Set("Strict-Transport-Security", "max-age=63072000; includeSubDomains")
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), cspNonceKey, nonce)))
+	})
+}
+
+// contentSecurityPolicy allows our own scripts and styles, bootstrap from
+// its cdn, and Stripe.js with the frames and calls it makes. Pages call the
+// api at apiURL. Forms aren't limited to our own origin, since paying can
+// redirect to the customer's bank for 3-D Secure
+func contentSecurityPolicy(nonce, apiURL string) string {
+	connect := "'self' https://api.stripe.com"
+	if u, err := url.Parse(apiURL); err == nil && u.Host != "" {
+		connect += " " + u.Scheme + "://" + u.Host
+	}
+	return strings.Join([]string{
+		"default-src 'self'",
+		"script-src 'self' 'nonce-" + nonce + "' https://js.stripe.com https://*.js.stripe.com https://cdn.jsdelivr.net",
+		"style-src 'self' https://cdn.jsdelivr.net",
+		"img-src 'self' data: https://*.stripe.com",
+		"connect-src " + connect,
+		"frame-src https://js.stripe.com https://*.js.stripe.com https://hooks.stripe.com",
+		"frame-ancestors 'none'",
+		"object-src 'none'",
+		"base-uri 'self'",
+	}, "; ")
+}
+
+// cspNonce is the script nonce SecureHeaders made for the request
+func cspNonce(ctx context.Context) string {
+	nonce, _ := ctx.Value(cspNonceKey).(string)
+	return nonce
+}
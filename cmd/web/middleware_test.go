@@ -0,0 +1,76 @@
+package main
+
+import (
+	"io/fs"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestSecureHeaders(t *testing.T) {
+	for _, env := range []string{"development", "production"} {
+		t.Run(env, func(t *testing.T) {
+			ta := newTestApp(t)
+			ta.config.env = env
+
+			resp, body := ta.get(t, "/widget/1")
+			h := resp.Header
+			if h.Get("X-Frame-Options") != "DENY" || h.Get("Referrer-Policy") != "strict-origin-when-cross-origin" {
+				t.Errorf("X-Frame-Options %q, Referrer-Policy %q", h.Get("X-Frame-Options"), h.Get("Referrer-Policy"))
+			}
+			if hsts := h.Get("Strict-Transport-Security"); (hsts != "") != (env == "production") {
+				t.Errorf("Strict-Transport-Security = %q in %s", hsts, env)
+			}
+
+			csp := h.Get("Content-Security-Policy")
+			nonce := regexp.MustCompile(`'nonce-([^']+)'`).FindStringSubmatch(csp)
+			if nonce == nil {
+				t.Fatalf("no nonce in %q", csp)
+			}
+			for _, want := range []string{"https://js.stripe.com", "connect-src 'self' https://api.stripe.com http://localhost:4001", "frame-ancestors 'none'"} {
+				if !strings.Contains(csp, want) {
+					t.Errorf("no %q in %q", want, csp)
+				}
+			}
+
+			// every script on the page, Stripe.js included, carries the nonce
+			scripts := strings.Count(body, "<script")
+			if n := strings.Count(body, `<script nonce="`+nonce[1]+`"`); scripts == 0 || n != scripts {
+				t.Errorf("%d of %d scripts have the nonce", n, scripts)
+			}
+			if strings.Contains(body, "onclick=") {
+				t.Error("inline event handler, which the policy blocks")
+			}
+
+			other, _ := ta.get(t, "/widget/1")
+			if other.Header.Get("Content-Security-Policy") == csp {
+				t.Error("nonce reused across requests")
+			}
+		})
+	}
+}
+
+// TestTemplatesWithinPolicy checks every page for the javascript: links and
+// inline event handlers the nonce policy blocks, including pages that need a
+// login to render
+func TestTemplatesWithinPolicy(t *testing.T) {
+	blocked := regexp.MustCompile(`javascript:|\son[a-z]+="`)
+	err := fs.WalkDir(tempateFs, "templates", func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		b, err := fs.ReadFile(tempateFs, path)
+		if err != nil {
+			return err
+		}
+		for i, line := range strings.Split(string(b), "\n") {
+			if blocked.MatchString(line) {
+				t.Errorf("%s:%d: %s", path, i+1, strings.TrimSpace(line))
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
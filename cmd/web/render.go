@@ -3,6 +3,7 @@ package main
 import (
 	"embed"
 	"fmt"
+	"go-stripe/internal/tracing"
 	"html/template"
 	"net/http"
 	"strings"
@@ -22,6 +23,12 @@ type templateData struct {
 	CSSVersion           string
 	StripeSecretKey      string
 	StripePublishableKey string
+	// Traceparent is the trace context of the request that rendered the
+	// page, sent back with the requests the page makes
+	Traceparent string
+	// CSPNonce lets the page's inline scripts run under the content
+	// security policy
+	CSPNonce string
 }
 
 var functions = template.FuncMap{
@@ -30,6 +37,13 @@ var functions = template.FuncMap{
 
 func formatCurrency(n int) string {
 	n /= 100
+	// Format the sign separately so it isn't grouped with the digits
+	sign := ""
+	if n < 0 {
+		sign = "-"
+		n = -n
+	}
+
 	// Convert int to string
 	s := fmt.Sprintf("%d", n)
 
@@ -56,16 +70,23 @@ func formatCurrency(n int) string {
 	}
 
 	// Return the formatted string with "Rp" prefix
-	return fmt.Sprintf("Rp%s,00", string(r))
+	return fmt.Sprintf("%sRp%s,00", sign, string(r))
 }
 
 //go:embed templates
 var tempateFs embed.FS
 
 func (app *application) addDefaultData(td *templateData, r *http.Request) *templateData {
+	td.Flash = app.Session.PopString(r.Context(), "flash")
+	td.Error = app.Session.PopString(r.Context(), "error")
+	if app.Session.Exists(r.Context(), "userID") {
+		td.IsAuthenticated = 1
+	}
 	td.API = app.config.api
 	td.StripeSecretKey = app.config.stripe.secret
 	td.StripePublishableKey = app.config.stripe.key
+	td.Traceparent = tracing.Traceparent(r.Context())
+	td.CSPNonce = cspNonce(r.Context())
 	return td
 }
 
@@ -81,7 +102,7 @@ func (app *application) renderTemplate(w http.ResponseWriter, r *http.Request, p
 	} else {
 		t, err = app.parseTemplate(partials, page, templateToRender)
 		if err != nil {
-			app.errorLog.Println(err)
+			app.logger.ErrorContext(r.Context(), err.Error())
 			return err
 		}
 	}
@@ -89,11 +110,11 @@ func (app *application) renderTemplate(w http.ResponseWriter, r *http.Request, p
 	if td == nil {
 		td = &templateData{}
 	}
-	//app.infoLog.Printf("Rendering template with data: %+v", td)
+	//app.logger.DebugContext(r.Context(), "rendering template", "data", td)
 	td = app.addDefaultData(td, r)
 	err = t.Execute(w, td)
 	if err != nil {
-		app.errorLog.Println(err)
+		app.logger.ErrorContext(r.Context(), err.Error())
 		return err
 	}
 
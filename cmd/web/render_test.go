@@ -0,0 +1,25 @@
+package main
+
+import "testing"
+
+func TestFormatCurrency(t *testing.T) {
+	tests := []struct {
+		n    int
+		want string
+	}{
+		{0, "Rp0,00"},
+		{99, "Rp0,00"},
+		{100, "Rp1,00"},
+		{100000, "Rp1.000,00"},
+		{5000000, "Rp50.000,00"},
+		{12345678900, "Rp123.456.789,00"},
+		{111000000, "Rp1.110.000,00"},
+		{-5000000, "-Rp50.000,00"},
+		{-12345600, "-Rp123.456,00"},
+	}
+	for _, tt := range tests {
+		if got := formatCurrency(tt.n); got != tt.want {
+			t.Errorf("formatCurrency(%d) = %q, want %q", tt.n, got, tt.want)
+		}
+	}
+}
@@ -2,24 +2,58 @@ package main
 
 import (
 	"github.com/go-chi/chi/v5"
+	"go-stripe/internal/logging"
+	"go-stripe/internal/metrics"
+	"go-stripe/internal/tracing"
 	"net/http"
 )
 
 func (app *application) routes() http.Handler {
 	mux := chi.NewRouter()
+	mux.Use(logging.RequestID)
+	mux.Use(tracing.HTTP)
+	mux.Use(logging.AccessLog(app.logger))
+	mux.Use(metrics.HTTP(app.metrics))
+	mux.Use(app.SecureHeaders)
 	mux.Use(SessionLoad)
+	mux.Method(http.MethodGet, "/metrics", metrics.RequireToken(app.config.metricsToken, app.metrics.Handler()))
 	mux.Get("/", app.Home)
-	mux.Get("/virtual-terminal", app.VirtualTerminal)
-	mux.Post("/virtual-terminal-payment-succeeded", app.VirtualTerminalPaymentSucceeded)
-	mux.Get("/virtual-terminal-receipt", app.VirtualTerminalReceipt)
+
+	// the virtual terminal is for staff, who are recorded against each charge
+	mux.Group(func(mux chi.Router) {
+		mux.Use(app.Auth)
+		mux.Get("/virtual-terminal", app.VirtualTerminal)
+		mux.Post("/virtual-terminal-payment-succeeded", app.VirtualTerminalPaymentSucceeded)
+		mux.Get("/virtual-terminal-receipt", app.VirtualTerminalReceipt)
+	})
 
 	mux.Post("/payment-succeeded", app.PaymentSucceeded)
-	mux.Get("/receipt", app.Receipt)
+	mux.Get("/payment-return", app.PaymentReturn)
+	mux.Get("/receipt/{token}", app.Receipt)
 
 	mux.Get("/widget/{id}", app.ChargeOnce)
 
 	//display
 	mux.Get("/charge-once", app.ChargeOnce)
+
+	// auth routes
+	mux.Get("/login", app.LoginPage)
+	mux.Post("/login", app.PostLoginPage)
+	mux.Get("/logout", app.Logout)
+
+	mux.Route("/admin", func(mux chi.Router) {
+		mux.Use(app.Auth)
+		mux.Get("/dashboard", app.SalesDashboard)
+		mux.Get("/orders", app.AllOrders)
+		mux.Get("/orders/{id}", app.ShowOrder)
+		mux.Get("/terminal-charges", app.TerminalCharges)
+		mux.Get("/disputes", app.AllDisputes)
+		mux.Get("/disputes/{id}", app.ShowDispute)
+		mux.Get("/payouts", app.AllPayouts)
+		mux.Get("/payouts/{id}", app.ShowPayout)
+		mux.Get("/widgets/import", app.ImportWidgets)
+		mux.Get("/audit", app.AuditLog)
+	})
 	fileServer := http.FileServer(http.Dir("./static"))
 	mux.Handle("/static/*", http.StripPrefix("/static", fileServer))
 
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"encoding/gob"
+	"github.com/alexedwards/scs/v2"
+	"go-stripe/internal/audit"
+	"go-stripe/internal/cards"
+	"go-stripe/internal/logging"
+	"go-stripe/internal/mailer"
+	"go-stripe/internal/models"
+	"go-stripe/internal/stripetest"
+	"html/template"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testApp is the web front end wired to a seeded in-memory store, a fake
+// stripe and a memory mailer, served over http the way it is in production
+type testApp struct {
+	*application
+	store  *models.MemoryStore
+	seed   models.Seed
+	stripe *stripetest.Server
+	mail   *mailer.MemoryMailer
+	server *httptest.Server
+	// client keeps the session cookie and doesn't follow redirects, so
+	// tests can check where each request sends the browser
+	client *http.Client
+}
+
+func newTestApp(t *testing.T) *testApp {
+	t.Helper()
+	gob.Register(TransactionData{})
+
+	store := models.NewMemoryStore()
+	seed, err := store.Seed()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var cfg config
+	cfg.env = "development"
+	cfg.api = "http://localhost:4001"
+	cfg.stripe.secret = "sk_test_fake"
+	cfg.stripe.key = "pk_test_fake"
+	cfg.mail.from = "receipts@widgets.com"
+	cfg.mail.attempts = 1
+	cfg.seller.name = "PT Widget Indonesia"
+
+	session = scs.New()
+	mail := &mailer.MemoryMailer{}
+	app := &application{
+		config:        cfg,
+		logger:        logging.Discard(),
+		templateCache: make(map[string]*template.Template),
+		version:       version,
+		Models:        store.Models(),
+		Session:       session,
+		Mailer:        mail,
+		auditLog:      &audit.Logger{DB: store},
+	}
+
+	app.initMetrics()
+
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ta := &testApp{
+		application: app,
+		store:       store,
+		seed:        seed,
+		stripe:      stripetest.NewServer(t),
+		mail:        mail,
+		server:      httptest.NewServer(app.routes()),
+		client: &http.Client{
+			Jar: jar,
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+	}
+	t.Cleanup(ta.server.Close)
+	return ta
+}
+
+// get requests path and returns the response, with its body read into body
+func (ta *testApp) get(t *testing.T, path string) (resp *http.Response, body string) {
+	t.Helper()
+	resp, err := ta.client.Get(ta.server.URL + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp, readBody(t, resp)
+}
+
+// postForm posts form to path and returns the response
+func (ta *testApp) postForm(t *testing.T, path string, form url.Values) *http.Response {
+	t.Helper()
+	resp, err := ta.client.PostForm(ta.server.URL+path, form)
+	if err != nil {
+		t.Fatal(err)
+	}
+	readBody(t, resp)
+	return resp
+}
+
+func readBody(t *testing.T, resp *http.Response) string {
+	t.Helper()
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+// login logs the seeded admin into the client's session
+func (ta *testApp) login(t *testing.T) {
+	t.Helper()
+	resp := ta.postForm(t, "/login", url.Values{
+		"email":    {ta.seed.Admin.Email},
+		"password": {ta.seed.AdminPassword},
+	})
+	if loc := resp.Header.Get("Location"); loc != "/admin/orders" {
+		t.Fatalf("login redirected to %q, want /admin/orders", loc)
+	}
+}
+
+// pay creates a payment intent for amount and confirms it with one of the
+// stripetest cards, as the api and stripe.js do between them before the
+// browser posts the payment to us
+func (ta *testApp) pay(t *testing.T, amount int, opts cards.ChargeOptions, paymentMethod string) string {
+	t.Helper()
+	card := cards.Card{Secret: ta.config.stripe.secret}
+	pi, msg, err := card.CreatePaymentIntentWithOptions(context.Background(), "idr", amount, opts)
+	if err != nil {
+		t.Fatalf("creating payment intent: %v (%s)", err, msg)
+	}
+	if _, err = ta.stripe.Confirm(pi.ID, paymentMethod); err != nil {
+		t.Fatal(err)
+	}
+	return pi.ID
+}
+
+// waitForMail waits for the mailer to have sent n messages, since email goes
+// out in the background, and returns them
+func (ta *testApp) waitForMail(t *testing.T, n int) []mailer.Message {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		msgs := ta.mail.Messages()
+		if len(msgs) >= n || time.Now().After(deadline) {
+			if len(msgs) != n {
+				t.Fatalf("%d emails sent, want %d", len(msgs), n)
+			}
+			return msgs
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// location is where resp redirects to, relative to the test server
+func (ta *testApp) location(resp *http.Response) string {
+	return strings.TrimPrefix(resp.Header.Get("Location"), ta.server.URL)
+}
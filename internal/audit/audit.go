@@ -0,0 +1,109 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"go-stripe/internal/models"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// actions recorded in the audit log
+const (
+	ActionLogin          = "user.login"
+	ActionLoginFailed    = "user.login_failed"
+	ActionTerminalCharge = "payment.terminal_charge"
+	ActionCapture        = "payment.capture"
+	ActionVoid           = "payment.void"
+	ActionRefund         = "payment.refund"
+	ActionOrderStatus    = "order.status_change"
+	ActionWidgetUpdate   = "widget.update"
+	ActionWidgetImport   = "widget.import"
+	ActionDisputeFile    = "dispute.evidence_file"
+	ActionDisputeUpdate  = "dispute.evidence"
+)
+
+// kinds of things an audit entry can be about
+const (
+	EntityOrder       = "order"
+	EntityTransaction = "transaction"
+	EntityWidget      = "widget"
+	EntityUser        = "user"
+	EntityDispute     = "dispute"
+)
+
+// Actor is whoever performed an action. ID is 0 when nobody is logged in
+type Actor struct {
+	ID    int
+	Email string
+}
+
+// Event describes one action for the audit log. Before and After are
+// marshalled to json, and may be left nil
+type Event struct {
+	Action     string
+	Actor      Actor
+	EntityType string
+	EntityID   int
+	Before     any
+	After      any
+}
+
+// Store is where audit entries are kept
+type Store interface {
+	InsertAuditEntry(ctx context.Context, e models.AuditEntry) error
+}
+
+// Logger writes events to the audit_log table
+type Logger struct {
+	DB Store
+}
+
+// Record appends e to the audit log, stamped with the client address and
+// request id of r. The entry is written even if the client has gone away,
+// since what it records has already happened
+func (l *Logger) Record(r *http.Request, e Event) error {
+	before, err := marshal(e.Before)
+	if err != nil {
+		return err
+	}
+	after, err := marshal(e.After)
+	if err != nil {
+		return err
+	}
+
+	return l.DB.InsertAuditEntry(context.WithoutCancel(r.Context()), models.AuditEntry{
+		Action:     e.Action,
+		UserID:     e.Actor.ID,
+		ActorEmail: strings.ToLower(e.Actor.Email),
+		IPAddress:  ClientIP(r),
+		RequestID:  middleware.GetReqID(r.Context()),
+		EntityType: e.EntityType,
+		EntityID:   e.EntityID,
+		Before:     before,
+		After:      after,
+	})
+}
+
+// ClientIP returns the address the request came from, without the port
+func ClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func marshal(v any) (string, error) {
+	if v == nil {
+		return "", nil
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
@@ -1,16 +1,90 @@
 package cards
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
 	"github.com/stripe/stripe-go/v72"
 	"github.com/stripe/stripe-go/v72/paymentintent"
 	"github.com/stripe/stripe-go/v72/paymentmethod"
+	"github.com/stripe/stripe-go/v72/refund"
+	"go-stripe/internal/models"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"log/slog"
+	"time"
 )
 
 type Card struct {
 	Secret   string
 	Key      string
 	Currency string
+	// Timeout is how long one call to stripe may take, 30 seconds when zero
+	Timeout time.Duration
+	// ListTimeout is how long listing may take across all of its pages, 2
+	// minutes when zero
+	ListTimeout time.Duration
+	// Logger is where calls to stripe are logged, the default logger when nil
+	Logger *slog.Logger
+	// Metrics times and counts the calls, nil to keep none
+	Metrics *Metrics
+}
+
+// withTimeout derives the context for one call to stripe from ctx, so the
+// call is abandoned when either runs out
+func (c *Card) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if c.Timeout <= 0 {
+		return context.WithTimeout(ctx, 30*time.Second)
+	}
+	return context.WithTimeout(ctx, c.Timeout)
+}
+
+// withListTimeout is withTimeout for listings that page through results
+func (c *Card) withListTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if c.ListTimeout <= 0 {
+		return context.WithTimeout(ctx, 2*time.Minute)
+	}
+	return context.WithTimeout(ctx, c.ListTimeout)
+}
+
+// tracer starts a span for every call to stripe
+var tracer = otel.Tracer("go-stripe/internal/cards")
+
+// call starts a call to stripe, op, and returns the func that logs,
+// measures and ends the span of how it went. Declines are the customer's
+// bank saying no and are logged as warnings, so they can be told apart
+// from stripe failing
+func (c *Card) call(ctx context.Context, op string) func(error) {
+	_, span := tracer.Start(ctx, "stripe "+op, trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("stripe.operation", op)))
+	start := time.Now()
+	return func(err error) {
+		defer span.End()
+		logger := c.Logger
+		if logger == nil {
+			logger = slog.Default()
+		}
+		elapsed := time.Since(start)
+		c.Metrics.observe(op, elapsed, err)
+
+		var stripeErr *stripe.Error
+		switch {
+		case err == nil:
+			logger.DebugContext(ctx, "stripe call", "op", op, "duration", elapsed)
+		case errors.As(err, &stripeErr) && stripeErr.Type == stripe.ErrorTypeCard:
+			// the call worked, the answer was no
+			span.SetAttributes(attribute.String("stripe.error_code", string(stripeErr.Code)),
+				attribute.String("stripe.decline_code", string(stripeErr.DeclineCode)))
+			logger.WarnContext(ctx, "card declined", "op", op, "duration", elapsed,
+				"code", stripeErr.Code, "decline_code", stripeErr.DeclineCode)
+		default:
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+			logger.ErrorContext(ctx, "stripe call failed", "op", op, "duration", elapsed, "err", err)
+		}
+	}
 }
 
 type Transaction struct {
@@ -21,20 +95,53 @@ type Transaction struct {
 	BankReturnCode      string
 }
 
-func (c *Card) Charge(currency string, amount int) (*stripe.PaymentIntent, string, error) {
-	return c.CreatePaymentIntent(currency, amount)
+func (c *Card) Charge(ctx context.Context, currency string, amount int) (*stripe.PaymentIntent, string, error) {
+	return c.CreatePaymentIntent(ctx, currency, amount)
 }
 
-func (c *Card) CreatePaymentIntent(currency string, amount int) (*stripe.PaymentIntent, string, error) {
+// ChargeOptions are the optional settings for a new payment intent
+type ChargeOptions struct {
+	// Metadata is stored on the payment intent and comes back every time it is retrieved
+	Metadata map[string]string
+	// ManualCapture only authorizes the card. The money is taken later with Capture
+	ManualCapture bool
+	// PaymentMethod is the card the payment intent is for, when the browser
+	// has already made one. The intent can then only be confirmed with it
+	PaymentMethod string
+}
+
+// AuthorizationWindow is how long stripe holds an uncaptured card authorization
+const AuthorizationWindow = 7 * 24 * time.Hour
+
+func (c *Card) CreatePaymentIntent(ctx context.Context, currency string, amount int) (*stripe.PaymentIntent, string, error) {
+	return c.CreatePaymentIntentWithOptions(ctx, currency, amount, ChargeOptions{})
+}
+
+// CreatePaymentIntentWithOptions creates a payment intent with the given options
+func (c *Card) CreatePaymentIntentWithOptions(ctx context.Context, currency string, amount int, opts ChargeOptions) (*stripe.PaymentIntent, string, error) {
 	stripe.Key = c.Secret
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
 
 	//create a payment intent
 	params := &stripe.PaymentIntentParams{
 		Amount:   stripe.Int64(int64(amount)),
 		Currency: stripe.String(currency),
 	}
-	//params.AddMetadata("key","value")
+	params.Context = ctx
+	for k, v := range opts.Metadata {
+		params.AddMetadata(k, v)
+	}
+	if opts.ManualCapture {
+		params.CaptureMethod = stripe.String(string(stripe.PaymentIntentCaptureMethodManual))
+	}
+	if opts.PaymentMethod != "" {
+		params.PaymentMethod = stripe.String(opts.PaymentMethod)
+	}
+	done := c.call(ctx, "payment_intent.create")
 	pi, err := paymentintent.New(params)
+	done(err)
+	c.Metrics.paymentIntentCreated(err)
 	if err != nil {
 		msg := ""
 		var stripeErr *stripe.Error
@@ -47,9 +154,15 @@ func (c *Card) CreatePaymentIntent(currency string, amount int) (*stripe.Payment
 }
 
 // GetPaymentMethod gets the payment method by id
-func (c *Card) GetPaymentMethod(s string) (*stripe.PaymentMethod, error) {
+func (c *Card) GetPaymentMethod(ctx context.Context, s string) (*stripe.PaymentMethod, error) {
 	stripe.Key = c.Secret
-	pm, err := paymentmethod.Get(s, nil)
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+	params := &stripe.PaymentMethodParams{}
+	params.Context = ctx
+	done := c.call(ctx, "payment_method.get")
+	pm, err := paymentmethod.Get(s, params)
+	done(err)
 	if err != nil {
 		return nil, err
 	}
@@ -57,15 +170,221 @@ func (c *Card) GetPaymentMethod(s string) (*stripe.PaymentMethod, error) {
 }
 
 // RetrievePaymentIntent gets an existing payment intent by id
-func (c *Card) RetrievePaymentIntent(id string) (*stripe.PaymentIntent, error) {
+func (c *Card) RetrievePaymentIntent(ctx context.Context, id string) (*stripe.PaymentIntent, error) {
 	stripe.Key = c.Secret
-	pi, err := paymentintent.Get(id, nil)
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+	params := &stripe.PaymentIntentParams{}
+	params.Context = ctx
+	done := c.call(ctx, "payment_intent.get")
+	pi, err := paymentintent.Get(id, params)
+	done(err)
 	if err != nil {
 		return nil, err
 	}
 	return pi, nil
 }
 
+// Capture takes the money for an authorized payment intent. An amount of 0
+// captures everything that was authorized, anything less is a partial capture
+// and the remainder is released back to the card. Calls with the same
+// idempotency key get the first call's result instead of capturing again
+func (c *Card) Capture(ctx context.Context, id string, amount int, idempotencyKey string) (*stripe.PaymentIntent, error) {
+	stripe.Key = c.Secret
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+	params := &stripe.PaymentIntentCaptureParams{}
+	params.Context = ctx
+	setIdempotencyKey(&params.Params, idempotencyKey)
+	if amount > 0 {
+		params.AmountToCapture = stripe.Int64(int64(amount))
+	}
+	done := c.call(ctx, "payment_intent.capture")
+	pi, err := paymentintent.Capture(id, params)
+	done(err)
+	if err != nil {
+		return nil, err
+	}
+	return pi, nil
+}
+
+// Cancel voids a payment intent that has not been captured, once per
+// idempotency key
+func (c *Card) Cancel(ctx context.Context, id string, idempotencyKey string) (*stripe.PaymentIntent, error) {
+	stripe.Key = c.Secret
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+	params := &stripe.PaymentIntentCancelParams{}
+	params.Context = ctx
+	setIdempotencyKey(&params.Params, idempotencyKey)
+	done := c.call(ctx, "payment_intent.cancel")
+	pi, err := paymentintent.Cancel(id, params)
+	done(err)
+	if err != nil {
+		return nil, err
+	}
+	return pi, nil
+}
+
+// Refund gives back amount of a captured payment intent, or all of it if
+// amount is 0, once per idempotency key
+func (c *Card) Refund(ctx context.Context, paymentIntent string, amount int, idempotencyKey string) (*stripe.Refund, error) {
+	stripe.Key = c.Secret
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+	params := &stripe.RefundParams{
+		PaymentIntent: stripe.String(paymentIntent),
+	}
+	params.Context = ctx
+	setIdempotencyKey(&params.Params, idempotencyKey)
+	if amount > 0 {
+		params.Amount = stripe.Int64(int64(amount))
+	}
+	done := c.call(ctx, "refund.create")
+	r, err := refund.New(params)
+	done(err)
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// setIdempotencyKey sends key as the request's Idempotency-Key, so stripe
+// answers a repeat with the first result instead of moving the money twice.
+// An empty key sends none
+func setIdempotencyKey(params *stripe.Params, key string) {
+	if key != "" {
+		params.SetIdempotencyKey(key)
+	}
+}
+
+// ListPaymentIntents returns the payment intents created in [from, to)
+func (c *Card) ListPaymentIntents(ctx context.Context, from, to time.Time) ([]*stripe.PaymentIntent, error) {
+	stripe.Key = c.Secret
+	ctx, cancel := c.withListTimeout(ctx)
+	defer cancel()
+	params := &stripe.PaymentIntentListParams{
+		CreatedRange: &stripe.RangeQueryParams{
+			GreaterThanOrEqual: from.Unix(),
+			LesserThan:         to.Unix(),
+		},
+	}
+	params.Limit = stripe.Int64(100)
+	params.Context = ctx
+
+	var intents []*stripe.PaymentIntent
+	done := c.call(ctx, "payment_intent.list")
+	i := paymentintent.List(params)
+	for i.Next() {
+		intents = append(intents, i.PaymentIntent())
+	}
+	err := i.Err()
+	done(err)
+	if err != nil {
+		return nil, err
+	}
+	return intents, nil
+}
+
+// TransactionStatus maps a payment intent status onto our transaction statuses.
+// Anything the customer or the bank still has to act on is pending
+func TransactionStatus(status stripe.PaymentIntentStatus) int {
+	switch status {
+	case stripe.PaymentIntentStatusSucceeded:
+		return models.TransactionStatusCleared
+	case stripe.PaymentIntentStatusRequiresCapture:
+		return models.TransactionStatusAuthorized
+	case stripe.PaymentIntentStatusCanceled, stripe.PaymentIntentStatusRequiresPaymentMethod:
+		return models.TransactionStatusDeclined
+	default:
+		return models.TransactionStatusPending
+	}
+}
+
+// ChargeID returns the id of the most recent charge on pi, or an empty
+// string if nothing has been charged yet, e.g. while 3-D Secure is pending
+func ChargeID(pi *stripe.PaymentIntent) string {
+	if pi.Charges == nil || len(pi.Charges.Data) == 0 {
+		return ""
+	}
+	return pi.Charges.Data[0].ID
+}
+
+// RedirectURL returns the page the customer must visit to authenticate the
+// payment, or an empty string if no redirect is needed
+func RedirectURL(pi *stripe.PaymentIntent) string {
+	if pi.Status != stripe.PaymentIntentStatusRequiresAction || pi.NextAction == nil || pi.NextAction.RedirectToURL == nil {
+		return ""
+	}
+	return pi.NextAction.RedirectToURL.URL
+}
+
+// IsPaymentFailedEvent reports whether a webhook event is a payment intent
+// that failed, which for a card is usually the bank declining it
+func IsPaymentFailedEvent(event stripe.Event) bool {
+	return event.Type == "payment_intent.payment_failed"
+}
+
+// IsPaymentSucceededEvent reports whether a webhook event is a payment intent
+// that has been paid
+func IsPaymentSucceededEvent(event stripe.Event) bool {
+	return event.Type == "payment_intent.succeeded"
+}
+
+// IsPaymentCanceledEvent reports whether a webhook event is a payment intent
+// that was cancelled, and so will never be paid
+func IsPaymentCanceledEvent(event stripe.Event) bool {
+	return event.Type == "payment_intent.canceled"
+}
+
+// PaymentIntentFromEvent reads the payment intent out of a payment_intent.*
+// event. Its last payment error carries the card that was tried in full
+func PaymentIntentFromEvent(event stripe.Event) (*stripe.PaymentIntent, error) {
+	if event.Data == nil {
+		return nil, errors.New("event has no data")
+	}
+	var pi stripe.PaymentIntent
+	if err := json.Unmarshal(event.Data.Raw, &pi); err != nil {
+		return nil, err
+	}
+	return &pi, nil
+}
+
+// cardErrorCategory is the group code is counted in, the code itself for
+// the ones cardErrorMessage explains and "other" for the rest
+func cardErrorCategory(code stripe.ErrorCode) string {
+	switch code {
+	case stripe.ErrorCodeCardDeclined, stripe.ErrorCodeExpiredCard, stripe.ErrorCodeIncorrectCVC,
+		stripe.ErrorCodeIncorrectZip, stripe.ErrorCodeAmountTooLarge, stripe.ErrorCodeAmountTooSmall,
+		stripe.ErrorCodeBalanceInsufficient, stripe.ErrorCodePostalCodeInvalid:
+		return string(code)
+	}
+	return "other"
+}
+
+// IsDecline reports whether err is the bank refusing the card, for one of
+// the reasons cardErrorMessage tells the customer about. A charge that is
+// too large or too small is about our amount rather than the card, so
+// isn't one
+func IsDecline(err error) bool {
+	var stripeErr *stripe.Error
+	if !errors.As(err, &stripeErr) || stripeErr == nil || stripeErr.Type != stripe.ErrorTypeCard {
+		return false
+	}
+	switch stripeErr.Code {
+	case stripe.ErrorCodeAmountTooLarge, stripe.ErrorCodeAmountTooSmall:
+		return false
+	}
+	return true
+}
+
+// Answered reports whether err is stripe turning a call down. Any other
+// error, such as a timeout, leaves it unknown whether the call took effect
+func Answered(err error) bool {
+	var stripeErr *stripe.Error
+	return errors.As(err, &stripeErr) && stripeErr != nil
+}
+
 func cardErrorMessage(code stripe.ErrorCode) string {
 	var msg = ""
 	switch code {
@@ -0,0 +1,198 @@
+package cards
+
+import (
+	"context"
+	"errors"
+	"github.com/stripe/stripe-go/v72"
+	"go-stripe/internal/metrics"
+	"go-stripe/internal/models"
+	"go-stripe/internal/stripetest"
+	"testing"
+)
+
+func TestCardErrorMessage(t *testing.T) {
+	tests := []struct {
+		code stripe.ErrorCode
+		want string
+	}{
+		{stripe.ErrorCodeCardDeclined, "Your cards was declined"},
+		{stripe.ErrorCodeExpiredCard, "Your cards is expired"},
+		{stripe.ErrorCodeIncorrectCVC, "Incorrect CVC code"},
+		{stripe.ErrorCodeIncorrectZip, "Incorrect zip/postal code"},
+		{stripe.ErrorCodeAmountTooLarge, "The amount is too large to charge to your cards"},
+		{stripe.ErrorCodeAmountTooSmall, "The amount is too small to charge to your cards"},
+		{stripe.ErrorCodeBalanceInsufficient, "Insufficient balance"},
+		{stripe.ErrorCodePostalCodeInvalid, "Your postal code is invalid"},
+		{stripe.ErrorCodeProcessingError, "Your cards was declined"},
+		{"", "Your cards was declined"},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.code), func(t *testing.T) {
+			if got := cardErrorMessage(tt.code); got != tt.want {
+				t.Errorf("cardErrorMessage(%q) = %q, want %q", tt.code, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsDecline(t *testing.T) {
+	var noError *stripe.Error
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"declined", &stripe.Error{Type: stripe.ErrorTypeCard, Code: stripe.ErrorCodeCardDeclined}, true},
+		{"wrong cvc", &stripe.Error{Type: stripe.ErrorTypeCard, Code: stripe.ErrorCodeIncorrectCVC}, true},
+		{"unexplained", &stripe.Error{Type: stripe.ErrorTypeCard, Code: stripe.ErrorCodeProcessingError}, true},
+		{"amount too small", &stripe.Error{Type: stripe.ErrorTypeCard, Code: stripe.ErrorCodeAmountTooSmall}, false},
+		{"stripe failed", &stripe.Error{Type: stripe.ErrorTypeAPI}, false},
+		{"network", errors.New("connection reset"), false},
+		{"nil stripe error", noError, false},
+	}
+	for _, tt := range tests {
+		if got := IsDecline(tt.err); got != tt.want {
+			t.Errorf("%s: IsDecline = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestCreatePaymentIntentErrors(t *testing.T) {
+	fake := stripetest.NewServer(t)
+	card := &Card{Secret: "sk_test_fake"}
+
+	tests := []struct {
+		name    string
+		fail    stripe.ErrorCode
+		wantMsg string
+	}{
+		{"declined", stripe.ErrorCodeCardDeclined, "Your cards was declined"},
+		{"insufficient funds", stripe.ErrorCodeBalanceInsufficient, "Insufficient balance"},
+		{"too large", stripe.ErrorCodeAmountTooLarge, "The amount is too large to charge to your cards"},
+		{"unknown code", stripe.ErrorCodeRateLimit, "Your cards was declined"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake.FailNext(tt.fail)
+			pi, msg, err := card.CreatePaymentIntent(context.Background(), "idr", 1000)
+			if err == nil {
+				t.Fatalf("got payment intent %v, want an error", pi.ID)
+			}
+			var stripeErr *stripe.Error
+			if !errors.As(err, &stripeErr) || stripeErr.Code != tt.fail {
+				t.Errorf("err = %v, want a stripe error with code %s", err, tt.fail)
+			}
+			if msg != tt.wantMsg {
+				t.Errorf("msg = %q, want %q", msg, tt.wantMsg)
+			}
+		})
+	}
+}
+
+func TestCreatePaymentIntentWithOptions(t *testing.T) {
+	fake := stripetest.NewServer(t)
+	card := &Card{Secret: "sk_test_fake"}
+
+	pi, msg, err := card.CreatePaymentIntentWithOptions(context.Background(), "idr", 111000, ChargeOptions{
+		Metadata:      map[string]string{"total": "111000"},
+		ManualCapture: true,
+	})
+	if err != nil {
+		t.Fatalf("CreatePaymentIntentWithOptions: %v (%s)", err, msg)
+	}
+
+	got, ok := fake.PaymentIntent(pi.ID)
+	if !ok {
+		t.Fatalf("payment intent %s not created", pi.ID)
+	}
+	if got.Amount != 111000 || got.Currency != "idr" {
+		t.Errorf("amount = %d %s, want 111000 idr", got.Amount, got.Currency)
+	}
+	if got.CaptureMethod != stripe.PaymentIntentCaptureMethodManual {
+		t.Errorf("capture method = %s, want manual", got.CaptureMethod)
+	}
+	if got.Metadata["total"] != "111000" {
+		t.Errorf("metadata = %v, want total 111000", got.Metadata)
+	}
+
+	// an authorized card is captured, and then refunded
+	if _, err = fake.Confirm(pi.ID, stripetest.CardVisa); err != nil {
+		t.Fatal(err)
+	}
+	captured, err := card.Capture(context.Background(), pi.ID, 100000, "")
+	if err != nil {
+		t.Fatalf("Capture: %v", err)
+	}
+	if captured.AmountReceived != 100000 || TransactionStatus(captured.Status) != models.TransactionStatusCleared {
+		t.Errorf("captured %d with status %s, want 100000 succeeded", captured.AmountReceived, captured.Status)
+	}
+	r, err := card.Refund(context.Background(), pi.ID, 0, "order-1-refunded")
+	if err != nil {
+		t.Fatalf("Refund: %v", err)
+	}
+	if r.Amount != 100000 {
+		t.Errorf("refunded %d, want everything captured, 100000", r.Amount)
+	}
+
+	// a retry with the same key gets the first refund back
+	again, err := card.Refund(context.Background(), pi.ID, 0, "order-1-refunded")
+	if err != nil || again.ID != r.ID {
+		t.Errorf("retried Refund = %v, %v, want refund %s again", again, err, r.ID)
+	}
+	if n := len(fake.Refunds()); n != 1 {
+		t.Errorf("%d refunds, want 1", n)
+	}
+}
+
+func TestTransactionStatus(t *testing.T) {
+	tests := []struct {
+		status stripe.PaymentIntentStatus
+		want   int
+	}{
+		{stripe.PaymentIntentStatusSucceeded, models.TransactionStatusCleared},
+		{stripe.PaymentIntentStatusRequiresCapture, models.TransactionStatusAuthorized},
+		{stripe.PaymentIntentStatusCanceled, models.TransactionStatusDeclined},
+		{stripe.PaymentIntentStatusRequiresPaymentMethod, models.TransactionStatusDeclined},
+		{stripe.PaymentIntentStatusRequiresAction, models.TransactionStatusPending},
+		{stripe.PaymentIntentStatusProcessing, models.TransactionStatusPending},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.status), func(t *testing.T) {
+			if got := TransactionStatus(tt.status); got != tt.want {
+				t.Errorf("TransactionStatus(%s) = %d, want %d", tt.status, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	fake := stripetest.NewServer(t)
+	m := NewMetrics(metrics.NewRegistry())
+	card := &Card{Secret: "sk_test_fake", Metrics: m}
+	ctx := context.Background()
+
+	pi, _, err := card.CreatePaymentIntent(ctx, "idr", 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fake.FailNext(stripe.ErrorCodeExpiredCard)
+	card.CreatePaymentIntent(ctx, "idr", 1000)
+	fake.FailNext(stripe.ErrorCodeRateLimit)
+	card.CreatePaymentIntent(ctx, "idr", 1000)
+	card.RetrievePaymentIntent(ctx, pi.ID)
+
+	for outcome, want := range map[string]float64{"created": 1, "expired_card": 1, "other": 1} {
+		if got := m.paymentIntents.Value(outcome); got != want {
+			t.Errorf("%s payment intents = %v, want %v", outcome, got, want)
+		}
+	}
+	if n := m.calls.Count("payment_intent.create"); n != 3 {
+		t.Errorf("timed %d creates, want 3", n)
+	}
+	if n := m.calls.Count("payment_intent.get"); n != 1 {
+		t.Errorf("timed %d gets, want 1", n)
+	}
+	if n := m.errors.Value("payment_intent.create", string(stripe.ErrorTypeCard)); n != 2 {
+		t.Errorf("%v card errors, want 2", n)
+	}
+}
@@ -0,0 +1,199 @@
+package cards
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/stripe/stripe-go/v72"
+	"github.com/stripe/stripe-go/v72/dispute"
+	"github.com/stripe/stripe-go/v72/file"
+	"go-stripe/internal/models"
+	"io"
+	"strings"
+	"time"
+)
+
+// ErrUnknownEvidenceField is returned for a file that isn't one of EvidenceFileFields
+var ErrUnknownEvidenceField = errors.New("unknown dispute evidence field")
+
+// EvidenceFileFields are the pieces of dispute evidence that are files
+var EvidenceFileFields = []string{
+	"receipt",
+	"customer_communication",
+	"shipping_documentation",
+	"refund_policy",
+	"uncategorized_file",
+}
+
+// ListDisputes returns the disputes opened since the given time
+func (c *Card) ListDisputes(ctx context.Context, since time.Time) ([]*stripe.Dispute, error) {
+	stripe.Key = c.Secret
+	ctx, cancel := c.withListTimeout(ctx)
+	defer cancel()
+	params := &stripe.DisputeListParams{
+		CreatedRange: &stripe.RangeQueryParams{
+			GreaterThanOrEqual: since.Unix(),
+		},
+	}
+	params.Limit = stripe.Int64(100)
+	params.Context = ctx
+
+	var disputes []*stripe.Dispute
+	done := c.call(ctx, "dispute.list")
+	i := dispute.List(params)
+	for i.Next() {
+		disputes = append(disputes, i.Dispute())
+	}
+	err := i.Err()
+	done(err)
+	if err != nil {
+		return nil, err
+	}
+	return disputes, nil
+}
+
+// GetDispute gets a dispute by id
+func (c *Card) GetDispute(ctx context.Context, id string) (*stripe.Dispute, error) {
+	stripe.Key = c.Secret
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+	params := &stripe.DisputeParams{}
+	params.Context = ctx
+	done := c.call(ctx, "dispute.get")
+	d, err := dispute.Get(id, params)
+	done(err)
+	if err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// UploadEvidence uploads a file for use as dispute evidence and returns the
+// stripe file
+func (c *Card) UploadEvidence(ctx context.Context, filename string, r io.Reader) (*stripe.File, error) {
+	stripe.Key = c.Secret
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+	params := &stripe.FileParams{
+		FileReader: r,
+		Filename:   stripe.String(filename),
+		Purpose:    stripe.String(string(stripe.FilePurposeDisputeEvidence)),
+	}
+	params.Context = ctx
+	done := c.call(ctx, "file.create")
+	f, err := file.New(params)
+	done(err)
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// UpdateDisputeEvidence sends our evidence for a dispute to stripe. Unless
+// submit is true the evidence is only staged, and can still be changed
+func (c *Card) UpdateDisputeEvidence(ctx context.Context, id string, ev models.DisputeEvidence, files []*models.DisputeFile, submit bool) (*stripe.Dispute, error) {
+	stripe.Key = c.Secret
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	evidence := &stripe.DisputeEvidenceParams{
+		ProductDescription:     optional(ev.ProductDescription),
+		CustomerName:           optional(ev.CustomerName),
+		CustomerEmailAddress:   optional(ev.CustomerEmailAddress),
+		BillingAddress:         optional(ev.BillingAddress),
+		ShippingAddress:        optional(ev.ShippingAddress),
+		ShippingCarrier:        optional(ev.ShippingCarrier),
+		ShippingTrackingNumber: optional(ev.ShippingTrackingNumber),
+		ShippingDate:           optional(ev.ShippingDate),
+		UncategorizedText:      optional(ev.UncategorizedText),
+	}
+	// the latest upload for each field wins
+	for _, f := range files {
+		switch f.Field {
+		case "receipt":
+			evidence.Receipt = stripe.String(f.StripeFileID)
+		case "customer_communication":
+			evidence.CustomerCommunication = stripe.String(f.StripeFileID)
+		case "shipping_documentation":
+			evidence.ShippingDocumentation = stripe.String(f.StripeFileID)
+		case "refund_policy":
+			evidence.RefundPolicy = stripe.String(f.StripeFileID)
+		case "uncategorized_file":
+			evidence.UncategorizedFile = stripe.String(f.StripeFileID)
+		default:
+			return nil, ErrUnknownEvidenceField
+		}
+	}
+
+	params := &stripe.DisputeParams{
+		Evidence: evidence,
+		Submit:   stripe.Bool(submit),
+	}
+	params.Context = ctx
+	done := c.call(ctx, "dispute.update")
+	d, err := dispute.Update(id, params)
+	done(err)
+	if err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// IsEvidenceFileField reports whether field is one of EvidenceFileFields
+func IsEvidenceFileField(field string) bool {
+	for _, f := range EvidenceFileFields {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
+
+// IsDisputeEvent reports whether a webhook event is about a dispute
+func IsDisputeEvent(event stripe.Event) bool {
+	return strings.HasPrefix(event.Type, "charge.dispute.")
+}
+
+// DisputeFromEvent reads the dispute out of a charge.dispute.* event
+func DisputeFromEvent(event stripe.Event) (*stripe.Dispute, error) {
+	if event.Data == nil {
+		return nil, errors.New("event has no data")
+	}
+	var d stripe.Dispute
+	if err := json.Unmarshal(event.Data.Raw, &d); err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
+
+// DisputeRecord converts a stripe dispute into the dispute we store
+func DisputeRecord(d *stripe.Dispute) models.Dispute {
+	rec := models.Dispute{
+		StripeDisputeID: d.ID,
+		Amount:          int(d.Amount),
+		Currency:        string(d.Currency),
+		Reason:          string(d.Reason),
+		Status:          string(d.Status),
+	}
+	if d.Charge != nil {
+		rec.ChargeID = d.Charge.ID
+	}
+	if d.PaymentIntent != nil {
+		rec.PaymentIntent = d.PaymentIntent.ID
+	}
+	if d.EvidenceDetails != nil {
+		if d.EvidenceDetails.DueBy > 0 {
+			rec.EvidenceDueBy = time.Unix(d.EvidenceDetails.DueBy, 0)
+		}
+		rec.HasEvidence = d.EvidenceDetails.HasEvidence
+		rec.SubmissionCount = int(d.EvidenceDetails.SubmissionCount)
+	}
+	return rec
+}
+
+func optional(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return stripe.String(s)
+}
@@ -0,0 +1,60 @@
+package cards
+
+import (
+	"errors"
+	"github.com/stripe/stripe-go/v72"
+	"go-stripe/internal/metrics"
+	"time"
+)
+
+// Metrics times and counts calls to stripe and the payment intents they
+// create. One is shared by every Card in a program
+type Metrics struct {
+	calls          *metrics.Histogram
+	errors         *metrics.Counter
+	paymentIntents *metrics.Counter
+}
+
+// NewMetrics adds the stripe metrics to r
+func NewMetrics(r *metrics.Registry) *Metrics {
+	return &Metrics{
+		calls:  r.Histogram("stripe_request_duration_seconds", "How long calls to stripe took, by operation.", metrics.DefaultBuckets, "op"),
+		errors: r.Counter("stripe_errors_total", "Calls to stripe that failed, by operation and stripe error type.", "op", "type"),
+		paymentIntents: r.Counter("payment_intents_created_total",
+			"Attempts to create a payment intent, by outcome: created, the card error category shown to the customer, or error.", "outcome"),
+	}
+}
+
+// observe records a call to stripe, op, that took elapsed and failed with
+// err, if it isn't nil
+func (m *Metrics) observe(op string, elapsed time.Duration, err error) {
+	if m == nil {
+		return
+	}
+	m.calls.Observe(elapsed.Seconds(), op)
+	if err == nil {
+		return
+	}
+	errType := "network"
+	var stripeErr *stripe.Error
+	if errors.As(err, &stripeErr) {
+		errType = string(stripeErr.Type)
+	}
+	m.errors.Inc(op, errType)
+}
+
+// paymentIntentCreated counts the outcome of creating a payment intent
+func (m *Metrics) paymentIntentCreated(err error) {
+	if m == nil {
+		return
+	}
+	outcome := "created"
+	if err != nil {
+		outcome = "error"
+		var stripeErr *stripe.Error
+		if errors.As(err, &stripeErr) {
+			outcome = cardErrorCategory(stripeErr.Code)
+		}
+	}
+	m.paymentIntents.Inc(outcome)
+}
@@ -0,0 +1,150 @@
+package cards
+
+import (
+	"context"
+	"github.com/stripe/stripe-go/v72"
+	"github.com/stripe/stripe-go/v72/balancetransaction"
+	"github.com/stripe/stripe-go/v72/payout"
+	"go-stripe/internal/models"
+	"time"
+)
+
+// ListPayouts returns the payouts created since the given time
+func (c *Card) ListPayouts(ctx context.Context, since time.Time) ([]*stripe.Payout, error) {
+	stripe.Key = c.Secret
+	ctx, cancel := c.withListTimeout(ctx)
+	defer cancel()
+	params := &stripe.PayoutListParams{
+		CreatedRange: &stripe.RangeQueryParams{
+			GreaterThanOrEqual: since.Unix(),
+		},
+	}
+	params.Limit = stripe.Int64(100)
+	params.Context = ctx
+
+	var payouts []*stripe.Payout
+	done := c.call(ctx, "payout.list")
+	i := payout.List(params)
+	for i.Next() {
+		payouts = append(payouts, i.Payout())
+	}
+	err := i.Err()
+	done(err)
+	if err != nil {
+		return nil, err
+	}
+	return payouts, nil
+}
+
+// GetPayout gets a payout by id
+func (c *Card) GetPayout(ctx context.Context, id string) (*stripe.Payout, error) {
+	stripe.Key = c.Secret
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+	params := &stripe.PayoutParams{}
+	params.Context = ctx
+	done := c.call(ctx, "payout.get")
+	p, err := payout.Get(id, params)
+	done(err)
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// PayoutBalanceTransactions returns the balance transactions paid out in a
+// payout, with the charge, refund or dispute behind each one expanded
+func (c *Card) PayoutBalanceTransactions(ctx context.Context, payoutID string) ([]*stripe.BalanceTransaction, error) {
+	stripe.Key = c.Secret
+	ctx, cancel := c.withListTimeout(ctx)
+	defer cancel()
+	params := &stripe.BalanceTransactionListParams{
+		Payout: stripe.String(payoutID),
+	}
+	params.Limit = stripe.Int64(100)
+	params.AddExpand("data.source")
+	params.Context = ctx
+
+	var txns []*stripe.BalanceTransaction
+	done := c.call(ctx, "balance_transaction.list")
+	i := balancetransaction.List(params)
+	for i.Next() {
+		txns = append(txns, i.BalanceTransaction())
+	}
+	err := i.Err()
+	done(err)
+	if err != nil {
+		return nil, err
+	}
+	return txns, nil
+}
+
+// PayoutRecord converts a stripe payout into the payout we store
+func PayoutRecord(p *stripe.Payout) models.Payout {
+	return models.Payout{
+		StripePayoutID: p.ID,
+		Amount:         int(p.Amount),
+		Currency:       string(p.Currency),
+		Status:         string(p.Status),
+		ArrivalDate:    time.Unix(p.ArrivalDate, 0),
+	}
+}
+
+// PayoutLineRecord converts a balance transaction into a payout line. ok is
+// false for the payout's own balance transaction, which isn't part of the
+// breakdown
+func PayoutLineRecord(bt *stripe.BalanceTransaction) (line models.PayoutLine, ok bool) {
+	if bt.Type == stripe.BalanceTransactionTypePayout {
+		return line, false
+	}
+
+	line = models.PayoutLine{
+		BalanceTransactionID: bt.ID,
+		Category:             models.PayoutLineOther,
+		StripeType:           string(bt.Type),
+		Amount:               int(bt.Amount),
+		Fee:                  int(bt.Fee),
+		Net:                  int(bt.Net),
+		Description:          bt.Description,
+		CreatedAt:            time.Unix(bt.Created, 0),
+	}
+
+	switch bt.Type {
+	case stripe.BalanceTransactionTypeCharge, stripe.BalanceTransactionTypePayment:
+		line.Category = models.PayoutLineCharge
+	case stripe.BalanceTransactionTypeRefund, stripe.BalanceTransactionTypePaymentRefund, stripe.BalanceTransactionTypePaymentFailureRefund:
+		line.Category = models.PayoutLineRefund
+	case stripe.BalanceTransactionTypeStripeFee, stripe.BalanceTransactionTypeStripeFxFee, stripe.BalanceTransactionTypeTaxFee:
+		line.Category = models.PayoutLineFee
+	}
+
+	// SourceID is the charge behind the line where there is one, which is
+	// what our transactions keep as their bank return code
+	src := bt.Source
+	if src == nil {
+		return line, true
+	}
+	line.SourceID = src.ID
+	switch {
+	case src.Charge != nil:
+		if src.Charge.PaymentIntent != nil {
+			line.PaymentIntent = src.Charge.PaymentIntent.ID
+		}
+	case src.Refund != nil:
+		if src.Refund.Charge != nil {
+			line.SourceID = src.Refund.Charge.ID
+		}
+		if src.Refund.PaymentIntent != nil {
+			line.PaymentIntent = src.Refund.PaymentIntent.ID
+		}
+	case src.Dispute != nil:
+		line.Category = models.PayoutLineDispute
+		if src.Dispute.Charge != nil {
+			line.SourceID = src.Dispute.Charge.ID
+		}
+		if src.Dispute.PaymentIntent != nil {
+			line.PaymentIntent = src.Dispute.PaymentIntent.ID
+		}
+	}
+	return line, true
+}
@@ -0,0 +1,119 @@
+// Package export writes tables of rows as spreadsheets one row at a time, so
+// large exports can be streamed to the client without holding them in memory
+package export
+
+import (
+	"encoding/csv"
+	"errors"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// formats an export can be written in
+const (
+	CSV  = "csv"
+	XLSX = "xlsx"
+)
+
+// TimeFormat is how times are written in both formats
+const TimeFormat = "2006-01-02 15:04:05"
+
+var ErrUnknownFormat = errors.New("format must be csv or xlsx")
+
+// Writer writes rows to a spreadsheet. A row's cells may be strings, ints,
+// float64s or time.Times. Close must be called once all rows are written
+type Writer interface {
+	Write(row []any) error
+	Close() error
+}
+
+// NewWriter returns a writer for the format that writes to w. sheet names the
+// worksheet in formats that have one
+func NewWriter(w io.Writer, format, sheet string) (Writer, error) {
+	switch format {
+	case CSV:
+		return &csvWriter{w: csv.NewWriter(w)}, nil
+	case XLSX:
+		return newXLSXWriter(w, sheet)
+	}
+	return nil, ErrUnknownFormat
+}
+
+// ContentType returns the mime type for a format
+func ContentType(format string) string {
+	if format == XLSX {
+		return "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+	}
+	return "text/csv; charset=utf-8"
+}
+
+// csvWriter flushes after every row so rows reach the client as they are
+// written rather than when the buffer fills
+type csvWriter struct {
+	w *csv.Writer
+}
+
+func (c *csvWriter) Write(row []any) error {
+	record := make([]string, len(row))
+	for i, v := range row {
+		record[i] = formatCell(v)
+	}
+	if err := c.w.Write(record); err != nil {
+		return err
+	}
+	c.w.Flush()
+	return c.w.Error()
+}
+
+func (c *csvWriter) Close() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+// formulaPrefixes are the characters that make a spreadsheet read a cell as
+// a formula. A customer's name or a memo starting with one could otherwise
+// run when the export is opened
+const formulaPrefixes = "=+-@\t\r"
+
+func formatCell(v any) string {
+	switch v := v.(type) {
+	case string:
+		if v != "" && strings.IndexByte(formulaPrefixes, v[0]) >= 0 {
+			return "'" + v
+		}
+		return v
+	case int:
+		return strconv.Itoa(v)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case time.Time:
+		if v.IsZero() {
+			return ""
+		}
+		return v.Format(TimeFormat)
+	case nil:
+		return ""
+	}
+	return ""
+}
+
+// minorUnits are the currencies stripe doesn't count in hundredths of a unit,
+// by how many decimal places their smallest unit has
+var minorUnits = map[string]int{
+	"bif": 0, "clp": 0, "djf": 0, "gnf": 0, "jpy": 0, "kmf": 0, "krw": 0, "mga": 0,
+	"pyg": 0, "rwf": 0, "ugx": 0, "vnd": 0, "vuv": 0, "xaf": 0, "xof": 0, "xpf": 0,
+	"bhd": 3, "jod": 3, "kwd": 3, "omr": 3, "tnd": 3,
+}
+
+// Money turns an amount in the smallest unit of currency into whole units,
+// which is what spreadsheets expect
+func Money(amount int, currency string) float64 {
+	places, ok := minorUnits[strings.ToLower(currency)]
+	if !ok {
+		places = 2
+	}
+	return float64(amount) / math.Pow10(places)
+}
@@ -0,0 +1,57 @@
+package export
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestFormatCell(t *testing.T) {
+	tests := []struct {
+		in   any
+		want string
+	}{
+		{"Ani Wijaya", "Ani Wijaya"},
+		{"", ""},
+		{"=HYPERLINK(\"http://example.com\")", "'=HYPERLINK(\"http://example.com\")"},
+		{"+62 812", "'+62 812"},
+		{"-1+1", "'-1+1"},
+		{"@SUM(A1)", "'@SUM(A1)"},
+		{"\tmemo", "'\tmemo"},
+		{"\rmemo", "'\rmemo"},
+		{"a=b", "a=b"},
+		{-5, "-5"},
+		{-1.5, "-1.5"},
+	}
+	for _, tt := range tests {
+		if got := formatCell(tt.in); got != tt.want {
+			t.Errorf("formatCell(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+
+	var buf bytes.Buffer
+	w, _ := NewWriter(&buf, CSV, "orders")
+	w.Write([]any{"=1+1", 2})
+	w.Close()
+	if got := buf.String(); got != "'=1+1,2\n" {
+		t.Errorf("csv row = %q, want the formula escaped", got)
+	}
+}
+
+func TestMoney(t *testing.T) {
+	tests := []struct {
+		amount   int
+		currency string
+		want     float64
+	}{
+		{1000050, "idr", 10000.5},
+		{1999, "USD", 19.99},
+		{500, "jpy", 500},
+		{1500, "kwd", 1.5},
+		{-250, "idr", -2.5},
+	}
+	for _, tt := range tests {
+		if got := Money(tt.amount, tt.currency); got != tt.want {
+			t.Errorf("Money(%d, %s) = %v, want %v", tt.amount, tt.currency, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,178 @@
+// Package inventory reads the stock files the warehouse sends us
+package inventory
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"go-stripe/internal/models"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// MaxRows is the most rows one import may have
+const MaxRows = 5000
+
+// Columns are the columns an import file may have, in any order. The first
+// line must name them. Either sku or name is needed to match rows to widgets
+var Columns = []string{"sku", "name", "description", "inventory_level", "price", "image"}
+
+var (
+	ErrNoHeader    = errors.New("the first line must name the columns, for example sku,name,inventory_level,price")
+	ErrNoKeyColumn = errors.New("the file needs a sku or name column")
+	ErrTooManyRows = fmt.Errorf("an import can have at most %d rows", MaxRows)
+)
+
+// ReadWidgets reads widget import rows from a csv file. Problems with a row
+// are recorded on the row so they can all be reported together; an error is
+// only returned when the file as a whole can't be read. Empty cells leave
+// the widget's value as it is. Prices are in whole rupiah and may have up to
+// two decimal places
+func ReadWidgets(r io.Reader) ([]models.WidgetImport, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+
+	header, err := cr.Read()
+	if errors.Is(err, io.EOF) {
+		return nil, ErrNoHeader
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	index := make(map[string]int)
+	for i, h := range header {
+		name := strings.ToLower(strings.TrimSpace(strings.TrimPrefix(h, "\ufeff")))
+		if !isColumn(name) {
+			return nil, fmt.Errorf("unknown column %q, columns are %s", h, strings.Join(Columns, ", "))
+		}
+		if _, dup := index[name]; dup {
+			return nil, fmt.Errorf("column %q appears twice", name)
+		}
+		index[name] = i
+	}
+	_, hasSKU := index["sku"]
+	_, hasName := index["name"]
+	if !hasSKU && !hasName {
+		return nil, ErrNoKeyColumn
+	}
+
+	var rows []models.WidgetImport
+	for {
+		record, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			var perr *csv.ParseError
+			if !errors.As(err, &perr) {
+				return nil, err
+			}
+			rows = append(rows, models.WidgetImport{Line: perr.Line, Errors: []string{perr.Err.Error()}})
+			continue
+		}
+		if blank(record) {
+			continue
+		}
+		if len(rows) == MaxRows {
+			return nil, ErrTooManyRows
+		}
+		line, _ := cr.FieldPos(0)
+		rows = append(rows, readRow(line, record, index))
+	}
+	return rows, nil
+}
+
+func readRow(line int, record []string, index map[string]int) models.WidgetImport {
+	row := models.WidgetImport{Line: line}
+	cell := func(name string) (string, bool) {
+		i, ok := index[name]
+		if !ok || i >= len(record) {
+			return "", false
+		}
+		v := strings.TrimSpace(record[i])
+		return v, v != ""
+	}
+
+	row.SKU, _ = cell("sku")
+	row.Name, _ = cell("name")
+	if row.SKU == "" && row.Name == "" {
+		row.Errors = append(row.Errors, "a sku or name is needed")
+	}
+	if len(row.SKU) > 64 {
+		row.Errors = append(row.Errors, "sku must be at most 64 characters")
+	}
+	if len(row.Name) > 255 {
+		row.Errors = append(row.Errors, "name must be at most 255 characters")
+	}
+
+	if v, ok := cell("description"); ok {
+		row.Description = &v
+	}
+	if v, ok := cell("image"); ok {
+		row.Image = &v
+	}
+	if v, ok := cell("inventory_level"); ok {
+		n, err := strconv.Atoi(v)
+		switch {
+		case err != nil:
+			row.Errors = append(row.Errors, fmt.Sprintf("inventory_level %q is not a whole number", v))
+		case n < 0:
+			row.Errors = append(row.Errors, "inventory_level can't be negative")
+		default:
+			row.InventoryLevel = &n
+		}
+	}
+	if v, ok := cell("price"); ok {
+		n, err := parsePrice(v)
+		switch {
+		case err != nil:
+			row.Errors = append(row.Errors, fmt.Sprintf("price %q is not an amount like 150000 or 149999.50", v))
+		case n < 1:
+			row.Errors = append(row.Errors, "price must be more than 0")
+		default:
+			row.Price = &n
+		}
+	}
+	return row
+}
+
+// parsePrice turns an amount in whole units into the smallest unit, without
+// going through floating point
+func parsePrice(s string) (int, error) {
+	whole, frac, found := strings.Cut(s, ".")
+	if found && (len(frac) == 0 || len(frac) > 2) {
+		return 0, errors.New("invalid price")
+	}
+	for len(frac) < 2 {
+		frac += "0"
+	}
+	if strings.HasPrefix(whole, "+") || strings.HasPrefix(whole, "-") {
+		return 0, errors.New("invalid price")
+	}
+	n, err := strconv.Atoi(whole + frac)
+	if err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+func isColumn(name string) bool {
+	for _, c := range Columns {
+		if c == name {
+			return true
+		}
+	}
+	return false
+}
+
+func blank(record []string) bool {
+	for _, v := range record {
+		if strings.TrimSpace(v) != "" {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,175 @@
+package invoice
+
+import (
+	"context"
+	"fmt"
+	"go-stripe/internal/models"
+	"strings"
+	"time"
+)
+
+// Seller holds our own company and tax details printed on every invoice
+type Seller struct {
+	Name    string
+	Address string // lines separated by ;
+	TaxID   string
+	Email   string
+}
+
+// Data is everything needed to render one invoice
+type Data struct {
+	Number      string
+	IssuedAt    time.Time
+	Seller      Seller
+	Order       models.Order
+	Customer    models.Customer
+	Transaction models.Transaction
+	Widget      models.Widget
+}
+
+// Filename returns the name an invoice should be downloaded or attached as
+func (d Data) Filename() string {
+	return fmt.Sprintf("%s.pdf", d.Number)
+}
+
+// Render draws the invoice and returns it as a PDF document
+func Render(d Data) ([]byte, error) {
+	if d.Number == "" {
+		return nil, fmt.Errorf("invoice: order %d has no invoice number", d.Order.ID)
+	}
+
+	const left, right = 50.0, 545.0
+	p := &page{}
+
+	// header
+	p.text(fontBold, 22, left, 70, "INVOICE")
+	p.text(fontBold, 11, left, 100, d.Seller.Name)
+	y := 115.0
+	for _, l := range strings.Split(d.Seller.Address, ";") {
+		p.text(fontRegular, 9, left, y, l)
+		y += 12
+	}
+	if d.Seller.TaxID != "" {
+		p.text(fontRegular, 9, left, y, "NPWP / Tax ID: "+d.Seller.TaxID)
+		y += 12
+	}
+	if d.Seller.Email != "" {
+		p.text(fontRegular, 9, left, y, d.Seller.Email)
+	}
+
+	p.textRight(fontBold, 10, right, 70, "Invoice No. "+d.Number)
+	p.textRight(fontRegular, 9, right, 85, "Date: "+d.IssuedAt.Format("02 Jan 2006"))
+	p.textRight(fontRegular, 9, right, 100, fmt.Sprintf("Order: #%d", d.Order.ID))
+	p.textRight(fontRegular, 9, right, 115, "Order date: "+d.Order.CreatedAt.Format("02 Jan 2006"))
+
+	// bill to
+	p.text(fontBold, 10, left, 190, "Bill to")
+	p.text(fontRegular, 10, left, 205, strings.TrimSpace(d.Customer.FirstName+" "+d.Customer.LastName))
+	p.text(fontRegular, 10, left, 219, d.Customer.Email)
+
+	// order lines
+	const qtyX, unitX = 360.0, 450.0
+	p.line(left, 250, right, 250)
+	p.text(fontBold, 10, left, 265, "Description")
+	p.textRight(fontBold, 10, qtyX, 265, "Qty")
+	p.textRight(fontBold, 10, unitX, 265, "Unit price")
+	p.textRight(fontBold, 10, right, 265, "Amount")
+	p.line(left, 273, right, 273)
+
+	quantity := d.Order.Quantity
+	if quantity < 1 {
+		quantity = 1
+	}
+	subtotal := d.Order.Subtotal
+	if subtotal == 0 {
+		subtotal = d.Order.Amount
+	}
+	p.text(fontRegular, 10, left, 290, d.Widget.Name)
+	p.textRight(fontRegular, 10, qtyX, 290, fmt.Sprintf("%d", quantity))
+	p.textRight(fontRegular, 10, unitX, 290, FormatAmount(subtotal/quantity))
+	p.textRight(fontRegular, 10, right, 290, FormatAmount(subtotal))
+	p.line(left, 300, right, 300)
+
+	y = 318
+	p.textRight(fontRegular, 10, unitX, y, "Subtotal")
+	p.textRight(fontRegular, 10, right, y, FormatAmount(subtotal))
+	if d.Order.Discount > 0 {
+		y += 15
+		p.textRight(fontRegular, 10, unitX, y, "Discount "+d.Order.CouponCode)
+		p.textRight(fontRegular, 10, right, y, "-"+FormatAmount(d.Order.Discount))
+	}
+	y += 15
+	p.textRight(fontRegular, 10, unitX, y, "Tax")
+	p.textRight(fontRegular, 10, right, y, FormatAmount(d.Order.Tax))
+	y += 20
+	p.textRight(fontBold, 11, unitX, y, "Total ("+strings.ToUpper(d.Transaction.Currency)+")")
+	p.textRight(fontBold, 11, right, y, FormatAmount(d.Order.Amount))
+
+	// payment
+	p.text(fontBold, 10, left, 400, "Payment")
+	p.text(fontRegular, 10, left, 415, "Card: "+MaskCard(d.Transaction.LastFour))
+	p.text(fontRegular, 10, left, 429, fmt.Sprintf("Expiry: %02d/%d", d.Transaction.ExpiryMonth, d.Transaction.ExpiryYear))
+	p.text(fontRegular, 10, left, 443, "Reference: "+d.Transaction.PaymentIntent)
+
+	p.text(fontRegular, 8, left, 800, "Thank you for your business.")
+
+	return p.bytes(), nil
+}
+
+// FormatAmount formats an amount in the smallest currency unit the same way
+// the web front end does, e.g. 100000050 becomes Rp1.000.000,50
+func FormatAmount(n int) string {
+	sign := ""
+	if n < 0 {
+		sign, n = "-", -n
+	}
+	s := fmt.Sprintf("%d", n/100)
+	var sb strings.Builder
+	for i, c := range s {
+		if i > 0 && (len(s)-i)%3 == 0 {
+			sb.WriteRune('.')
+		}
+		sb.WriteRune(c)
+	}
+	return fmt.Sprintf("%sRp%s,%02d", sign, sb.String(), n%100)
+}
+
+// MaskCard hides all but the last four digits of a card number
+func MaskCard(lastFour string) string {
+	return "**** **** **** " + lastFour
+}
+
+// Load gathers the order, customer, transaction and widget for orderID and
+// issues the invoice number if the order does not have one yet
+func Load(ctx context.Context, db models.Models, seller Seller, orderID int) (Data, error) {
+	d := Data{Seller: seller}
+
+	order, err := db.Orders.GetOrder(ctx, orderID)
+	if err != nil {
+		return d, err
+	}
+	customer, err := db.Customers.GetCustomer(ctx, order.CustomerID)
+	if err != nil {
+		return d, err
+	}
+	txn, err := db.Transactions.GetTransaction(ctx, order.TransactionID)
+	if err != nil {
+		return d, err
+	}
+	widget, err := db.Widgets.GetWidget(ctx, order.WidgetID)
+	if err != nil {
+		return d, err
+	}
+	inv, err := db.Orders.GetOrCreateInvoice(ctx, order.ID)
+	if err != nil {
+		return d, err
+	}
+
+	d.Number = inv.InvoiceNumber
+	d.IssuedAt = inv.CreatedAt
+	d.Order = order
+	d.Customer = customer
+	d.Transaction = txn
+	d.Widget = widget
+	return d, nil
+}
@@ -0,0 +1,23 @@
+package invoice
+
+import "testing"
+
+func TestFormatAmount(t *testing.T) {
+	tests := []struct {
+		n    int
+		want string
+	}{
+		{0, "Rp0,00"},
+		{5, "Rp0,05"},
+		{99, "Rp0,99"},
+		{100000000, "Rp1.000.000,00"},
+		{100000050, "Rp1.000.000,50"},
+		{123456789, "Rp1.234.567,89"},
+		{-2550, "-Rp25,50"},
+	}
+	for _, tt := range tests {
+		if got := FormatAmount(tt.n); got != tt.want {
+			t.Errorf("FormatAmount(%d) = %q, want %q", tt.n, got, tt.want)
+		}
+	}
+}
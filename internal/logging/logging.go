@@ -0,0 +1,59 @@
+// Package logging sets up the structured loggers the servers write with.
+// Every record logged with a request's context carries its request id, and
+// emails and payment method ids are redacted wherever they appear
+package logging
+
+import (
+	"context"
+	"github.com/go-chi/chi/v5/middleware"
+	"go.opentelemetry.io/otel/trace"
+	"io"
+	"log/slog"
+)
+
+// New returns a logger writing to w at level and above, as json in
+// production so the lines can be searched by field, and as text otherwise
+// for people to read
+func New(w io.Writer, env string, level slog.Leveler) *slog.Logger {
+	opts := &slog.HandlerOptions{Level: level, ReplaceAttr: redact}
+
+	var h slog.Handler
+	if env == "production" {
+		h = slog.NewJSONHandler(w, opts)
+	} else {
+		h = slog.NewTextHandler(w, opts)
+	}
+	return slog.New(contextHandler{h})
+}
+
+// Discard is a logger that drops everything, for tests and tools that have
+// nothing to say
+func Discard() *slog.Logger {
+	return slog.New(slog.NewTextHandler(io.Discard, nil))
+}
+
+// contextHandler adds the request id in the context to each record, so
+// whatever a request does, down to its queries and calls to stripe, can be
+// pulled out of the logs together, and the trace and span ids to find its
+// spans by
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if id := middleware.GetReqID(ctx); id != "" {
+		r.AddAttrs(slog.String("request_id", id))
+	}
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		r.AddAttrs(slog.String("trace_id", sc.TraceID().String()), slog.String("span_id", sc.SpanID().String()))
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
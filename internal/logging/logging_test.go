@@ -0,0 +1,119 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"github.com/go-chi/chi/v5"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRedact(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"budi@example.com", "b***@example.com"},
+		{"receipt to Siti.Putri+shop@mail.example.co.id failed", "receipt to S***@mail.example.co.id failed"},
+		{"pm_1NvVhs2eZvKYlo2C", "pm_***lo2C"},
+		{"No such PaymentMethod: 'pm_card_visa'", "No such PaymentMethod: 'pm_***visa'"},
+		{"pm_1", "pm_***"},
+		{"pi_3NvVhs2eZvKYlo2C and npm_install", "pi_3NvVhs2eZvKYlo2C and npm_install"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := Redact(tt.in); got != tt.want {
+			t.Errorf("Redact(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestNew(t *testing.T) {
+	var buf bytes.Buffer
+	logger := New(&buf, "production", slog.LevelInfo)
+
+	ctx := httptest.NewRequest(http.MethodGet, "/", nil).Context()
+	RequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx = r.Context()
+	})).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+	logger.DebugContext(ctx, "below the level")
+	logger.ErrorContext(ctx, "charging budi@example.com",
+		"payment_method", "pm_1NvVhs2eZvKYlo2C",
+		"err", errors.New("declined for budi@example.com"))
+
+	var line map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &line); err != nil {
+		t.Fatalf("want one json line, got %q: %v", buf.String(), err)
+	}
+	want := map[string]string{
+		"level":          "ERROR",
+		"msg":            "charging b***@example.com",
+		"payment_method": "pm_***lo2C",
+		"err":            "declined for b***@example.com",
+	}
+	for k, v := range want {
+		if line[k] != v {
+			t.Errorf("%s = %v, want %q", k, line[k], v)
+		}
+	}
+	if id, _ := line["request_id"].(string); id == "" {
+		t.Errorf("no request id in %v", line)
+	}
+
+	buf.Reset()
+	New(&buf, "development", slog.LevelInfo).Info("hello")
+	if !strings.Contains(buf.String(), "level=INFO msg=hello") {
+		t.Errorf("development logs %q, want text", buf.String())
+	}
+}
+
+func TestAccessLog(t *testing.T) {
+	var buf bytes.Buffer
+	logger := New(&buf, "production", slog.LevelInfo)
+
+	mux := chi.NewRouter()
+	mux.Use(RequestID)
+	mux.Use(AccessLog(logger))
+	mux.Get("/receipt/{token}", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "gone", http.StatusGone)
+	})
+	mux.Get("/ok", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})
+
+	tests := []struct {
+		path       string
+		wantLevel  string
+		wantRoute  string
+		wantStatus float64
+	}{
+		{"/ok", "INFO", "/ok", 200},
+		{"/receipt/secret-token", "WARN", "/receipt/{token}", 410},
+		{"/nowhere", "WARN", "unmatched", 404},
+	}
+	for _, tt := range tests {
+		buf.Reset()
+		req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+		req.Header.Set("X-Request-Id", "upstream-1")
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+
+		var line map[string]any
+		if err := json.Unmarshal(buf.Bytes(), &line); err != nil {
+			t.Fatalf("%s: want one json line, got %q: %v", tt.path, buf.String(), err)
+		}
+		if line["level"] != tt.wantLevel || line["route"] != tt.wantRoute || line["status"] != tt.wantStatus {
+			t.Errorf("%s: logged %v", tt.path, line)
+		}
+		if line["request_id"] != "upstream-1" || rec.Header().Get("X-Request-Id") != "upstream-1" {
+			t.Errorf("%s: request id logged %v, sent %q, want upstream-1", tt.path, line["request_id"], rec.Header().Get("X-Request-Id"))
+		}
+		if strings.Contains(buf.String(), "secret-token") {
+			t.Errorf("%s: logged the path %s", tt.path, buf.String())
+		}
+	}
+}
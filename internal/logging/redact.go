@@ -0,0 +1,43 @@
+package logging
+
+import (
+	"log/slog"
+	"regexp"
+	"strings"
+)
+
+var (
+	emailPattern         = regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9-]+(\.[A-Za-z0-9-]+)+`)
+	paymentMethodPattern = regexp.MustCompile(`\bpm_[A-Za-z0-9_]+`)
+)
+
+// redact is the handlers' ReplaceAttr. Emails and payment method ids are
+// masked in every string, message and error logged, not only in attributes
+// named for them, since they turn up in errors from stripe and the database
+func redact(groups []string, a slog.Attr) slog.Attr {
+	switch v := a.Value; v.Kind() {
+	case slog.KindString:
+		a.Value = slog.StringValue(Redact(v.String()))
+	case slog.KindAny:
+		if err, ok := v.Any().(error); ok {
+			a.Value = slog.StringValue(Redact(err.Error()))
+		}
+	}
+	return a
+}
+
+// Redact masks the emails and payment method ids in s, keeping enough of
+// each to tell them apart: budi@example.com becomes b***@example.com and
+// pm_1NvVhs2eZvKYlo2C becomes pm_***lo2C
+func Redact(s string) string {
+	s = emailPattern.ReplaceAllStringFunc(s, func(email string) string {
+		local, domain, _ := strings.Cut(email, "@")
+		return local[:1] + "***@" + domain
+	})
+	return paymentMethodPattern.ReplaceAllStringFunc(s, func(id string) string {
+		if len(id) <= 7 {
+			return "pm_***"
+		}
+		return "pm_***" + id[len(id)-4:]
+	})
+}
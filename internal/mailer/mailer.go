@@ -0,0 +1,75 @@
+package mailer
+
+import (
+	"errors"
+	"net/mail"
+	"strings"
+	"time"
+)
+
+// Attachment is a file sent along with a message
+type Attachment struct {
+	Filename    string
+	ContentType string
+	Data        []byte
+}
+
+// Message is the type for an outgoing email
+type Message struct {
+	From        string
+	FromName    string
+	To          string
+	Subject     string
+	HTML        string
+	Plain       string
+	Attachments []Attachment
+	CreatedAt   time.Time
+}
+
+// Mailer is implemented by every way we know how to deliver an email
+type Mailer interface {
+	Send(msg Message) error
+}
+
+// ErrNoRecipient is returned when a message has no To address
+var ErrNoRecipient = errors.New("mailer: message has no recipient")
+
+// ErrBadAddress is returned when a message's To or From isn't a single email
+// address
+var ErrBadAddress = errors.New("mailer: invalid email address")
+
+// ParseAddress checks that s is a single email address, such as
+// ani@example.com or Ani <ani@example.com>, and returns the bare address.
+// Line breaks are refused outright, since an address with one would let
+// whoever typed it add headers of their own
+func ParseAddress(s string) (string, error) {
+	if strings.ContainsAny(s, "\r\n") {
+		return "", ErrBadAddress
+	}
+	addr, err := mail.ParseAddress(s)
+	if err != nil {
+		return "", ErrBadAddress
+	}
+	return addr.Address, nil
+}
+
+// SendWithRetry tries to send msg up to attempts times, doubling the wait
+// between each try, and returns the last error if all of them failed. A
+// message without a valid recipient is never retried
+func SendWithRetry(m Mailer, msg Message, attempts int, wait time.Duration) error {
+	if attempts < 1 {
+		attempts = 1
+	}
+	var err error
+	for i := 0; i < attempts; i++ {
+		err = m.Send(msg)
+		if err == nil || errors.Is(err, ErrNoRecipient) || errors.Is(err, ErrBadAddress) {
+			return err
+		}
+		if i < attempts-1 {
+			time.Sleep(wait)
+			wait *= 2
+		}
+	}
+	return err
+}
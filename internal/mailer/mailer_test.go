@@ -0,0 +1,269 @@
+package mailer
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"strings"
+	"testing"
+)
+
+func TestBuildHeaders(t *testing.T) {
+	tests := []struct {
+		name    string
+		msg     Message
+		wantErr error
+
+		wantFrom    string
+		wantTo      string
+		wantSubject string
+	}{
+		{
+			name:        "plain",
+			msg:         Message{From: "shop@example.com", FromName: "Widget Shop", To: "ani@example.com", Subject: "Your receipt"},
+			wantFrom:    `"Widget Shop" <shop@example.com>`,
+			wantTo:      "<ani@example.com>",
+			wantSubject: "Your receipt",
+		},
+		{
+			name:        "non-ascii subject and name",
+			msg:         Message{From: "shop@example.com", FromName: "Toko Widget ✓", To: "Ani <ani@example.com>", Subject: "Kuitansi Anda ✓"},
+			wantFrom:    "=?utf-8?q?Toko_Widget_=E2=9C=93?= <shop@example.com>",
+			wantTo:      "<ani@example.com>",
+			wantSubject: "Kuitansi Anda ✓",
+		},
+		{
+			name:        "line break in the subject",
+			msg:         Message{From: "shop@example.com", To: "ani@example.com", Subject: "Hi\r\nBcc: all@example.com"},
+			wantFrom:    "<shop@example.com>",
+			wantTo:      "<ani@example.com>",
+			wantSubject: "Hi\r\nBcc: all@example.com",
+		},
+		{
+			name:    "no recipient",
+			msg:     Message{From: "shop@example.com"},
+			wantErr: ErrNoRecipient,
+		},
+		{
+			name:    "header injected into the recipient",
+			msg:     Message{From: "shop@example.com", To: "ani@example.com\r\nBcc: all@example.com"},
+			wantErr: ErrBadAddress,
+		},
+		{
+			name:    "bare line feed in the recipient",
+			msg:     Message{From: "shop@example.com", To: "ani@example.com\nBcc: all@example.com"},
+			wantErr: ErrBadAddress,
+		},
+		{
+			name:    "two recipients",
+			msg:     Message{From: "shop@example.com", To: "ani@example.com, budi@example.com"},
+			wantErr: ErrBadAddress,
+		},
+		{
+			name:    "not an address",
+			msg:     Message{From: "shop@example.com", To: "ani"},
+			wantErr: ErrBadAddress,
+		},
+		{
+			name:    "bad sender",
+			msg:     Message{From: "shop", To: "ani@example.com"},
+			wantErr: ErrBadAddress,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := build(tt.msg)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("build() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				return
+			}
+
+			m, err := mail.ReadMessage(bytes.NewReader(b))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := m.Header.Get("From"); got != tt.wantFrom {
+				t.Errorf("From = %q, want %q", got, tt.wantFrom)
+			}
+			if got := m.Header.Get("To"); got != tt.wantTo {
+				t.Errorf("To = %q, want %q", got, tt.wantTo)
+			}
+			subject, err := new(mime.WordDecoder).DecodeHeader(m.Header.Get("Subject"))
+			if err != nil || subject != tt.wantSubject {
+				t.Errorf("Subject = %q (%v), want %q", subject, err, tt.wantSubject)
+			}
+			if bcc := m.Header.Get("Bcc"); bcc != "" {
+				t.Errorf("Bcc = %q, want no such header", bcc)
+			}
+		})
+	}
+}
+
+// part is one leaf of a built message, decoded
+type part struct {
+	contentType string
+	filename    string
+	body        string
+}
+
+// readParts walks the multipart tree of a built message and returns its
+// leaves in order
+func readParts(t *testing.T, contentType string, r io.Reader) []part {
+	t.Helper()
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(mediaType, "multipart/") {
+		b, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return []part{{contentType: mediaType, body: string(b)}}
+	}
+
+	var parts []part
+	mr := multipart.NewReader(r, params["boundary"])
+	for {
+		p, err := mr.NextPart()
+		if err == io.EOF {
+			return parts
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		// quoted-printable parts are decoded by the reader itself
+		var body io.Reader = p
+		if p.Header.Get("Content-Transfer-Encoding") == "base64" {
+			body = base64.NewDecoder(base64.StdEncoding, p)
+		}
+		leaves := readParts(t, p.Header.Get("Content-Type"), body)
+		if len(leaves) == 1 {
+			leaves[0].filename = p.FileName()
+		}
+		parts = append(parts, leaves...)
+	}
+}
+
+func TestBuildParts(t *testing.T) {
+	// long enough to be wrapped over several lines
+	pdf := bytes.Repeat([]byte("%PDF-1.4 binary\x00\xff"), 20)
+
+	tests := []struct {
+		name string
+		msg  Message
+		want []part
+	}{
+		{
+			name: "text only",
+			msg:  Message{Plain: "Thanks for your order"},
+			want: []part{{contentType: "text/plain", body: "Thanks for your order"}},
+		},
+		{
+			name: "text and html",
+			msg:  Message{Plain: "Total: Rp1.000.000,00", HTML: "<p>Total: <b>Rp1.000.000,00</b></p>"},
+			want: []part{
+				{contentType: "text/plain", body: "Total: Rp1.000.000,00"},
+				{contentType: "text/html", body: "<p>Total: <b>Rp1.000.000,00</b></p>"},
+			},
+		},
+		{
+			name: "attachments",
+			msg: Message{
+				Plain: "Your invoice is attached",
+				HTML:  "<p>Your invoice is attached</p>",
+				Attachments: []Attachment{
+					{Filename: "INV-2024-000001.pdf", ContentType: "application/pdf", Data: pdf},
+					{Filename: "notes.bin", Data: []byte{1, 2, 3}},
+				},
+			},
+			want: []part{
+				{contentType: "text/plain", body: "Your invoice is attached"},
+				{contentType: "text/html", body: "<p>Your invoice is attached</p>"},
+				{contentType: "application/pdf", filename: "INV-2024-000001.pdf", body: string(pdf)},
+				{contentType: "application/octet-stream", filename: "notes.bin", body: "\x01\x02\x03"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.msg.From, tt.msg.To = "shop@example.com", "ani@example.com"
+			b, err := build(tt.msg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, line := range strings.Split(string(b), "\r\n") {
+				if len(line) > 998 {
+					t.Fatalf("line of %d characters, want at most 998", len(line))
+				}
+			}
+
+			m, err := mail.ReadMessage(bytes.NewReader(b))
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := readParts(t, m.Header.Get("Content-Type"), m.Body)
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d parts %+v, want %d", len(got), got, len(tt.want))
+			}
+			for i := range tt.want {
+				if got[i] != tt.want[i] {
+					t.Errorf("part %d = %+v, want %+v", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+// flakyMailer fails the first failures sends with err
+type flakyMailer struct {
+	failures int
+	err      error
+	calls    int
+}
+
+func (m *flakyMailer) Send(msg Message) error {
+	m.calls++
+	if m.calls <= m.failures {
+		return m.err
+	}
+	return nil
+}
+
+func TestSendWithRetry(t *testing.T) {
+	down := errors.New("connection refused")
+	tests := []struct {
+		name     string
+		mailer   flakyMailer
+		attempts int
+
+		wantErr   error
+		wantCalls int
+	}{
+		{"first try", flakyMailer{}, 3, nil, 1},
+		{"after failures", flakyMailer{failures: 2, err: down}, 3, nil, 3},
+		{"every try fails", flakyMailer{failures: 5, err: down}, 3, down, 3},
+		{"at least one try", flakyMailer{failures: 5, err: down}, 0, down, 1},
+		{"no recipient", flakyMailer{failures: 5, err: ErrNoRecipient}, 3, ErrNoRecipient, 1},
+		{"bad address", flakyMailer{failures: 5, err: ErrBadAddress}, 3, ErrBadAddress, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := SendWithRetry(&tt.mailer, Message{}, tt.attempts, 0)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.mailer.calls != tt.wantCalls {
+				t.Errorf("sent %d times, want %d", tt.mailer.calls, tt.wantCalls)
+			}
+		})
+	}
+}
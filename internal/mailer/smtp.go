@@ -0,0 +1,33 @@
+package mailer
+
+import (
+	"fmt"
+	"net/smtp"
+)
+
+// SMTPMailer delivers messages through an SMTP relay
+type SMTPMailer struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+}
+
+// Send renders msg and hands it to the SMTP server
+func (m *SMTPMailer) Send(msg Message) error {
+	body, err := build(msg)
+	if err != nil {
+		return err
+	}
+
+	var auth smtp.Auth
+	if m.Username != "" {
+		auth = smtp.PlainAuth("", m.Username, m.Password, m.Host)
+	}
+
+	// build has already checked both addresses
+	from, _ := ParseAddress(msg.From)
+	to, _ := ParseAddress(msg.To)
+	addr := fmt.Sprintf("%s:%d", m.Host, m.Port)
+	return smtp.SendMail(addr, auth, from, []string{to}, body)
+}
@@ -0,0 +1,35 @@
+package metrics
+
+import "database/sql"
+
+// DBStats adds gauges and counters for db's connection pool, read from
+// sql.DBStats at each scrape
+func (r *Registry) DBStats(db *sql.DB) {
+	r.GaugeFunc("db_max_open_connections", "Most connections the pool may open.", func() float64 {
+		return float64(db.Stats().MaxOpenConnections)
+	})
+	r.GaugeFunc("db_open_connections", "Connections open, in use and idle.", func() float64 {
+		return float64(db.Stats().OpenConnections)
+	})
+	r.GaugeFunc("db_in_use_connections", "Connections in use.", func() float64 {
+		return float64(db.Stats().InUse)
+	})
+	r.GaugeFunc("db_idle_connections", "Connections idle.", func() float64 {
+		return float64(db.Stats().Idle)
+	})
+	r.CounterFunc("db_wait_count_total", "Times a query waited for a connection.", func() float64 {
+		return float64(db.Stats().WaitCount)
+	})
+	r.CounterFunc("db_wait_duration_seconds_total", "Time spent waiting for connections.", func() float64 {
+		return db.Stats().WaitDuration.Seconds()
+	})
+	r.CounterFunc("db_max_idle_closed_total", "Connections closed for exceeding the idle limit.", func() float64 {
+		return float64(db.Stats().MaxIdleClosed)
+	})
+	r.CounterFunc("db_max_idle_time_closed_total", "Connections closed for being idle too long.", func() float64 {
+		return float64(db.Stats().MaxIdleTimeClosed)
+	})
+	r.CounterFunc("db_max_lifetime_closed_total", "Connections closed for reaching their lifetime.", func() float64 {
+		return float64(db.Stats().MaxLifetimeClosed)
+	})
+}
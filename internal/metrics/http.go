@@ -0,0 +1,53 @@
+package metrics
+
+import (
+	"crypto/subtle"
+	"github.com/go-chi/chi/v5/middleware"
+	"go-stripe/internal/logging"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// HTTP counts and times requests by method, chi route pattern and status.
+// Routes rather than paths keep the number of series down, one per route
+// instead of one per order or receipt
+func HTTP(r *Registry) func(http.Handler) http.Handler {
+	requests := r.Counter("http_requests_total", "HTTP requests served.", "method", "route", "status")
+	durations := r.Histogram("http_request_duration_seconds", "How long HTTP requests took to serve.", DefaultBuckets, "method", "route")
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			start := time.Now()
+			ww := middleware.NewWrapResponseWriter(w, req.ProtoMajor)
+			next.ServeHTTP(ww, req)
+
+			status := ww.Status()
+			if status == 0 {
+				status = http.StatusOK
+			}
+			route := logging.Route(req)
+			requests.Inc(req.Method, route, strconv.Itoa(status))
+			durations.Observe(time.Since(start).Seconds(), req.Method, route)
+		})
+	}
+}
+
+// RequireToken serves next only to scrapers sending token as a bearer token.
+// With no token set the metrics aren't served at all, so they are never
+// public by accident
+func RequireToken(token string, next http.Handler) http.Handler {
+	want := []byte("Bearer " + token)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if token == "" {
+			http.NotFound(w, r)
+			return
+		}
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), want) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="metrics"`)
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
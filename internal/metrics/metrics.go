@@ -0,0 +1,314 @@
+// Package metrics keeps counters, histograms and gauges and serves them in
+// the prometheus text format, so they can be scraped by prometheus or read
+// with curl
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultBuckets are histogram buckets in seconds for timing requests and
+// calls to other services
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// metric is one named family of series
+type metric interface {
+	write(w *bufio.Writer)
+}
+
+// Registry holds metrics and writes them out in the order they were added
+type Registry struct {
+	mu      sync.Mutex
+	metrics []metric
+	byName  map[string]metric
+}
+
+// NewRegistry returns an empty registry
+func NewRegistry() *Registry {
+	return &Registry{byName: make(map[string]metric)}
+}
+
+// add adds m under name, unless there is a metric by that name already, in
+// which case that one is returned instead
+func (r *Registry) add(name string, m metric) metric {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if existing, ok := r.byName[name]; ok {
+		return existing
+	}
+	r.byName[name] = m
+	r.metrics = append(r.metrics, m)
+	return m
+}
+
+// WriteTo writes every metric to w in the prometheus text format
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	metrics := append([]metric(nil), r.metrics...)
+	r.mu.Unlock()
+
+	cw := &countingWriter{w: w}
+	bw := bufio.NewWriter(cw)
+	for _, m := range metrics {
+		m.write(bw)
+	}
+	err := bw.Flush()
+	return cw.n, err
+}
+
+// Handler serves the metrics for scraping
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		r.WriteTo(w)
+	})
+}
+
+// Counter is a family of counters, one for each combination of its labels'
+// values
+type Counter struct {
+	desc
+	mu     sync.Mutex
+	series map[string]*counterSeries
+}
+
+type counterSeries struct {
+	labels []string
+	value  float64
+}
+
+// Counter adds a counter with the given labels, or returns the one added
+// under name before
+func (r *Registry) Counter(name, help string, labels ...string) *Counter {
+	c, ok := r.add(name, &Counter{desc: desc{name, help, "counter", labels}, series: make(map[string]*counterSeries)}).(*Counter)
+	if !ok || !c.sameLabels(labels) {
+		panic("metrics: " + name + " is already registered as something else")
+	}
+	return c
+}
+
+// Inc adds one to the counter with the label values
+func (c *Counter) Inc(values ...string) {
+	c.Add(1, values...)
+}
+
+// Add adds v, which mustn't be negative, to the counter with the label values
+func (c *Counter) Add(v float64, values ...string) {
+	key := c.key(values)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s, ok := c.series[key]
+	if !ok {
+		s = &counterSeries{labels: append([]string(nil), values...)}
+		c.series[key] = s
+	}
+	s.value += v
+}
+
+// Value is the count for the label values, for tests
+func (c *Counter) Value(values ...string) float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if s, ok := c.series[c.key(values)]; ok {
+		return s.value
+	}
+	return 0
+}
+
+func (c *Counter) write(w *bufio.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.header(w)
+	for _, key := range sortedKeys(c.series) {
+		s := c.series[key]
+		c.sample(w, "", s.labels, "", "", s.value)
+	}
+}
+
+// Histogram is a family of histograms, one for each combination of its
+// labels' values
+type Histogram struct {
+	desc
+	buckets []float64
+	mu      sync.Mutex
+	series  map[string]*histogramSeries
+}
+
+type histogramSeries struct {
+	labels []string
+	counts []uint64 // per bucket, not cumulative
+	sum    float64
+	count  uint64
+}
+
+// Histogram adds a histogram with the given upper bucket bounds, in
+// increasing order, and labels, or returns the one added under name before
+func (r *Registry) Histogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	h, ok := r.add(name, &Histogram{desc: desc{name, help, "histogram", labels}, buckets: buckets, series: make(map[string]*histogramSeries)}).(*Histogram)
+	if !ok || !h.sameLabels(labels) {
+		panic("metrics: " + name + " is already registered as something else")
+	}
+	return h
+}
+
+// Observe records v in the histogram with the label values
+func (h *Histogram) Observe(v float64, values ...string) {
+	key := h.key(values)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s, ok := h.series[key]
+	if !ok {
+		s = &histogramSeries{labels: append([]string(nil), values...), counts: make([]uint64, len(h.buckets))}
+		h.series[key] = s
+	}
+	if i := sort.SearchFloat64s(h.buckets, v); i < len(h.buckets) {
+		s.counts[i]++
+	}
+	s.sum += v
+	s.count++
+}
+
+// Count is how many values were observed for the label values, for tests
+func (h *Histogram) Count(values ...string) uint64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if s, ok := h.series[h.key(values)]; ok {
+		return s.count
+	}
+	return 0
+}
+
+func (h *Histogram) write(w *bufio.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.header(w)
+	for _, key := range sortedKeys(h.series) {
+		s := h.series[key]
+		var cumulative uint64
+		for i, upper := range h.buckets {
+			cumulative += s.counts[i]
+			h.sample(w, "_bucket", s.labels, "le", formatFloat(upper), float64(cumulative))
+		}
+		h.sample(w, "_bucket", s.labels, "le", "+Inf", float64(s.count))
+		h.sample(w, "_sum", s.labels, "", "", s.sum)
+		h.sample(w, "_count", s.labels, "", "", float64(s.count))
+	}
+}
+
+// funcMetric is a single value read when the metrics are written
+type funcMetric struct {
+	desc
+	fn func() float64
+}
+
+// GaugeFunc adds a gauge whose value is fn's when the metrics are written.
+// A gauge already added under name is kept
+func (r *Registry) GaugeFunc(name, help string, fn func() float64) {
+	r.add(name, &funcMetric{desc{name, help, "gauge", nil}, fn})
+}
+
+// CounterFunc adds a counter whose value is fn's when the metrics are
+// written, for counts kept elsewhere. A counter already added under name is
+// kept
+func (r *Registry) CounterFunc(name, help string, fn func() float64) {
+	r.add(name, &funcMetric{desc{name, help, "counter", nil}, fn})
+}
+
+func (f *funcMetric) write(w *bufio.Writer) {
+	f.header(w)
+	f.sample(w, "", nil, "", "", f.fn())
+}
+
+// desc is what every metric has
+type desc struct {
+	name   string
+	help   string
+	typ    string
+	labels []string
+}
+
+func (d desc) sameLabels(labels []string) bool {
+	return strings.Join(d.labels, ",") == strings.Join(labels, ",")
+}
+
+// key identifies the series for values, which must be one for each label
+func (d desc) key(values []string) string {
+	if len(values) != len(d.labels) {
+		panic(fmt.Sprintf("metrics: %s takes %d label values, got %d", d.name, len(d.labels), len(values)))
+	}
+	return strings.Join(values, "\xff")
+}
+
+func (d desc) header(w *bufio.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n", d.name, helpEscaper.Replace(d.help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", d.name, d.typ)
+}
+
+// sample writes one line, the metric's name with suffix, its labels with
+// values and the extra label if there is one, then v
+func (d desc) sample(w *bufio.Writer, suffix string, values []string, extra, extraValue string, v float64) {
+	w.WriteString(d.name)
+	w.WriteString(suffix)
+	if len(values) > 0 || extra != "" {
+		w.WriteByte('{')
+		for i, l := range d.labels {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			fmt.Fprintf(w, `%s="%s"`, l, labelEscaper.Replace(values[i]))
+		}
+		if extra != "" {
+			if len(values) > 0 {
+				w.WriteByte(',')
+			}
+			fmt.Fprintf(w, `%s="%s"`, extra, extraValue)
+		}
+		w.WriteByte('}')
+	}
+	w.WriteByte(' ')
+	w.WriteString(formatFloat(v))
+	w.WriteByte('\n')
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
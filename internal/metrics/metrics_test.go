@@ -0,0 +1,127 @@
+package metrics
+
+import (
+	"github.com/go-chi/chi/v5"
+	"go-stripe/internal/driver"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestWriteTo(t *testing.T) {
+	r := NewRegistry()
+	c := r.Counter("payments_total", "Payments by outcome.", "outcome")
+	h := r.Histogram("call_duration_seconds", "Call durations.", []float64{.1, 1}, "op")
+	r.GaugeFunc("temperature", "A gauge\nover two lines.", func() float64 { return -1.5 })
+
+	c.Inc("ok")
+	c.Add(2, "ok")
+	c.Inc(`say "no"`)
+	h.Observe(.05, "get")
+	h.Observe(.1, "get")
+	h.Observe(.5, "get")
+	h.Observe(3, "get")
+
+	var sb strings.Builder
+	if _, err := r.WriteTo(&sb); err != nil {
+		t.Fatal(err)
+	}
+	want := `# HELP payments_total Payments by outcome.
+# TYPE payments_total counter
+payments_total{outcome="ok"} 3
+payments_total{outcome="say \"no\""} 1
+# HELP call_duration_seconds Call durations.
+# TYPE call_duration_seconds histogram
+call_duration_seconds_bucket{op="get",le="0.1"} 2
+call_duration_seconds_bucket{op="get",le="1"} 3
+call_duration_seconds_bucket{op="get",le="+Inf"} 4
+call_duration_seconds_sum{op="get"} 3.65
+call_duration_seconds_count{op="get"} 4
+# HELP temperature A gauge\nover two lines.
+# TYPE temperature gauge
+temperature -1.5
+`
+	if got := sb.String(); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestLabelCount(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("want a panic for the wrong number of label values")
+		}
+	}()
+	NewRegistry().Counter("c", "c", "a", "b").Inc("only one")
+}
+
+func TestHTTP(t *testing.T) {
+	r := NewRegistry()
+	mux := chi.NewRouter()
+	mux.Use(HTTP(r))
+	mux.Get("/widget/{id}", func(w http.ResponseWriter, req *http.Request) {})
+	mux.Get("/metrics", r.Handler().ServeHTTP)
+
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	for _, path := range []string{"/widget/1", "/widget/2", "/nowhere"} {
+		resp, err := http.Get(srv.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+
+	// scraped the way prometheus does it
+	resp, err := http.Get(srv.URL + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	b, _ := io.ReadAll(resp.Body)
+	body := string(b)
+	if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("content type %q", ct)
+	}
+	for _, line := range []string{
+		`http_requests_total{method="GET",route="/widget/{id}",status="200"} 2`,
+		`http_requests_total{method="GET",route="unmatched",status="404"} 1`,
+		`http_request_duration_seconds_count{method="GET",route="/widget/{id}"} 2`,
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("no %s in\n%s", line, body)
+		}
+	}
+}
+
+func TestDBStats(t *testing.T) {
+	db, err := driver.OpenDB("sqlite::memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(3)
+
+	r := NewRegistry()
+	r.DBStats(db)
+	var sb strings.Builder
+	r.WriteTo(&sb)
+	if !strings.Contains(sb.String(), "db_max_open_connections 3\n") {
+		t.Errorf("no pool size in\n%s", sb.String())
+	}
+}
+
+func TestRegisterTwice(t *testing.T) {
+	r := NewRegistry()
+	if a, b := r.Counter("c", "c", "l"), r.Counter("c", "c", "l"); a != b {
+		t.Error("registering a counter twice made two")
+	}
+	defer func() {
+		if recover() == nil {
+			t.Error("want a panic for a name registered as another type")
+		}
+	}()
+	r.Histogram("c", "c", DefaultBuckets, "l")
+}
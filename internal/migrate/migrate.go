@@ -0,0 +1,501 @@
+// Package migrate applies the versioned sql migrations in an fs.FS to a
+// database and records which have been applied in the schema_versions table
+package migrate
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"go-stripe/internal/driver"
+	"io/fs"
+	"log"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// LockName names the lock held while migrating, so two instances starting at
+// once don't both apply the same migration. It is a named lock in mysql and
+// an advisory lock in postgres. sqlite databases are a file on one machine
+// and aren't locked
+const LockName = "go_stripe_migrate"
+
+// LockTimeout is how long to wait for another instance to finish migrating
+const LockTimeout = time.Minute
+
+var (
+	ErrLocked         = errors.New("another migration is running")
+	ErrUnknownVersion = errors.New("no migration has that version")
+)
+
+// Migration is one schema change and how to undo it
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+}
+
+// Status is a migration and whether it has been applied
+type Status struct {
+	Version   int64
+	Name      string
+	Applied   bool
+	AppliedAt string
+}
+
+// Load reads the migrations in fsys, oldest first. Files are named
+// VERSION_name.up.sql and VERSION_name.down.sql and every migration needs
+// both
+func Load(fsys fs.FS) ([]Migration, error) {
+	files, err := fs.Glob(fsys, "*.sql")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int64]*Migration)
+	for _, f := range files {
+		base := path.Base(f)
+		var direction string
+		switch {
+		case strings.HasSuffix(base, ".up.sql"):
+			direction = "up"
+		case strings.HasSuffix(base, ".down.sql"):
+			direction = "down"
+		default:
+			return nil, fmt.Errorf("%s: migrations must end in .up.sql or .down.sql", f)
+		}
+		stem := strings.TrimSuffix(base, "."+direction+".sql")
+		v, name, ok := strings.Cut(stem, "_")
+		version, err := strconv.ParseInt(v, 10, 64)
+		if !ok || err != nil {
+			return nil, fmt.Errorf("%s: migrations must be named VERSION_name", f)
+		}
+
+		body, err := fs.ReadFile(fsys, f)
+		if err != nil {
+			return nil, err
+		}
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: name}
+			byVersion[version] = m
+		}
+		if m.Name != name {
+			return nil, fmt.Errorf("version %d is used by both %s and %s", version, m.Name, name)
+		}
+		if direction == "up" {
+			m.Up = string(body)
+		} else {
+			m.Down = string(body)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" || m.Down == "" {
+			return nil, fmt.Errorf("migration %d_%s needs both an up and a down file", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	return migrations, nil
+}
+
+// Migrator applies migrations to a database
+type Migrator struct {
+	DB         *sql.DB
+	Dialect    driver.Dialect
+	Migrations []Migration
+	Log        *log.Logger
+}
+
+// New loads the migrations in fsys ready to apply to db, which speaks
+// dialect, mysql when it is empty
+func New(db *sql.DB, dialect driver.Dialect, fsys fs.FS, logger *log.Logger) (*Migrator, error) {
+	migrations, err := Load(fsys)
+	if err != nil {
+		return nil, err
+	}
+	if dialect == "" {
+		dialect = driver.MySQL
+	}
+	return &Migrator{DB: db, Dialect: dialect, Migrations: migrations, Log: logger}, nil
+}
+
+// Up applies every migration that hasn't been applied yet and returns how
+// many it applied
+func (m *Migrator) Up(ctx context.Context) (int, error) {
+	n := 0
+	err := m.locked(ctx, func(conn *sql.Conn, applied map[int64]bool) error {
+		for _, mig := range m.Migrations {
+			if applied[mig.Version] {
+				continue
+			}
+			if err := m.apply(ctx, conn, mig); err != nil {
+				return err
+			}
+			n++
+		}
+		return nil
+	})
+	return n, err
+}
+
+// Down rolls back the last steps applied migrations, newest first, and
+// returns how many it rolled back
+func (m *Migrator) Down(ctx context.Context, steps int) (int, error) {
+	n := 0
+	err := m.locked(ctx, func(conn *sql.Conn, applied map[int64]bool) error {
+		for i := len(m.Migrations) - 1; i >= 0 && n < steps; i-- {
+			mig := m.Migrations[i]
+			if !applied[mig.Version] {
+				continue
+			}
+			if err := m.revert(ctx, conn, mig); err != nil {
+				return err
+			}
+			n++
+		}
+		return nil
+	})
+	return n, err
+}
+
+// To applies or rolls back migrations until version is the newest applied.
+// A version of 0 rolls back everything
+func (m *Migrator) To(ctx context.Context, version int64) (int, error) {
+	if version != 0 && !m.known(version) {
+		return 0, ErrUnknownVersion
+	}
+
+	n := 0
+	err := m.locked(ctx, func(conn *sql.Conn, applied map[int64]bool) error {
+		for i := len(m.Migrations) - 1; i >= 0; i-- {
+			mig := m.Migrations[i]
+			if mig.Version <= version || !applied[mig.Version] {
+				continue
+			}
+			if err := m.revert(ctx, conn, mig); err != nil {
+				return err
+			}
+			n++
+		}
+		for _, mig := range m.Migrations {
+			if mig.Version > version || applied[mig.Version] {
+				continue
+			}
+			if err := m.apply(ctx, conn, mig); err != nil {
+				return err
+			}
+			n++
+		}
+		return nil
+	})
+	return n, err
+}
+
+// Status lists every migration and whether it has been applied, oldest
+// first. Versions recorded in the database that have no migration file are
+// listed last
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	conn, err := m.DB.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if err = ensureVersionsTable(ctx, conn); err != nil {
+		return nil, err
+	}
+	// mysql can't cast to text
+	asText := "text"
+	if m.Dialect == driver.MySQL {
+		asText = "char"
+	}
+	rows, err := conn.QueryContext(ctx, "select version, name, cast(applied_at as "+asText+") from schema_versions order by version")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	recorded := make(map[int64]Status)
+	var order []int64
+	for rows.Next() {
+		var s Status
+		if err = rows.Scan(&s.Version, &s.Name, &s.AppliedAt); err != nil {
+			return nil, err
+		}
+		s.Applied = true
+		recorded[s.Version] = s
+		order = append(order, s.Version)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var statuses []Status
+	for _, mig := range m.Migrations {
+		s, ok := recorded[mig.Version]
+		if !ok {
+			s = Status{Version: mig.Version, Name: mig.Name}
+		}
+		statuses = append(statuses, s)
+		delete(recorded, mig.Version)
+	}
+	for _, v := range order {
+		if s, ok := recorded[v]; ok {
+			statuses = append(statuses, s)
+		}
+	}
+	return statuses, nil
+}
+
+func (m *Migrator) known(version int64) bool {
+	for _, mig := range m.Migrations {
+		if mig.Version == version {
+			return true
+		}
+	}
+	return false
+}
+
+// locked runs fn on one connection while holding the migration lock, with
+// the versions that have already been applied
+func (m *Migrator) locked(ctx context.Context, fn func(*sql.Conn, map[int64]bool) error) error {
+	conn, err := m.DB.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	unlock, err := m.lock(ctx, conn)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if err = ensureVersionsTable(ctx, conn); err != nil {
+		return err
+	}
+	if m.Dialect == driver.MySQL {
+		if err = m.adoptSoda(ctx, conn); err != nil {
+			return err
+		}
+	}
+
+	applied, err := appliedVersions(ctx, conn)
+	if err != nil {
+		return err
+	}
+	return fn(conn, applied)
+}
+
+// lock takes the migration lock on conn, waiting up to LockTimeout for it,
+// and returns a function that releases it
+func (m *Migrator) lock(ctx context.Context, conn *sql.Conn) (func(), error) {
+	switch m.Dialect {
+	case driver.MySQL:
+		var got sql.NullInt64
+		err := conn.QueryRowContext(ctx, "select get_lock(?, ?)", LockName, int(LockTimeout.Seconds())).Scan(&got)
+		if err != nil {
+			return nil, err
+		}
+		if got.Int64 != 1 {
+			return nil, ErrLocked
+		}
+		return func() {
+			conn.ExecContext(context.Background(), "select release_lock(?)", LockName)
+		}, nil
+
+	case driver.Postgres:
+		deadline := time.Now().Add(LockTimeout)
+		for {
+			var got bool
+			err := conn.QueryRowContext(ctx, "select pg_try_advisory_lock(hashtext($1))", LockName).Scan(&got)
+			if err != nil {
+				return nil, err
+			}
+			if got {
+				break
+			}
+			if time.Now().After(deadline) {
+				return nil, ErrLocked
+			}
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-time.After(time.Second):
+			}
+		}
+		return func() {
+			conn.ExecContext(context.Background(), "select pg_advisory_unlock(hashtext($1))", LockName)
+		}, nil
+	}
+	return func() {}, nil
+}
+
+func ensureVersionsTable(ctx context.Context, conn *sql.Conn) error {
+	_, err := conn.ExecContext(ctx, `create table if not exists schema_versions (
+		version bigint not null primary key,
+		name varchar(255) not null,
+		applied_at timestamp not null default current_timestamp
+	)`)
+	return err
+}
+
+func appliedVersions(ctx context.Context, conn *sql.Conn) (map[int64]bool, error) {
+	rows, err := conn.QueryContext(ctx, "select version from schema_versions")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := make(map[int64]bool)
+	for rows.Next() {
+		var v int64
+		if err = rows.Scan(&v); err != nil {
+			return nil, err
+		}
+		applied[v] = true
+	}
+	return applied, rows.Err()
+}
+
+// adoptSoda records the migrations a database was given by the soda cli,
+// which kept them in schema_migration, so they aren't applied a second time.
+// It only does so while schema_versions is empty
+func (m *Migrator) adoptSoda(ctx context.Context, conn *sql.Conn) error {
+	var count int
+	err := conn.QueryRowContext(ctx, "select count(*) from schema_versions").Scan(&count)
+	if err != nil || count > 0 {
+		return err
+	}
+	err = conn.QueryRowContext(ctx,
+		"select count(*) from information_schema.tables where table_schema = database() and table_name = 'schema_migration'").Scan(&count)
+	if err != nil || count == 0 {
+		return err
+	}
+
+	rows, err := conn.QueryContext(ctx, "select version from schema_migration")
+	if err != nil {
+		return err
+	}
+	var versions []string
+	for rows.Next() {
+		var v string
+		if err = rows.Scan(&v); err != nil {
+			rows.Close()
+			return err
+		}
+		versions = append(versions, v)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	for _, v := range versions {
+		version, err := strconv.ParseInt(strings.TrimSpace(v), 10, 64)
+		if err != nil {
+			continue
+		}
+		for _, mig := range m.Migrations {
+			if mig.Version != version {
+				continue
+			}
+			if _, err = conn.ExecContext(ctx, "insert into schema_versions (version, name) values (?, ?)", mig.Version, mig.Name); err != nil {
+				return err
+			}
+			m.logf("adopted %d_%s from soda", mig.Version, mig.Name)
+		}
+	}
+	return nil
+}
+
+// execer is a *sql.Conn or *sql.Tx
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+// apply runs a migration's up statements and records it. Postgres and
+// sqlite do this in a transaction, but mysql commits schema changes as it
+// goes, so there a migration that fails part way has to be tidied up by hand
+// before it can be run again
+func (m *Migrator) apply(ctx context.Context, conn *sql.Conn, mig Migration) error {
+	m.logf("applying %d_%s", mig.Version, mig.Name)
+	return m.run(ctx, conn, mig, mig.Up, "insert into schema_versions (version, name) values (?, ?)", mig.Version, mig.Name)
+}
+
+// revert runs a migration's down statements and forgets it
+func (m *Migrator) revert(ctx context.Context, conn *sql.Conn, mig Migration) error {
+	m.logf("rolling back %d_%s", mig.Version, mig.Name)
+	return m.run(ctx, conn, mig, mig.Down, "delete from schema_versions where version = ?", mig.Version)
+}
+
+// run executes the statements in body followed by the bookkeeping statement
+// track
+func (m *Migrator) run(ctx context.Context, conn *sql.Conn, mig Migration, body, track string, args ...any) error {
+	var ex execer = conn
+	var tx *sql.Tx
+	if m.Dialect != driver.MySQL {
+		var err error
+		tx, err = conn.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+		ex = tx
+	}
+
+	for _, stmt := range Statements(body) {
+		if _, err := ex.ExecContext(ctx, stmt); err != nil {
+			return fmt.Errorf("%d_%s: %w", mig.Version, mig.Name, err)
+		}
+	}
+	if _, err := ex.ExecContext(ctx, m.Dialect.Rebind(track), args...); err != nil {
+		return err
+	}
+	if tx != nil {
+		return tx.Commit()
+	}
+	return nil
+}
+
+func (m *Migrator) logf(format string, args ...any) {
+	if m.Log != nil {
+		m.Log.Printf(format, args...)
+	}
+}
+
+// Statements splits a migration file into statements, without their closing
+// semicolons. A statement ends with a semicolon at the end of a line, and
+// lines starting with -- are comments
+func Statements(body string) []string {
+	var stmts []string
+	var cur strings.Builder
+	sc := bufio.NewScanner(strings.NewReader(body))
+	for sc.Scan() {
+		line := sc.Text()
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" && cur.Len() == 0 || strings.HasPrefix(trimmed, "--") {
+			continue
+		}
+		cur.WriteString(line)
+		cur.WriteString("\n")
+		if strings.HasSuffix(trimmed, ";") {
+			stmts = append(stmts, strings.TrimSuffix(strings.TrimSpace(cur.String()), ";"))
+			cur.Reset()
+		}
+	}
+	if s := strings.TrimSpace(cur.String()); s != "" {
+		stmts = append(stmts, s)
+	}
+	return stmts
+}
@@ -0,0 +1,140 @@
+package models
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+// AuditEntry is one row of the append only audit log. Before and After hold
+// json snapshots of the thing that changed, when there is one
+type AuditEntry struct {
+	ID         int       `json:"id"`
+	Action     string    `json:"action"`
+	UserID     int       `json:"user_id"`
+	ActorEmail string    `json:"actor_email"`
+	IPAddress  string    `json:"ip_address"`
+	RequestID  string    `json:"request_id"`
+	EntityType string    `json:"entity_type"`
+	EntityID   int       `json:"entity_id"`
+	Before     string    `json:"before"`
+	After      string    `json:"after"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// AuditFilter narrows down GetAuditEntries. Zero values match everything
+type AuditFilter struct {
+	Action     string
+	ActorEmail string
+	EntityType string
+	EntityID   int
+	From       time.Time
+	To         time.Time
+}
+
+// InsertAuditEntry appends an entry to the audit log. There is deliberately
+// no way to change or remove entries
+func (m *DBModel) InsertAuditEntry(ctx context.Context, e AuditEntry) error {
+	ctx, span := tracer.Start(ctx, "DBModel.InsertAuditEntry")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, m.Timeouts.forQuery())
+	defer cancel()
+
+	stmt := `insert into audit_log (action,user_id,actor_email,ip_address,request_id,entity_type,entity_id,before_json,after_json,created_at)
+		values(?,nullif(?,0),?,?,?,?,?,nullif(?,''),nullif(?,''),?)`
+	_, err := m.conn().ExecContext(ctx, m.rebind(stmt), e.Action, e.UserID, e.ActorEmail, e.IPAddress, e.RequestID, e.EntityType, e.EntityID, e.Before, e.After, time.Now())
+	return err
+}
+
+// GetAuditEntries returns one page of the audit log, newest first, along
+// with the number of the last page and the total number of matching entries
+func (m *DBModel) GetAuditEntries(ctx context.Context, f AuditFilter, pageSize, page int) ([]*AuditEntry, int, int, error) {
+	ctx, span := tracer.Start(ctx, "DBModel.GetAuditEntries")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, m.Timeouts.forQuery())
+	defer cancel()
+
+	if pageSize < 1 {
+		pageSize = 20
+	}
+	if page < 1 {
+		page = 1
+	}
+
+	var where []string
+	var args []any
+	if f.Action != "" {
+		where = append(where, "action like ?")
+		args = append(args, f.Action+"%")
+	}
+	if f.ActorEmail != "" {
+		where = append(where, "actor_email = ?")
+		args = append(args, f.ActorEmail)
+	}
+	if f.EntityType != "" {
+		where = append(where, "entity_type = ?")
+		args = append(args, f.EntityType)
+	}
+	if f.EntityID > 0 {
+		where = append(where, "entity_id = ?")
+		args = append(args, f.EntityID)
+	}
+	if !f.From.IsZero() {
+		where = append(where, "created_at >= ?")
+		args = append(args, f.From)
+	}
+	if !f.To.IsZero() {
+		where = append(where, "created_at < ?")
+		args = append(args, f.To)
+	}
+	clause := ""
+	if len(where) > 0 {
+		clause = " where " + strings.Join(where, " and ")
+	}
+
+	var totalRecords int
+	err := m.conn().QueryRowContext(ctx, m.rebind("select count(id) from audit_log"+clause), args...).Scan(&totalRecords)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+
+	query := `select id, action, coalesce(user_id, 0), actor_email, ip_address, request_id,
+		entity_type, entity_id, coalesce(before_json, ''), coalesce(after_json, ''), created_at
+		from audit_log` + clause + " order by id desc limit ? offset ?"
+	rows, err := m.conn().QueryContext(ctx, m.rebind(query), append(args, pageSize, (page-1)*pageSize)...)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	defer rows.Close()
+
+	var entries []*AuditEntry
+	for rows.Next() {
+		var e AuditEntry
+		err = rows.Scan(
+			&e.ID,
+			&e.Action,
+			&e.UserID,
+			&e.ActorEmail,
+			&e.IPAddress,
+			&e.RequestID,
+			&e.EntityType,
+			&e.EntityID,
+			&e.Before,
+			&e.After,
+			&e.CreatedAt,
+		)
+		if err != nil {
+			return nil, 0, 0, err
+		}
+		entries = append(entries, &e)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, 0, 0, err
+	}
+
+	lastPage := totalRecords / pageSize
+	if totalRecords%pageSize != 0 {
+		lastPage++
+	}
+	return entries, lastPage, totalRecords, nil
+}
@@ -0,0 +1,100 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"go-stripe/internal/driver"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// slowQuery is how long a statement runs before it is logged as slow
+const slowQuery = 500 * time.Millisecond
+
+// tracer starts a span for every DBModel method, and one inside it for
+// each statement the method runs
+var tracer = otel.Tracer("go-stripe/internal/models")
+
+// conn is the database with its statements logged and traced. Statements
+// in a transaction run on the *sql.Tx and aren't
+func (m *DBModel) conn() loggedDB {
+	logger := m.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return loggedDB{db: m.DB, logger: logger, system: dbSystem(m.dialect())}
+}
+
+// loggedDB logs every statement at debug level, and the ones that fail or
+// are slow above it. The statements are logged with the caller's context,
+// so with the request that ran them, and without their arguments, which
+// hold customers' details
+type loggedDB struct {
+	db     *sql.DB
+	logger *slog.Logger
+	system string
+}
+
+func (l loggedDB) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	ctx, done := l.start(ctx, query)
+	result, err := l.db.ExecContext(ctx, query, args...)
+	done(err)
+	return result, err
+}
+
+func (l loggedDB) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	ctx, done := l.start(ctx, query)
+	rows, err := l.db.QueryContext(ctx, query, args...)
+	done(err)
+	return rows, err
+}
+
+func (l loggedDB) QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row {
+	ctx, done := l.start(ctx, query)
+	row := l.db.QueryRowContext(ctx, query, args...)
+	done(row.Err())
+	return row
+}
+
+// start starts running query and returns the func that logs it and ends
+// its span once it has run
+func (l loggedDB) start(ctx context.Context, query string) (context.Context, func(error)) {
+	query = strings.Join(strings.Fields(query), " ")
+	operation, _, _ := strings.Cut(query, " ")
+	ctx, span := tracer.Start(ctx, strings.ToLower(operation),
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("db.system", l.system),
+			attribute.String("db.query.text", query),
+		),
+	)
+	start := time.Now()
+
+	return ctx, func(err error) {
+		defer span.End()
+		elapsed := time.Since(start)
+		switch {
+		case err != nil:
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+			l.logger.ErrorContext(ctx, "query failed", "query", query, "duration", elapsed, "err", err)
+		case elapsed >= slowQuery:
+			l.logger.WarnContext(ctx, "slow query", "query", query, "duration", elapsed)
+		default:
+			l.logger.DebugContext(ctx, "query", "query", query, "duration", elapsed)
+		}
+	}
+}
+
+// dbSystem is OpenTelemetry's name for the database d speaks
+func dbSystem(d driver.Dialect) string {
+	if d == driver.Postgres {
+		return "postgresql"
+	}
+	return string(d)
+}
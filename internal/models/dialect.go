@@ -0,0 +1,67 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"go-stripe/internal/driver"
+	"strings"
+)
+
+// querier is a *sql.DB or *sql.Tx
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// dialect is the sql the database speaks
+func (m *DBModel) dialect() driver.Dialect {
+	if m.Dialect == "" {
+		return driver.MySQL
+	}
+	return m.Dialect
+}
+
+// rebind rewrites the ? placeholders in query for the database's dialect
+func (m *DBModel) rebind(query string) string {
+	return m.Dialect.Rebind(query)
+}
+
+// insertID runs an insert and returns the id of the row it wrote. Postgres
+// has no LastInsertId and sqlite's misses rows an upsert updated, so for
+// both the id comes back from a returning clause
+func (m *DBModel) insertID(ctx context.Context, q querier, stmt string, args ...any) (int, error) {
+	if m.dialect() != driver.MySQL {
+		var id int
+		err := q.QueryRowContext(ctx, m.rebind(stmt)+" returning id", args...).Scan(&id)
+		return id, err
+	}
+
+	result, err := q.ExecContext(ctx, m.rebind(stmt), args...)
+	if err != nil {
+		return 0, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	return int(id), nil
+}
+
+// forUpdate is the clause that locks selected rows until the transaction
+// ends. sqlite has no row locks, it lets one transaction write at a time
+func (m *DBModel) forUpdate() string {
+	if m.dialect() == driver.SQLite {
+		return ""
+	}
+	return " for update"
+}
+
+// insertIgnore makes an insert do nothing when a row with the same key
+// column already exists
+func (m *DBModel) insertIgnore(stmt, key string) string {
+	if m.dialect() == driver.MySQL {
+		return strings.Replace(stmt, "insert into", "insert ignore into", 1)
+	}
+	return stmt + " on conflict (" + key + ") do nothing"
+}
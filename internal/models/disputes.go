@@ -0,0 +1,273 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"go-stripe/internal/driver"
+	"time"
+)
+
+// dispute statuses we still have to act on, as reported by stripe
+const (
+	DisputeStatusNeedsResponse        = "needs_response"
+	DisputeStatusWarningNeedsResponse = "warning_needs_response"
+)
+
+// DisputeEvidence is the written evidence we send the bank for a dispute.
+// Field names follow stripe's dispute evidence
+type DisputeEvidence struct {
+	ProductDescription     string `json:"product_description"`
+	CustomerName           string `json:"customer_name"`
+	CustomerEmailAddress   string `json:"customer_email_address"`
+	BillingAddress         string `json:"billing_address"`
+	ShippingAddress        string `json:"shipping_address"`
+	ShippingCarrier        string `json:"shipping_carrier"`
+	ShippingTrackingNumber string `json:"shipping_tracking_number"`
+	ShippingDate           string `json:"shipping_date"`
+	UncategorizedText      string `json:"uncategorized_text"`
+}
+
+// Dispute is a chargeback raised against one of our charges
+type Dispute struct {
+	ID                  int             `json:"id"`
+	TransactionID       int             `json:"transaction_id"`
+	StripeDisputeID     string          `json:"stripe_dispute_id"`
+	ChargeID            string          `json:"charge_id"`
+	PaymentIntent       string          `json:"payment_intent"`
+	Amount              int             `json:"amount"`
+	Currency            string          `json:"currency"`
+	Reason              string          `json:"reason"`
+	Status              string          `json:"status"`
+	EvidenceDueBy       time.Time       `json:"evidence_due_by"`
+	HasEvidence         bool            `json:"has_evidence"`
+	SubmissionCount     int             `json:"submission_count"`
+	Evidence            DisputeEvidence `json:"evidence"`
+	EvidenceSubmittedAt time.Time       `json:"evidence_submitted_at"`
+	CreatedAt           time.Time       `json:"created_at"`
+	UpdatedAt           time.Time       `json:"updated_at"`
+	Files               []*DisputeFile  `json:"files,omitempty"`
+}
+
+// NeedsResponse reports whether we still have to send evidence
+func (d Dispute) NeedsResponse() bool {
+	return d.Status == DisputeStatusNeedsResponse || d.Status == DisputeStatusWarningNeedsResponse
+}
+
+// DisputeFile is a file uploaded to stripe as evidence for a dispute. Field
+// is the piece of evidence it is, such as receipt or shipping_documentation
+type DisputeFile struct {
+	ID           int       `json:"id"`
+	DisputeID    int       `json:"dispute_id"`
+	StripeFileID string    `json:"stripe_file_id"`
+	Field        string    `json:"field"`
+	Filename     string    `json:"filename"`
+	UserID       int       `json:"user_id"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// SaveDispute records a dispute as stripe last reported it, linking it to
+// the transaction for its payment intent or charge. Evidence we have written
+// is kept
+func (m *DBModel) SaveDispute(ctx context.Context, d Dispute) error {
+	ctx, span := tracer.Start(ctx, "DBModel.SaveDispute")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, m.Timeouts.forQuery())
+	defer cancel()
+
+	var dueBy any
+	if !d.EvidenceDueBy.IsZero() {
+		dueBy = d.EvidenceDueBy
+	}
+
+	now := time.Now()
+	stmt := `insert into disputes (transaction_id,stripe_dispute_id,charge_id,payment_intent,amount,currency,reason,status,
+			evidence_due_by,has_evidence,submission_count,created_at,updated_at)
+		values((select id from transactions where (payment_intent = ? and payment_intent <> '') or (bank_return_code = ? and bank_return_code <> '')
+				order by id desc limit 1),
+			?,?,?,?,?,?,?,?,?,?,?,?)`
+	if m.dialect() == driver.MySQL {
+		stmt += `
+		on duplicate key update amount=values(amount), reason=values(reason), status=values(status),
+			evidence_due_by=values(evidence_due_by), has_evidence=values(has_evidence),
+			submission_count=values(submission_count), updated_at=values(updated_at),
+			transaction_id=coalesce(transaction_id, values(transaction_id))`
+	} else {
+		stmt += `
+		on conflict (stripe_dispute_id) do update set amount=excluded.amount, reason=excluded.reason, status=excluded.status,
+			evidence_due_by=excluded.evidence_due_by, has_evidence=excluded.has_evidence,
+			submission_count=excluded.submission_count, updated_at=excluded.updated_at,
+			transaction_id=coalesce(disputes.transaction_id, excluded.transaction_id)`
+	}
+	_, err := m.conn().ExecContext(ctx, m.rebind(stmt), d.PaymentIntent, d.ChargeID,
+		d.StripeDisputeID, d.ChargeID, d.PaymentIntent, d.Amount, d.Currency, d.Reason, d.Status,
+		dueBy, d.HasEvidence, d.SubmissionCount, now, now)
+	return err
+}
+
+const disputeColumns = `id, coalesce(transaction_id, 0), stripe_dispute_id, charge_id, payment_intent, amount, currency,
+	reason, status, evidence_due_by, has_evidence, submission_count, coalesce(evidence, ''),
+	evidence_submitted_at, created_at, updated_at`
+
+func scanDispute(row interface{ Scan(...any) error }) (*Dispute, error) {
+	var d Dispute
+	var dueBy, submittedAt sql.NullTime
+	var evidence string
+	err := row.Scan(
+		&d.ID,
+		&d.TransactionID,
+		&d.StripeDisputeID,
+		&d.ChargeID,
+		&d.PaymentIntent,
+		&d.Amount,
+		&d.Currency,
+		&d.Reason,
+		&d.Status,
+		&dueBy,
+		&d.HasEvidence,
+		&d.SubmissionCount,
+		&evidence,
+		&submittedAt,
+		&d.CreatedAt,
+		&d.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	d.EvidenceDueBy = dueBy.Time
+	d.EvidenceSubmittedAt = submittedAt.Time
+	if evidence != "" {
+		if err = json.Unmarshal([]byte(evidence), &d.Evidence); err != nil {
+			return nil, err
+		}
+	}
+	return &d, nil
+}
+
+// GetDispute gets a dispute along with its evidence files
+func (m *DBModel) GetDispute(ctx context.Context, id int) (*Dispute, error) {
+	ctx, span := tracer.Start(ctx, "DBModel.GetDispute")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, m.Timeouts.forQuery())
+	defer cancel()
+
+	row := m.conn().QueryRowContext(ctx, m.rebind("select "+disputeColumns+" from disputes where id = ?"), id)
+	d, err := scanDispute(row)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := m.conn().QueryContext(ctx, m.rebind(`select id, dispute_id, stripe_file_id, field, filename, coalesce(user_id, 0), created_at
+		from dispute_files where dispute_id = ? order by id`), id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var f DisputeFile
+		err = rows.Scan(&f.ID, &f.DisputeID, &f.StripeFileID, &f.Field, &f.Filename, &f.UserID, &f.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		d.Files = append(d.Files, &f)
+	}
+	return d, rows.Err()
+}
+
+// GetDisputesPaginated returns one page of disputes along with the number of
+// the last page and the total number of disputes. Disputes waiting on us come
+// first, soonest due first, followed by the rest newest first. An empty
+// status returns disputes in any status
+func (m *DBModel) GetDisputesPaginated(ctx context.Context, status string, pageSize, page int) ([]*Dispute, int, int, error) {
+	ctx, span := tracer.Start(ctx, "DBModel.GetDisputesPaginated")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, m.Timeouts.forQuery())
+	defer cancel()
+
+	if pageSize < 1 {
+		pageSize = 10
+	}
+	if page < 1 {
+		page = 1
+	}
+	offset := (page - 1) * pageSize
+
+	where := ""
+	var args []any
+	if status != "" {
+		where = " where status = ?"
+		args = append(args, status)
+	}
+
+	var totalRecords int
+	err := m.conn().QueryRowContext(ctx, m.rebind("select count(id) from disputes"+where), args...).Scan(&totalRecords)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+
+	query := "select " + disputeColumns + " from disputes" + where + `
+		order by status in ('needs_response', 'warning_needs_response') desc,
+			case when status in ('needs_response', 'warning_needs_response') then evidence_due_by end,
+			created_at desc, id desc
+		limit ? offset ?`
+	rows, err := m.conn().QueryContext(ctx, m.rebind(query), append(args, pageSize, offset)...)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	defer rows.Close()
+
+	var disputes []*Dispute
+	for rows.Next() {
+		d, err := scanDispute(rows)
+		if err != nil {
+			return nil, 0, 0, err
+		}
+		disputes = append(disputes, d)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, 0, 0, err
+	}
+
+	lastPage := totalRecords / pageSize
+	if totalRecords%pageSize != 0 {
+		lastPage++
+	}
+
+	return disputes, lastPage, totalRecords, nil
+}
+
+// SaveDisputeEvidence stores the written evidence for a dispute. submitted
+// marks it as sent to the bank
+func (m *DBModel) SaveDisputeEvidence(ctx context.Context, id int, evidence DisputeEvidence, submitted bool) error {
+	ctx, span := tracer.Start(ctx, "DBModel.SaveDisputeEvidence")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, m.Timeouts.forQuery())
+	defer cancel()
+
+	b, err := json.Marshal(evidence)
+	if err != nil {
+		return err
+	}
+
+	var submittedAt any
+	if submitted {
+		submittedAt = time.Now()
+	}
+	stmt := `update disputes set evidence=?, evidence_submitted_at=coalesce(?, evidence_submitted_at), updated_at=?
+		where id=?`
+	_, err = m.conn().ExecContext(ctx, m.rebind(stmt), string(b), submittedAt, time.Now(), id)
+	return err
+}
+
+// InsertDisputeFile records a file uploaded to stripe as dispute evidence
+func (m *DBModel) InsertDisputeFile(ctx context.Context, f DisputeFile) (int, error) {
+	ctx, span := tracer.Start(ctx, "DBModel.InsertDisputeFile")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, m.Timeouts.forQuery())
+	defer cancel()
+
+	stmt := `insert into dispute_files (dispute_id,stripe_file_id,field,filename,user_id,created_at,updated_at)
+		values(?,?,?,?,nullif(?,0),?,?)`
+	return m.insertID(ctx, m.conn(), stmt, f.DisputeID, f.StripeFileID, f.Field, f.Filename, f.UserID, time.Now(), time.Now())
+}
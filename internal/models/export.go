@@ -0,0 +1,128 @@
+package models
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+// EachOrder calls fn with every order matching filter, oldest first, reading
+// them from the database one at a time. It stops at the first error from fn
+func (m *DBModel) EachOrder(ctx context.Context, filter OrderFilter, fn func(*Order) error) error {
+	ctx, span := tracer.Start(ctx, "DBModel.EachOrder")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, m.Timeouts.forExport())
+	defer cancel()
+
+	where, args := filter.where()
+	rows, err := m.conn().QueryContext(ctx, m.rebind(orderDetailQuery+where+" order by o.created_at, o.id"), args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		o, err := scanOrderDetail(rows)
+		if err != nil {
+			return err
+		}
+		if err = fn(o); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// TransactionFilter narrows down the transactions exported. Zero values
+// match every transaction and To is exclusive
+type TransactionFilter struct {
+	StatusID int
+	Source   string
+	From     time.Time
+	To       time.Time
+}
+
+func (f TransactionFilter) where() (string, []any) {
+	var conds []string
+	var args []any
+	if f.StatusID > 0 {
+		conds = append(conds, "t.transaction_status_id = ?")
+		args = append(args, f.StatusID)
+	}
+	if f.Source != "" {
+		conds = append(conds, "t.source = ?")
+		args = append(args, f.Source)
+	}
+	if !f.From.IsZero() {
+		conds = append(conds, "t.created_at >= ?")
+		args = append(args, f.From)
+	}
+	if !f.To.IsZero() {
+		conds = append(conds, "t.created_at < ?")
+		args = append(args, f.To)
+	}
+	if len(conds) == 0 {
+		return "", nil
+	}
+	return " where " + strings.Join(conds, " and "), args
+}
+
+// TransactionDetail is a transaction with its status, the customer charged
+// and, for checkout transactions, the order it paid for
+type TransactionDetail struct {
+	Transaction
+	Status   string   `json:"status"`
+	Customer Customer `json:"customer"`
+	OrderID  int      `json:"order_id"`
+	Widget   Widget   `json:"widget"`
+	Quantity int      `json:"quantity"`
+}
+
+// EachTransaction calls fn with every transaction matching filter, oldest
+// first, reading them from the database one at a time. It stops at the first
+// error from fn
+func (m *DBModel) EachTransaction(ctx context.Context, filter TransactionFilter, fn func(*TransactionDetail) error) error {
+	ctx, span := tracer.Start(ctx, "DBModel.EachTransaction")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, m.Timeouts.forExport())
+	defer cancel()
+
+	where, args := filter.where()
+	query := `
+		select ` + transactionColumns + `, coalesce(ts.name, ''),
+			coalesce(c.id, 0), coalesce(c.first_name, ''), coalesce(c.last_name, ''), coalesce(c.email, ''),
+			coalesce(o.id, 0), coalesce(w.id, 0), coalesce(w.name, ''), coalesce(o.quantity, 0)
+		from transactions t
+		left join transaction_statuses ts on (t.transaction_status_id = ts.id)
+		left join orders o on (o.transaction_id = t.id)
+		left join widgets w on (o.widget_id = w.id)
+		left join customers c on (c.id = coalesce(t.customer_id, o.customer_id))` + where + `
+		order by t.created_at, t.id`
+	rows, err := m.conn().QueryContext(ctx, m.rebind(query), args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var d TransactionDetail
+		fields := append(transactionFields(&d.Transaction),
+			&d.Status,
+			&d.Customer.ID,
+			&d.Customer.FirstName,
+			&d.Customer.LastName,
+			&d.Customer.Email,
+			&d.OrderID,
+			&d.Widget.ID,
+			&d.Widget.Name,
+			&d.Quantity,
+		)
+		if err = rows.Scan(fields...); err != nil {
+			return err
+		}
+		if err = fn(&d); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
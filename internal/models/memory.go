@@ -0,0 +1,656 @@
+package models
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"fmt"
+	"golang.org/x/crypto/bcrypt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// orderStatusLabels are the names seeded into the statuses table, which the
+// database joins onto orders
+var orderStatusLabels = map[int]string{
+	OrderStatusCleared:   "Cleared",
+	OrderStatusRefunded:  "Refunded",
+	OrderStatusCancelled: "Cancelled",
+	OrderStatusPacked:    "Packed",
+	OrderStatusShipped:   "Shipped",
+	OrderStatusDelivered: "Delivered",
+	OrderStatusPending:   "Pending",
+}
+
+// MemoryStore keeps everything in maps, for running the checkout without a
+// database. It is safe for concurrent use
+type MemoryStore struct {
+	mu           sync.Mutex
+	ids          map[string]int
+	widgets      map[int]Widget
+	orders       map[int]Order
+	events       []OrderEvent
+	invoices     map[int]Invoice
+	transactions map[int]Transaction
+	customers    map[int]Customer
+	users        map[int]User
+	tokens       []Token
+	coupons      map[int]Coupon
+	reservations map[string]CouponReservation
+	audit        []AuditEntry
+	disputes     map[int]Dispute
+	disputeFiles []DisputeFile
+	payouts      map[int]Payout
+	payoutLines  []PayoutLine
+}
+
+// NewMemoryStore returns an empty store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		ids:          make(map[string]int),
+		widgets:      make(map[int]Widget),
+		orders:       make(map[int]Order),
+		invoices:     make(map[int]Invoice),
+		transactions: make(map[int]Transaction),
+		customers:    make(map[int]Customer),
+		users:        make(map[int]User),
+		coupons:      make(map[int]Coupon),
+		reservations: make(map[string]CouponReservation),
+		disputes:     make(map[int]Dispute),
+		payouts:      make(map[int]Payout),
+	}
+}
+
+// Models returns the repositories backed by s
+func (s *MemoryStore) Models() Models {
+	return Models{
+		Widgets:      s,
+		Orders:       s,
+		Transactions: s,
+		Customers:    s,
+		Users:        s,
+		Coupons:      s,
+		Reports:      s,
+		Disputes:     s,
+		Payouts:      s,
+		Imports:      s,
+		Audit:        s,
+	}
+}
+
+// nextID hands out ids the way an auto increment column would
+func (s *MemoryStore) nextID(table string) int {
+	s.ids[table]++
+	return s.ids[table]
+}
+
+// AddWidget stores a widget and returns its id
+func (s *MemoryStore) AddWidget(w Widget) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	w.ID = s.nextID("widgets")
+	w.CreatedAt, w.UpdatedAt = time.Now(), time.Now()
+	s.widgets[w.ID] = w
+	return w.ID
+}
+
+// AddUser stores a user who logs in with password and returns their id
+func (s *MemoryStore) AddUser(u User, password string) (int, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	if err != nil {
+		return 0, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u.ID = s.nextID("users")
+	u.Email = strings.ToLower(u.Email)
+	u.Password = string(hash)
+	u.CreatedAt, u.UpdatedAt = time.Now(), time.Now()
+	s.users[u.ID] = u
+	return u.ID, nil
+}
+
+// AddCoupon stores a coupon and returns its id
+func (s *MemoryStore) AddCoupon(c Coupon) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c.ID = s.nextID("coupons")
+	c.CreatedAt, c.UpdatedAt = time.Now(), time.Now()
+	s.coupons[c.ID] = c
+	return c.ID
+}
+
+// AuditEntries returns everything recorded with InsertAuditEntry, oldest first
+func (s *MemoryStore) AuditEntries() []AuditEntry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]AuditEntry(nil), s.audit...)
+}
+
+// InsertAuditEntry appends an entry to the audit log
+func (s *MemoryStore) InsertAuditEntry(ctx context.Context, e AuditEntry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e.ID = s.nextID("audit_log")
+	e.CreatedAt = time.Now()
+	s.audit = append(s.audit, e)
+	return nil
+}
+
+func (s *MemoryStore) GetWidget(ctx context.Context, id int) (Widget, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	w, ok := s.widgets[id]
+	if !ok {
+		return w, sql.ErrNoRows
+	}
+	return w, nil
+}
+
+// UpdateWidget saves the editable details of a widget
+func (s *MemoryStore) UpdateWidget(ctx context.Context, widget Widget) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	w, ok := s.widgets[widget.ID]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	w.SKU = widget.SKU
+	w.Name = widget.Name
+	w.Description = widget.Description
+	w.InventoryLevel = widget.InventoryLevel
+	w.Price = widget.Price
+	w.Image = widget.Image
+	w.UpdatedAt = time.Now()
+	s.widgets[w.ID] = w
+	return nil
+}
+
+// InsertOrder stores a new order and returns its id
+func (s *MemoryStore) InsertOrder(ctx context.Context, order Order) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	order.ID = s.nextID("orders")
+	order.CreatedAt, order.UpdatedAt = time.Now(), time.Now()
+	order.Status = ""
+	order.Widget, order.Transaction, order.Customer = Widget{}, Transaction{}, Customer{}
+	s.orders[order.ID] = order
+	return order.ID, nil
+}
+
+func (s *MemoryStore) GetOrder(ctx context.Context, id int) (Order, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	o, ok := s.orders[id]
+	if !ok {
+		return o, sql.ErrNoRows
+	}
+	return o, nil
+}
+
+func (s *MemoryStore) GetOrderByTransactionID(ctx context.Context, txnID int) (Order, error) {
+	return s.findOrder(func(o Order) bool { return o.TransactionID == txnID })
+}
+
+func (s *MemoryStore) GetOrderByReceiptToken(ctx context.Context, token string) (Order, error) {
+	return s.findOrder(func(o Order) bool { return token != "" && o.ReceiptToken == token })
+}
+
+func (s *MemoryStore) findOrder(match func(Order) bool) (Order, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, o := range s.orders {
+		if match(o) {
+			return o, nil
+		}
+	}
+	return Order{}, sql.ErrNoRows
+}
+
+// GetOrderDetail gets one order along with its widget, transaction and customer
+func (s *MemoryStore) GetOrderDetail(ctx context.Context, id int) (*Order, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	o, ok := s.orders[id]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	return s.detail(o), nil
+}
+
+// detail fills in what the database joins onto an order
+func (s *MemoryStore) detail(o Order) *Order {
+	o.Status = orderStatusLabels[o.StatusID]
+	o.Widget = s.widgets[o.WidgetID]
+	o.Transaction = s.transactions[o.TransactionID]
+	o.Customer = s.customers[o.CustomerID]
+	return &o
+}
+
+// GetAllOrdersPaginated returns one page of the orders matching filter,
+// newest first, along with the number of the last page and the total number
+// of matching orders
+func (s *MemoryStore) GetAllOrdersPaginated(ctx context.Context, filter OrderFilter, pageSize, page int) ([]*Order, int, int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if pageSize < 1 {
+		pageSize = 10
+	}
+	if page < 1 {
+		page = 1
+	}
+
+	var matched []Order
+	for _, o := range s.orders {
+		if filter.StatusID > 0 && o.StatusID != filter.StatusID ||
+			!filter.From.IsZero() && o.CreatedAt.Before(filter.From) ||
+			!filter.To.IsZero() && !o.CreatedAt.Before(filter.To) {
+			continue
+		}
+		matched = append(matched, o)
+	}
+	sort.Slice(matched, func(i, j int) bool {
+		if !matched[i].CreatedAt.Equal(matched[j].CreatedAt) {
+			return matched[i].CreatedAt.After(matched[j].CreatedAt)
+		}
+		return matched[i].ID > matched[j].ID
+	})
+
+	totalRecords := len(matched)
+	lastPage := totalRecords / pageSize
+	if totalRecords%pageSize != 0 {
+		lastPage++
+	}
+
+	var orders []*Order
+	for i := (page - 1) * pageSize; i < totalRecords && len(orders) < pageSize; i++ {
+		orders = append(orders, s.detail(matched[i]))
+	}
+	return orders, lastPage, totalRecords, nil
+}
+
+// ChangeOrderStatus moves an order to a new status and records who did it in
+// the order's history, with the same checks as the database
+func (s *MemoryStore) ChangeOrderStatus(ctx context.Context, orderID int, c OrderChange) error {
+	if !CanTransition(c.FromStatusID, c.ToStatusID) {
+		return ErrInvalidTransition
+	}
+	if c.ToStatusID == OrderStatusShipped && (c.TrackingNumber == "" || c.Carrier == "") {
+		return ErrTrackingRequired
+	}
+
+	return s.moveOrderStatus(orderID, c, needsPayment(c.ToStatusID))
+}
+
+// SettlePendingOrder moves an order that was waiting for its payment on to
+// paid, or cancels it when the payment is declined
+func (s *MemoryStore) SettlePendingOrder(ctx context.Context, orderID int, paid bool) error {
+	return s.moveOrderStatus(orderID, pendingChange(paid), false)
+}
+
+func (s *MemoryStore) moveOrderStatus(orderID int, c OrderChange, requirePaid bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	o, ok := s.orders[orderID]
+	if !ok || o.StatusID != c.FromStatusID {
+		return ErrOrderStatusConflict
+	}
+	if requirePaid && !paidFor(s.transactions[o.TransactionID].TransactionStatusID) {
+		return ErrOrderNotPaid
+	}
+
+	now := time.Now()
+	o.StatusID = c.ToStatusID
+	o.UpdatedAt = now
+	if c.TrackingNumber != "" {
+		o.TrackingNumber = c.TrackingNumber
+	}
+	if c.Carrier != "" {
+		o.Carrier = c.Carrier
+	}
+	s.orders[orderID] = o
+
+	s.events = append(s.events, OrderEvent{
+		ID:             s.nextID("order_events"),
+		OrderID:        orderID,
+		FromStatusID:   c.FromStatusID,
+		ToStatusID:     c.ToStatusID,
+		TrackingNumber: c.TrackingNumber,
+		Carrier:        c.Carrier,
+		Note:           c.Note,
+		UserID:         c.UserID,
+		CreatedAt:      now,
+	})
+	return nil
+}
+
+// CaptureOrder records that amount was captured for an order paid by an
+// authorized card, updating its transaction and totals together
+func (s *MemoryStore) CaptureOrder(ctx context.Context, order Order, amount int, bankReturnCode string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	if t, ok := s.transactions[order.TransactionID]; ok {
+		t.Amount = amount
+		t.TransactionStatusID = TransactionStatusCleared
+		t.BankReturnCode = bankReturnCode
+		t.UpdatedAt = now
+		s.transactions[order.TransactionID] = t
+	}
+	if o, ok := s.orders[order.ID]; ok {
+		o.Subtotal, o.Tax = capturedTotals(order, amount)
+		o.Amount = amount
+		o.UpdatedAt = now
+		s.orders[order.ID] = o
+	}
+	return nil
+}
+
+// RevertOrderStatus moves an order that ChangeOrderStatus moved with c back
+// to where it was
+func (s *MemoryStore) RevertOrderStatus(ctx context.Context, orderID int, c OrderChange) error {
+	return s.moveOrderStatus(orderID, OrderChange{
+		FromStatusID: c.ToStatusID,
+		ToStatusID:   c.FromStatusID,
+		Note:         c.Note,
+		UserID:       c.UserID,
+	}, false)
+}
+
+// GetOrderEvents returns the history of an order, oldest first
+func (s *MemoryStore) GetOrderEvents(ctx context.Context, orderID int) ([]*OrderEvent, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var events []*OrderEvent
+	for _, e := range s.events {
+		if e.OrderID != orderID {
+			continue
+		}
+		e.FromStatus = orderStatusLabels[e.FromStatusID]
+		e.ToStatus = orderStatusLabels[e.ToStatusID]
+		if u, ok := s.users[e.UserID]; ok {
+			e.UserName = u.FirstName + " " + u.LastName
+		}
+		events = append(events, &e)
+	}
+	return events, nil
+}
+
+// GetOrCreateInvoice returns the invoice for an order, issuing the next
+// invoice number the first time it is asked for
+func (s *MemoryStore) GetOrCreateInvoice(ctx context.Context, orderID int) (Invoice, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if inv, ok := s.invoices[orderID]; ok {
+		return inv, nil
+	}
+
+	now := time.Now()
+	id := s.nextID("invoices")
+	inv := Invoice{
+		ID:            id,
+		OrderID:       orderID,
+		InvoiceNumber: fmt.Sprintf("INV-%d-%06d", now.Year(), s.nextID(fmt.Sprintf("invoice_counters/%d", now.Year()))),
+		CreatedAt:     now,
+		UpdatedAt:     now,
+	}
+	s.invoices[orderID] = inv
+	return inv, nil
+}
+
+// InsertTransaction stores a new transaction and returns its id
+func (s *MemoryStore) InsertTransaction(ctx context.Context, txn Transaction) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if txn.Source == "" {
+		txn.Source = TransactionSourceCheckout
+	}
+	for _, t := range s.transactions {
+		if txn.PaymentIntent != "" && t.PaymentIntent == txn.PaymentIntent {
+			return 0, ErrDuplicatePaymentIntent
+		}
+	}
+	txn.ID = s.nextID("transactions")
+	txn.CreatedAt, txn.UpdatedAt = time.Now(), time.Now()
+	s.transactions[txn.ID] = txn
+	return txn.ID, nil
+}
+
+func (s *MemoryStore) GetTransaction(ctx context.Context, id int) (Transaction, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.transactions[id]
+	if !ok {
+		return t, sql.ErrNoRows
+	}
+	return t, nil
+}
+
+// GetTransactionByPaymentIntent gets the latest transaction recorded for a
+// payment intent
+func (s *MemoryStore) GetTransactionByPaymentIntent(ctx context.Context, paymentIntent string) (Transaction, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, t := range s.transactions {
+		if paymentIntent != "" && t.PaymentIntent == paymentIntent {
+			return t, nil
+		}
+	}
+	return Transaction{}, sql.ErrNoRows
+}
+
+// GetTransactionsCreatedBetween returns the transactions created in [from, to), oldest first
+func (s *MemoryStore) GetTransactionsCreatedBetween(ctx context.Context, from, to time.Time) ([]Transaction, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var txns []Transaction
+	for _, t := range s.transactions {
+		if !t.CreatedAt.Before(from) && t.CreatedAt.Before(to) {
+			txns = append(txns, t)
+		}
+	}
+	sort.Slice(txns, func(i, j int) bool {
+		if !txns[i].CreatedAt.Equal(txns[j].CreatedAt) {
+			return txns[i].CreatedAt.Before(txns[j].CreatedAt)
+		}
+		return txns[i].ID < txns[j].ID
+	})
+	return txns, nil
+}
+
+// UpdateTransactionStatus sets the status and bank return code of a transaction
+func (s *MemoryStore) UpdateTransactionStatus(ctx context.Context, id, statusID int, bankReturnCode string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.transactions[id]
+	if !ok {
+		return nil
+	}
+	t.TransactionStatusID = statusID
+	t.BankReturnCode = bankReturnCode
+	t.UpdatedAt = time.Now()
+	s.transactions[id] = t
+	return nil
+}
+
+// InsertCustomer stores a new customer and returns their id
+func (s *MemoryStore) InsertCustomer(ctx context.Context, c Customer) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c.ID = s.nextID("customers")
+	c.CreatedAt, c.UpdatedAt = time.Now(), time.Now()
+	s.customers[c.ID] = c
+	return c.ID, nil
+}
+
+func (s *MemoryStore) GetCustomer(ctx context.Context, id int) (Customer, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, ok := s.customers[id]
+	if !ok {
+		return c, sql.ErrNoRows
+	}
+	return c, nil
+}
+
+// GetCustomerByEmail gets the most recent customer with an email address
+func (s *MemoryStore) GetCustomerByEmail(ctx context.Context, email string) (Customer, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var found Customer
+	for _, c := range s.customers {
+		if strings.EqualFold(c.Email, email) && c.ID > found.ID {
+			found = c
+		}
+	}
+	if found.ID == 0 {
+		return found, sql.ErrNoRows
+	}
+	return found, nil
+}
+
+// GetUserByEmail gets a user by email address
+func (s *MemoryStore) GetUserByEmail(ctx context.Context, email string) (User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, u := range s.users {
+		if strings.EqualFold(u.Email, email) {
+			return u, nil
+		}
+	}
+	return User{}, sql.ErrNoRows
+}
+
+// Authenticate checks an email and password and returns the id of the user
+func (s *MemoryStore) Authenticate(ctx context.Context, email, password string) (int, error) {
+	u, err := s.GetUserByEmail(ctx, email)
+	return checkPassword(u, err, password)
+}
+
+// InsertToken stores the hash of a token for user
+func (s *MemoryStore) InsertToken(ctx context.Context, t *Token, u User) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stored := *t
+	stored.PlainText = ""
+	stored.UserID = int64(u.ID)
+	s.tokens = append(s.tokens, stored)
+	return nil
+}
+
+// GetUserForToken returns the user a token that hasn't expired belongs to
+func (s *MemoryStore) GetUserForToken(ctx context.Context, token string) (*User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	hash := sha256.Sum256([]byte(token))
+	for _, t := range s.tokens {
+		if string(t.Hash) != string(hash[:]) || !t.Expiry.After(time.Now()) {
+			continue
+		}
+		u, ok := s.users[int(t.UserID)]
+		if !ok {
+			break
+		}
+		return &User{ID: u.ID, FirstName: u.FirstName, LastName: u.LastName, Email: u.Email}, nil
+	}
+	return nil, sql.ErrNoRows
+}
+
+// GetCouponByCode gets a coupon by its code, ignoring case
+func (s *MemoryStore) GetCouponByCode(ctx context.Context, code string) (Coupon, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, c := range s.coupons {
+		if strings.EqualFold(c.Code, code) {
+			return c, nil
+		}
+	}
+	return Coupon{}, sql.ErrNoRows
+}
+
+// ReserveCoupon holds one use of a coupon for a payment intent until until,
+// failing with ErrCouponUnavailable if the coupon has expired or its uses
+// and unexpired holds have reached its limit
+func (s *MemoryStore) ReserveCoupon(ctx context.Context, code, paymentIntent string, until time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	for _, c := range s.coupons {
+		if !strings.EqualFold(c.Code, code) {
+			continue
+		}
+		if !c.ExpiresAt.IsZero() && !c.ExpiresAt.After(now) {
+			return ErrCouponUnavailable
+		}
+		held := 0
+		for _, r := range s.reservations {
+			if r.CouponID == c.ID && !r.Redeemed && r.ExpiresAt.After(now) {
+				held++
+			}
+		}
+		if c.MaxUses > 0 && c.TimesUsed+held >= c.MaxUses {
+			return ErrCouponUnavailable
+		}
+		s.reservations[paymentIntent] = CouponReservation{
+			ID:            s.nextID("coupon_reservations"),
+			CouponID:      c.ID,
+			PaymentIntent: paymentIntent,
+			ExpiresAt:     until,
+			CreatedAt:     now,
+			UpdatedAt:     now,
+		}
+		return nil
+	}
+	return ErrCouponUnavailable
+}
+
+// ConfirmCoupon turns the hold for a payment intent that has been paid into
+// a use of the coupon, once
+func (s *MemoryStore) ConfirmCoupon(ctx context.Context, paymentIntent string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r, ok := s.reservations[paymentIntent]
+	if !ok || r.Redeemed {
+		return nil
+	}
+	now := time.Now()
+	r.Redeemed = true
+	r.UpdatedAt = now
+	s.reservations[paymentIntent] = r
+	if c, ok := s.coupons[r.CouponID]; ok {
+		c.TimesUsed++
+		c.UpdatedAt = now
+		s.coupons[r.CouponID] = c
+	}
+	return nil
+}
+
+// ReleaseCoupon gives back the hold for a payment intent that will never be
+// paid, unless it has been confirmed
+func (s *MemoryStore) ReleaseCoupon(ctx context.Context, paymentIntent string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if r, ok := s.reservations[paymentIntent]; ok && !r.Redeemed {
+		delete(s.reservations, paymentIntent)
+	}
+	return nil
+}
+
+var (
+	_ WidgetRepo      = (*MemoryStore)(nil)
+	_ OrderRepo       = (*MemoryStore)(nil)
+	_ TransactionRepo = (*MemoryStore)(nil)
+	_ CustomerRepo    = (*MemoryStore)(nil)
+	_ UserRepo        = (*MemoryStore)(nil)
+	_ CouponRepo      = (*MemoryStore)(nil)
+	_ ReportRepo      = (*MemoryStore)(nil)
+	_ DisputeRepo     = (*MemoryStore)(nil)
+	_ PayoutRepo      = (*MemoryStore)(nil)
+	_ ImportRepo      = (*MemoryStore)(nil)
+	_ AuditRepo       = (*MemoryStore)(nil)
+)
@@ -0,0 +1,774 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// transactionStatusLabels are the names seeded into the transaction_statuses
+// table, which the database joins onto exported transactions
+var transactionStatusLabels = map[int]string{
+	TransactionStatusPending:           "Pending",
+	TransactionStatusCleared:           "Cleared",
+	TransactionStatusDeclined:          "Declined",
+	TransactionStatusRefunded:          "Refunded",
+	TransactionStatusPartiallyRefunded: "Partially refunded",
+	TransactionStatusAuthorized:        "Authorized",
+	TransactionStatusVoided:            "Voided",
+}
+
+// lastPage is the number of the last page of total records
+func lastPage(total, pageSize int) int {
+	last := total / pageSize
+	if total%pageSize != 0 {
+		last++
+	}
+	return last
+}
+
+// sale is an order the reports count, with what it took in its currency
+type sale struct {
+	order    Order
+	currency string
+	refunded bool
+}
+
+// sales returns the orders placed in [from, to) that the reports count, with
+// the same rules as salesWhere, oldest first
+func (s *MemoryStore) sales(from, to time.Time) []sale {
+	var sales []sale
+	for _, o := range s.orders {
+		t := s.transactions[o.TransactionID]
+		switch t.TransactionStatusID {
+		case TransactionStatusCleared, TransactionStatusRefunded, TransactionStatusPartiallyRefunded:
+		default:
+			continue
+		}
+		if o.StatusID == OrderStatusCancelled || o.CreatedAt.Before(from) || !o.CreatedAt.Before(to) {
+			continue
+		}
+		sales = append(sales, sale{
+			order:    o,
+			currency: t.Currency,
+			refunded: o.StatusID == OrderStatusRefunded || t.TransactionStatusID == TransactionStatusPartiallyRefunded,
+		})
+	}
+	sort.Slice(sales, func(i, j int) bool { return sales[i].order.ID < sales[j].order.ID })
+	return sales
+}
+
+// refunds is what a sale gave back
+func (sl sale) refunds() int {
+	if sl.refunded {
+		return sl.order.Amount
+	}
+	return 0
+}
+
+// GetSalesSummary returns the headline figures for orders placed in [from,
+// to), one summary per currency
+func (s *MemoryStore) GetSalesSummary(ctx context.Context, from, to time.Time) ([]SalesSummary, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	index := make(map[string]int)
+	var summaries []SalesSummary
+	for _, sl := range s.sales(from, to) {
+		i, ok := index[sl.currency]
+		if !ok {
+			i = len(summaries)
+			index[sl.currency] = i
+			summaries = append(summaries, SalesSummary{Currency: sl.currency})
+		}
+		summaries[i].Orders++
+		summaries[i].Gross += sl.order.Amount
+		summaries[i].Refunds += sl.refunds()
+		if sl.refunded {
+			summaries[i].RefundedOrders++
+		}
+	}
+	sort.Slice(summaries, func(i, j int) bool { return summaries[i].Currency < summaries[j].Currency })
+	for i := range summaries {
+		sm := &summaries[i]
+		sm.Net = sm.Gross - sm.Refunds
+		sm.AverageOrderValue = sm.Gross / sm.Orders
+		sm.RefundRate = float64(sm.RefundedOrders) / float64(sm.Orders)
+	}
+	return summaries, nil
+}
+
+// periodLabel labels the day, week or month t falls in the way periodLabels
+// does
+func periodLabel(t time.Time, period string) (string, error) {
+	switch period {
+	case PeriodDay:
+		return t.Format("2006-01-02"), nil
+	case PeriodWeek:
+		year, week := t.ISOWeek()
+		return fmt.Sprintf("%d-W%02d", year, week), nil
+	case PeriodMonth:
+		return t.Format("2006-01"), nil
+	}
+	return "", ErrUnknownPeriod
+}
+
+// GetRevenueByPeriod returns sales for orders placed in [from, to) grouped by
+// day, week or month and currency, oldest first
+func (s *MemoryStore) GetRevenueByPeriod(ctx context.Context, from, to time.Time, period string) ([]RevenuePoint, error) {
+	if _, err := periodLabel(from, period); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	index := make(map[[2]string]int)
+	var points []RevenuePoint
+	for _, sl := range s.sales(from, to) {
+		label, _ := periodLabel(sl.order.CreatedAt, period)
+		key := [2]string{label, sl.currency}
+		i, ok := index[key]
+		if !ok {
+			i = len(points)
+			index[key] = i
+			points = append(points, RevenuePoint{Period: label, Currency: sl.currency})
+		}
+		points[i].Orders++
+		points[i].Gross += sl.order.Amount
+		points[i].Refunds += sl.refunds()
+	}
+	sort.Slice(points, func(i, j int) bool {
+		if points[i].Period != points[j].Period {
+			return points[i].Period < points[j].Period
+		}
+		return points[i].Currency < points[j].Currency
+	})
+	for i := range points {
+		points[i].Net = points[i].Gross - points[i].Refunds
+	}
+	return points, nil
+}
+
+// GetSalesByWidget returns sales for orders placed in [from, to) by widget
+// and currency, best selling first in each currency
+func (s *MemoryStore) GetSalesByWidget(ctx context.Context, from, to time.Time) ([]WidgetSales, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	type key struct {
+		widgetID int
+		currency string
+	}
+	index := make(map[key]int)
+	var sales []WidgetSales
+	for _, sl := range s.sales(from, to) {
+		k := key{sl.order.WidgetID, sl.currency}
+		i, ok := index[k]
+		if !ok {
+			i = len(sales)
+			index[k] = i
+			sales = append(sales, WidgetSales{WidgetID: sl.order.WidgetID, Name: s.widgets[sl.order.WidgetID].Name, Currency: sl.currency})
+		}
+		sales[i].Orders++
+		sales[i].Quantity += sl.order.Quantity
+		sales[i].Gross += sl.order.Amount
+		sales[i].Refunds += sl.refunds()
+	}
+	sort.Slice(sales, func(i, j int) bool {
+		a, b := sales[i], sales[j]
+		if a.Currency != b.Currency {
+			return a.Currency < b.Currency
+		}
+		if a.Gross != b.Gross {
+			return a.Gross > b.Gross
+		}
+		return a.Name < b.Name
+	})
+	return sales, nil
+}
+
+// GetTopCustomers returns the customers who spent the most, after refunds, on
+// orders placed in [from, to), up to limit of them in each currency
+func (s *MemoryStore) GetTopCustomers(ctx context.Context, from, to time.Time, limit int) ([]CustomerSales, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if limit < 1 {
+		limit = 10
+	}
+
+	type key struct {
+		customerID int
+		currency   string
+	}
+	index := make(map[key]int)
+	var all []CustomerSales
+	for _, sl := range s.sales(from, to) {
+		k := key{sl.order.CustomerID, sl.currency}
+		i, ok := index[k]
+		if !ok {
+			i = len(all)
+			index[k] = i
+			c := s.customers[sl.order.CustomerID]
+			all = append(all, CustomerSales{
+				Customer: Customer{ID: c.ID, FirstName: c.FirstName, LastName: c.LastName, Email: c.Email},
+				Currency: sl.currency,
+			})
+		}
+		all[i].Orders++
+		all[i].Net += sl.order.Amount - sl.refunds()
+	}
+	sort.Slice(all, func(i, j int) bool {
+		a, b := all[i], all[j]
+		if a.Currency != b.Currency {
+			return a.Currency < b.Currency
+		}
+		if a.Net != b.Net {
+			return a.Net > b.Net
+		}
+		return a.Customer.ID < b.Customer.ID
+	})
+
+	var customers []CustomerSales
+	perCurrency := make(map[string]int)
+	for _, c := range all {
+		if perCurrency[c.Currency] == limit {
+			continue
+		}
+		perCurrency[c.Currency]++
+		customers = append(customers, c)
+	}
+	return customers, nil
+}
+
+// transactionFor is the id of the latest transaction for a payment intent or
+// charge, or 0 if there is none
+func (s *MemoryStore) transactionFor(paymentIntent, chargeID string) int {
+	id := 0
+	for _, t := range s.transactions {
+		if (paymentIntent != "" && t.PaymentIntent == paymentIntent || chargeID != "" && t.BankReturnCode == chargeID) && t.ID > id {
+			id = t.ID
+		}
+	}
+	return id
+}
+
+// SaveDispute records a dispute as stripe last reported it, linking it to
+// the transaction for its payment intent or charge. Evidence we have written
+// is kept
+func (s *MemoryStore) SaveDispute(ctx context.Context, d Dispute) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for id, existing := range s.disputes {
+		if existing.StripeDisputeID != d.StripeDisputeID {
+			continue
+		}
+		existing.Amount = d.Amount
+		existing.Reason = d.Reason
+		existing.Status = d.Status
+		existing.EvidenceDueBy = d.EvidenceDueBy
+		existing.HasEvidence = d.HasEvidence
+		existing.SubmissionCount = d.SubmissionCount
+		existing.UpdatedAt = now
+		if existing.TransactionID == 0 {
+			existing.TransactionID = s.transactionFor(d.PaymentIntent, d.ChargeID)
+		}
+		s.disputes[id] = existing
+		return nil
+	}
+
+	d.ID = s.nextID("disputes")
+	d.TransactionID = s.transactionFor(d.PaymentIntent, d.ChargeID)
+	d.Evidence = DisputeEvidence{}
+	d.EvidenceSubmittedAt = time.Time{}
+	d.Files = nil
+	d.CreatedAt, d.UpdatedAt = now, now
+	s.disputes[d.ID] = d
+	return nil
+}
+
+// GetDispute gets a dispute along with its evidence files
+func (s *MemoryStore) GetDispute(ctx context.Context, id int) (*Dispute, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d, ok := s.disputes[id]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	for _, f := range s.disputeFiles {
+		if f.DisputeID == id {
+			f := f
+			d.Files = append(d.Files, &f)
+		}
+	}
+	return &d, nil
+}
+
+// GetDisputesPaginated returns one page of disputes, the ones waiting on us
+// first and soonest due first, followed by the rest newest first. An empty
+// status returns disputes in any status
+func (s *MemoryStore) GetDisputesPaginated(ctx context.Context, status string, pageSize, page int) ([]*Dispute, int, int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if pageSize < 1 {
+		pageSize = 10
+	}
+	if page < 1 {
+		page = 1
+	}
+
+	var matched []Dispute
+	for _, d := range s.disputes {
+		if status == "" || d.Status == status {
+			matched = append(matched, d)
+		}
+	}
+	sort.Slice(matched, func(i, j int) bool {
+		a, b := matched[i], matched[j]
+		if a.NeedsResponse() != b.NeedsResponse() {
+			return a.NeedsResponse()
+		}
+		if a.NeedsResponse() && !a.EvidenceDueBy.Equal(b.EvidenceDueBy) {
+			return a.EvidenceDueBy.Before(b.EvidenceDueBy)
+		}
+		if !a.CreatedAt.Equal(b.CreatedAt) {
+			return a.CreatedAt.After(b.CreatedAt)
+		}
+		return a.ID > b.ID
+	})
+
+	totalRecords := len(matched)
+	var disputes []*Dispute
+	for i := (page - 1) * pageSize; i < totalRecords && len(disputes) < pageSize; i++ {
+		d := matched[i]
+		disputes = append(disputes, &d)
+	}
+	return disputes, lastPage(totalRecords, pageSize), totalRecords, nil
+}
+
+// SaveDisputeEvidence stores the written evidence for a dispute. submitted
+// marks it as sent to the bank
+func (s *MemoryStore) SaveDisputeEvidence(ctx context.Context, id int, evidence DisputeEvidence, submitted bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d, ok := s.disputes[id]
+	if !ok {
+		return nil
+	}
+	now := time.Now()
+	d.Evidence = evidence
+	if submitted {
+		d.EvidenceSubmittedAt = now
+	}
+	d.UpdatedAt = now
+	s.disputes[id] = d
+	return nil
+}
+
+// InsertDisputeFile records a file uploaded to stripe as dispute evidence
+func (s *MemoryStore) InsertDisputeFile(ctx context.Context, f DisputeFile) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.disputes[f.DisputeID]; !ok {
+		return 0, fmt.Errorf("dispute %d does not exist", f.DisputeID)
+	}
+	f.ID = s.nextID("dispute_files")
+	f.CreatedAt = time.Now()
+	s.disputeFiles = append(s.disputeFiles, f)
+	return f.ID, nil
+}
+
+// SavePayout records a payout as stripe last reported it, along with the
+// balance transactions in it, and stamps the fee, net amount and payout on
+// the transactions they paid out. It returns the id of the payout
+func (s *MemoryStore) SavePayout(ctx context.Context, p Payout, lines []PayoutLine) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	p.ID = 0
+	for id, existing := range s.payouts {
+		if existing.StripePayoutID == p.StripePayoutID {
+			p.ID, p.CreatedAt = id, existing.CreatedAt
+		}
+	}
+	if p.ID == 0 {
+		p.ID = s.nextID("payouts")
+		p.CreatedAt = now
+	}
+	p.UpdatedAt = now
+	s.payouts[p.ID] = p
+
+	kept := s.payoutLines[:0]
+	for _, l := range s.payoutLines {
+		if l.PayoutID != p.ID {
+			kept = append(kept, l)
+		}
+	}
+	s.payoutLines = kept
+
+	for _, l := range lines {
+		l.ID = s.nextID("payout_lines")
+		l.PayoutID = p.ID
+		l.TransactionID = s.transactionFor(l.PaymentIntent, l.SourceID)
+		l.OrderID = 0
+		s.payoutLines = append(s.payoutLines, l)
+
+		if t, ok := s.transactions[l.TransactionID]; ok && l.Category == PayoutLineCharge {
+			t.Fee, t.Net, t.PayoutID = l.Fee, l.Net, p.ID
+			s.transactions[t.ID] = t
+		}
+	}
+	return p.ID, nil
+}
+
+// GetPayoutsPaginated returns one page of payouts, latest arrival first,
+// along with the number of the last page and the total number of payouts
+func (s *MemoryStore) GetPayoutsPaginated(ctx context.Context, pageSize, page int) ([]*Payout, int, int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if pageSize < 1 {
+		pageSize = 10
+	}
+	if page < 1 {
+		page = 1
+	}
+
+	var all []Payout
+	for _, p := range s.payouts {
+		all = append(all, p)
+	}
+	sort.Slice(all, func(i, j int) bool {
+		if !all[i].ArrivalDate.Equal(all[j].ArrivalDate) {
+			return all[i].ArrivalDate.After(all[j].ArrivalDate)
+		}
+		return all[i].ID > all[j].ID
+	})
+
+	totalRecords := len(all)
+	var payouts []*Payout
+	for i := (page - 1) * pageSize; i < totalRecords && len(payouts) < pageSize; i++ {
+		p := all[i]
+		payouts = append(payouts, &p)
+	}
+	return payouts, lastPage(totalRecords, pageSize), totalRecords, nil
+}
+
+// GetPayout gets a payout with its lines, each with the order it paid for
+// when there is one, and the lines totalled by category
+func (s *MemoryStore) GetPayout(ctx context.Context, id int) (*Payout, []*PayoutLine, []PayoutTotal, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, ok := s.payouts[id]
+	if !ok {
+		return nil, nil, nil, sql.ErrNoRows
+	}
+
+	var lines []*PayoutLine
+	for _, l := range s.payoutLines {
+		if l.PayoutID != id {
+			continue
+		}
+		l := l
+		for _, o := range s.orders {
+			if l.TransactionID != 0 && o.TransactionID == l.TransactionID {
+				l.OrderID = o.ID
+			}
+		}
+		lines = append(lines, &l)
+	}
+	sort.Slice(lines, func(i, j int) bool {
+		if !lines[i].CreatedAt.Equal(lines[j].CreatedAt) {
+			return lines[i].CreatedAt.Before(lines[j].CreatedAt)
+		}
+		return lines[i].ID < lines[j].ID
+	})
+
+	var totals []PayoutTotal
+	index := make(map[string]int)
+	for _, l := range lines {
+		i, ok := index[l.Category]
+		if !ok {
+			i = len(totals)
+			index[l.Category] = i
+			totals = append(totals, PayoutTotal{Category: l.Category})
+		}
+		totals[i].Count++
+		totals[i].Amount += l.Amount
+		totals[i].Fee += l.Fee
+		totals[i].Net += l.Net
+	}
+	return &p, lines, totals, nil
+}
+
+// ImportWidgets creates or updates a widget for each row, matching them the
+// way the database does. Rows are applied to a copy of the widgets, which
+// only replaces them when every row is valid and dryRun is false, and applied
+// reports whether it did
+func (s *MemoryStore) ImportWidgets(ctx context.Context, rows []WidgetImport, dryRun bool) (results []WidgetImportResult, applied bool, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	widgets := make(map[int]Widget, len(s.widgets))
+	for id, w := range s.widgets {
+		widgets[id] = w
+	}
+	lastID := s.ids["widgets"]
+
+	failed := false
+	for _, row := range rows {
+		res := importWidget(widgets, &lastID, row)
+		if res.Action == ImportError {
+			failed = true
+		}
+		results = append(results, res)
+	}
+
+	if failed || dryRun {
+		return results, false, nil
+	}
+	s.widgets = widgets
+	s.ids["widgets"] = lastID
+	return results, true, nil
+}
+
+// importWidget applies one row to widgets, numbering a new widget after
+// lastID
+func importWidget(widgets map[int]Widget, lastID *int, row WidgetImport) WidgetImportResult {
+	res := WidgetImportResult{
+		Line:   row.Line,
+		SKU:    row.SKU,
+		Name:   row.Name,
+		Errors: row.Errors,
+	}
+	fail := func(msg string) WidgetImportResult {
+		res.Action = ImportError
+		res.Errors = append(res.Errors, msg)
+		return res
+	}
+	if len(res.Errors) > 0 {
+		res.Action = ImportError
+		return res
+	}
+
+	before, err := findWidget(widgets, row)
+	var conflict importConflict
+	if errors.As(err, &conflict) {
+		return fail(conflict.Error())
+	}
+
+	var after Widget
+	if err == nil {
+		after = before
+		res.WidgetID = before.ID
+		res.Before = &before
+	} else if row.Name == "" || row.Price == nil {
+		return fail("a new widget needs a name and a price")
+	}
+
+	after = importedWidget(after, row)
+	now := time.Now()
+	switch {
+	case res.Before == nil:
+		res.Action = ImportCreate
+		*lastID++
+		after.ID = *lastID
+		after.CreatedAt, after.UpdatedAt = now, now
+		res.WidgetID = after.ID
+	case widgetUnchanged(before, after):
+		res.Action = ImportUnchanged
+	default:
+		res.Action = ImportUpdate
+		after.UpdatedAt = now
+	}
+	widgets[after.ID] = after
+	res.After = &after
+	return res
+}
+
+// findWidget finds the widget an import row refers to, by SKU or else by name
+func findWidget(widgets map[int]Widget, row WidgetImport) (Widget, error) {
+	if row.SKU != "" {
+		for _, w := range widgets {
+			if w.SKU == row.SKU {
+				return w, nil
+			}
+		}
+	}
+	if row.Name == "" {
+		return Widget{}, errNoImportMatch
+	}
+
+	var matches []Widget
+	for _, w := range widgets {
+		if w.Name == row.Name {
+			matches = append(matches, w)
+		}
+	}
+	sort.Slice(matches, func(i, j int) bool { return matches[i].ID < matches[j].ID })
+	return pickNameMatch(row, matches)
+}
+
+// EachOrder calls fn with every order matching filter, oldest first. It
+// stops at the first error from fn
+func (s *MemoryStore) EachOrder(ctx context.Context, filter OrderFilter, fn func(*Order) error) error {
+	s.mu.Lock()
+	var orders []*Order
+	for _, o := range s.orders {
+		if filter.StatusID > 0 && o.StatusID != filter.StatusID ||
+			!filter.From.IsZero() && o.CreatedAt.Before(filter.From) ||
+			!filter.To.IsZero() && !o.CreatedAt.Before(filter.To) {
+			continue
+		}
+		orders = append(orders, s.detail(o))
+	}
+	s.mu.Unlock()
+
+	sort.Slice(orders, func(i, j int) bool {
+		if !orders[i].CreatedAt.Equal(orders[j].CreatedAt) {
+			return orders[i].CreatedAt.Before(orders[j].CreatedAt)
+		}
+		return orders[i].ID < orders[j].ID
+	})
+	for _, o := range orders {
+		if err := fn(o); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// EachTransaction calls fn with every transaction matching filter, oldest
+// first. It stops at the first error from fn
+func (s *MemoryStore) EachTransaction(ctx context.Context, filter TransactionFilter, fn func(*TransactionDetail) error) error {
+	s.mu.Lock()
+	var details []*TransactionDetail
+	for _, t := range s.transactions {
+		if filter.StatusID > 0 && t.TransactionStatusID != filter.StatusID ||
+			filter.Source != "" && t.Source != filter.Source ||
+			!filter.From.IsZero() && t.CreatedAt.Before(filter.From) ||
+			!filter.To.IsZero() && !t.CreatedAt.Before(filter.To) {
+			continue
+		}
+		d := &TransactionDetail{Transaction: t, Status: transactionStatusLabels[t.TransactionStatusID]}
+		customerID := t.CustomerID
+		for _, o := range s.orders {
+			if o.TransactionID == t.ID {
+				d.OrderID, d.Quantity = o.ID, o.Quantity
+				w := s.widgets[o.WidgetID]
+				d.Widget = Widget{ID: w.ID, Name: w.Name}
+				if customerID == 0 {
+					customerID = o.CustomerID
+				}
+			}
+		}
+		c := s.customers[customerID]
+		d.Customer = Customer{ID: c.ID, FirstName: c.FirstName, LastName: c.LastName, Email: c.Email}
+		details = append(details, d)
+	}
+	s.mu.Unlock()
+
+	sort.Slice(details, func(i, j int) bool {
+		if !details[i].CreatedAt.Equal(details[j].CreatedAt) {
+			return details[i].CreatedAt.Before(details[j].CreatedAt)
+		}
+		return details[i].ID < details[j].ID
+	})
+	for _, d := range details {
+		if err := fn(d); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetTerminalChargesPaginated returns one page of virtual terminal charges,
+// newest first, along with the number of the last page and the total number
+// of terminal charges
+func (s *MemoryStore) GetTerminalChargesPaginated(ctx context.Context, pageSize, page int) ([]*TerminalCharge, int, int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if pageSize < 1 {
+		pageSize = 10
+	}
+	if page < 1 {
+		page = 1
+	}
+
+	var matched []Transaction
+	for _, t := range s.transactions {
+		if t.Source == TransactionSourceTerminal {
+			matched = append(matched, t)
+		}
+	}
+	sort.Slice(matched, func(i, j int) bool {
+		if !matched[i].CreatedAt.Equal(matched[j].CreatedAt) {
+			return matched[i].CreatedAt.After(matched[j].CreatedAt)
+		}
+		return matched[i].ID > matched[j].ID
+	})
+
+	totalRecords := len(matched)
+	var charges []*TerminalCharge
+	for i := (page - 1) * pageSize; i < totalRecords && len(charges) < pageSize; i++ {
+		t := matched[i]
+		c := s.customers[t.CustomerID]
+		charge := &TerminalCharge{
+			Transaction: t,
+			Status:      transactionStatusLabels[t.TransactionStatusID],
+			Customer:    Customer{ID: c.ID, FirstName: c.FirstName, LastName: c.LastName, Email: c.Email},
+			ChargedAt:   t.CreatedAt,
+		}
+		if u, ok := s.users[t.UserID]; ok {
+			charge.OperatorName = u.FirstName + " " + u.LastName
+			charge.OperatorEmail = u.Email
+		}
+		charges = append(charges, charge)
+	}
+	return charges, lastPage(totalRecords, pageSize), totalRecords, nil
+}
+
+// GetAuditEntries returns one page of the audit log, newest first, along
+// with the number of the last page and the total number of matching entries
+func (s *MemoryStore) GetAuditEntries(ctx context.Context, f AuditFilter, pageSize, page int) ([]*AuditEntry, int, int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if pageSize < 1 {
+		pageSize = 20
+	}
+	if page < 1 {
+		page = 1
+	}
+
+	var matched []AuditEntry
+	for i := len(s.audit) - 1; i >= 0; i-- {
+		e := s.audit[i]
+		if f.Action != "" && !strings.HasPrefix(e.Action, f.Action) ||
+			f.ActorEmail != "" && e.ActorEmail != f.ActorEmail ||
+			f.EntityType != "" && e.EntityType != f.EntityType ||
+			f.EntityID > 0 && e.EntityID != f.EntityID ||
+			!f.From.IsZero() && e.CreatedAt.Before(f.From) ||
+			!f.To.IsZero() && !e.CreatedAt.Before(f.To) {
+			continue
+		}
+		matched = append(matched, e)
+	}
+
+	totalRecords := len(matched)
+	var entries []*AuditEntry
+	for i := (page - 1) * pageSize; i < totalRecords && len(entries) < pageSize; i++ {
+		e := matched[i]
+		entries = append(entries, &e)
+	}
+	return entries, lastPage(totalRecords, pageSize), totalRecords, nil
+}
@@ -3,31 +3,54 @@ package models
 import (
 	"context"
 	"database/sql"
+	"errors"
+	"fmt"
+	"go-stripe/internal/driver"
+	"log/slog"
+	"strings"
 	"time"
 )
 
-// DBModel is the type for database connection
+// DBModel is the type for database connection. Dialect is the sql the
+// database speaks, mysql when it is left empty. Statements are logged to
+// Logger, or the default logger when it is nil
 type DBModel struct {
-	DB *sql.DB
+	DB       *sql.DB
+	Dialect  driver.Dialect
+	Timeouts Timeouts
+	Logger   *slog.Logger
 }
 
-// Models is the wrapper for all model
-type Models struct {
-	DB DBModel
+// Timeouts is how long each kind of database work may run. The caller's
+// context still applies, so a request that goes away cancels its queries
+// sooner. A zero field takes the default
+type Timeouts struct {
+	// Query is for single reads and writes, 3 seconds by default
+	Query time.Duration
+	// Report is for reports and other reads over many rows, 10 seconds by default
+	Report time.Duration
+	// Import is for bulk imports, 30 seconds by default
+	Import time.Duration
+	// Export is for streaming exports, 5 minutes by default
+	Export time.Duration
 }
 
-// NewModels return a model type with database connection pool
-func NewModels(db *sql.DB) Models {
-	return Models{
-		DB: DBModel{
-			DB: db,
-		},
+func (t Timeouts) forQuery() time.Duration  { return orDefault(t.Query, 3*time.Second) }
+func (t Timeouts) forReport() time.Duration { return orDefault(t.Report, 10*time.Second) }
+func (t Timeouts) forImport() time.Duration { return orDefault(t.Import, 30*time.Second) }
+func (t Timeouts) forExport() time.Duration { return orDefault(t.Export, 5*time.Minute) }
+
+func orDefault(d, def time.Duration) time.Duration {
+	if d <= 0 {
+		return def
 	}
+	return d
 }
 
 // Widget is the type for all widgets
 type Widget struct {
 	ID             int       `json:"id"`
+	SKU            string    `json:"sku"`
 	Name           string    `json:"name"`
 	Description    string    `json:"description"`
 	InventoryLevel int       `json:"inventory_level"`
@@ -39,15 +62,26 @@ type Widget struct {
 
 // Order is the type for all orders
 type Order struct {
-	ID            int       `json:"id"`
-	WidgetID      int       `json:"widget_id"`
-	TransactionID int       `json:"transaction_id"`
-	CustomerID    int       `json:"customer_id"`
-	StatusID      int       `json:"status_id"`
-	Quantity      int       `json:"quantity"`
-	Amount        int       `json:"amount"`
-	CreatedAt     time.Time `json:"-"`
-	UpdatedAt     time.Time `json:"-"`
+	ID             int         `json:"id"`
+	WidgetID       int         `json:"widget_id"`
+	TransactionID  int         `json:"transaction_id"`
+	CustomerID     int         `json:"customer_id"`
+	StatusID       int         `json:"status_id"`
+	Quantity       int         `json:"quantity"`
+	Amount         int         `json:"amount"`
+	Subtotal       int         `json:"subtotal"`
+	Discount       int         `json:"discount"`
+	Tax            int         `json:"tax"`
+	CouponCode     string      `json:"coupon_code"`
+	ReceiptToken   string      `json:"-"`
+	TrackingNumber string      `json:"tracking_number"`
+	Carrier        string      `json:"carrier"`
+	CreatedAt      time.Time   `json:"created_at"`
+	UpdatedAt      time.Time   `json:"updated_at"`
+	Status         string      `json:"status,omitempty"`
+	Widget         Widget      `json:"widget"`
+	Transaction    Transaction `json:"transaction"`
+	Customer       Customer    `json:"customer"`
 }
 
 // Status is the type for statusses
@@ -66,7 +100,25 @@ type TransactionStatus struct {
 	UpdatedAt time.Time `json:"-"`
 }
 
-// Transaction is the type for Transaction
+// transaction statuses, as seeded into the transaction_statuses table
+const (
+	TransactionStatusPending           = 1
+	TransactionStatusCleared           = 2
+	TransactionStatusDeclined          = 3
+	TransactionStatusRefunded          = 4
+	TransactionStatusPartiallyRefunded = 5
+	TransactionStatusAuthorized        = 6
+	TransactionStatusVoided            = 7
+)
+
+// where a transaction was taken
+const (
+	TransactionSourceCheckout = "checkout"
+	TransactionSourceTerminal = "terminal"
+)
+
+// Transaction is the type for Transaction. Terminal charges have the
+// operator who ran them in UserID
 type Transaction struct {
 	ID                  int       `json:"id"`
 	Amount              int       `json:"amount"`
@@ -78,6 +130,13 @@ type Transaction struct {
 	PaymentMethod       string    `json:"payment_method"`
 	BankReturnCode      string    `json:"bank_return_code"`
 	TransactionStatusID int       `json:"transaction_status_id"`
+	Source              string    `json:"source"`
+	CustomerID          int       `json:"customer_id"`
+	UserID              int       `json:"user_id"`
+	Memo                string    `json:"memo"`
+	Fee                 int       `json:"fee"`
+	Net                 int       `json:"net"`
+	PayoutID            int       `json:"payout_id"`
 	CreatedAt           time.Time `json:"-"`
 	UpdatedAt           time.Time `json:"-"`
 }
@@ -102,13 +161,22 @@ type Customer struct {
 	UpdatedAt time.Time `json:"-"`
 }
 
-func (m *DBModel) GetWidget(id int) (Widget, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+func (m *DBModel) GetWidget(ctx context.Context, id int) (Widget, error) {
+	ctx, span := tracer.Start(ctx, "DBModel.GetWidget")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, m.Timeouts.forQuery())
 	defer cancel()
+	row := m.conn().QueryRowContext(ctx, m.rebind("select "+widgetColumns+" from widgets where id=?"), id)
+	return scanWidget(row)
+}
+
+const widgetColumns = "id,coalesce(sku,''),name,coalesce(description,''),inventory_level,price,coalesce(image,''),created_at,updated_at"
+
+func scanWidget(row interface{ Scan(...any) error }) (Widget, error) {
 	var widget Widget
-	row := m.DB.QueryRowContext(ctx, "select id,name,description,inventory_level,price,coalesce(image,''),created_at,updated_at from widgets where id=?", id)
 	err := row.Scan(
 		&widget.ID,
+		&widget.SKU,
 		&widget.Name,
 		&widget.Description,
 		&widget.InventoryLevel,
@@ -123,52 +191,507 @@ func (m *DBModel) GetWidget(id int) (Widget, error) {
 	return widget, nil
 }
 
-// InsertTransaction insert a new txn and return the id of the txn
-func (m *DBModel) InsertTransaction(txn Transaction) (int, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+// UpdateWidget saves the editable details of a widget
+func (m *DBModel) UpdateWidget(ctx context.Context, widget Widget) error {
+	ctx, span := tracer.Start(ctx, "DBModel.UpdateWidget")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, m.Timeouts.forQuery())
 	defer cancel()
-	stmt := "insert into transactions (amount,currency,last_four,bank_return_code,expiry_month,expiry_year,payment_intent,payment_method,transaction_status_id,created_at,updated_at) values(?,?,?,?,?,?,?,?,?,?,?)"
-	result, err := m.DB.ExecContext(ctx, stmt, txn.Amount, txn.Currency, txn.LastFour, txn.BankReturnCode, txn.ExpiryMonth, txn.ExpiryYear, txn.PaymentIntent, txn.PaymentMethod, txn.TransactionStatusID, time.Now(), time.Now())
+	stmt := `update widgets set sku=nullif(?, ''), name=?, description=?, inventory_level=?, price=?, image=?, updated_at=?
+		where id=?`
+	result, err := m.conn().ExecContext(ctx, m.rebind(stmt), widget.SKU, widget.Name, widget.Description, widget.InventoryLevel, widget.Price, widget.Image, time.Now(), widget.ID)
 	if err != nil {
-		return 0, err
+		return err
 	}
-	id, err := result.LastInsertId()
+	n, err := result.RowsAffected()
 	if err != nil {
-		return 0, err
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// InsertTransaction insert a new txn and return the id of the txn. A payment
+// intent that has already been recorded fails the unique index
+func (m *DBModel) InsertTransaction(ctx context.Context, txn Transaction) (int, error) {
+	ctx, span := tracer.Start(ctx, "DBModel.InsertTransaction")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, m.Timeouts.forQuery())
+	defer cancel()
+	if txn.Source == "" {
+		txn.Source = TransactionSourceCheckout
 	}
-	return int(id), nil // return the id of the transaction
+	stmt := `insert into transactions (amount,currency,last_four,bank_return_code,expiry_month,expiry_year,payment_intent,payment_method,transaction_status_id,
+		source,customer_id,user_id,memo,created_at,updated_at) values(?,?,?,?,?,?,?,?,?,?,nullif(?,0),nullif(?,0),?,?,?)`
+	return m.insertID(ctx, m.conn(), stmt, txn.Amount, txn.Currency, txn.LastFour, txn.BankReturnCode, txn.ExpiryMonth, txn.ExpiryYear, txn.PaymentIntent, txn.PaymentMethod, txn.TransactionStatusID,
+		txn.Source, txn.CustomerID, txn.UserID, txn.Memo, time.Now(), time.Now())
 }
 
 // InsertOrder insert a new order and return the id of the order
-func (m *DBModel) InsertOrder(order Order) (int, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+func (m *DBModel) InsertOrder(ctx context.Context, order Order) (int, error) {
+	ctx, span := tracer.Start(ctx, "DBModel.InsertOrder")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, m.Timeouts.forQuery())
+	defer cancel()
+	stmt := "insert into orders (widget_id,transaction_id,status_id,quantity,customer_id,amount,subtotal,discount,tax,coupon_code,receipt_token,created_at,updated_at) values(?,?,?,?,?,?,?,?,?,?,nullif(?,''),?,?)"
+	return m.insertID(ctx, m.conn(), stmt, order.WidgetID, order.TransactionID, order.StatusID, order.Quantity, order.CustomerID, order.Amount, order.Subtotal, order.Discount, order.Tax, order.CouponCode, order.ReceiptToken, time.Now(), time.Now())
+}
+
+// InsertCustomer insert a new customer and return the id of the customer
+func (m *DBModel) InsertCustomer(ctx context.Context, c Customer) (int, error) {
+	ctx, span := tracer.Start(ctx, "DBModel.InsertCustomer")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, m.Timeouts.forQuery())
+	defer cancel()
+
+	stmt := "insert into customers (first_name,last_name,email,created_at,updated_at) values(?,?,?,?,?)"
+
+	return m.insertID(ctx, m.conn(), stmt, c.FirstName, c.LastName, c.Email, time.Now(), time.Now())
+}
+
+// Invoice is the type for invoices issued for orders
+type Invoice struct {
+	ID            int       `json:"id"`
+	OrderID       int       `json:"order_id"`
+	InvoiceNumber string    `json:"invoice_number"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"-"`
+}
+
+// orderColumns is the select list read by scanOrder, for orders aliased as o
+const orderColumns = "o.id,o.widget_id,o.transaction_id,o.customer_id,o.status_id,o.quantity,o.amount,o.subtotal,o.discount,o.tax,coalesce(o.coupon_code,''),coalesce(o.receipt_token,''),coalesce(o.tracking_number,''),coalesce(o.carrier,''),o.created_at,o.updated_at"
+
+// scanOrder reads one row selected with orderColumns
+func scanOrder(row interface{ Scan(...any) error }) (Order, error) {
+	var o Order
+	err := row.Scan(
+		&o.ID,
+		&o.WidgetID,
+		&o.TransactionID,
+		&o.CustomerID,
+		&o.StatusID,
+		&o.Quantity,
+		&o.Amount,
+		&o.Subtotal,
+		&o.Discount,
+		&o.Tax,
+		&o.CouponCode,
+		&o.ReceiptToken,
+		&o.TrackingNumber,
+		&o.Carrier,
+		&o.CreatedAt,
+		&o.UpdatedAt,
+	)
+	return o, err
+}
+
+// GetOrder gets one order by id
+func (m *DBModel) GetOrder(ctx context.Context, id int) (Order, error) {
+	ctx, span := tracer.Start(ctx, "DBModel.GetOrder")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, m.Timeouts.forQuery())
 	defer cancel()
-	stmt := "insert into orders (widget_id,transaction_id,status_id,quantity,customer_id,amount,created_at,updated_at) values(?,?,?,?,?,?,?,?)"
-	result, err := m.DB.ExecContext(ctx, stmt, order.WidgetID, order.TransactionID, order.StatusID, order.Quantity, order.CustomerID, order.Amount, time.Now(), time.Now())
+	row := m.conn().QueryRowContext(ctx, m.rebind("select "+orderColumns+" from orders o where o.id=?"), id)
+	return scanOrder(row)
+}
+
+// GetOrderByTransactionID gets the order paid for by a transaction
+func (m *DBModel) GetOrderByTransactionID(ctx context.Context, txnID int) (Order, error) {
+	ctx, span := tracer.Start(ctx, "DBModel.GetOrderByTransactionID")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, m.Timeouts.forQuery())
+	defer cancel()
+	row := m.conn().QueryRowContext(ctx, m.rebind("select "+orderColumns+" from orders o where o.transaction_id=?"), txnID)
+	return scanOrder(row)
+}
+
+// GetOrderByReceiptToken gets the order a receipt link points at
+func (m *DBModel) GetOrderByReceiptToken(ctx context.Context, token string) (Order, error) {
+	ctx, span := tracer.Start(ctx, "DBModel.GetOrderByReceiptToken")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, m.Timeouts.forQuery())
+	defer cancel()
+	row := m.conn().QueryRowContext(ctx, m.rebind("select "+orderColumns+" from orders o where o.receipt_token=?"), token)
+	return scanOrder(row)
+}
+
+// GetCustomerByEmail gets the most recent customer with an email address
+func (m *DBModel) GetCustomerByEmail(ctx context.Context, email string) (Customer, error) {
+	ctx, span := tracer.Start(ctx, "DBModel.GetCustomerByEmail")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, m.Timeouts.forQuery())
+	defer cancel()
+	var c Customer
+	row := m.conn().QueryRowContext(ctx, m.rebind("select id,first_name,last_name,email,created_at,updated_at from customers where email=? order by id desc limit 1"), strings.ToLower(email))
+	err := row.Scan(
+		&c.ID,
+		&c.FirstName,
+		&c.LastName,
+		&c.Email,
+		&c.CreatedAt,
+		&c.UpdatedAt,
+	)
 	if err != nil {
-		return 0, err
+		return c, err
 	}
-	id, err := result.LastInsertId()
+	return c, nil
+}
+
+// GetCustomer gets one customer by id
+func (m *DBModel) GetCustomer(ctx context.Context, id int) (Customer, error) {
+	ctx, span := tracer.Start(ctx, "DBModel.GetCustomer")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, m.Timeouts.forQuery())
+	defer cancel()
+	var c Customer
+	row := m.conn().QueryRowContext(ctx, m.rebind("select id,first_name,last_name,email,created_at,updated_at from customers where id=?"), id)
+	err := row.Scan(
+		&c.ID,
+		&c.FirstName,
+		&c.LastName,
+		&c.Email,
+		&c.CreatedAt,
+		&c.UpdatedAt,
+	)
 	if err != nil {
-		return 0, err
+		return c, err
 	}
-	return int(id), nil // return the id of the order
+	return c, nil
 }
 
-// InsertCustomer insert a new customer and return the id of the customer
-func (m *DBModel) InsertCustomer(c Customer) (int, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+// transactionColumns are the columns scanTransaction reads, in order
+const transactionColumns = `t.id,t.amount,t.currency,t.last_four,t.expiry_month,t.expiry_year,t.payment_intent,t.payment_method,t.bank_return_code,
+	t.transaction_status_id,t.source,coalesce(t.customer_id,0),coalesce(t.user_id,0),t.memo,t.fee,t.net,coalesce(t.payout_id,0),
+	t.created_at,t.updated_at`
+
+// transactionFields returns pointers to the fields of t in the order of
+// transactionColumns, for scanning
+func transactionFields(t *Transaction) []any {
+	return []any{
+		&t.ID,
+		&t.Amount,
+		&t.Currency,
+		&t.LastFour,
+		&t.ExpiryMonth,
+		&t.ExpiryYear,
+		&t.PaymentIntent,
+		&t.PaymentMethod,
+		&t.BankReturnCode,
+		&t.TransactionStatusID,
+		&t.Source,
+		&t.CustomerID,
+		&t.UserID,
+		&t.Memo,
+		&t.Fee,
+		&t.Net,
+		&t.PayoutID,
+		&t.CreatedAt,
+		&t.UpdatedAt,
+	}
+}
+
+func scanTransaction(row interface{ Scan(...any) error }) (Transaction, error) {
+	var t Transaction
+	err := row.Scan(transactionFields(&t)...)
+	return t, err
+}
+
+// GetTransaction gets one transaction by id
+func (m *DBModel) GetTransaction(ctx context.Context, id int) (Transaction, error) {
+	ctx, span := tracer.Start(ctx, "DBModel.GetTransaction")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, m.Timeouts.forQuery())
 	defer cancel()
+	row := m.conn().QueryRowContext(ctx, m.rebind("select "+transactionColumns+" from transactions t where t.id=?"), id)
+	return scanTransaction(row)
+}
 
-	stmt := "insert into customers (first_name,last_name,email,created_at,updated_at) values(?,?,?,?,?)"
+// GetTransactionByPaymentIntent gets the transaction recorded for a payment
+// intent, which is only ever recorded once
+func (m *DBModel) GetTransactionByPaymentIntent(ctx context.Context, paymentIntent string) (Transaction, error) {
+	ctx, span := tracer.Start(ctx, "DBModel.GetTransactionByPaymentIntent")
+	defer span.End()
+	if paymentIntent == "" {
+		return Transaction{}, sql.ErrNoRows
+	}
+	ctx, cancel := context.WithTimeout(ctx, m.Timeouts.forQuery())
+	defer cancel()
+	row := m.conn().QueryRowContext(ctx, m.rebind("select "+transactionColumns+" from transactions t where t.payment_intent=?"), paymentIntent)
+	return scanTransaction(row)
+}
+
+// GetTransactionsCreatedBetween returns the transactions created in [from, to), oldest first
+func (m *DBModel) GetTransactionsCreatedBetween(ctx context.Context, from, to time.Time) ([]Transaction, error) {
+	ctx, span := tracer.Start(ctx, "DBModel.GetTransactionsCreatedBetween")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, m.Timeouts.forReport())
+	defer cancel()
 
-	result, err := m.DB.ExecContext(ctx, stmt, c.FirstName, c.LastName, c.Email, time.Now(), time.Now())
+	query := "select " + transactionColumns + " from transactions t where t.created_at >= ? and t.created_at < ? order by t.created_at, t.id"
+	rows, err := m.conn().QueryContext(ctx, m.rebind(query), from, to)
 	if err != nil {
-		return 0, err
+		return nil, err
+	}
+	defer rows.Close()
+
+	var txns []Transaction
+	for rows.Next() {
+		t, err := scanTransaction(rows)
+		if err != nil {
+			return nil, err
+		}
+		txns = append(txns, t)
 	}
-	id, err := result.LastInsertId()
+	return txns, rows.Err()
+}
+
+// UpdateTransactionStatus sets the status and bank return code of a transaction
+func (m *DBModel) UpdateTransactionStatus(ctx context.Context, id, statusID int, bankReturnCode string) error {
+	ctx, span := tracer.Start(ctx, "DBModel.UpdateTransactionStatus")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, m.Timeouts.forQuery())
+	defer cancel()
+	stmt := "update transactions set transaction_status_id=?, bank_return_code=?, updated_at=? where id=?"
+	_, err := m.conn().ExecContext(ctx, m.rebind(stmt), statusID, bankReturnCode, time.Now(), id)
+	return err
+}
+
+// GetOrCreateInvoice returns the invoice for an order, issuing the next
+// invoice number of the year the first time it is asked for. Numbers run
+// from 1 each year without gaps, counted in invoice_counters. Two first
+// requests may race, so the invoice and the year's counter are locked while
+// it is numbered and both read back the same invoice
+func (m *DBModel) GetOrCreateInvoice(ctx context.Context, orderID int) (Invoice, error) {
+	ctx, span := tracer.Start(ctx, "DBModel.GetOrCreateInvoice")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, m.Timeouts.forQuery())
+	defer cancel()
+
+	inv, err := m.getInvoice(ctx, orderID)
+	if err == nil && inv.InvoiceNumber != "" {
+		return inv, nil
+	}
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return inv, err
+	}
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return inv, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	stmt := m.insertIgnore("insert into invoices (order_id,invoice_number,created_at,updated_at) values(?,'',?,?)", "order_id")
+	if _, err = tx.ExecContext(ctx, m.rebind(stmt), orderID, now, now); err != nil {
+		return inv, err
+	}
+	query := "select id,invoice_number,created_at from invoices where order_id=?" + m.forUpdate()
+	if err = tx.QueryRowContext(ctx, m.rebind(query), orderID).Scan(&inv.ID, &inv.InvoiceNumber, &inv.CreatedAt); err != nil {
+		return inv, err
+	}
+	if inv.InvoiceNumber == "" {
+		year := inv.CreatedAt.Year()
+		stmt = m.insertIgnore("insert into invoice_counters (year,last_number) values(?,0)", "year")
+		if _, err = tx.ExecContext(ctx, m.rebind(stmt), year); err != nil {
+			return inv, err
+		}
+		var last int
+		query = "select last_number from invoice_counters where year=?" + m.forUpdate()
+		if err = tx.QueryRowContext(ctx, m.rebind(query), year).Scan(&last); err != nil {
+			return inv, err
+		}
+		if _, err = tx.ExecContext(ctx, m.rebind("update invoice_counters set last_number=? where year=?"), last+1, year); err != nil {
+			return inv, err
+		}
+		stmt = "update invoices set invoice_number=?, updated_at=? where id=?"
+		if _, err = tx.ExecContext(ctx, m.rebind(stmt), fmt.Sprintf("INV-%d-%06d", year, last+1), now, inv.ID); err != nil {
+			return inv, err
+		}
+	}
+	if err = tx.Commit(); err != nil {
+		return inv, err
+	}
+	return m.getInvoice(ctx, orderID)
+}
+
+// getInvoice gets the invoice issued for an order
+func (m *DBModel) getInvoice(ctx context.Context, orderID int) (Invoice, error) {
+	var inv Invoice
+	query := "select id,order_id,invoice_number,created_at,updated_at from invoices where order_id=?"
+	err := m.conn().QueryRowContext(ctx, m.rebind(query), orderID).Scan(&inv.ID, &inv.OrderID, &inv.InvoiceNumber, &inv.CreatedAt, &inv.UpdatedAt)
+	return inv, err
+}
+
+// coupon kinds
+const (
+	CouponPercent = "percent"
+	CouponFixed   = "fixed"
+)
+
+// Coupon is the type for discount codes. Value is a whole percentage for
+// percent coupons and an amount in the smallest currency unit for fixed ones.
+// A MaxUses of 0 means the coupon can be used any number of times
+type Coupon struct {
+	ID        int       `json:"id"`
+	Code      string    `json:"code"`
+	Kind      string    `json:"kind"`
+	Value     int       `json:"value"`
+	ExpiresAt time.Time `json:"expires_at"`
+	MaxUses   int       `json:"max_uses"`
+	TimesUsed int       `json:"times_used"`
+	CreatedAt time.Time `json:"-"`
+	UpdatedAt time.Time `json:"-"`
+}
+
+// ErrDuplicatePaymentIntent is returned by the in-memory store for a
+// transaction whose payment intent has already been recorded, where the
+// database's unique index fails the insert
+var ErrDuplicatePaymentIntent = errors.New("payment intent is already recorded")
+
+// ErrCouponUnavailable is returned when a coupon can no longer be redeemed
+var ErrCouponUnavailable = errors.New("coupon is no longer available")
+
+// GetCouponByCode gets a coupon by its code, ignoring case
+func (m *DBModel) GetCouponByCode(ctx context.Context, code string) (Coupon, error) {
+	ctx, span := tracer.Start(ctx, "DBModel.GetCouponByCode")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, m.Timeouts.forQuery())
+	defer cancel()
+	var c Coupon
+	var expiresAt sql.NullTime
+	row := m.conn().QueryRowContext(ctx, m.rebind("select id,code,kind,value,expires_at,max_uses,times_used,created_at,updated_at from coupons where upper(code)=upper(?)"), code)
+	err := row.Scan(
+		&c.ID,
+		&c.Code,
+		&c.Kind,
+		&c.Value,
+		&expiresAt,
+		&c.MaxUses,
+		&c.TimesUsed,
+		&c.CreatedAt,
+		&c.UpdatedAt,
+	)
+	if err != nil {
+		return c, err
+	}
+	if expiresAt.Valid {
+		c.ExpiresAt = expiresAt.Time
+	}
+	return c, nil
+}
+
+// CouponReservation is a use of a coupon held for the checkout paying with
+// PaymentIntent. It counts against the coupon's limit until ExpiresAt unless
+// the payment goes through first, which makes it one of the coupon's uses
+type CouponReservation struct {
+	ID            int
+	CouponID      int
+	PaymentIntent string
+	ExpiresAt     time.Time
+	Redeemed      bool
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+}
+
+// ReserveCoupon holds one use of a coupon for a payment intent until until,
+// failing with ErrCouponUnavailable if the coupon has expired or its uses
+// and unexpired holds have reached its limit
+func (m *DBModel) ReserveCoupon(ctx context.Context, code, paymentIntent string, until time.Time) error {
+	ctx, span := tracer.Start(ctx, "DBModel.ReserveCoupon")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, m.Timeouts.forQuery())
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
 	if err != nil {
-		return 0, err
+		return err
 	}
-	return int(id), nil
+	defer tx.Rollback()
+
+	// locking the coupon makes checkouts using it at once take turns
+	var c Coupon
+	var expiresAt sql.NullTime
+	row := tx.QueryRowContext(ctx, m.rebind("select id,expires_at,max_uses,times_used from coupons where upper(code)=upper(?)"+m.forUpdate()), code)
+	err = row.Scan(&c.ID, &expiresAt, &c.MaxUses, &c.TimesUsed)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrCouponUnavailable
+	}
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	if expiresAt.Valid && !expiresAt.Time.After(now) {
+		return ErrCouponUnavailable
+	}
+	if c.MaxUses > 0 {
+		var held int
+		stmt := "select count(*) from coupon_reservations where coupon_id=? and redeemed=? and expires_at>?"
+		if err = tx.QueryRowContext(ctx, m.rebind(stmt), c.ID, false, now).Scan(&held); err != nil {
+			return err
+		}
+		if c.TimesUsed+held >= c.MaxUses {
+			return ErrCouponUnavailable
+		}
+	}
+
+	stmt := `insert into coupon_reservations (coupon_id,payment_intent,expires_at,redeemed,created_at,updated_at)
+		values(?,?,?,?,?,?)`
+	if _, err = tx.ExecContext(ctx, m.rebind(stmt), c.ID, paymentIntent, until, false, now, now); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// ConfirmCoupon turns the hold ReserveCoupon took for a payment intent that
+// has been paid into a use of the coupon. It does nothing for a payment
+// intent with no hold or one already confirmed, so it is safe to call again
+// for the same payment
+func (m *DBModel) ConfirmCoupon(ctx context.Context, paymentIntent string) error {
+	ctx, span := tracer.Start(ctx, "DBModel.ConfirmCoupon")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, m.Timeouts.forQuery())
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	stmt := "update coupon_reservations set redeemed=?, updated_at=? where payment_intent=? and redeemed=?"
+	result, err := tx.ExecContext(ctx, m.rebind(stmt), true, now, paymentIntent, false)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return nil
+	}
+
+	stmt = `update coupons set times_used=times_used+1, updated_at=?
+		where id=(select coupon_id from coupon_reservations where payment_intent=?)`
+	if _, err = tx.ExecContext(ctx, m.rebind(stmt), now, paymentIntent); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// ReleaseCoupon gives back the hold ReserveCoupon took for a payment intent
+// that will never be paid, such as one declined or cancelled. A hold that
+// has been confirmed is kept
+func (m *DBModel) ReleaseCoupon(ctx context.Context, paymentIntent string) error {
+	ctx, span := tracer.Start(ctx, "DBModel.ReleaseCoupon")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, m.Timeouts.forQuery())
+	defer cancel()
+	stmt := "delete from coupon_reservations where payment_intent=? and redeemed=?"
+	_, err := m.conn().ExecContext(ctx, m.rebind(stmt), paymentIntent, false)
+	return err
 }
@@ -0,0 +1,376 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"go-stripe/internal/driver"
+	"go-stripe/internal/migrate"
+	"go-stripe/migrations"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newTestDB is a DBModel on an in-memory sqlite database with every
+// migration applied
+func newTestDB(t *testing.T) *DBModel {
+	t.Helper()
+	db, err := driver.OpenDB("sqlite::memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	fsys, err := migrations.For(driver.SQLite)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := migrate.New(db, driver.SQLite, fsys, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = m.Up(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return &DBModel{DB: db, Dialect: driver.SQLite}
+}
+
+// insertOrder records a paid order for the seeded widget, returning the
+// order's id
+func insertOrder(t *testing.T, m *DBModel, txn Transaction, order Order) int {
+	t.Helper()
+	ctx := context.Background()
+	customerID, err := m.InsertCustomer(ctx, Customer{FirstName: "Ani", LastName: "Wijaya", Email: "ani@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if txn.TransactionStatusID == 0 {
+		txn.TransactionStatusID = TransactionStatusCleared
+	}
+	if txn.Amount == 0 {
+		txn.Amount = order.Amount
+	}
+	if order.TransactionID, err = m.InsertTransaction(ctx, txn); err != nil {
+		t.Fatal(err)
+	}
+	if order.StatusID == 0 {
+		order.StatusID = 1
+	}
+	if order.Quantity == 0 {
+		order.Quantity = 1
+	}
+	order.WidgetID = 1
+	order.CustomerID = customerID
+	id, err := m.InsertOrder(ctx, order)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+func TestGetOrCreateInvoice(t *testing.T) {
+	m := newTestDB(t)
+	ctx := context.Background()
+	first := insertOrder(t, m, Transaction{Currency: "idr", PaymentIntent: "pi_1"}, Order{Amount: 1000000})
+	second := insertOrder(t, m, Transaction{Currency: "idr", PaymentIntent: "pi_2"}, Order{Amount: 1000000})
+
+	// every first request gets the one invoice
+	var wg sync.WaitGroup
+	invoices := make([]Invoice, 4)
+	errs := make([]error, 4)
+	for i := range invoices {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			invoices[i], errs[i] = m.GetOrCreateInvoice(ctx, first)
+		}()
+	}
+	wg.Wait()
+	for i, inv := range invoices {
+		if errs[i] != nil {
+			t.Fatal(errs[i])
+		}
+		if inv.InvoiceNumber == "" || inv != invoices[0] {
+			t.Errorf("request %d got invoice %+v, want %+v", i, inv, invoices[0])
+		}
+	}
+
+	other, err := m.GetOrCreateInvoice(ctx, second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// numbered from 1 in the year they were issued
+	year := invoices[0].CreatedAt.Year()
+	if want := fmt.Sprintf("INV-%d-000001", year); invoices[0].InvoiceNumber != want {
+		t.Errorf("first invoice numbered %q, want %q", invoices[0].InvoiceNumber, want)
+	}
+	if want := fmt.Sprintf("INV-%d-000002", year); other.ID == invoices[0].ID || other.InvoiceNumber != want {
+		t.Errorf("second order got invoice %+v, want it numbered %q", other, want)
+	}
+
+	if _, err := m.GetOrCreateInvoice(ctx, 99); err == nil || err == sql.ErrNoRows {
+		t.Errorf("invoice for a missing order = %v, want the foreign key to refuse it", err)
+	}
+}
+
+func TestTransactionPaymentIntentUnique(t *testing.T) {
+	m := newTestDB(t)
+	ctx := context.Background()
+	insert := func(paymentIntent string) error {
+		_, err := m.InsertTransaction(ctx, Transaction{Amount: 1000, Currency: "idr", PaymentIntent: paymentIntent, TransactionStatusID: TransactionStatusCleared})
+		return err
+	}
+
+	if err := insert("pi_1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := insert("pi_1"); err == nil {
+		t.Error("recorded pi_1 twice")
+	}
+	// transactions from before payment intents were kept have none
+	for i := 0; i < 2; i++ {
+		if err := insert(""); err != nil {
+			t.Errorf("transaction without a payment intent: %v", err)
+		}
+	}
+	if _, err := m.GetTransactionByPaymentIntent(ctx, ""); err != sql.ErrNoRows {
+		t.Errorf("lookup without a payment intent = %v, want sql.ErrNoRows", err)
+	}
+}
+
+func TestTransactionPaymentIntentDuplicates(t *testing.T) {
+	db, err := driver.OpenDB("sqlite::memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	fsys, err := migrations.For(driver.SQLite)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mig, err := migrate.New(db, driver.SQLite, fsys, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	if _, err = mig.To(ctx, 20241029090000); err != nil {
+		t.Fatal(err)
+	}
+
+	// payments recorded twice before payment intents had to be unique
+	for _, row := range [][2]string{{"pi_1", ""}, {"pi_1", ""}, {"pi_2", ""}, {"pi_1", "phone order"}, {"", ""}, {"", ""}} {
+		_, err = db.ExecContext(ctx, `insert into transactions (amount,currency,last_four,bank_return_code,transaction_status_id,payment_intent,memo)
+			values(1000,'idr','4242','',2,?,?)`, row[0], row[1])
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err = mig.Up(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	rows, err := db.QueryContext(ctx, "select payment_intent, memo from transactions order by id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var got [][2]string
+	for rows.Next() {
+		var row [2]string
+		if err = rows.Scan(&row[0], &row[1]); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, row)
+	}
+	want := [][2]string{
+		{"pi_1", ""},
+		{"", "(duplicate of pi_1)"},
+		{"pi_2", ""},
+		{"", "phone order (duplicate of pi_1)"},
+		{"", ""},
+		{"", ""},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("transactions = %q, want %q", got, want)
+	}
+}
+
+func TestCaptureOrder(t *testing.T) {
+	m := newTestDB(t)
+	ctx := context.Background()
+	id := insertOrder(t, m, Transaction{TransactionStatusID: TransactionStatusAuthorized, PaymentIntent: "pi_partial"},
+		Order{Amount: 111000, Subtotal: 100000, Tax: 11000, ReceiptToken: "partial"})
+	order, err := m.GetOrder(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = m.CaptureOrder(ctx, order, 55500, "ch_1"); err != nil {
+		t.Fatal(err)
+	}
+	got, err := m.GetOrderDetail(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Amount != 55500 || got.Subtotal != 50000 || got.Tax != 5500 {
+		t.Errorf("order amount %d subtotal %d tax %d, want 55500 50000 5500", got.Amount, got.Subtotal, got.Tax)
+	}
+	if got.Transaction.Amount != 55500 || got.Transaction.TransactionStatusID != TransactionStatusCleared {
+		t.Errorf("transaction %d with status %d, want 55500 cleared", got.Transaction.Amount, got.Transaction.TransactionStatusID)
+	}
+}
+
+func TestChangeOrderStatusNotPaid(t *testing.T) {
+	m := newTestDB(t)
+	ctx := context.Background()
+	tests := []struct {
+		txnStatus int
+		want      error
+	}{
+		{TransactionStatusCleared, nil},
+		{TransactionStatusAuthorized, nil},
+		{TransactionStatusPending, ErrOrderNotPaid},
+		{TransactionStatusDeclined, ErrOrderNotPaid},
+		{TransactionStatusVoided, ErrOrderNotPaid},
+	}
+	for i, tt := range tests {
+		id := insertOrder(t, m, Transaction{TransactionStatusID: tt.txnStatus, PaymentIntent: fmt.Sprintf("pi_%d", i)},
+			Order{Amount: 100000, ReceiptToken: fmt.Sprintf("r%d", i)})
+		err := m.ChangeOrderStatus(ctx, id, OrderChange{FromStatusID: OrderStatusCleared, ToStatusID: OrderStatusPacked})
+		if !errors.Is(err, tt.want) {
+			t.Errorf("packing with transaction status %d = %v, want %v", tt.txnStatus, err, tt.want)
+		}
+	}
+
+	// cancelling doesn't need the payment
+	id := insertOrder(t, m, Transaction{TransactionStatusID: TransactionStatusDeclined, PaymentIntent: "pi_cancel"},
+		Order{Amount: 100000, ReceiptToken: "cancel"})
+	if err := m.ChangeOrderStatus(ctx, id, OrderChange{FromStatusID: OrderStatusCleared, ToStatusID: OrderStatusCancelled}); err != nil {
+		t.Errorf("cancelling = %v", err)
+	}
+}
+
+func TestCouponReservations(t *testing.T) {
+	m := newTestDB(t)
+	ctx := context.Background()
+	_, err := m.DB.ExecContext(ctx, "insert into coupons (code,kind,value,max_uses,times_used) values('ONCE','percent',10,2,0)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	timesUsed := func() int {
+		c, err := m.GetCouponByCode(ctx, "once")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c.TimesUsed
+	}
+	hour := time.Now().Add(time.Hour)
+
+	// a hold that has run out no longer counts against the limit
+	if err = m.ReserveCoupon(ctx, "once", "pi_expired", time.Now().Add(-time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	for _, pi := range []string{"pi_1", "pi_2"} {
+		if err = m.ReserveCoupon(ctx, "once", pi, hour); err != nil {
+			t.Fatalf("reserving for %s = %v", pi, err)
+		}
+	}
+	if err = m.ReserveCoupon(ctx, "once", "pi_3", hour); !errors.Is(err, ErrCouponUnavailable) {
+		t.Fatalf("third hold = %v, want ErrCouponUnavailable", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err = m.ConfirmCoupon(ctx, "pi_1"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = m.ReleaseCoupon(ctx, "pi_1"); err != nil {
+		t.Fatal(err)
+	}
+	if n := timesUsed(); n != 1 {
+		t.Errorf("coupon used %d times, want 1 however often it is confirmed or released", n)
+	}
+
+	if err = m.ReleaseCoupon(ctx, "pi_2"); err != nil {
+		t.Fatal(err)
+	}
+	if err = m.ReserveCoupon(ctx, "once", "pi_3", hour); err != nil {
+		t.Errorf("hold after one was released = %v", err)
+	}
+}
+
+func TestCapturedTotals(t *testing.T) {
+	tests := []struct {
+		name         string
+		order        Order
+		amount       int
+		wantSubtotal int
+		wantTax      int
+	}{
+		{"everything", Order{Amount: 111000, Subtotal: 100000, Tax: 11000}, 111000, 100000, 11000},
+		{"exclusive tax", Order{Amount: 111000, Subtotal: 100000, Tax: 11000}, 55500, 50000, 5500},
+		{"exclusive tax and a discount", Order{Amount: 99900, Subtotal: 100000, Discount: 10000, Tax: 9900}, 49950, 55000, 4950},
+		{"inclusive tax", Order{Amount: 100000, Subtotal: 100000, Tax: 9910}, 50000, 50000, 4955},
+		{"no breakdown", Order{Amount: 100000}, 50000, 0, 0},
+	}
+	for _, tt := range tests {
+		subtotal, tax := capturedTotals(tt.order, tt.amount)
+		if subtotal != tt.wantSubtotal || tax != tt.wantTax {
+			t.Errorf("%s: capturedTotals = %d, %d, want %d, %d", tt.name, subtotal, tax, tt.wantSubtotal, tt.wantTax)
+		}
+	}
+}
+
+func TestSalesReports(t *testing.T) {
+	m := newTestDB(t)
+	ctx := context.Background()
+	insertOrder(t, m, Transaction{Currency: "idr", PaymentIntent: "pi_1"}, Order{Amount: 100000, ReceiptToken: "1"})
+	insertOrder(t, m, Transaction{Currency: "idr", PaymentIntent: "pi_2", TransactionStatusID: TransactionStatusPartiallyRefunded},
+		Order{Amount: 50000, ReceiptToken: "2"})
+	insertOrder(t, m, Transaction{Currency: "usd", PaymentIntent: "pi_3"}, Order{Amount: 2500, ReceiptToken: "3"})
+	insertOrder(t, m, Transaction{Currency: "usd", PaymentIntent: "pi_4"}, Order{Amount: 1500, ReceiptToken: "4", StatusID: OrderStatusRefunded})
+	// payments that never took the money aren't sales, whatever the order says
+	insertOrder(t, m, Transaction{Currency: "idr", PaymentIntent: "pi_5", TransactionStatusID: TransactionStatusDeclined},
+		Order{Amount: 70000, ReceiptToken: "5"})
+	insertOrder(t, m, Transaction{Currency: "usd", PaymentIntent: "pi_6", TransactionStatusID: TransactionStatusVoided},
+		Order{Amount: 9000, ReceiptToken: "6", StatusID: OrderStatusRefunded})
+	from, to := time.Now().Add(-time.Hour), time.Now().Add(time.Hour)
+
+	summaries, err := m.GetSalesSummary(ctx, from, to)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []SalesSummary{
+		{Currency: "idr", Orders: 2, Gross: 150000, RefundedOrders: 1, Refunds: 50000, Net: 100000, AverageOrderValue: 75000, RefundRate: 0.5},
+		{Currency: "usd", Orders: 2, Gross: 4000, RefundedOrders: 1, Refunds: 1500, Net: 2500, AverageOrderValue: 2000, RefundRate: 0.5},
+	}
+	if !reflect.DeepEqual(summaries, want) {
+		t.Errorf("GetSalesSummary = %+v, want %+v", summaries, want)
+	}
+
+	points, err := m.GetRevenueByPeriod(ctx, from, to, PeriodMonth)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(points) != 2 || points[0].Currency != "idr" || points[0].Net != 100000 || points[1].Currency != "usd" || points[1].Net != 2500 {
+		t.Errorf("GetRevenueByPeriod = %+v, want one point for idr and one for usd", points)
+	}
+
+	widgets, err := m.GetSalesByWidget(ctx, from, to)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(widgets) != 2 || widgets[0].Gross != 150000 || widgets[1].Gross != 4000 {
+		t.Errorf("GetSalesByWidget = %+v, want the widget once for idr and once for usd", widgets)
+	}
+
+	customers, err := m.GetTopCustomers(ctx, from, to, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(customers) != 2 || customers[0].Net != 100000 || customers[1].Currency != "usd" || customers[1].Net != 2500 {
+		t.Errorf("GetTopCustomers = %+v, want the top customer in idr and in usd", customers)
+	}
+}
@@ -0,0 +1,480 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"math"
+	"strings"
+	"time"
+)
+
+// order statuses, as seeded into the statuses table. Cleared means the
+// order has been paid for and is waiting to be packed
+const (
+	OrderStatusCleared   = 1
+	OrderStatusRefunded  = 2
+	OrderStatusCancelled = 3
+	OrderStatusPacked    = 4
+	OrderStatusShipped   = 5
+	OrderStatusDelivered = 6
+	OrderStatusPending   = 7
+)
+
+// orderStatusNames are the names admins use to move an order along
+var orderStatusNames = map[string]int{
+	"paid":      OrderStatusCleared,
+	"packed":    OrderStatusPacked,
+	"shipped":   OrderStatusShipped,
+	"delivered": OrderStatusDelivered,
+	"cancelled": OrderStatusCancelled,
+	"refunded":  OrderStatusRefunded,
+	"pending":   OrderStatusPending,
+}
+
+// orderTransitions lists where an order may go from each status. Orders can
+// be cancelled until they leave the warehouse and refunded at any point
+// after they are paid. Cancelled and refunded orders are final, and pending
+// orders only move on with their payment, through SettlePendingOrder
+var orderTransitions = map[int][]int{
+	OrderStatusCleared:   {OrderStatusPacked, OrderStatusCancelled, OrderStatusRefunded},
+	OrderStatusPacked:    {OrderStatusShipped, OrderStatusCancelled, OrderStatusRefunded},
+	OrderStatusShipped:   {OrderStatusDelivered, OrderStatusRefunded},
+	OrderStatusDelivered: {OrderStatusRefunded},
+}
+
+var (
+	ErrInvalidTransition   = errors.New("order can't move to that status")
+	ErrTrackingRequired    = errors.New("a tracking number and carrier are needed to ship an order")
+	ErrUnknownOrderStatus  = errors.New("unknown order status")
+	ErrOrderStatusConflict = errors.New("order was changed by someone else, reload and try again")
+	ErrOrderNotPaid        = errors.New("order can't be packed or shipped until its payment has cleared")
+)
+
+// OrderStatusByName returns the status id for a name such as "shipped"
+func OrderStatusByName(name string) (int, error) {
+	id, ok := orderStatusNames[strings.ToLower(strings.TrimSpace(name))]
+	if !ok {
+		return 0, ErrUnknownOrderStatus
+	}
+	return id, nil
+}
+
+// OrderStatusName returns the name used for a status id by OrderStatusByName
+func OrderStatusName(id int) string {
+	for name, statusID := range orderStatusNames {
+		if statusID == id {
+			return name
+		}
+	}
+	return ""
+}
+
+// CanTransition reports whether an order may move from one status to another
+func CanTransition(from, to int) bool {
+	for _, next := range orderTransitions[from] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+// NextOrderStatuses returns the names of the statuses an order can move to
+func NextOrderStatuses(from int) []string {
+	var names []string
+	for _, next := range orderTransitions[from] {
+		names = append(names, OrderStatusName(next))
+	}
+	return names
+}
+
+// OrderChange is a request to move an order to a new status
+type OrderChange struct {
+	FromStatusID   int
+	ToStatusID     int
+	TrackingNumber string
+	Carrier        string
+	Note           string
+	UserID         int
+}
+
+// OrderEvent is one entry in an order's history
+type OrderEvent struct {
+	ID             int       `json:"id"`
+	OrderID        int       `json:"order_id"`
+	FromStatusID   int       `json:"from_status_id"`
+	ToStatusID     int       `json:"to_status_id"`
+	FromStatus     string    `json:"from_status"`
+	ToStatus       string    `json:"to_status"`
+	TrackingNumber string    `json:"tracking_number"`
+	Carrier        string    `json:"carrier"`
+	Note           string    `json:"note"`
+	UserID         int       `json:"user_id"`
+	UserName       string    `json:"user_name"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+// ChangeOrderStatus moves an order to a new status and records who did it in
+// the order's history. The move is refused unless the order is still in
+// FromStatusID and the state machine allows it, and an order is only packed
+// or shipped once its payment has cleared or is authorized
+func (m *DBModel) ChangeOrderStatus(ctx context.Context, orderID int, c OrderChange) error {
+	ctx, span := tracer.Start(ctx, "DBModel.ChangeOrderStatus")
+	defer span.End()
+	if !CanTransition(c.FromStatusID, c.ToStatusID) {
+		return ErrInvalidTransition
+	}
+	if c.ToStatusID == OrderStatusShipped && (c.TrackingNumber == "" || c.Carrier == "") {
+		return ErrTrackingRequired
+	}
+
+	return m.moveOrderStatus(ctx, orderID, c, needsPayment(c.ToStatusID))
+}
+
+// SettlePendingOrder moves an order that was waiting for its payment on to
+// paid once the payment clears or is authorized, or cancels it when the
+// payment is declined
+func (m *DBModel) SettlePendingOrder(ctx context.Context, orderID int, paid bool) error {
+	ctx, span := tracer.Start(ctx, "DBModel.SettlePendingOrder")
+	defer span.End()
+	return m.moveOrderStatus(ctx, orderID, pendingChange(paid), false)
+}
+
+// pendingChange is the move SettlePendingOrder makes
+func pendingChange(paid bool) OrderChange {
+	if paid {
+		return OrderChange{FromStatusID: OrderStatusPending, ToStatusID: OrderStatusCleared, Note: "payment cleared"}
+	}
+	return OrderChange{FromStatusID: OrderStatusPending, ToStatusID: OrderStatusCancelled, Note: "payment declined"}
+}
+
+// needsPayment reports whether an order can only move to status once it has
+// been paid for, as it can't be packed or shipped on a card that was declined
+// or voided
+func needsPayment(status int) bool {
+	return status == OrderStatusPacked || status == OrderStatusShipped
+}
+
+// paidFor reports whether a transaction in status has paid for its order. An
+// authorized card is captured when the order ships
+func paidFor(status int) bool {
+	return status == TransactionStatusCleared || status == TransactionStatusAuthorized
+}
+
+// moveOrderStatus moves an order from c.FromStatusID to c.ToStatusID and
+// records the move in its history, or returns ErrOrderStatusConflict if the
+// order has moved on from c.FromStatusID already. With requirePaid it returns
+// ErrOrderNotPaid unless the order's transaction has paid for it
+func (m *DBModel) moveOrderStatus(ctx context.Context, orderID int, c OrderChange, requirePaid bool) error {
+	ctx, cancel := context.WithTimeout(ctx, m.Timeouts.forQuery())
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if requirePaid {
+		var status int
+		stmt := `select t.transaction_status_id from orders o
+			inner join transactions t on (t.id = o.transaction_id)
+			where o.id = ?` + m.forUpdate()
+		err = tx.QueryRowContext(ctx, m.rebind(stmt), orderID).Scan(&status)
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrOrderStatusConflict
+		}
+		if err != nil {
+			return err
+		}
+		if !paidFor(status) {
+			return ErrOrderNotPaid
+		}
+	}
+
+	now := time.Now()
+	stmt := `update orders set status_id=?, updated_at=?,
+		tracking_number=coalesce(nullif(?,''),tracking_number),
+		carrier=coalesce(nullif(?,''),carrier)
+		where id=? and status_id=?`
+	result, err := tx.ExecContext(ctx, m.rebind(stmt), c.ToStatusID, now, c.TrackingNumber, c.Carrier, orderID, c.FromStatusID)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrOrderStatusConflict
+	}
+
+	stmt = `insert into order_events (order_id,from_status_id,to_status_id,tracking_number,carrier,note,user_id,created_at,updated_at)
+		values(?,?,?,?,?,?,nullif(?,0),?,?)`
+	_, err = tx.ExecContext(ctx, m.rebind(stmt), orderID, c.FromStatusID, c.ToStatusID, c.TrackingNumber, c.Carrier, c.Note, c.UserID, now, now)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// CaptureOrder records that amount was captured for an order paid by an
+// authorized card. The transaction and the order's totals change together,
+// so a partial capture leaves the order showing what the customer paid
+func (m *DBModel) CaptureOrder(ctx context.Context, order Order, amount int, bankReturnCode string) error {
+	ctx, span := tracer.Start(ctx, "DBModel.CaptureOrder")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, m.Timeouts.forQuery())
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	stmt := "update transactions set amount=?, transaction_status_id=?, bank_return_code=?, updated_at=? where id=?"
+	_, err = tx.ExecContext(ctx, m.rebind(stmt), amount, TransactionStatusCleared, bankReturnCode, now, order.TransactionID)
+	if err != nil {
+		return err
+	}
+
+	subtotal, tax := capturedTotals(order, amount)
+	stmt = "update orders set amount=?, subtotal=?, tax=?, updated_at=? where id=?"
+	_, err = tx.ExecContext(ctx, m.rebind(stmt), amount, subtotal, tax, now, order.ID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// capturedTotals returns an order's subtotal and tax once only amount of it
+// has been captured. The tax shrinks in proportion and the subtotal takes the
+// rest, so the totals still add up the way pricing worked them out
+func capturedTotals(o Order, amount int) (subtotal, tax int) {
+	if amount == o.Amount || o.Amount == 0 || o.Subtotal == 0 {
+		return o.Subtotal, o.Tax
+	}
+	tax = int(math.Round(float64(o.Tax) * float64(amount) / float64(o.Amount)))
+	subtotal = amount + o.Discount
+	// exclusive tax was added on top of the subtotal; inclusive tax is part of it
+	if o.Amount == o.Subtotal-o.Discount+o.Tax {
+		subtotal -= tax
+	}
+	return subtotal, tax
+}
+
+// RevertOrderStatus moves an order that ChangeOrderStatus moved with c back
+// to where it was, when the money that goes with the move could not be
+// moved, and records why in its history
+func (m *DBModel) RevertOrderStatus(ctx context.Context, orderID int, c OrderChange) error {
+	ctx, span := tracer.Start(ctx, "DBModel.RevertOrderStatus")
+	defer span.End()
+	return m.moveOrderStatus(ctx, orderID, OrderChange{
+		FromStatusID: c.ToStatusID,
+		ToStatusID:   c.FromStatusID,
+		Note:         c.Note,
+		UserID:       c.UserID,
+	}, false)
+}
+
+// GetOrderEvents returns the history of an order, oldest first
+func (m *DBModel) GetOrderEvents(ctx context.Context, orderID int) ([]*OrderEvent, error) {
+	ctx, span := tracer.Start(ctx, "DBModel.GetOrderEvents")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, m.Timeouts.forQuery())
+	defer cancel()
+
+	var events []*OrderEvent
+
+	query := `
+		select e.id, e.order_id, e.from_status_id, e.to_status_id, coalesce(fs.name, ''), coalesce(ts.name, ''),
+			e.tracking_number, e.carrier, coalesce(e.note, ''), coalesce(e.user_id, 0),
+			coalesce(concat(u.first_name, ' ', u.last_name), ''), e.created_at
+		from order_events e
+		left join statuses fs on (e.from_status_id = fs.id)
+		left join statuses ts on (e.to_status_id = ts.id)
+		left join users u on (e.user_id = u.id)
+		where e.order_id = ?
+		order by e.created_at, e.id`
+
+	rows, err := m.conn().QueryContext(ctx, m.rebind(query), orderID)
+	if err != nil {
+		return events, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var e OrderEvent
+		err = rows.Scan(
+			&e.ID,
+			&e.OrderID,
+			&e.FromStatusID,
+			&e.ToStatusID,
+			&e.FromStatus,
+			&e.ToStatus,
+			&e.TrackingNumber,
+			&e.Carrier,
+			&e.Note,
+			&e.UserID,
+			&e.UserName,
+			&e.CreatedAt,
+		)
+		if err != nil {
+			return events, err
+		}
+		events = append(events, &e)
+	}
+	return events, rows.Err()
+}
+
+// orderDetailQuery selects orders with their status, widget, transaction and
+// customer, ready for scanOrderDetail
+const orderDetailQuery = `
+	select ` + orderColumns + `, s.name,
+		w.id, w.name,
+		t.id, t.amount, t.currency, t.last_four, t.expiry_month, t.expiry_year,
+		t.payment_intent, t.bank_return_code, t.transaction_status_id,
+		coalesce(c.id, 0), coalesce(c.first_name, ''), coalesce(c.last_name, ''), coalesce(c.email, '')
+	from orders o
+	inner join statuses s on (o.status_id = s.id)
+	inner join widgets w on (o.widget_id = w.id)
+	inner join transactions t on (o.transaction_id = t.id)
+	left join customers c on (o.customer_id = c.id)`
+
+func scanOrderDetail(row interface{ Scan(...any) error }) (*Order, error) {
+	var o Order
+	err := row.Scan(
+		&o.ID,
+		&o.WidgetID,
+		&o.TransactionID,
+		&o.CustomerID,
+		&o.StatusID,
+		&o.Quantity,
+		&o.Amount,
+		&o.Subtotal,
+		&o.Discount,
+		&o.Tax,
+		&o.CouponCode,
+		&o.ReceiptToken,
+		&o.TrackingNumber,
+		&o.Carrier,
+		&o.CreatedAt,
+		&o.UpdatedAt,
+		&o.Status,
+		&o.Widget.ID,
+		&o.Widget.Name,
+		&o.Transaction.ID,
+		&o.Transaction.Amount,
+		&o.Transaction.Currency,
+		&o.Transaction.LastFour,
+		&o.Transaction.ExpiryMonth,
+		&o.Transaction.ExpiryYear,
+		&o.Transaction.PaymentIntent,
+		&o.Transaction.BankReturnCode,
+		&o.Transaction.TransactionStatusID,
+		&o.Customer.ID,
+		&o.Customer.FirstName,
+		&o.Customer.LastName,
+		&o.Customer.Email,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &o, nil
+}
+
+// GetOrderDetail gets one order along with its widget, transaction and customer
+func (m *DBModel) GetOrderDetail(ctx context.Context, id int) (*Order, error) {
+	ctx, span := tracer.Start(ctx, "DBModel.GetOrderDetail")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, m.Timeouts.forQuery())
+	defer cancel()
+	row := m.conn().QueryRowContext(ctx, m.rebind(orderDetailQuery+" where o.id = ?"), id)
+	return scanOrderDetail(row)
+}
+
+// OrderFilter narrows down the orders listed or exported. Zero values match
+// every order and To is exclusive
+type OrderFilter struct {
+	StatusID int
+	From     time.Time
+	To       time.Time
+}
+
+func (f OrderFilter) where() (string, []any) {
+	var conds []string
+	var args []any
+	if f.StatusID > 0 {
+		conds = append(conds, "o.status_id = ?")
+		args = append(args, f.StatusID)
+	}
+	if !f.From.IsZero() {
+		conds = append(conds, "o.created_at >= ?")
+		args = append(args, f.From)
+	}
+	if !f.To.IsZero() {
+		conds = append(conds, "o.created_at < ?")
+		args = append(args, f.To)
+	}
+	if len(conds) == 0 {
+		return "", nil
+	}
+	return " where " + strings.Join(conds, " and "), args
+}
+
+// GetAllOrdersPaginated returns one page of the orders matching filter,
+// newest first, along with the number of the last page and the total number
+// of matching orders
+func (m *DBModel) GetAllOrdersPaginated(ctx context.Context, filter OrderFilter, pageSize, page int) ([]*Order, int, int, error) {
+	ctx, span := tracer.Start(ctx, "DBModel.GetAllOrdersPaginated")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, m.Timeouts.forQuery())
+	defer cancel()
+
+	var orders []*Order
+	if pageSize < 1 {
+		pageSize = 10
+	}
+	if page < 1 {
+		page = 1
+	}
+	offset := (page - 1) * pageSize
+
+	where, args := filter.where()
+
+	var totalRecords int
+	err := m.conn().QueryRowContext(ctx, m.rebind("select count(o.id) from orders o"+where), args...).Scan(&totalRecords)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+
+	query := orderDetailQuery + where + " order by o.created_at desc, o.id desc limit ? offset ?"
+	rows, err := m.conn().QueryContext(ctx, m.rebind(query), append(args, pageSize, offset)...)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		o, err := scanOrderDetail(rows)
+		if err != nil {
+			return nil, 0, 0, err
+		}
+		orders = append(orders, o)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, 0, 0, err
+	}
+
+	lastPage := totalRecords / pageSize
+	if totalRecords%pageSize != 0 {
+		lastPage++
+	}
+
+	return orders, lastPage, totalRecords, nil
+}
@@ -0,0 +1,268 @@
+package models
+
+import (
+	"context"
+	"go-stripe/internal/driver"
+	"time"
+)
+
+// categories a payout line falls into on the payout report
+const (
+	PayoutLineCharge  = "charge"
+	PayoutLineRefund  = "refund"
+	PayoutLineDispute = "dispute"
+	PayoutLineFee     = "fee"
+	PayoutLineOther   = "other"
+)
+
+// Payout is money stripe sent to our bank account
+type Payout struct {
+	ID             int       `json:"id"`
+	StripePayoutID string    `json:"stripe_payout_id"`
+	Amount         int       `json:"amount"`
+	Currency       string    `json:"currency"`
+	Status         string    `json:"status"`
+	ArrivalDate    time.Time `json:"arrival_date"`
+	CreatedAt      time.Time `json:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at"`
+}
+
+// PayoutLine is one stripe balance transaction that made up a payout. Amount
+// is before stripe's fee and Net after it
+type PayoutLine struct {
+	ID                   int       `json:"id"`
+	PayoutID             int       `json:"payout_id"`
+	BalanceTransactionID string    `json:"balance_transaction_id"`
+	Category             string    `json:"category"`
+	StripeType           string    `json:"stripe_type"`
+	SourceID             string    `json:"source_id"`
+	PaymentIntent        string    `json:"payment_intent"`
+	TransactionID        int       `json:"transaction_id"`
+	OrderID              int       `json:"order_id"`
+	Amount               int       `json:"amount"`
+	Fee                  int       `json:"fee"`
+	Net                  int       `json:"net"`
+	Description          string    `json:"description"`
+	CreatedAt            time.Time `json:"created_at"`
+}
+
+// PayoutTotal is the sum of a payout's lines in one category
+type PayoutTotal struct {
+	Category string `json:"category"`
+	Count    int    `json:"count"`
+	Amount   int    `json:"amount"`
+	Fee      int    `json:"fee"`
+	Net      int    `json:"net"`
+}
+
+// SavePayout records a payout as stripe last reported it, along with the
+// balance transactions in it, and stamps the fee, net amount and payout on
+// the transactions they paid out. It returns the id of the payout
+func (m *DBModel) SavePayout(ctx context.Context, p Payout, lines []PayoutLine) (int, error) {
+	ctx, span := tracer.Start(ctx, "DBModel.SavePayout")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, m.Timeouts.forReport())
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	stmt := `insert into payouts (stripe_payout_id,amount,currency,status,arrival_date,created_at,updated_at)
+		values(?,?,?,?,?,?,?)`
+	if m.dialect() == driver.MySQL {
+		// last_insert_id(id) makes an update report the existing row's id
+		stmt += `
+		on duplicate key update id=last_insert_id(id), amount=values(amount), status=values(status),
+			arrival_date=values(arrival_date), updated_at=values(updated_at)`
+	} else {
+		stmt += `
+		on conflict (stripe_payout_id) do update set amount=excluded.amount, status=excluded.status,
+			arrival_date=excluded.arrival_date, updated_at=excluded.updated_at`
+	}
+	payoutID, err := m.insertID(ctx, tx, stmt, p.StripePayoutID, p.Amount, p.Currency, p.Status, p.ArrivalDate, now, now)
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.ExecContext(ctx, m.rebind("delete from payout_lines where payout_id = ?"), payoutID)
+	if err != nil {
+		return 0, err
+	}
+
+	stmt = `insert into payout_lines (payout_id,balance_transaction_id,category,stripe_type,source_id,payment_intent,
+			transaction_id,amount,fee,net,description,created_at)
+		values(?,?,?,?,?,?,
+			(select id from transactions where (payment_intent = ? and payment_intent <> '') or (bank_return_code = ? and bank_return_code <> '')
+				order by id desc limit 1),
+			?,?,?,?,?)`
+	for _, l := range lines {
+		_, err = tx.ExecContext(ctx, m.rebind(stmt), payoutID, l.BalanceTransactionID, l.Category, l.StripeType, l.SourceID, l.PaymentIntent,
+			l.PaymentIntent, l.SourceID,
+			l.Amount, l.Fee, l.Net, l.Description, l.CreatedAt)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	if m.dialect() == driver.MySQL {
+		stmt = `update transactions t
+		inner join payout_lines l on (l.transaction_id = t.id)
+		set t.fee = l.fee, t.net = l.net, t.payout_id = l.payout_id
+		where l.payout_id = ? and l.category = ?`
+	} else {
+		stmt = `update transactions
+		set fee = l.fee, net = l.net, payout_id = l.payout_id
+		from payout_lines l
+		where l.transaction_id = transactions.id and l.payout_id = ? and l.category = ?`
+	}
+	_, err = tx.ExecContext(ctx, m.rebind(stmt), payoutID, PayoutLineCharge)
+	if err != nil {
+		return 0, err
+	}
+
+	return payoutID, tx.Commit()
+}
+
+const payoutColumns = "id, stripe_payout_id, amount, currency, status, arrival_date, created_at, updated_at"
+
+func scanPayout(row interface{ Scan(...any) error }) (*Payout, error) {
+	var p Payout
+	err := row.Scan(
+		&p.ID,
+		&p.StripePayoutID,
+		&p.Amount,
+		&p.Currency,
+		&p.Status,
+		&p.ArrivalDate,
+		&p.CreatedAt,
+		&p.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// GetPayoutsPaginated returns one page of payouts, latest arrival first,
+// along with the number of the last page and the total number of payouts
+func (m *DBModel) GetPayoutsPaginated(ctx context.Context, pageSize, page int) ([]*Payout, int, int, error) {
+	ctx, span := tracer.Start(ctx, "DBModel.GetPayoutsPaginated")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, m.Timeouts.forQuery())
+	defer cancel()
+
+	if pageSize < 1 {
+		pageSize = 10
+	}
+	if page < 1 {
+		page = 1
+	}
+	offset := (page - 1) * pageSize
+
+	var totalRecords int
+	err := m.conn().QueryRowContext(ctx, m.rebind("select count(id) from payouts")).Scan(&totalRecords)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+
+	query := "select " + payoutColumns + " from payouts order by arrival_date desc, id desc limit ? offset ?"
+	rows, err := m.conn().QueryContext(ctx, m.rebind(query), pageSize, offset)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	defer rows.Close()
+
+	var payouts []*Payout
+	for rows.Next() {
+		p, err := scanPayout(rows)
+		if err != nil {
+			return nil, 0, 0, err
+		}
+		payouts = append(payouts, p)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, 0, 0, err
+	}
+
+	lastPage := totalRecords / pageSize
+	if totalRecords%pageSize != 0 {
+		lastPage++
+	}
+
+	return payouts, lastPage, totalRecords, nil
+}
+
+// GetPayout gets a payout with its lines, each with the order it paid for
+// when there is one, and the lines totalled by category
+func (m *DBModel) GetPayout(ctx context.Context, id int) (*Payout, []*PayoutLine, []PayoutTotal, error) {
+	ctx, span := tracer.Start(ctx, "DBModel.GetPayout")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, m.Timeouts.forQuery())
+	defer cancel()
+
+	row := m.conn().QueryRowContext(ctx, m.rebind("select "+payoutColumns+" from payouts where id = ?"), id)
+	p, err := scanPayout(row)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	query := `
+		select l.id, l.payout_id, l.balance_transaction_id, l.category, l.stripe_type, l.source_id, l.payment_intent,
+			coalesce(l.transaction_id, 0), coalesce(o.id, 0), l.amount, l.fee, l.net, l.description, l.created_at
+		from payout_lines l
+		left join orders o on (o.transaction_id = l.transaction_id)
+		where l.payout_id = ?
+		order by l.created_at, l.id`
+	rows, err := m.conn().QueryContext(ctx, m.rebind(query), id)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	defer rows.Close()
+
+	var lines []*PayoutLine
+	var totals []PayoutTotal
+	index := make(map[string]int)
+	for rows.Next() {
+		var l PayoutLine
+		err = rows.Scan(
+			&l.ID,
+			&l.PayoutID,
+			&l.BalanceTransactionID,
+			&l.Category,
+			&l.StripeType,
+			&l.SourceID,
+			&l.PaymentIntent,
+			&l.TransactionID,
+			&l.OrderID,
+			&l.Amount,
+			&l.Fee,
+			&l.Net,
+			&l.Description,
+			&l.CreatedAt,
+		)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		lines = append(lines, &l)
+
+		i, ok := index[l.Category]
+		if !ok {
+			i = len(totals)
+			index[l.Category] = i
+			totals = append(totals, PayoutTotal{Category: l.Category})
+		}
+		totals[i].Count++
+		totals[i].Amount += l.Amount
+		totals[i].Fee += l.Fee
+		totals[i].Net += l.Net
+	}
+	if err = rows.Err(); err != nil {
+		return nil, nil, nil, err
+	}
+
+	return p, lines, totals, nil
+}
@@ -0,0 +1,274 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"go-stripe/internal/driver"
+	"time"
+)
+
+// periods revenue can be grouped by
+const (
+	PeriodDay   = "day"
+	PeriodWeek  = "week"
+	PeriodMonth = "month"
+)
+
+// periodLabels are the expressions that label the period an order falls in,
+// like 2024-10-25, 2024-W43 or 2024-10, for each dialect. Weeks are ISO weeks
+// so a week that spans new year isn't split in two. sqlite has no ISO week
+// format, but the ISO week of a day is the week its Thursday falls in
+var periodLabels = map[driver.Dialect]map[string]string{
+	driver.MySQL: {
+		PeriodDay:   "date_format(o.created_at, '%Y-%m-%d')",
+		PeriodWeek:  "date_format(o.created_at, '%x-W%v')",
+		PeriodMonth: "date_format(o.created_at, '%Y-%m')",
+	},
+	driver.Postgres: {
+		PeriodDay:   "to_char(o.created_at, 'YYYY-MM-DD')",
+		PeriodWeek:  `to_char(o.created_at, 'IYYY-"W"IW')`,
+		PeriodMonth: "to_char(o.created_at, 'YYYY-MM')",
+	},
+	driver.SQLite: {
+		PeriodDay: "strftime('%Y-%m-%d', o.created_at)",
+		PeriodWeek: `strftime('%Y', o.created_at, '-3 days', 'weekday 4') || '-W' ||
+			printf('%02d', (strftime('%j', o.created_at, '-3 days', 'weekday 4') - 1) / 7 + 1)`,
+		PeriodMonth: "strftime('%Y-%m', o.created_at)",
+	},
+}
+
+var ErrUnknownPeriod = errors.New("period must be day, week or month")
+
+// SalesSummary is the headline figures for a date range in one currency
+type SalesSummary struct {
+	Currency          string  `json:"currency"`
+	Orders            int     `json:"orders"`
+	Gross             int     `json:"gross"`
+	RefundedOrders    int     `json:"refunded_orders"`
+	Refunds           int     `json:"refunds"`
+	Net               int     `json:"net"`
+	AverageOrderValue int     `json:"average_order_value"`
+	RefundRate        float64 `json:"refund_rate"`
+}
+
+// RevenuePoint is the sales in one currency in one day, week or month
+type RevenuePoint struct {
+	Period   string `json:"period"`
+	Currency string `json:"currency"`
+	Orders   int    `json:"orders"`
+	Gross    int    `json:"gross"`
+	Refunds  int    `json:"refunds"`
+	Net      int    `json:"net"`
+}
+
+// WidgetSales is how much of one widget was sold in one currency
+type WidgetSales struct {
+	WidgetID int    `json:"widget_id"`
+	Name     string `json:"name"`
+	Currency string `json:"currency"`
+	Orders   int    `json:"orders"`
+	Quantity int    `json:"quantity"`
+	Gross    int    `json:"gross"`
+	Refunds  int    `json:"refunds"`
+}
+
+// CustomerSales is how much one customer spent in one currency
+type CustomerSales struct {
+	Customer Customer `json:"customer"`
+	Currency string   `json:"currency"`
+	Orders   int      `json:"orders"`
+	Net      int      `json:"net"`
+}
+
+// salesColumns sums the orders selected by a report into orders, gross and
+// refunds, in the smallest currency unit as amounts are stored. Amounts in
+// different currencies can't be added up, so every report groups by the
+// currency of the order's transaction, which salesFrom joins in. An order is
+// sold from the moment it is paid for, so refunded orders stay in gross and
+// are taken off again as refunds. We don't keep how much of a partial refund
+// was given, so a partially refunded order is taken off whole rather than
+// counted as money we still have. salesWhere leaves out cancelled orders and
+// any whose payment never took the money, such as one declined, voided,
+// still pending or only authorized. Together they take salesArgs
+const salesColumns = `
+	count(o.id),
+	coalesce(sum(o.amount), 0),
+	coalesce(sum(case when ` + salesRefunded + ` then o.amount else 0 end), 0)`
+
+// salesRefunded is true for an order that has given money back, and takes
+// refundedArgs
+const salesRefunded = "(o.status_id = ? or t.transaction_status_id = ?)"
+
+const salesFrom = `
+		from orders o
+		inner join transactions t on (o.transaction_id = t.id)`
+
+const salesWhere = ` where o.status_id <> ? and t.transaction_status_id in (?, ?, ?)
+		and o.created_at >= ? and o.created_at < ?`
+
+func refundedArgs() []any {
+	return []any{OrderStatusRefunded, TransactionStatusPartiallyRefunded}
+}
+
+func salesArgs(from, to time.Time) []any {
+	return append(refundedArgs(), OrderStatusCancelled,
+		TransactionStatusCleared, TransactionStatusRefunded, TransactionStatusPartiallyRefunded, from, to)
+}
+
+// GetSalesSummary returns the headline figures for orders placed in [from,
+// to), one summary per currency
+func (m *DBModel) GetSalesSummary(ctx context.Context, from, to time.Time) ([]SalesSummary, error) {
+	ctx, span := tracer.Start(ctx, "DBModel.GetSalesSummary")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, m.Timeouts.forReport())
+	defer cancel()
+
+	query := `
+		select t.currency, ` + salesColumns + `,
+			coalesce(sum(case when ` + salesRefunded + ` then 1 else 0 end), 0)` +
+		salesFrom + salesWhere + `
+		group by t.currency
+		order by t.currency`
+	args := append(refundedArgs(), salesArgs(from, to)...)
+	rows, err := m.conn().QueryContext(ctx, m.rebind(query), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var summaries []SalesSummary
+	for rows.Next() {
+		var s SalesSummary
+		if err = rows.Scan(&s.Currency, &s.Orders, &s.Gross, &s.Refunds, &s.RefundedOrders); err != nil {
+			return nil, err
+		}
+		s.Net = s.Gross - s.Refunds
+		if s.Orders > 0 {
+			s.AverageOrderValue = s.Gross / s.Orders
+			s.RefundRate = float64(s.RefundedOrders) / float64(s.Orders)
+		}
+		summaries = append(summaries, s)
+	}
+	return summaries, rows.Err()
+}
+
+// GetRevenueByPeriod returns sales for orders placed in [from, to) grouped by
+// day, week or month and currency, oldest first. Periods with no orders are
+// left out
+func (m *DBModel) GetRevenueByPeriod(ctx context.Context, from, to time.Time, period string) ([]RevenuePoint, error) {
+	ctx, span := tracer.Start(ctx, "DBModel.GetRevenueByPeriod")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, m.Timeouts.forReport())
+	defer cancel()
+
+	label, ok := periodLabels[m.dialect()][period]
+	if !ok {
+		return nil, ErrUnknownPeriod
+	}
+
+	query := `
+		select ` + label + ` as period, t.currency, ` + salesColumns +
+		salesFrom + salesWhere + `
+		group by period, t.currency
+		order by period, t.currency`
+	rows, err := m.conn().QueryContext(ctx, m.rebind(query), salesArgs(from, to)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var points []RevenuePoint
+	for rows.Next() {
+		var p RevenuePoint
+		if err = rows.Scan(&p.Period, &p.Currency, &p.Orders, &p.Gross, &p.Refunds); err != nil {
+			return nil, err
+		}
+		p.Net = p.Gross - p.Refunds
+		points = append(points, p)
+	}
+	return points, rows.Err()
+}
+
+// GetSalesByWidget returns sales for orders placed in [from, to) by widget
+// and currency, best selling first in each currency
+func (m *DBModel) GetSalesByWidget(ctx context.Context, from, to time.Time) ([]WidgetSales, error) {
+	ctx, span := tracer.Start(ctx, "DBModel.GetSalesByWidget")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, m.Timeouts.forReport())
+	defer cancel()
+
+	query := `
+		select w.id, w.name, t.currency, ` + salesColumns + `, coalesce(sum(o.quantity), 0)` +
+		salesFrom + `
+		inner join widgets w on (o.widget_id = w.id)` + salesWhere + `
+		group by w.id, w.name, t.currency
+		order by t.currency, 5 desc, w.name`
+	rows, err := m.conn().QueryContext(ctx, m.rebind(query), salesArgs(from, to)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sales []WidgetSales
+	for rows.Next() {
+		var s WidgetSales
+		if err = rows.Scan(&s.WidgetID, &s.Name, &s.Currency, &s.Orders, &s.Gross, &s.Refunds, &s.Quantity); err != nil {
+			return nil, err
+		}
+		sales = append(sales, s)
+	}
+	return sales, rows.Err()
+}
+
+// GetTopCustomers returns the customers who spent the most, after refunds, on
+// orders placed in [from, to), up to limit of them in each currency
+func (m *DBModel) GetTopCustomers(ctx context.Context, from, to time.Time, limit int) ([]CustomerSales, error) {
+	ctx, span := tracer.Start(ctx, "DBModel.GetTopCustomers")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, m.Timeouts.forReport())
+	defer cancel()
+
+	if limit < 1 {
+		limit = 10
+	}
+
+	query := `
+		select c.id, c.first_name, c.last_name, c.email, t.currency, ` + salesColumns +
+		salesFrom + `
+		inner join customers c on (o.customer_id = c.id)` + salesWhere + `
+		group by c.id, c.first_name, c.last_name, c.email, t.currency
+		order by t.currency, sum(o.amount) - sum(case when ` + salesRefunded + ` then o.amount else 0 end) desc, c.id`
+	args := append(salesArgs(from, to), refundedArgs()...)
+	rows, err := m.conn().QueryContext(ctx, m.rebind(query), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var customers []CustomerSales
+	perCurrency := make(map[string]int)
+	for rows.Next() {
+		var s CustomerSales
+		var gross, refunds int
+		err = rows.Scan(
+			&s.Customer.ID,
+			&s.Customer.FirstName,
+			&s.Customer.LastName,
+			&s.Customer.Email,
+			&s.Currency,
+			&s.Orders,
+			&gross,
+			&refunds,
+		)
+		if err != nil {
+			return nil, err
+		}
+		if perCurrency[s.Currency] == limit {
+			continue
+		}
+		perCurrency[s.Currency]++
+		s.Net = gross - refunds
+		customers = append(customers, s)
+	}
+	return customers, rows.Err()
+}
@@ -0,0 +1,145 @@
+package models
+
+import (
+	"context"
+	"time"
+)
+
+// WidgetRepo stores the widgets we sell
+type WidgetRepo interface {
+	GetWidget(ctx context.Context, id int) (Widget, error)
+	UpdateWidget(ctx context.Context, widget Widget) error
+}
+
+// OrderRepo stores orders, their history and their invoices
+type OrderRepo interface {
+	InsertOrder(ctx context.Context, order Order) (int, error)
+	GetOrder(ctx context.Context, id int) (Order, error)
+	GetOrderByTransactionID(ctx context.Context, txnID int) (Order, error)
+	GetOrderByReceiptToken(ctx context.Context, token string) (Order, error)
+	GetOrderDetail(ctx context.Context, id int) (*Order, error)
+	GetAllOrdersPaginated(ctx context.Context, filter OrderFilter, pageSize, page int) ([]*Order, int, int, error)
+	CaptureOrder(ctx context.Context, order Order, amount int, bankReturnCode string) error
+	ChangeOrderStatus(ctx context.Context, orderID int, c OrderChange) error
+	RevertOrderStatus(ctx context.Context, orderID int, c OrderChange) error
+	SettlePendingOrder(ctx context.Context, orderID int, paid bool) error
+	GetOrderEvents(ctx context.Context, orderID int) ([]*OrderEvent, error)
+	GetOrCreateInvoice(ctx context.Context, orderID int) (Invoice, error)
+	EachOrder(ctx context.Context, filter OrderFilter, fn func(*Order) error) error
+}
+
+// TransactionRepo stores the payments taken for orders and at the terminal
+type TransactionRepo interface {
+	InsertTransaction(ctx context.Context, txn Transaction) (int, error)
+	GetTransaction(ctx context.Context, id int) (Transaction, error)
+	GetTransactionByPaymentIntent(ctx context.Context, paymentIntent string) (Transaction, error)
+	GetTransactionsCreatedBetween(ctx context.Context, from, to time.Time) ([]Transaction, error)
+	UpdateTransactionStatus(ctx context.Context, id, statusID int, bankReturnCode string) error
+	GetTerminalChargesPaginated(ctx context.Context, pageSize, page int) ([]*TerminalCharge, int, int, error)
+	EachTransaction(ctx context.Context, filter TransactionFilter, fn func(*TransactionDetail) error) error
+}
+
+// CustomerRepo stores the people who have bought from us
+type CustomerRepo interface {
+	InsertCustomer(ctx context.Context, c Customer) (int, error)
+	GetCustomer(ctx context.Context, id int) (Customer, error)
+	GetCustomerByEmail(ctx context.Context, email string) (Customer, error)
+}
+
+// UserRepo stores admin users and their authentication tokens
+type UserRepo interface {
+	GetUserByEmail(ctx context.Context, email string) (User, error)
+	Authenticate(ctx context.Context, email, password string) (int, error)
+	InsertToken(ctx context.Context, t *Token, u User) error
+	GetUserForToken(ctx context.Context, token string) (*User, error)
+}
+
+// CouponRepo stores discount codes
+type CouponRepo interface {
+	GetCouponByCode(ctx context.Context, code string) (Coupon, error)
+	ReserveCoupon(ctx context.Context, code, paymentIntent string, until time.Time) error
+	ConfirmCoupon(ctx context.Context, paymentIntent string) error
+	ReleaseCoupon(ctx context.Context, paymentIntent string) error
+}
+
+// ReportRepo totals up sales for the reports
+type ReportRepo interface {
+	GetSalesSummary(ctx context.Context, from, to time.Time) ([]SalesSummary, error)
+	GetRevenueByPeriod(ctx context.Context, from, to time.Time, period string) ([]RevenuePoint, error)
+	GetSalesByWidget(ctx context.Context, from, to time.Time) ([]WidgetSales, error)
+	GetTopCustomers(ctx context.Context, from, to time.Time, limit int) ([]CustomerSales, error)
+}
+
+// DisputeRepo stores chargebacks and the evidence we send for them
+type DisputeRepo interface {
+	SaveDispute(ctx context.Context, d Dispute) error
+	GetDispute(ctx context.Context, id int) (*Dispute, error)
+	GetDisputesPaginated(ctx context.Context, status string, pageSize, page int) ([]*Dispute, int, int, error)
+	SaveDisputeEvidence(ctx context.Context, id int, evidence DisputeEvidence, submitted bool) error
+	InsertDisputeFile(ctx context.Context, f DisputeFile) (int, error)
+}
+
+// PayoutRepo stores the money stripe sends to our bank account
+type PayoutRepo interface {
+	SavePayout(ctx context.Context, p Payout, lines []PayoutLine) (int, error)
+	GetPayoutsPaginated(ctx context.Context, pageSize, page int) ([]*Payout, int, int, error)
+	GetPayout(ctx context.Context, id int) (*Payout, []*PayoutLine, []PayoutTotal, error)
+}
+
+// ImportRepo applies bulk imports
+type ImportRepo interface {
+	ImportWidgets(ctx context.Context, rows []WidgetImport, dryRun bool) ([]WidgetImportResult, bool, error)
+}
+
+// AuditRepo stores the audit log
+type AuditRepo interface {
+	InsertAuditEntry(ctx context.Context, e AuditEntry) error
+	GetAuditEntries(ctx context.Context, f AuditFilter, pageSize, page int) ([]*AuditEntry, int, int, error)
+}
+
+// Models holds the repositories the handlers work with. Lookups that find
+// nothing return sql.ErrNoRows whichever store is behind them
+type Models struct {
+	Widgets      WidgetRepo
+	Orders       OrderRepo
+	Transactions TransactionRepo
+	Customers    CustomerRepo
+	Users        UserRepo
+	Coupons      CouponRepo
+	Reports      ReportRepo
+	Disputes     DisputeRepo
+	Payouts      PayoutRepo
+	Imports      ImportRepo
+	Audit        AuditRepo
+}
+
+// Models returns the repositories backed by the database
+func (m *DBModel) Models() Models {
+	return Models{
+		Widgets:      m,
+		Orders:       m,
+		Transactions: m,
+		Customers:    m,
+		Users:        m,
+		Coupons:      m,
+		Reports:      m,
+		Disputes:     m,
+		Payouts:      m,
+		Imports:      m,
+		Audit:        m,
+	}
+}
+
+var (
+	_ WidgetRepo      = (*DBModel)(nil)
+	_ OrderRepo       = (*DBModel)(nil)
+	_ TransactionRepo = (*DBModel)(nil)
+	_ CustomerRepo    = (*DBModel)(nil)
+	_ UserRepo        = (*DBModel)(nil)
+	_ CouponRepo      = (*DBModel)(nil)
+	_ ReportRepo      = (*DBModel)(nil)
+	_ DisputeRepo     = (*DBModel)(nil)
+	_ PayoutRepo      = (*DBModel)(nil)
+	_ ImportRepo      = (*DBModel)(nil)
+	_ AuditRepo       = (*DBModel)(nil)
+)
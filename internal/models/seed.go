@@ -0,0 +1,47 @@
+package models
+
+import "time"
+
+// Seed is what MemoryStore.Seed loaded: the widget and admin user the
+// migrations seed a database with, and a coupon for it
+type Seed struct {
+	Widget        Widget
+	Admin         User
+	AdminPassword string
+	Coupon        Coupon
+}
+
+// Seed loads s with the same widget and admin user as a freshly migrated
+// database, plus a 10% coupon, so tests start from known data
+func (s *MemoryStore) Seed() (Seed, error) {
+	seed := Seed{
+		Widget: Widget{
+			Name:           "widget",
+			Description:    "A very nice widget",
+			InventoryLevel: 10,
+			Price:          1000000,
+		},
+		Admin: User{
+			FirstName: "Admin",
+			LastName:  "User",
+			Email:     "admin@example.com",
+		},
+		AdminPassword: "password",
+		Coupon: Coupon{
+			Code:      "SAVE10",
+			Kind:      CouponPercent,
+			Value:     10,
+			ExpiresAt: time.Now().AddDate(1, 0, 0),
+			MaxUses:   100,
+		},
+	}
+
+	seed.Widget.ID = s.AddWidget(seed.Widget)
+	seed.Coupon.ID = s.AddCoupon(seed.Coupon)
+	id, err := s.AddUser(seed.Admin, seed.AdminPassword)
+	if err != nil {
+		return seed, err
+	}
+	seed.Admin.ID = id
+	return seed, nil
+}
@@ -0,0 +1,89 @@
+package models
+
+import (
+	"context"
+	"time"
+)
+
+// TerminalCharge is a virtual terminal transaction with the customer who was
+// charged and the operator who ran it
+type TerminalCharge struct {
+	Transaction
+	Status        string    `json:"status"`
+	Customer      Customer  `json:"customer"`
+	OperatorName  string    `json:"operator_name"`
+	OperatorEmail string    `json:"operator_email"`
+	ChargedAt     time.Time `json:"created_at"`
+}
+
+// GetTerminalChargesPaginated returns one page of virtual terminal charges,
+// newest first, along with the number of the last page and the total number
+// of terminal charges
+func (m *DBModel) GetTerminalChargesPaginated(ctx context.Context, pageSize, page int) ([]*TerminalCharge, int, int, error) {
+	ctx, span := tracer.Start(ctx, "DBModel.GetTerminalChargesPaginated")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, m.Timeouts.forQuery())
+	defer cancel()
+
+	if pageSize < 1 {
+		pageSize = 10
+	}
+	if page < 1 {
+		page = 1
+	}
+	offset := (page - 1) * pageSize
+
+	var totalRecords int
+	err := m.conn().QueryRowContext(ctx, m.rebind("select count(id) from transactions where source = ?"), TransactionSourceTerminal).Scan(&totalRecords)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+
+	query := `
+		select ` + transactionColumns + `, coalesce(ts.name, ''),
+			coalesce(c.id, 0), coalesce(c.first_name, ''), coalesce(c.last_name, ''), coalesce(c.email, ''),
+			coalesce(concat(u.first_name, ' ', u.last_name), ''), coalesce(u.email, '')
+		from transactions t
+		left join transaction_statuses ts on (t.transaction_status_id = ts.id)
+		left join customers c on (t.customer_id = c.id)
+		left join users u on (t.user_id = u.id)
+		where t.source = ?
+		order by t.created_at desc, t.id desc
+		limit ? offset ?`
+
+	rows, err := m.conn().QueryContext(ctx, m.rebind(query), TransactionSourceTerminal, pageSize, offset)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	defer rows.Close()
+
+	var charges []*TerminalCharge
+	for rows.Next() {
+		var c TerminalCharge
+		fields := append(transactionFields(&c.Transaction),
+			&c.Status,
+			&c.Customer.ID,
+			&c.Customer.FirstName,
+			&c.Customer.LastName,
+			&c.Customer.Email,
+			&c.OperatorName,
+			&c.OperatorEmail,
+		)
+		err = rows.Scan(fields...)
+		if err != nil {
+			return nil, 0, 0, err
+		}
+		c.ChargedAt = c.CreatedAt
+		charges = append(charges, &c)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, 0, 0, err
+	}
+
+	lastPage := totalRecords / pageSize
+	if totalRecords%pageSize != 0 {
+		lastPage++
+	}
+
+	return charges, lastPage, totalRecords, nil
+}
@@ -0,0 +1,88 @@
+package models
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"strings"
+	"time"
+)
+
+const (
+	ScopeAuthentication = "authentication"
+)
+
+// Token is the type for authentication tokens
+type Token struct {
+	PlainText string    `json:"token"`
+	UserID    int64     `json:"-"`
+	Hash      []byte    `json:"-"`
+	Expiry    time.Time `json:"expiry"`
+	Scope     string    `json:"-"`
+}
+
+// GenerateToken generates a token that lasts for ttl, and returns it
+func GenerateToken(userID int, ttl time.Duration, scope string) (*Token, error) {
+	token := &Token{
+		UserID: int64(userID),
+		Expiry: time.Now().Add(ttl),
+		Scope:  scope,
+	}
+
+	randomBytes := make([]byte, 16)
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	token.PlainText = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes)
+	hash := sha256.Sum256([]byte(token.PlainText))
+	token.Hash = hash[:]
+	return token, nil
+}
+
+// InsertToken stores the hash of a token for user
+func (m *DBModel) InsertToken(ctx context.Context, t *Token, u User) error {
+	ctx, span := tracer.Start(ctx, "DBModel.InsertToken")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, m.Timeouts.forQuery())
+	defer cancel()
+
+	stmt := "insert into tokens (user_id,name,email,token_hash,expiry,created_at,updated_at) values(?,?,?,?,?,?,?)"
+
+	name := strings.TrimSpace(u.FirstName + " " + u.LastName)
+	_, err := m.conn().ExecContext(ctx, m.rebind(stmt), u.ID, name, u.Email, t.Hash, t.Expiry, time.Now(), time.Now())
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// GetUserForToken returns the user a token that hasn't expired belongs to
+func (m *DBModel) GetUserForToken(ctx context.Context, token string) (*User, error) {
+	ctx, span := tracer.Start(ctx, "DBModel.GetUserForToken")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, m.Timeouts.forQuery())
+	defer cancel()
+
+	tokenHash := sha256.Sum256([]byte(token))
+	var user User
+
+	query := `
+		select u.id, u.first_name, u.last_name, u.email
+		from users u
+		inner join tokens t on (u.id = t.user_id)
+		where t.token_hash = ? and t.expiry > ?`
+
+	err := m.conn().QueryRowContext(ctx, m.rebind(query), tokenHash[:], time.Now()).Scan(
+		&user.ID,
+		&user.FirstName,
+		&user.LastName,
+		&user.Email,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
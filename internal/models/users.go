@@ -0,0 +1,66 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"golang.org/x/crypto/bcrypt"
+	"strings"
+)
+
+// ErrInvalidCredentials is returned when an email and password don't match a user
+var ErrInvalidCredentials = errors.New("invalid credentials")
+
+// GetUserByEmail gets a user by email address
+func (m *DBModel) GetUserByEmail(ctx context.Context, email string) (User, error) {
+	ctx, span := tracer.Start(ctx, "DBModel.GetUserByEmail")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, m.Timeouts.forQuery())
+	defer cancel()
+
+	email = strings.ToLower(email)
+	var u User
+
+	row := m.conn().QueryRowContext(ctx, m.rebind("select id,first_name,last_name,email,password,created_at,updated_at from users where email=?"), email)
+	err := row.Scan(
+		&u.ID,
+		&u.FirstName,
+		&u.LastName,
+		&u.Email,
+		&u.Password,
+		&u.CreatedAt,
+		&u.UpdatedAt,
+	)
+	if err != nil {
+		return u, err
+	}
+	return u, nil
+}
+
+// Authenticate checks an email and password and returns the id of the user
+func (m *DBModel) Authenticate(ctx context.Context, email, password string) (int, error) {
+	ctx, span := tracer.Start(ctx, "DBModel.Authenticate")
+	defer span.End()
+	u, err := m.GetUserByEmail(ctx, email)
+	return checkPassword(u, err, password)
+}
+
+// checkPassword compares password with the hash of u, which was looked up by
+// email with the error err, so every store authenticates the same way
+func checkPassword(u User, err error, password string) (int, error) {
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, ErrInvalidCredentials
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	err = bcrypt.CompareHashAndPassword([]byte(u.Password), []byte(password))
+	if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+		return 0, ErrInvalidCredentials
+	}
+	if err != nil {
+		return 0, err
+	}
+	return u.ID, nil
+}
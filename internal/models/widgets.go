@@ -0,0 +1,252 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// what an import did with each row
+const (
+	ImportCreate    = "create"
+	ImportUpdate    = "update"
+	ImportUnchanged = "unchanged"
+	ImportError     = "error"
+)
+
+// WidgetImport is one row of a widget import. Nil fields are left as they
+// are on an existing widget. Errors holds any problems found reading the row,
+// which stop the whole import from being applied
+type WidgetImport struct {
+	Line           int
+	SKU            string
+	Name           string
+	Description    *string
+	InventoryLevel *int
+	Price          *int
+	Image          *string
+	Errors         []string
+}
+
+// WidgetImportResult is what happened, or would happen on a dry run, to the
+// widget named by one import row
+type WidgetImportResult struct {
+	Line     int      `json:"line"`
+	Action   string   `json:"action"`
+	WidgetID int      `json:"widget_id,omitempty"`
+	SKU      string   `json:"sku"`
+	Name     string   `json:"name"`
+	Errors   []string `json:"errors,omitempty"`
+	Before   *Widget  `json:"before,omitempty"`
+	After    *Widget  `json:"after,omitempty"`
+}
+
+// ImportWidgets creates or updates a widget for each row in one database
+// transaction. A row matches an existing widget by SKU, or by name when the
+// row has no SKU or its SKU is new. The transaction is only committed when
+// every row is valid and dryRun is false, and applied reports whether it was
+func (m *DBModel) ImportWidgets(ctx context.Context, rows []WidgetImport, dryRun bool) (results []WidgetImportResult, applied bool, err error) {
+	ctx, span := tracer.Start(ctx, "DBModel.ImportWidgets")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, m.Timeouts.forImport())
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, false, err
+	}
+	defer tx.Rollback()
+
+	failed := false
+	for _, row := range rows {
+		res := m.importWidget(ctx, tx, row)
+		if res.Action == ImportError {
+			failed = true
+		}
+		results = append(results, res)
+	}
+	if err = ctx.Err(); err != nil {
+		return nil, false, err
+	}
+
+	if failed || dryRun {
+		return results, false, nil
+	}
+	if err = tx.Commit(); err != nil {
+		return nil, false, err
+	}
+	return results, true, nil
+}
+
+var errNoImportMatch = errors.New("no widget matches")
+
+// importConflict is a row that matches widgets in a way we can't resolve
+type importConflict string
+
+func (c importConflict) Error() string {
+	return string(c)
+}
+
+// importWidget applies one row inside the import transaction
+func (m *DBModel) importWidget(ctx context.Context, tx *sql.Tx, row WidgetImport) WidgetImportResult {
+	res := WidgetImportResult{
+		Line:   row.Line,
+		SKU:    row.SKU,
+		Name:   row.Name,
+		Errors: row.Errors,
+	}
+	fail := func(msg string) WidgetImportResult {
+		res.Action = ImportError
+		res.Errors = append(res.Errors, msg)
+		return res
+	}
+	if len(res.Errors) > 0 {
+		res.Action = ImportError
+		return res
+	}
+
+	before, err := m.findImportWidget(ctx, tx, row)
+	var conflict importConflict
+	if errors.As(err, &conflict) {
+		return fail(conflict.Error())
+	}
+	if err != nil && !errors.Is(err, errNoImportMatch) {
+		return fail("could not look up widget")
+	}
+
+	var after Widget
+	if err == nil {
+		after = before
+		res.WidgetID = before.ID
+		res.Before = &before
+	} else if row.Name == "" || row.Price == nil {
+		return fail("a new widget needs a name and a price")
+	}
+
+	after = importedWidget(after, row)
+	res.After = &after
+
+	now := time.Now()
+	switch {
+	case res.Before == nil:
+		res.Action = ImportCreate
+		stmt := `insert into widgets (sku,name,description,inventory_level,price,image,created_at,updated_at)
+			values(nullif(?, ''),?,?,?,?,?,?,?)`
+		id, err := m.insertID(ctx, tx, stmt, after.SKU, after.Name, after.Description, after.InventoryLevel, after.Price, after.Image, now, now)
+		if err != nil {
+			return fail("could not create widget")
+		}
+		res.WidgetID = id
+		after.ID = id
+	case widgetUnchanged(before, after):
+		res.Action = ImportUnchanged
+	default:
+		res.Action = ImportUpdate
+		stmt := `update widgets set sku=nullif(?, ''), name=?, description=?, inventory_level=?, price=?, image=?, updated_at=?
+			where id=?`
+		_, err := tx.ExecContext(ctx, m.rebind(stmt), after.SKU, after.Name, after.Description, after.InventoryLevel, after.Price, after.Image, now, after.ID)
+		if err != nil {
+			return fail("could not update widget")
+		}
+	}
+	return res
+}
+
+// findImportWidget finds the widget an import row refers to, locking it for
+// the rest of the import
+func (m *DBModel) findImportWidget(ctx context.Context, tx *sql.Tx, row WidgetImport) (Widget, error) {
+	if row.SKU != "" {
+		w, err := scanWidget(tx.QueryRowContext(ctx, m.rebind("select "+widgetColumns+" from widgets where sku = ?"+m.forUpdate()), row.SKU))
+		if err == nil {
+			return w, nil
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			return w, err
+		}
+	}
+	if row.Name == "" {
+		return Widget{}, errNoImportMatch
+	}
+
+	rows, err := tx.QueryContext(ctx, m.rebind("select "+widgetColumns+" from widgets where name = ?"+m.forUpdate()), row.Name)
+	if err != nil {
+		return Widget{}, err
+	}
+	defer rows.Close()
+
+	var matches []Widget
+	for rows.Next() {
+		w, err := scanWidget(rows)
+		if err != nil {
+			return Widget{}, err
+		}
+		matches = append(matches, w)
+	}
+	if err = rows.Err(); err != nil {
+		return Widget{}, err
+	}
+
+	return pickNameMatch(row, matches)
+}
+
+// pickNameMatch chooses the widget an import row refers to from the widgets
+// with the row's name
+func pickNameMatch(row WidgetImport, matches []Widget) (Widget, error) {
+	switch {
+	case len(matches) == 0:
+		return Widget{}, errNoImportMatch
+	case len(matches) > 1:
+		return Widget{}, importConflict(fmt.Sprintf("%d widgets are called %q, give a SKU to choose one", len(matches), row.Name))
+	case row.SKU != "" && matches[0].SKU != "":
+		return Widget{}, importConflict(fmt.Sprintf("%q already has SKU %s", row.Name, matches[0].SKU))
+	}
+	return matches[0], nil
+}
+
+// importedWidget is w with the fields an import row sets changed
+func importedWidget(w Widget, row WidgetImport) Widget {
+	if row.SKU != "" {
+		w.SKU = row.SKU
+	}
+	if row.Name != "" {
+		w.Name = row.Name
+	}
+	if row.Description != nil {
+		w.Description = *row.Description
+	}
+	if row.InventoryLevel != nil {
+		w.InventoryLevel = *row.InventoryLevel
+	}
+	if row.Price != nil {
+		w.Price = *row.Price
+	}
+	if row.Image != nil {
+		w.Image = *row.Image
+	}
+	return w
+}
+
+func widgetUnchanged(a, b Widget) bool {
+	return a.SKU == b.SKU &&
+		a.Name == b.Name &&
+		a.Description == b.Description &&
+		a.InventoryLevel == b.InventoryLevel &&
+		a.Price == b.Price &&
+		a.Image == b.Image
+}
+
+// ImportWidgetsSummary counts import results by action
+func ImportWidgetsSummary(results []WidgetImportResult) map[string]int {
+	counts := map[string]int{
+		ImportCreate:    0,
+		ImportUpdate:    0,
+		ImportUnchanged: 0,
+		ImportError:     0,
+	}
+	for _, r := range results {
+		counts[r.Action]++
+	}
+	return counts
+}
@@ -0,0 +1,199 @@
+package pricing
+
+import (
+	"errors"
+	"fmt"
+	"go-stripe/internal/models"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrInvalidQuantity = errors.New("quantity must be at least 1")
+	ErrCouponExpired   = errors.New("this coupon has expired")
+	ErrCouponUsedUp    = errors.New("this coupon has already been used the maximum number of times")
+	ErrCouponInvalid   = errors.New("this coupon is not valid")
+)
+
+// TaxRule is the tax charged in one country. Rate is in basis points, so
+// 11% PPN is 1100. Inclusive rules mean the price already contains the tax
+type TaxRule struct {
+	Country   string
+	Rate      int
+	Inclusive bool
+}
+
+// TaxRules holds one rule per ISO country code
+type TaxRules map[string]TaxRule
+
+// ParseTaxRules reads rules written as COUNTRY:RATE:MODE separated by commas,
+// for example "ID:11:exclusive,SG:9:inclusive"
+func ParseTaxRules(s string) (TaxRules, error) {
+	rules := TaxRules{}
+	for _, field := range strings.Split(s, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		parts := strings.Split(field, ":")
+		if len(parts) != 3 {
+			return nil, fmt.Errorf("pricing: invalid tax rule %q", field)
+		}
+		rate, err := strconv.ParseFloat(parts[1], 64)
+		if err != nil || rate < 0 {
+			return nil, fmt.Errorf("pricing: invalid tax rate in %q", field)
+		}
+		var inclusive bool
+		switch strings.ToLower(parts[2]) {
+		case "inclusive":
+			inclusive = true
+		case "exclusive":
+		default:
+			return nil, fmt.Errorf("pricing: tax mode in %q must be inclusive or exclusive", field)
+		}
+		country := strings.ToUpper(parts[0])
+		rules[country] = TaxRule{
+			Country:   country,
+			Rate:      int(math.Round(rate * 100)),
+			Inclusive: inclusive,
+		}
+	}
+	return rules, nil
+}
+
+// Engine computes checkout totals
+type Engine struct {
+	Rules          TaxRules
+	DefaultCountry string
+}
+
+// Breakdown is how a total was arrived at. All amounts are in the smallest
+// currency unit, the same as Transaction.Amount
+type Breakdown struct {
+	WidgetID     int    `json:"widget_id"`
+	UnitPrice    int    `json:"unit_price"`
+	Quantity     int    `json:"quantity"`
+	Subtotal     int    `json:"subtotal"`
+	CouponCode   string `json:"coupon_code,omitempty"`
+	Discount     int    `json:"discount"`
+	Country      string `json:"country"`
+	TaxRate      int    `json:"tax_rate"`
+	TaxInclusive bool   `json:"tax_inclusive"`
+	Tax          int    `json:"tax"`
+	Total        int    `json:"total"`
+}
+
+// Compute prices quantity items at unitPrice for a buyer in country, applying
+// coupon if it is not nil
+func (e *Engine) Compute(unitPrice, quantity int, country string, coupon *models.Coupon, now time.Time) (Breakdown, error) {
+	var b Breakdown
+	if quantity < 1 {
+		return b, ErrInvalidQuantity
+	}
+	if country == "" {
+		country = e.DefaultCountry
+	}
+	country = strings.ToUpper(country)
+
+	b.UnitPrice = unitPrice
+	b.Quantity = quantity
+	b.Subtotal = unitPrice * quantity
+	b.Country = country
+
+	if coupon != nil {
+		if err := CheckCoupon(*coupon, now); err != nil {
+			return b, err
+		}
+		b.CouponCode = coupon.Code
+		b.Discount = discount(*coupon, b.Subtotal)
+	}
+
+	taxable := b.Subtotal - b.Discount
+	rule, ok := e.Rules[country]
+	if ok {
+		b.TaxRate = rule.Rate
+		b.TaxInclusive = rule.Inclusive
+	}
+
+	if rule.Inclusive {
+		net := int(math.Round(float64(taxable) * 10000 / float64(10000+rule.Rate)))
+		b.Tax = taxable - net
+		b.Total = taxable
+	} else {
+		b.Tax = int(math.Round(float64(taxable) * float64(rule.Rate) / 10000))
+		b.Total = taxable + b.Tax
+	}
+
+	return b, nil
+}
+
+// CheckCoupon reports why a coupon can't be used, or nil if it can
+func CheckCoupon(c models.Coupon, now time.Time) error {
+	if c.Kind != models.CouponPercent && c.Kind != models.CouponFixed {
+		return ErrCouponInvalid
+	}
+	if !c.ExpiresAt.IsZero() && now.After(c.ExpiresAt) {
+		return ErrCouponExpired
+	}
+	if c.MaxUses > 0 && c.TimesUsed >= c.MaxUses {
+		return ErrCouponUsedUp
+	}
+	return nil
+}
+
+func discount(c models.Coupon, subtotal int) int {
+	var d int
+	switch c.Kind {
+	case models.CouponPercent:
+		d = int(math.Round(float64(subtotal) * float64(c.Value) / 100))
+	case models.CouponFixed:
+		d = c.Value
+	}
+	if d > subtotal {
+		d = subtotal
+	}
+	if d < 0 {
+		d = 0
+	}
+	return d
+}
+
+// Metadata returns the breakdown as Stripe metadata, so that whoever records
+// the payment afterwards gets the figures the server priced, not the browser
+func (b Breakdown) Metadata() map[string]string {
+	return map[string]string{
+		"widget_id":     strconv.Itoa(b.WidgetID),
+		"unit_price":    strconv.Itoa(b.UnitPrice),
+		"quantity":      strconv.Itoa(b.Quantity),
+		"subtotal":      strconv.Itoa(b.Subtotal),
+		"coupon_code":   b.CouponCode,
+		"discount":      strconv.Itoa(b.Discount),
+		"country":       b.Country,
+		"tax_rate":      strconv.Itoa(b.TaxRate),
+		"tax_inclusive": strconv.FormatBool(b.TaxInclusive),
+		"tax":           strconv.Itoa(b.Tax),
+		"total":         strconv.Itoa(b.Total),
+	}
+}
+
+// FromMetadata reads back a breakdown written by Metadata. ok is false when
+// the payment intent was not priced by the engine, e.g. terminal charges
+func FromMetadata(md map[string]string) (b Breakdown, ok bool) {
+	if _, found := md["total"]; !found {
+		return b, false
+	}
+	b.WidgetID, _ = strconv.Atoi(md["widget_id"])
+	b.UnitPrice, _ = strconv.Atoi(md["unit_price"])
+	b.Quantity, _ = strconv.Atoi(md["quantity"])
+	b.Subtotal, _ = strconv.Atoi(md["subtotal"])
+	b.CouponCode = md["coupon_code"]
+	b.Discount, _ = strconv.Atoi(md["discount"])
+	b.Country = md["country"]
+	b.TaxRate, _ = strconv.Atoi(md["tax_rate"])
+	b.TaxInclusive, _ = strconv.ParseBool(md["tax_inclusive"])
+	b.Tax, _ = strconv.Atoi(md["tax"])
+	b.Total, _ = strconv.Atoi(md["total"])
+	return b, true
+}
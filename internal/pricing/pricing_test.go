@@ -0,0 +1,109 @@
+package pricing
+
+import (
+	"go-stripe/internal/models"
+	"testing"
+	"time"
+)
+
+func TestCompute(t *testing.T) {
+	now := time.Date(2024, 10, 22, 9, 0, 0, 0, time.UTC)
+	percent := &models.Coupon{Code: "SAVE10", Kind: models.CouponPercent, Value: 10}
+	fixed := &models.Coupon{Code: "MINUS50K", Kind: models.CouponFixed, Value: 50000}
+	engine := Engine{
+		Rules: TaxRules{
+			"ID": {Country: "ID", Rate: 1100},
+			"SG": {Country: "SG", Rate: 900, Inclusive: true},
+		},
+		DefaultCountry: "ID",
+	}
+
+	tests := []struct {
+		name     string
+		unit     int
+		quantity int
+		country  string
+		coupon   *models.Coupon
+
+		want    Breakdown
+		wantErr error
+	}{
+		{
+			name: "exclusive tax", unit: 1000000, quantity: 2,
+			want: Breakdown{UnitPrice: 1000000, Quantity: 2, Subtotal: 2000000, Country: "ID", TaxRate: 1100, Tax: 220000, Total: 2220000},
+		},
+		{
+			name: "exclusive tax after a percent coupon", unit: 1000000, quantity: 2, coupon: percent,
+			want: Breakdown{UnitPrice: 1000000, Quantity: 2, Subtotal: 2000000, CouponCode: "SAVE10", Discount: 200000,
+				Country: "ID", TaxRate: 1100, Tax: 198000, Total: 1998000},
+		},
+		{
+			name: "inclusive tax", unit: 1000000, quantity: 1, country: "sg",
+			want: Breakdown{UnitPrice: 1000000, Quantity: 1, Subtotal: 1000000, Country: "SG", TaxRate: 900, TaxInclusive: true,
+				Tax: 82569, Total: 1000000},
+		},
+		{
+			name: "inclusive tax after a fixed coupon", unit: 1000000, quantity: 1, country: "SG", coupon: fixed,
+			want: Breakdown{UnitPrice: 1000000, Quantity: 1, Subtotal: 1000000, CouponCode: "MINUS50K", Discount: 50000,
+				Country: "SG", TaxRate: 900, TaxInclusive: true, Tax: 78440, Total: 950000},
+		},
+		{
+			name: "country without a rule", unit: 1000000, quantity: 1, country: "MY",
+			want: Breakdown{UnitPrice: 1000000, Quantity: 1, Subtotal: 1000000, Country: "MY", Total: 1000000},
+		},
+		{
+			name: "discount capped at the subtotal", unit: 20000, quantity: 1, coupon: fixed,
+			want: Breakdown{UnitPrice: 20000, Quantity: 1, Subtotal: 20000, CouponCode: "MINUS50K", Discount: 20000,
+				Country: "ID", TaxRate: 1100},
+		},
+		{
+			name: "rounding", unit: 333, quantity: 1,
+			want: Breakdown{UnitPrice: 333, Quantity: 1, Subtotal: 333, Country: "ID", TaxRate: 1100, Tax: 37, Total: 370},
+		},
+		{name: "no quantity", unit: 1000000, quantity: 0, wantErr: ErrInvalidQuantity},
+		{
+			name: "expired coupon", unit: 1000000, quantity: 1,
+			coupon:  &models.Coupon{Kind: models.CouponPercent, Value: 10, ExpiresAt: now.Add(-time.Second)},
+			wantErr: ErrCouponExpired,
+		},
+		{
+			name: "used up coupon", unit: 1000000, quantity: 1,
+			coupon:  &models.Coupon{Kind: models.CouponPercent, Value: 10, MaxUses: 5, TimesUsed: 5},
+			wantErr: ErrCouponUsedUp,
+		},
+		{
+			name: "unknown coupon kind", unit: 1000000, quantity: 1,
+			coupon:  &models.Coupon{Kind: "free", Value: 10},
+			wantErr: ErrCouponInvalid,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := engine.Compute(tt.unit, tt.quantity, tt.country, tt.coupon, now)
+			if err != tt.wantErr {
+				t.Fatalf("error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				return
+			}
+			if got != tt.want {
+				t.Errorf("Compute = %+v\nwant      %+v", got, tt.want)
+			}
+			if got.Total != got.Subtotal-got.Discount+got.Tax && !got.TaxInclusive {
+				t.Errorf("total %d is not subtotal less discount plus tax", got.Total)
+			}
+		})
+	}
+}
+
+func TestMetadata(t *testing.T) {
+	b := Breakdown{WidgetID: 1, UnitPrice: 1000000, Quantity: 2, Subtotal: 2000000, CouponCode: "SAVE10", Discount: 200000,
+		Country: "SG", TaxRate: 900, TaxInclusive: true, Tax: 148624, Total: 1800000}
+	got, ok := FromMetadata(b.Metadata())
+	if !ok || got != b {
+		t.Errorf("FromMetadata(Metadata()) = %+v, %v, want %+v", got, ok, b)
+	}
+	if _, ok := FromMetadata(map[string]string{"client_ip": "203.0.113.7"}); ok {
+		t.Error("read a breakdown from metadata without one")
+	}
+}
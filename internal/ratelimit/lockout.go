@@ -0,0 +1,50 @@
+package ratelimit
+
+import (
+	"context"
+	"time"
+)
+
+// Lockout turns away the clients, emails and cards that have been declined
+// too often, which is what testing stolen card numbers looks like. Every
+// decline takes a token from the bucket of each key it was made with, and a
+// key with an empty bucket is locked out until a token comes back, so with
+// a Limit of 5/1h five declines in a row lock the key out for twelve
+// minutes
+type Lockout struct {
+	Store Store
+	Limit Limit
+}
+
+// Decline counts a declined payment against keys
+func (l *Lockout) Decline(ctx context.Context, keys ...string) error {
+	if l.Limit.N == 0 {
+		return nil
+	}
+	for _, key := range keys {
+		if _, _, err := l.Store.Take(ctx, "declines "+key, l.Limit); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Locked reports whether any of keys is locked out, and if so for how long
+func (l *Lockout) Locked(ctx context.Context, keys ...string) (bool, time.Duration, error) {
+	if l.Limit.N == 0 {
+		return false, 0, nil
+	}
+	var locked bool
+	var longest time.Duration
+	for _, key := range keys {
+		ok, retryAfter, err := l.Store.Peek(ctx, "declines "+key, l.Limit)
+		if err != nil {
+			return false, 0, err
+		}
+		if !ok {
+			locked = true
+			longest = max(longest, retryAfter)
+		}
+	}
+	return locked, longest, nil
+}
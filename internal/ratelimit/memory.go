@@ -0,0 +1,100 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// sweepEvery is how often the memory store forgets the buckets that have
+// filled up again, which are the same as no bucket at all
+const sweepEvery = time.Minute
+
+// MemoryStore keeps the buckets in this process, so each server counts
+// only the requests it sees. The zero value is ready to use
+type MemoryStore struct {
+	// Now is the clock, time.Now when nil
+	Now func() time.Time
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+type bucket struct {
+	tokens float64
+	at     time.Time
+	full   time.Time
+}
+
+// Take takes a token from key's bucket
+func (s *MemoryStore) Take(ctx context.Context, key string, limit Limit) (bool, time.Duration, error) {
+	ok, wait := s.take(key, limit, true)
+	return ok, wait, nil
+}
+
+// Peek reports whether key's bucket has a token, without taking it
+func (s *MemoryStore) Peek(ctx context.Context, key string, limit Limit) (bool, time.Duration, error) {
+	ok, wait := s.take(key, limit, false)
+	return ok, wait, nil
+}
+
+// take refills key's bucket up to now and reports whether it holds a
+// token, taking it if remove is true. When it doesn't, it also returns how
+// long until it will
+func (s *MemoryStore) take(key string, limit Limit, remove bool) (bool, time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	s.sweep(now)
+
+	b := s.refill(key, limit, now)
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) * float64(limit.interval()))
+	}
+	if remove {
+		b.tokens--
+		b.full = now.Add(time.Duration((float64(limit.N) - b.tokens) * float64(limit.interval())))
+	}
+	return true, 0
+}
+
+// refill returns key's bucket with the tokens it has earned back since it
+// was last used, creating it full
+func (s *MemoryStore) refill(key string, limit Limit, now time.Time) *bucket {
+	if s.buckets == nil {
+		s.buckets = make(map[string]*bucket)
+	}
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(limit.N), at: now, full: now}
+		s.buckets[key] = b
+		return b
+	}
+	b.tokens += float64(now.Sub(b.at)) / float64(limit.interval())
+	if b.tokens > float64(limit.N) {
+		b.tokens = float64(limit.N)
+	}
+	b.at = now
+	return b
+}
+
+// sweep drops the buckets that are full by now
+func (s *MemoryStore) sweep(now time.Time) {
+	if now.Sub(s.lastSweep) < sweepEvery {
+		return
+	}
+	s.lastSweep = now
+	for key, b := range s.buckets {
+		if !now.Before(b.full) {
+			delete(s.buckets, key)
+		}
+	}
+}
+
+func (s *MemoryStore) now() time.Time {
+	if s.Now == nil {
+		return time.Now()
+	}
+	return s.Now()
+}
@@ -0,0 +1,149 @@
+// Package ratelimit limits how often a client may call an endpoint, and
+// locks out the ones whose cards keep being declined. Limits are token
+// buckets kept in a Store, in memory by default, or anywhere that
+// implements the interface so the limits hold across servers
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"go-stripe/internal/logging"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Limit is a token bucket that holds N tokens and gets them all back over
+// Per, so N requests can come at once but no more than N every Per on
+// average. The zero Limit doesn't limit anything
+type Limit struct {
+	N   int
+	Per time.Duration
+}
+
+// ParseLimit reads a limit written as N/duration, such as 10/1m
+func ParseLimit(s string) (Limit, error) {
+	n, per, ok := strings.Cut(strings.TrimSpace(s), "/")
+	if !ok {
+		return Limit{}, fmt.Errorf("limit %q: want N/duration, such as 10/1m", s)
+	}
+	var l Limit
+	var err error
+	if l.N, err = strconv.Atoi(n); err != nil || l.N < 1 {
+		return Limit{}, fmt.Errorf("limit %q: %q is not a positive number", s, n)
+	}
+	if l.Per, err = time.ParseDuration(per); err != nil || l.Per <= 0 {
+		return Limit{}, fmt.Errorf("limit %q: %q is not a positive duration", s, per)
+	}
+	return l, nil
+}
+
+// ParseLimits reads the limits of routes written as route=N/duration,
+// comma separated, such as /api/payment-intent=10/1m,/api/authenticate=5/1m.
+// The routes are chi patterns, as they are in the router
+func ParseLimits(s string) (map[string]Limit, error) {
+	limits := make(map[string]Limit)
+	for _, part := range strings.Split(s, ",") {
+		if strings.TrimSpace(part) == "" {
+			continue
+		}
+		route, limit, ok := strings.Cut(part, "=")
+		if !ok {
+			return nil, fmt.Errorf("rate limit %q: want route=N/duration", part)
+		}
+		l, err := ParseLimit(limit)
+		if err != nil {
+			return nil, err
+		}
+		limits[strings.TrimSpace(route)] = l
+	}
+	return limits, nil
+}
+
+// interval is how long l takes to give back one token
+func (l Limit) interval() time.Duration {
+	return l.Per / time.Duration(l.N)
+}
+
+// Store keeps the token buckets, one per key
+type Store interface {
+	// Take takes a token from key's bucket under limit. When the bucket is
+	// empty nothing is taken, and it returns false and how long until a
+	// token is back
+	Take(ctx context.Context, key string, limit Limit) (bool, time.Duration, error)
+	// Peek reports what Take would, without taking anything
+	Peek(ctx context.Context, key string, limit Limit) (bool, time.Duration, error)
+}
+
+// Limiter limits requests to the routes in Limits
+type Limiter struct {
+	Store Store
+	// Limits are by chi route pattern. Routes without one aren't limited
+	Limits map[string]Limit
+	Logger *slog.Logger
+}
+
+// Allow takes a token for key from the bucket of route, and reports whether
+// there was one and if not how long until there is. Keys are counted
+// separately for each route. A store that fails lets the request through,
+// since turning away every customer is worse than letting a few extra
+// requests in
+func (l *Limiter) Allow(ctx context.Context, route, key string) (bool, time.Duration) {
+	limit, ok := l.Limits[route]
+	if !ok || limit.N == 0 {
+		return true, 0
+	}
+	allowed, retryAfter, err := l.Store.Take(ctx, route+" "+key, limit)
+	if err != nil {
+		l.logger().ErrorContext(ctx, "rate limit store failed", "route", route, "err", err)
+		return true, 0
+	}
+	if !allowed {
+		l.logger().WarnContext(ctx, "rate limited", "route", route, "key", key, "retry_after", retryAfter)
+	}
+	return allowed, retryAfter
+}
+
+// ByIP limits each client address to its route's limit. The route is only
+// known once chi has matched it, so ByIP goes on a route or group with
+// With or Use, not on the router itself
+func (l *Limiter) ByIP(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ok, retryAfter := l.Allow(r.Context(), logging.Route(r), "ip:"+ClientIP(r)); !ok {
+			Deny(w, retryAfter, "Too many requests, please try again later")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (l *Limiter) logger() *slog.Logger {
+	if l.Logger == nil {
+		return slog.Default()
+	}
+	return l.Logger
+}
+
+// ClientIP is the address r came from. Behind a proxy that is the proxy's,
+// unless the proxy sets RemoteAddr from X-Forwarded-For, as chi's RealIP
+// middleware does
+func ClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// Deny answers 429 with msg in the api's json error shape, and when to try
+// again in Retry-After
+func Deny(w http.ResponseWriter, retryAfter time.Duration, msg string) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusTooManyRequests)
+	fmt.Fprintf(w, "{\"ok\": false, \"message\": %q}\n", msg)
+}
@@ -0,0 +1,159 @@
+package ratelimit
+
+import (
+	"context"
+	"github.com/go-chi/chi/v5"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseLimits(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    map[string]Limit
+		wantErr bool
+	}{
+		{
+			in: "/api/payment-intent=10/1m, /api/authenticate=5/30s",
+			want: map[string]Limit{
+				"/api/payment-intent": {N: 10, Per: time.Minute},
+				"/api/authenticate":   {N: 5, Per: 30 * time.Second},
+			},
+		},
+		{in: "", want: map[string]Limit{}},
+		{in: "/api/payment-intent", wantErr: true},
+		{in: "/api/payment-intent=10", wantErr: true},
+		{in: "/api/payment-intent=0/1m", wantErr: true},
+		{in: "/api/payment-intent=10/soon", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseLimits(tt.in)
+		if (err != nil) != tt.wantErr || (!tt.wantErr && !reflect.DeepEqual(got, tt.want)) {
+			t.Errorf("ParseLimits(%q) = %v, %v, want %v, error %v", tt.in, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestMemoryStore(t *testing.T) {
+	now := time.Date(2024, 10, 30, 9, 0, 0, 0, time.UTC)
+	s := &MemoryStore{Now: func() time.Time { return now }}
+	ctx := context.Background()
+	limit := Limit{N: 3, Per: time.Minute}
+
+	take := func(key string) (bool, time.Duration) {
+		t.Helper()
+		ok, retryAfter, err := s.Take(ctx, key, limit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ok, retryAfter
+	}
+
+	// a new bucket is full, so the burst goes through
+	for i := 0; i < 3; i++ {
+		if ok, _ := take("a"); !ok {
+			t.Fatalf("request %d turned away", i+1)
+		}
+	}
+	if ok, retryAfter := take("a"); ok || retryAfter != 20*time.Second {
+		t.Errorf("fourth request = %v retry after %v, want turned away for 20s", ok, retryAfter)
+	}
+	if ok, _ := take("b"); !ok {
+		t.Error("another key shares the bucket")
+	}
+
+	// a token comes back every 20s
+	now = now.Add(15 * time.Second)
+	if ok, _, _ := s.Peek(ctx, "a", limit); ok {
+		t.Error("token back after 15s, want 20s")
+	}
+	now = now.Add(5 * time.Second)
+	if ok, _, _ := s.Peek(ctx, "a", limit); !ok {
+		t.Error("no token back after 20s")
+	}
+	if ok, _ := take("a"); !ok {
+		t.Error("Peek took the token")
+	}
+
+	// full buckets are forgotten
+	now = now.Add(time.Hour)
+	take("c")
+	if _, ok := s.buckets["b"]; ok {
+		t.Error("full bucket not swept")
+	}
+}
+
+func TestByIP(t *testing.T) {
+	l := &Limiter{
+		Store:  &MemoryStore{},
+		Limits: map[string]Limit{"/pay/{id}": {N: 2, Per: time.Minute}},
+	}
+	mux := chi.NewRouter()
+	mux.With(l.ByIP).Post("/pay/{id}", func(w http.ResponseWriter, r *http.Request) {})
+	mux.With(l.ByIP).Get("/free", func(w http.ResponseWriter, r *http.Request) {})
+
+	send := func(method, path, addr string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, path, nil)
+		r.RemoteAddr = addr
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, r)
+		return w
+	}
+
+	// the route's limit covers every path that matches it
+	for _, path := range []string{"/pay/1", "/pay/2"} {
+		if w := send(http.MethodPost, path, "203.0.113.7:5000"); w.Code != http.StatusOK {
+			t.Fatalf("POST %s = %d, want 200", path, w.Code)
+		}
+	}
+	w := send(http.MethodPost, "/pay/3", "203.0.113.7:5001")
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "30" {
+		t.Errorf("third request = %d retry after %q, want 429 after 30", w.Code, w.Header().Get("Retry-After"))
+	}
+	if w := send(http.MethodPost, "/pay/3", "198.51.100.2:5000"); w.Code != http.StatusOK {
+		t.Errorf("another client = %d, want 200", w.Code)
+	}
+	for i := 0; i < 5; i++ {
+		if w := send(http.MethodGet, "/free", "203.0.113.7:5000"); w.Code != http.StatusOK {
+			t.Fatalf("route without a limit = %d, want 200", w.Code)
+		}
+	}
+}
+
+func TestLockout(t *testing.T) {
+	now := time.Date(2024, 10, 30, 9, 0, 0, 0, time.UTC)
+	l := &Lockout{
+		Store: &MemoryStore{Now: func() time.Time { return now }},
+		Limit: Limit{N: 3, Per: time.Hour},
+	}
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		if locked, _, _ := l.Locked(ctx, "ip:203.0.113.7", "card:fp_1"); locked {
+			t.Fatalf("locked out after %d declines, want 3", i)
+		}
+		// a new card each time, as a card tester would
+		l.Decline(ctx, "ip:203.0.113.7", "card:fp_"+string(rune('a'+i)))
+	}
+	locked, retryAfter, err := l.Locked(ctx, "ip:203.0.113.7", "card:fp_1")
+	if err != nil || !locked || retryAfter != 20*time.Minute {
+		t.Errorf("Locked = %v, %v, %v, want locked for 20m", locked, retryAfter, err)
+	}
+	if locked, _, _ := l.Locked(ctx, "ip:198.51.100.2", "card:fp_a"); locked {
+		t.Error("a card declined once is locked out")
+	}
+
+	now = now.Add(20 * time.Minute)
+	if locked, _, _ := l.Locked(ctx, "ip:203.0.113.7"); locked {
+		t.Error("still locked out once a decline has expired")
+	}
+
+	off := &Lockout{Store: &MemoryStore{}}
+	off.Decline(ctx, "ip:203.0.113.7")
+	if locked, _, _ := off.Locked(ctx, "ip:203.0.113.7"); locked {
+		t.Error("the zero Limit locked a key out")
+	}
+}
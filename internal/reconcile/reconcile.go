@@ -0,0 +1,285 @@
+package reconcile
+
+import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"go-stripe/internal/cards"
+	"go-stripe/internal/models"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/stripe/stripe-go/v72"
+)
+
+// Slack is how far apart a payment intent and its transaction row may be
+// created. The row is written when the browser comes back from stripe, which
+// can be a while later if the customer had to authenticate with their bank
+const Slack = 24 * time.Hour
+
+// kinds of discrepancy
+const (
+	Missing        = "missing"
+	Extra          = "extra"
+	AmountMismatch = "amount_mismatch"
+	StatusMismatch = "status_mismatch"
+)
+
+// Discrepancy is one way our transactions and stripe disagree
+type Discrepancy struct {
+	Kind          string    `json:"kind"`
+	PaymentIntent string    `json:"payment_intent"`
+	TransactionID int       `json:"transaction_id,omitempty"`
+	StripeStatus  string    `json:"stripe_status,omitempty"`
+	LocalStatusID int       `json:"local_status_id,omitempty"`
+	StripeAmount  int       `json:"stripe_amount"`
+	LocalAmount   int       `json:"local_amount"`
+	Currency      string    `json:"currency"`
+	CreatedAt     time.Time `json:"created_at"`
+	// BackfilledID is the transaction written for a missing payment intent
+	BackfilledID int `json:"backfilled_id,omitempty"`
+}
+
+// Report is the outcome of comparing one date range
+type Report struct {
+	From          time.Time     `json:"from"`
+	To            time.Time     `json:"to"`
+	StripeCount   int           `json:"stripe_count"`
+	LocalCount    int           `json:"local_count"`
+	Matched       int           `json:"matched"`
+	Backfilled    int           `json:"backfilled"`
+	Discrepancies []Discrepancy `json:"discrepancies"`
+}
+
+// Reconciler compares the transactions table with stripe
+type Reconciler struct {
+	Card         *cards.Card
+	Transactions models.TransactionRepo
+}
+
+// Run compares payment intents and transactions created in [from, to). With
+// backfill, a transaction is written for every payment intent that took or
+// is holding money but has no row
+func (rc *Reconciler) Run(ctx context.Context, from, to time.Time, backfill bool) (*Report, error) {
+	intents, err := rc.Card.ListPaymentIntents(ctx, from.Add(-Slack), to)
+	if err != nil {
+		return nil, err
+	}
+	txns, err := rc.Transactions.GetTransactionsCreatedBetween(ctx, from, to.Add(Slack))
+	if err != nil {
+		return nil, err
+	}
+
+	report := &Report{From: from, To: to}
+
+	// the latest row wins when a payment intent was recorded more than once
+	local := make(map[string]models.Transaction)
+	for _, t := range txns {
+		if t.PaymentIntent == "" {
+			continue
+		}
+		local[t.PaymentIntent] = t
+		if inRange(t.CreatedAt, from, to) {
+			report.LocalCount++
+		}
+	}
+
+	remote := make(map[string]bool)
+	for _, pi := range intents {
+		remote[pi.ID] = true
+		created := time.Unix(pi.Created, 0)
+		if !inRange(created, from, to) {
+			continue
+		}
+		report.StripeCount++
+
+		t, ok := local[pi.ID]
+		if !ok && movedMoney(pi) {
+			// the row may have been written outside the window, by an
+			// earlier backfill or a customer who came back from stripe late
+			found, err := rc.Transactions.GetTransactionByPaymentIntent(ctx, pi.ID)
+			if err != nil && !errors.Is(err, sql.ErrNoRows) {
+				return report, err
+			}
+			t, ok = found, err == nil
+		}
+		if !ok {
+			if !movedMoney(pi) {
+				continue
+			}
+			d := Discrepancy{
+				Kind:          Missing,
+				PaymentIntent: pi.ID,
+				StripeStatus:  string(pi.Status),
+				StripeAmount:  amount(pi),
+				Currency:      string(pi.Currency),
+				CreatedAt:     created,
+			}
+			if backfill {
+				id, err := rc.Transactions.InsertTransaction(ctx, backfillTransaction(pi))
+				if err != nil {
+					return report, err
+				}
+				d.BackfilledID = id
+				report.Backfilled++
+			}
+			report.Discrepancies = append(report.Discrepancies, d)
+			continue
+		}
+
+		matched := true
+		if !statusMatches(t.TransactionStatusID, pi) {
+			matched = false
+			report.Discrepancies = append(report.Discrepancies, discrepancy(StatusMismatch, pi, t))
+		}
+		if movedMoney(pi) && t.Amount != amount(pi) {
+			matched = false
+			report.Discrepancies = append(report.Discrepancies, discrepancy(AmountMismatch, pi, t))
+		}
+		if matched {
+			report.Matched++
+		}
+	}
+
+	for _, t := range txns {
+		if t.PaymentIntent == "" || remote[t.PaymentIntent] || !inRange(t.CreatedAt, from, to) {
+			continue
+		}
+		report.Discrepancies = append(report.Discrepancies, Discrepancy{
+			Kind:          Extra,
+			PaymentIntent: t.PaymentIntent,
+			TransactionID: t.ID,
+			LocalStatusID: t.TransactionStatusID,
+			LocalAmount:   t.Amount,
+			Currency:      t.Currency,
+			CreatedAt:     t.CreatedAt,
+		})
+	}
+
+	return report, nil
+}
+
+func inRange(t, from, to time.Time) bool {
+	return !t.Before(from) && t.Before(to)
+}
+
+func discrepancy(kind string, pi *stripe.PaymentIntent, t models.Transaction) Discrepancy {
+	return Discrepancy{
+		Kind:          kind,
+		PaymentIntent: pi.ID,
+		TransactionID: t.ID,
+		StripeStatus:  string(pi.Status),
+		LocalStatusID: t.TransactionStatusID,
+		StripeAmount:  amount(pi),
+		LocalAmount:   t.Amount,
+		Currency:      string(pi.Currency),
+		CreatedAt:     time.Unix(pi.Created, 0),
+	}
+}
+
+// movedMoney reports whether a payment intent took, is holding, or is about
+// to take money. Abandoned checkouts never get a transaction row
+func movedMoney(pi *stripe.PaymentIntent) bool {
+	switch pi.Status {
+	case stripe.PaymentIntentStatusSucceeded, stripe.PaymentIntentStatusRequiresCapture, stripe.PaymentIntentStatusProcessing:
+		return true
+	}
+	return false
+}
+
+// amount is what stripe collected, or was asked to collect if nothing has
+// been collected yet
+func amount(pi *stripe.PaymentIntent) int {
+	if pi.AmountReceived > 0 {
+		return int(pi.AmountReceived)
+	}
+	return int(pi.Amount)
+}
+
+// expectedStatus is the transaction status we should have for pi, taking
+// refunds into account since they don't change the payment intent's status
+func expectedStatus(pi *stripe.PaymentIntent) int {
+	status := cards.TransactionStatus(pi.Status)
+	if status != models.TransactionStatusCleared || pi.Charges == nil || len(pi.Charges.Data) == 0 {
+		return status
+	}
+	ch := pi.Charges.Data[0]
+	switch {
+	case ch.Refunded:
+		return models.TransactionStatusRefunded
+	case ch.AmountRefunded > 0:
+		return models.TransactionStatusPartiallyRefunded
+	}
+	return status
+}
+
+func statusMatches(local int, pi *stripe.PaymentIntent) bool {
+	expected := expectedStatus(pi)
+	if expected == local {
+		return true
+	}
+	// a cancelled intent is a void if we had authorized it
+	return pi.Status == stripe.PaymentIntentStatusCanceled && local == models.TransactionStatusVoided
+}
+
+// backfillTransaction builds the row we should have written for pi
+func backfillTransaction(pi *stripe.PaymentIntent) models.Transaction {
+	t := models.Transaction{
+		Amount:              amount(pi),
+		Currency:            string(pi.Currency),
+		PaymentIntent:       pi.ID,
+		BankReturnCode:      cards.ChargeID(pi),
+		TransactionStatusID: expectedStatus(pi),
+		Memo:                "backfilled from stripe",
+	}
+	if pi.PaymentMethod != nil {
+		t.PaymentMethod = pi.PaymentMethod.ID
+	}
+	if pi.Charges != nil && len(pi.Charges.Data) > 0 {
+		if pmd := pi.Charges.Data[0].PaymentMethodDetails; pmd != nil && pmd.Card != nil {
+			t.LastFour = pmd.Card.Last4
+			t.ExpiryMonth = int(pmd.Card.ExpMonth)
+			t.ExpiryYear = int(pmd.Card.ExpYear)
+		}
+	}
+	return t
+}
+
+// WriteJSON writes the report as indented json
+func (r *Report) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "   ")
+	return enc.Encode(r)
+}
+
+// WriteCSV writes one line per discrepancy, with a header
+func (r *Report) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	err := cw.Write([]string{"kind", "payment_intent", "transaction_id", "stripe_status", "local_status_id",
+		"stripe_amount", "local_amount", "currency", "created_at", "backfilled_id"})
+	if err != nil {
+		return err
+	}
+	for _, d := range r.Discrepancies {
+		err = cw.Write([]string{
+			d.Kind,
+			d.PaymentIntent,
+			strconv.Itoa(d.TransactionID),
+			d.StripeStatus,
+			strconv.Itoa(d.LocalStatusID),
+			strconv.Itoa(d.StripeAmount),
+			strconv.Itoa(d.LocalAmount),
+			d.Currency,
+			d.CreatedAt.Format(time.RFC3339),
+			strconv.Itoa(d.BackfilledID),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
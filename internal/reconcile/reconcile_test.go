@@ -0,0 +1,75 @@
+package reconcile
+
+import (
+	"context"
+	"go-stripe/internal/cards"
+	"go-stripe/internal/models"
+	"go-stripe/internal/stripetest"
+	"testing"
+	"time"
+)
+
+func TestRun(t *testing.T) {
+	fake := stripetest.NewServer(t)
+	card := &cards.Card{Secret: "sk_test_fake"}
+	store := models.NewMemoryStore()
+	rc := &Reconciler{Card: card, Transactions: store}
+	ctx := context.Background()
+
+	// paid creates a payment intent the customer paid a week ago
+	weekAgo := time.Now().Add(-7 * 24 * time.Hour)
+	paid := func(amount int) string {
+		t.Helper()
+		pi, _, err := card.CreatePaymentIntent(ctx, "idr", amount)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = fake.Confirm(pi.ID, stripetest.CardVisa); err != nil {
+			t.Fatal(err)
+		}
+		fake.Backdate(pi.ID, weekAgo)
+		return pi.ID
+	}
+	recorded := paid(100000)
+	missing := paid(250000)
+	if _, _, err := card.CreatePaymentIntent(ctx, "idr", 5000); err != nil {
+		t.Fatal(err)
+	}
+	// recorded when the customer came back from stripe, though that was
+	// long after the window being reconciled
+	_, err := store.InsertTransaction(ctx, models.Transaction{
+		Amount:              100000,
+		Currency:            "idr",
+		PaymentIntent:       recorded,
+		TransactionStatusID: models.TransactionStatusCleared,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	from, to := weekAgo.Add(-time.Hour), weekAgo.Add(time.Hour)
+	report, err := rc.Run(ctx, from, to, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.StripeCount != 2 || report.Matched != 1 || report.Backfilled != 1 || len(report.Discrepancies) != 1 {
+		t.Fatalf("report = %+v, want 2 payments, 1 matched and 1 backfilled", report)
+	}
+	d := report.Discrepancies[0]
+	if d.Kind != Missing || d.PaymentIntent != missing || d.StripeAmount != 250000 || d.BackfilledID == 0 {
+		t.Errorf("discrepancy = %+v, want %s missing and backfilled", d, missing)
+	}
+	txn, err := store.GetTransaction(ctx, d.BackfilledID)
+	if err != nil || txn.PaymentIntent != missing || txn.Amount != 250000 || txn.TransactionStatusID != models.TransactionStatusCleared {
+		t.Errorf("backfilled %+v, %v, want a cleared transaction for 250000", txn, err)
+	}
+
+	// the backfilled row is found again, however old the window
+	report, err = rc.Run(ctx, from, to, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Matched != 2 || report.Backfilled != 0 || len(report.Discrepancies) != 0 {
+		t.Errorf("second run = %+v, want both payments matched", report)
+	}
+}
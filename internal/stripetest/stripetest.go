@@ -0,0 +1,626 @@
+// Package stripetest runs a fake stripe API for tests. It keeps payment
+// intents and refunds in memory and implements enough of the API for
+// internal/cards. Payment methods behave like stripe's own test cards, so
+// pm_card_visa is charged and pm_card_chargeDeclined is declined
+package stripetest
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/go-chi/chi/v5"
+	"github.com/stripe/stripe-go/v72"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// the test payment methods, named as they are in stripe's test mode
+const (
+	CardVisa                   = "pm_card_visa"
+	CardDeclined               = "pm_card_chargeDeclined"
+	CardInsufficientFunds      = "pm_card_chargeDeclinedInsufficientFunds"
+	CardExpired                = "pm_card_chargeDeclinedExpiredCard"
+	CardIncorrectCVC           = "pm_card_chargeDeclinedIncorrectCvc"
+	CardAuthenticationRequired = "pm_card_authenticationRequired"
+)
+
+// testCard is how a test payment method behaves when a payment intent is
+// confirmed with it. A card with a decline code is always declined
+type testCard struct {
+	last4          string
+	declineCode    stripe.ErrorCode
+	requiresAction bool
+}
+
+var testCards = map[string]testCard{
+	CardVisa:                   {last4: "4242"},
+	CardDeclined:               {last4: "0002", declineCode: stripe.ErrorCodeCardDeclined},
+	CardInsufficientFunds:      {last4: "9995", declineCode: stripe.ErrorCodeBalanceInsufficient},
+	CardExpired:                {last4: "0069", declineCode: stripe.ErrorCodeExpiredCard},
+	CardIncorrectCVC:           {last4: "0127", declineCode: stripe.ErrorCodeIncorrectCVC},
+	CardAuthenticationRequired: {last4: "3184", requiresAction: true},
+}
+
+// Server is a fake stripe API listening on a local address
+type Server struct {
+	// URL is the base url of the fake API
+	URL string
+
+	srv      *httptest.Server
+	mu       sync.Mutex
+	nextID   int
+	intents  map[string]*stripe.PaymentIntent
+	refunds  []*stripe.Refund
+	failures []stripe.ErrorCode
+	delays   []time.Duration
+	// replies are the responses to requests sent with an Idempotency-Key
+	replies map[string]*httptest.ResponseRecorder
+}
+
+// NewServer starts a fake stripe API and points stripe-go at it until the
+// test ends. stripe-go's backend is global, so tests using a Server must not
+// run in parallel
+func NewServer(t testing.TB) *Server {
+	s := &Server{
+		intents: make(map[string]*stripe.PaymentIntent),
+		replies: make(map[string]*httptest.ResponseRecorder),
+	}
+	s.srv = httptest.NewServer(s.routes())
+	s.URL = s.srv.URL
+
+	stripe.SetBackend(stripe.APIBackend, stripe.GetBackendWithConfig(stripe.APIBackend, &stripe.BackendConfig{
+		URL:               stripe.String(s.URL),
+		HTTPClient:        s.srv.Client(),
+		LeveledLogger:     &stripe.LeveledLogger{Level: stripe.LevelNull},
+		MaxNetworkRetries: stripe.Int64(0),
+	}))
+	t.Cleanup(func() {
+		stripe.SetBackend(stripe.APIBackend, nil)
+		s.srv.Close()
+	})
+	return s
+}
+
+func (s *Server) routes() http.Handler {
+	mux := chi.NewRouter()
+	mux.Use(s.delayed, s.idempotent)
+	mux.Post("/v1/payment_intents", s.createPaymentIntent)
+	mux.Get("/v1/payment_intents", s.listPaymentIntents)
+	mux.Get("/v1/payment_intents/{id}", s.getPaymentIntent)
+	mux.Post("/v1/payment_intents/{id}/confirm", s.confirmPaymentIntent)
+	mux.Post("/v1/payment_intents/{id}/capture", s.capturePaymentIntent)
+	mux.Post("/v1/payment_intents/{id}/cancel", s.cancelPaymentIntent)
+	mux.Get("/v1/payment_methods/{id}", s.getPaymentMethod)
+	mux.Post("/v1/refunds", s.createRefund)
+	return mux
+}
+
+// idempotent answers a request with an Idempotency-Key it has seen before
+// with the first response, as stripe does, instead of running it again
+func (s *Server) idempotent(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("Idempotency-Key")
+		if key == "" || r.Method != http.MethodPost {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		s.mu.Lock()
+		rec, seen := s.replies[key]
+		s.mu.Unlock()
+		if !seen {
+			rec = httptest.NewRecorder()
+			next.ServeHTTP(rec, r)
+			s.mu.Lock()
+			s.replies[key] = rec
+			s.mu.Unlock()
+		} else {
+			w.Header().Set("Idempotent-Replayed", "true")
+		}
+		writeRecorded(w, rec)
+	})
+}
+
+// delayed holds back the answer to a request queued by DelayNext until the
+// request has taken effect and the delay has passed, or the client has
+// given up waiting
+func (s *Server) delayed(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		var delay time.Duration
+		if len(s.delays) > 0 {
+			delay, s.delays = s.delays[0], s.delays[1:]
+		}
+		s.mu.Unlock()
+		if delay == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		rec := httptest.NewRecorder()
+		next.ServeHTTP(rec, r)
+		select {
+		case <-time.After(delay):
+		case <-r.Context().Done():
+			return
+		}
+		writeRecorded(w, rec)
+	})
+}
+
+func writeRecorded(w http.ResponseWriter, rec *httptest.ResponseRecorder) {
+	for k, v := range rec.Header() {
+		w.Header()[k] = v
+	}
+	w.WriteHeader(rec.Code)
+	w.Write(rec.Body.Bytes())
+}
+
+// FailNext makes the next request to the API fail with a card error with
+// code, the way stripe answers a charge the bank refuses
+func (s *Server) FailNext(code stripe.ErrorCode) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures = append(s.failures, code)
+}
+
+// DelayNext makes the next request to the API take effect but only answer
+// after delay, the way a call that times out on our side can still go
+// through at stripe
+func (s *Server) DelayNext(delay time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.delays = append(s.delays, delay)
+}
+
+// PaymentIntent returns a copy of the payment intent with id
+func (s *Server) PaymentIntent(id string) (stripe.PaymentIntent, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	pi, ok := s.intents[id]
+	if !ok {
+		return stripe.PaymentIntent{}, false
+	}
+	return *pi, true
+}
+
+// Backdate makes the payment intent with id look as if it was created at t
+func (s *Server) Backdate(id string, t time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if pi, ok := s.intents[id]; ok {
+		pi.Created = t.Unix()
+	}
+}
+
+// PaymentIntents returns copies of every payment intent, oldest first
+func (s *Server) PaymentIntents() []stripe.PaymentIntent {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make([]stripe.PaymentIntent, 0, len(s.intents))
+	for _, pi := range s.sortedIntents() {
+		out = append(out, *pi)
+	}
+	return out
+}
+
+// Refunds returns copies of every refund, oldest first
+func (s *Server) Refunds() []stripe.Refund {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make([]stripe.Refund, 0, len(s.refunds))
+	for _, r := range s.refunds {
+		out = append(out, *r)
+	}
+	return out
+}
+
+// Confirm pays payment intent id with a test payment method, as stripe.js
+// does in the customer's browser
+func (s *Server) Confirm(id, paymentMethod string) (stripe.PaymentIntent, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	pi, ok := s.intents[id]
+	if !ok {
+		return stripe.PaymentIntent{}, fmt.Errorf("no such payment_intent: %s", id)
+	}
+	if err := s.confirm(pi, paymentMethod); err != nil {
+		return stripe.PaymentIntent{}, err
+	}
+	return *pi, nil
+}
+
+// Authenticate finishes the 3-D Secure check of a payment intent that
+// requires action. The payment goes through if passed is true, and is
+// declined otherwise
+func (s *Server) Authenticate(id string, passed bool) (stripe.PaymentIntent, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	pi, ok := s.intents[id]
+	if !ok {
+		return stripe.PaymentIntent{}, fmt.Errorf("no such payment_intent: %s", id)
+	}
+	if pi.Status != stripe.PaymentIntentStatusRequiresAction {
+		return stripe.PaymentIntent{}, fmt.Errorf("payment_intent %s is %s, not requires_action", id, pi.Status)
+	}
+	pi.NextAction = nil
+	if !passed {
+		pi.Status = stripe.PaymentIntentStatusRequiresPaymentMethod
+		pi.LastPaymentError = &stripe.Error{
+			Type: stripe.ErrorTypeCard,
+			Code: stripe.ErrorCodePaymentIntentAuthenticationFailure,
+		}
+		return *pi, nil
+	}
+	s.charge(pi)
+	return *pi, nil
+}
+
+// confirm attaches paymentMethod to pi and charges it, or declines it, the
+// way the test card would be
+func (s *Server) confirm(pi *stripe.PaymentIntent, paymentMethod string) error {
+	card, ok := testCards[paymentMethod]
+	if !ok {
+		return fmt.Errorf("no such payment_method: %s", paymentMethod)
+	}
+	pi.PaymentMethod = &stripe.PaymentMethod{ID: paymentMethod}
+	pi.LastPaymentError = nil
+
+	switch {
+	case card.declineCode != "":
+		pi.Status = stripe.PaymentIntentStatusRequiresPaymentMethod
+		pi.LastPaymentError = &stripe.Error{
+			Type:          stripe.ErrorTypeCard,
+			Code:          card.declineCode,
+			PaymentMethod: testPaymentMethod(paymentMethod, card),
+		}
+	case card.requiresAction:
+		pi.Status = stripe.PaymentIntentStatusRequiresAction
+		pi.NextAction = &stripe.PaymentIntentNextAction{
+			Type: stripe.PaymentIntentNextActionTypeRedirectToURL,
+			RedirectToURL: &stripe.PaymentIntentNextActionRedirectToURL{
+				URL: s.URL + "/3ds/" + pi.ID,
+			},
+		}
+	default:
+		s.charge(pi)
+	}
+	return nil
+}
+
+// charge takes the money for pi, or only holds it when pi is captured manually
+func (s *Server) charge(pi *stripe.PaymentIntent) {
+	if pi.CaptureMethod == stripe.PaymentIntentCaptureMethodManual {
+		pi.Status = stripe.PaymentIntentStatusRequiresCapture
+		pi.AmountCapturable = pi.Amount
+	} else {
+		pi.Status = stripe.PaymentIntentStatusSucceeded
+		pi.AmountReceived = pi.Amount
+	}
+	pi.Charges = &stripe.ChargeList{
+		Data: []*stripe.Charge{{
+			ID:             s.newID("ch"),
+			Amount:         pi.Amount,
+			AmountCaptured: pi.AmountReceived,
+			Captured:       pi.Status == stripe.PaymentIntentStatusSucceeded,
+			Currency:       stripe.Currency(pi.Currency),
+			Paid:           true,
+			Status:         "succeeded",
+		}},
+	}
+}
+
+func (s *Server) newID(prefix string) string {
+	s.nextID++
+	return fmt.Sprintf("%s_test%06d", prefix, s.nextID)
+}
+
+// sortedIntents returns the payment intents oldest first
+func (s *Server) sortedIntents() []*stripe.PaymentIntent {
+	intents := make([]*stripe.PaymentIntent, 0, len(s.intents))
+	for _, pi := range s.intents {
+		intents = append(intents, pi)
+	}
+	sort.Slice(intents, func(i, j int) bool { return intents[i].ID < intents[j].ID })
+	return intents
+}
+
+func (s *Server) createPaymentIntent(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.failed(w) || !parseForm(w, r) {
+		return
+	}
+
+	amount, err := strconv.ParseInt(r.PostForm.Get("amount"), 10, 64)
+	if err != nil || amount < 1 {
+		writeError(w, http.StatusBadRequest, stripe.ErrorTypeInvalidRequest, stripe.ErrorCodeAmountTooSmall, "amount must be at least 1")
+		return
+	}
+	currency := r.PostForm.Get("currency")
+	if currency == "" {
+		writeError(w, http.StatusBadRequest, stripe.ErrorTypeInvalidRequest, stripe.ErrorCodeParameterMissing, "missing required param: currency")
+		return
+	}
+
+	pi := &stripe.PaymentIntent{
+		ID:            s.newID("pi"),
+		Object:        "payment_intent",
+		Amount:        amount,
+		Currency:      currency,
+		CaptureMethod: stripe.PaymentIntentCaptureMethodAutomatic,
+		Created:       time.Now().Unix(),
+		Metadata:      make(map[string]string),
+		Status:        stripe.PaymentIntentStatusRequiresPaymentMethod,
+	}
+	pi.ClientSecret = pi.ID + "_secret_test"
+	if r.PostForm.Get("capture_method") == string(stripe.PaymentIntentCaptureMethodManual) {
+		pi.CaptureMethod = stripe.PaymentIntentCaptureMethodManual
+	}
+	for k, v := range r.PostForm {
+		if key, ok := strings.CutPrefix(k, "metadata["); ok && len(v) > 0 {
+			pi.Metadata[strings.TrimSuffix(key, "]")] = v[0]
+		}
+	}
+	if pm := r.PostForm.Get("payment_method"); pm != "" && r.PostForm.Get("confirm") != "true" {
+		pi.PaymentMethod = &stripe.PaymentMethod{ID: pm}
+	} else if pm != "" {
+		if err := s.confirm(pi, pm); err != nil {
+			writeError(w, http.StatusNotFound, stripe.ErrorTypeInvalidRequest, stripe.ErrorCodeResourceMissing, err.Error())
+			return
+		}
+	}
+
+	s.intents[pi.ID] = pi
+	writeJSON(w, pi)
+}
+
+func (s *Server) listPaymentIntents(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.failed(w) {
+		return
+	}
+
+	q := r.URL.Query()
+	list := &stripe.PaymentIntentList{}
+	list.URL = "/v1/payment_intents"
+	for _, pi := range s.sortedIntents() {
+		if !inCreatedRange(pi.Created, q) {
+			continue
+		}
+		list.Data = append(list.Data, pi)
+	}
+	writeJSON(w, list)
+}
+
+// inCreatedRange reports whether created matches the created[gte] and
+// created[lt] filters in q
+func inCreatedRange(created int64, q map[string][]string) bool {
+	if v, ok := q["created[gte]"]; ok {
+		if gte, _ := strconv.ParseInt(v[0], 10, 64); created < gte {
+			return false
+		}
+	}
+	if v, ok := q["created[lt]"]; ok {
+		if lt, _ := strconv.ParseInt(v[0], 10, 64); created >= lt {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *Server) getPaymentIntent(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.failed(w) {
+		return
+	}
+	pi, ok := s.findIntent(w, r)
+	if !ok {
+		return
+	}
+	writeJSON(w, pi)
+}
+
+func (s *Server) confirmPaymentIntent(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.failed(w) || !parseForm(w, r) {
+		return
+	}
+	pi, ok := s.findIntent(w, r)
+	if !ok {
+		return
+	}
+	if err := s.confirm(pi, r.PostForm.Get("payment_method")); err != nil {
+		writeError(w, http.StatusNotFound, stripe.ErrorTypeInvalidRequest, stripe.ErrorCodeResourceMissing, err.Error())
+		return
+	}
+	if pi.LastPaymentError != nil {
+		writeError(w, http.StatusPaymentRequired, stripe.ErrorTypeCard, pi.LastPaymentError.Code, "Your card was declined.")
+		return
+	}
+	writeJSON(w, pi)
+}
+
+func (s *Server) capturePaymentIntent(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.failed(w) || !parseForm(w, r) {
+		return
+	}
+	pi, ok := s.findIntent(w, r)
+	if !ok {
+		return
+	}
+	if pi.Status != stripe.PaymentIntentStatusRequiresCapture {
+		writeError(w, http.StatusBadRequest, stripe.ErrorTypeInvalidRequest, stripe.ErrorCodePaymentIntentUnexpectedState,
+			fmt.Sprintf("This PaymentIntent could not be captured because it has a status of %s.", pi.Status))
+		return
+	}
+
+	amount := pi.AmountCapturable
+	if v := r.PostForm.Get("amount_to_capture"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n < 1 || n > pi.AmountCapturable {
+			writeError(w, http.StatusBadRequest, stripe.ErrorTypeInvalidRequest, stripe.ErrorCodeAmountTooLarge, "amount_to_capture is invalid")
+			return
+		}
+		amount = n
+	}
+	pi.Status = stripe.PaymentIntentStatusSucceeded
+	pi.AmountReceived = amount
+	pi.AmountCapturable = 0
+	if ch := pi.Charges.Data[0]; ch != nil {
+		ch.AmountCaptured = amount
+		ch.Captured = true
+	}
+	writeJSON(w, pi)
+}
+
+func (s *Server) cancelPaymentIntent(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.failed(w) {
+		return
+	}
+	pi, ok := s.findIntent(w, r)
+	if !ok {
+		return
+	}
+	if pi.Status == stripe.PaymentIntentStatusSucceeded || pi.Status == stripe.PaymentIntentStatusCanceled {
+		writeError(w, http.StatusBadRequest, stripe.ErrorTypeInvalidRequest, stripe.ErrorCodePaymentIntentUnexpectedState,
+			fmt.Sprintf("You cannot cancel this PaymentIntent because it has a status of %s.", pi.Status))
+		return
+	}
+	pi.Status = stripe.PaymentIntentStatusCanceled
+	pi.AmountCapturable = 0
+	pi.CanceledAt = time.Now().Unix()
+	writeJSON(w, pi)
+}
+
+func (s *Server) getPaymentMethod(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.failed(w) {
+		return
+	}
+	id := chi.URLParam(r, "id")
+	card, ok := testCards[id]
+	if !ok {
+		writeError(w, http.StatusNotFound, stripe.ErrorTypeInvalidRequest, stripe.ErrorCodeResourceMissing, "No such PaymentMethod: '"+id+"'")
+		return
+	}
+	writeJSON(w, testPaymentMethod(id, card))
+}
+
+// testPaymentMethod is the payment method id for card. Each test card has
+// a fingerprint of its own, as each card number does
+func testPaymentMethod(id string, card testCard) *stripe.PaymentMethod {
+	return &stripe.PaymentMethod{
+		ID:     id,
+		Object: "payment_method",
+		Type:   stripe.PaymentMethodTypeCard,
+		Card: &stripe.PaymentMethodCard{
+			Brand:       stripe.PaymentMethodCardBrandVisa,
+			Last4:       card.last4,
+			ExpMonth:    12,
+			ExpYear:     uint64(time.Now().Year() + 2),
+			Fingerprint: "fp_test" + card.last4,
+		},
+	}
+}
+
+func (s *Server) createRefund(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.failed(w) || !parseForm(w, r) {
+		return
+	}
+	id := r.PostForm.Get("payment_intent")
+	pi, ok := s.intents[id]
+	if !ok {
+		writeError(w, http.StatusNotFound, stripe.ErrorTypeInvalidRequest, stripe.ErrorCodeResourceMissing, "No such payment_intent: '"+id+"'")
+		return
+	}
+	if pi.Status != stripe.PaymentIntentStatusSucceeded {
+		writeError(w, http.StatusBadRequest, stripe.ErrorTypeInvalidRequest, stripe.ErrorCodePaymentIntentUnexpectedState,
+			fmt.Sprintf("This PaymentIntent has a status of %s and cannot be refunded.", pi.Status))
+		return
+	}
+
+	ch := pi.Charges.Data[0]
+	remaining := ch.AmountCaptured - ch.AmountRefunded
+	amount := remaining
+	if v := r.PostForm.Get("amount"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n < 1 || n > remaining {
+			writeError(w, http.StatusBadRequest, stripe.ErrorTypeInvalidRequest, stripe.ErrorCodeAmountTooLarge, "amount is more than remains to be refunded")
+			return
+		}
+		amount = n
+	}
+	ch.AmountRefunded += amount
+	ch.Refunded = ch.AmountRefunded == ch.AmountCaptured
+
+	refund := &stripe.Refund{
+		ID:            s.newID("re"),
+		Object:        "refund",
+		Amount:        amount,
+		Currency:      stripe.Currency(pi.Currency),
+		Charge:        &stripe.Charge{ID: ch.ID},
+		PaymentIntent: &stripe.PaymentIntent{ID: pi.ID},
+		Status:        stripe.RefundStatusSucceeded,
+		Created:       time.Now().Unix(),
+	}
+	s.refunds = append(s.refunds, refund)
+	writeJSON(w, refund)
+}
+
+// failed answers the request with the next error queued by FailNext, if any
+func (s *Server) failed(w http.ResponseWriter) bool {
+	if len(s.failures) == 0 {
+		return false
+	}
+	code := s.failures[0]
+	s.failures = s.failures[1:]
+	writeError(w, http.StatusPaymentRequired, stripe.ErrorTypeCard, code, "Your card was declined.")
+	return true
+}
+
+func (s *Server) findIntent(w http.ResponseWriter, r *http.Request) (*stripe.PaymentIntent, bool) {
+	id := chi.URLParam(r, "id")
+	pi, ok := s.intents[id]
+	if !ok {
+		writeError(w, http.StatusNotFound, stripe.ErrorTypeInvalidRequest, stripe.ErrorCodeResourceMissing, "No such payment_intent: '"+id+"'")
+	}
+	return pi, ok
+}
+
+func parseForm(w http.ResponseWriter, r *http.Request) bool {
+	if err := r.ParseForm(); err != nil {
+		writeError(w, http.StatusBadRequest, stripe.ErrorTypeInvalidRequest, "", err.Error())
+		return false
+	}
+	return true
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeError answers with an error shaped the way stripe-go decodes them
+func writeError(w http.ResponseWriter, status int, typ stripe.ErrorType, code stripe.ErrorCode, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]*stripe.Error{
+		"error": {Type: typ, Code: code, Msg: msg},
+	})
+}
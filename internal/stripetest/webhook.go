@@ -0,0 +1,32 @@
+package stripetest
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/stripe/stripe-go/v72"
+	"github.com/stripe/stripe-go/v72/webhook"
+	"time"
+)
+
+// Event is a webhook event of type typ about obj, such as a payment intent,
+// and the Stripe-Signature header stripe sends it with, signed with secret
+func Event(typ string, obj any, secret string) (payload []byte, signature string, err error) {
+	raw, err := json.Marshal(obj)
+	if err != nil {
+		return nil, "", err
+	}
+	payload, err = json.Marshal(map[string]any{
+		"id":          fmt.Sprintf("evt_test%d", time.Now().UnixNano()),
+		"object":      "event",
+		"type":        typ,
+		"api_version": stripe.APIVersion,
+		"created":     time.Now().Unix(),
+		"data":        map[string]json.RawMessage{"object": raw},
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	now := time.Now()
+	signature = fmt.Sprintf("t=%d,v1=%x", now.Unix(), webhook.ComputeSignature(now, payload, secret))
+	return payload, signature, nil
+}
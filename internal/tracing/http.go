@@ -0,0 +1,76 @@
+package tracing
+
+import (
+	"context"
+	"github.com/go-chi/chi/v5/middleware"
+	"go-stripe/internal/logging"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+	"mime"
+	"net/http"
+)
+
+// TraceparentField is the form field a browser posts the page's trace
+// context in, since a form can't set the traceparent header
+const TraceparentField = "traceparent"
+
+var tracer = otel.Tracer("go-stripe/internal/tracing")
+
+// HTTP starts a server span for every request, named for its chi route
+// once it has been routed, and continues the trace in the request's
+// traceparent header, or in the traceparent field of a posted form
+func HTTP(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), carrier(r))
+		ctx, span := tracer.Start(ctx, r.Method,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				semconv.HTTPRequestMethodKey.String(r.Method),
+				attribute.String("request_id", middleware.GetReqID(r.Context())),
+			),
+		)
+		defer span.End()
+
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r.WithContext(ctx))
+
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+		route := logging.Route(r)
+		span.SetName(r.Method + " " + route)
+		span.SetAttributes(semconv.HTTPRoute(route), semconv.HTTPResponseStatusCode(status))
+		if status >= 500 {
+			span.SetStatus(codes.Error, http.StatusText(status))
+		}
+	})
+}
+
+// carrier is where r's trace context is: its headers, unless it is a form
+// posted by a browser without them
+func carrier(r *http.Request) propagation.TextMapCarrier {
+	headers := propagation.HeaderCarrier(r.Header)
+	if r.Method != http.MethodPost || headers.Get(TraceparentField) != "" {
+		return headers
+	}
+	if ct, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); ct != "application/x-www-form-urlencoded" {
+		return headers
+	}
+	if tp := r.PostFormValue(TraceparentField); tp != "" {
+		return propagation.MapCarrier{TraceparentField: tp}
+	}
+	return headers
+}
+
+// Traceparent is the W3C traceparent for the span in ctx, for a page to
+// send back with the requests it makes, or "" when there is no trace
+func Traceparent(ctx context.Context) string {
+	c := propagation.MapCarrier{}
+	propagation.TraceContext{}.Inject(ctx, c)
+	return c[TraceparentField]
+}
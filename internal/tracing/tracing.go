@@ -0,0 +1,78 @@
+// Package tracing sets up OpenTelemetry tracing for the servers. Spans are
+// exported to stdout or an OTLP collector, and trace context travels
+// between the web front end, the browser and the api in W3C traceparent
+// headers
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"io"
+)
+
+// Config says where a program's spans go
+type Config struct {
+	// Service and Version identify the program in its spans
+	Service string
+	Version string
+	// Exporter is none, stdout or otlp. With none, trace context is still
+	// passed on but no spans are recorded
+	Exporter string
+	// Endpoint is the OTLP collector's url, such as http://localhost:4318.
+	// When empty the OTEL_EXPORTER_OTLP_ENDPOINT environment variable is
+	// used, and failing that https://localhost:4318
+	Endpoint string
+	// Stdout is where the stdout exporter writes, os.Stdout when nil
+	Stdout io.Writer
+}
+
+// Setup installs the global tracer provider and the W3C trace context
+// propagator. The returned func flushes the spans not yet exported and
+// stops the exporter
+func Setup(ctx context.Context, cfg Config) (shutdown func(context.Context) error, err error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	var exporter sdktrace.SpanExporter
+	switch cfg.Exporter {
+	case "", "none":
+		return func(context.Context) error { return nil }, nil
+	case "stdout":
+		var opts []stdouttrace.Option
+		if cfg.Stdout != nil {
+			opts = append(opts, stdouttrace.WithWriter(cfg.Stdout))
+		}
+		exporter, err = stdouttrace.New(opts...)
+	case "otlp":
+		var opts []otlptracehttp.Option
+		if cfg.Endpoint != "" {
+			opts = append(opts, otlptracehttp.WithEndpointURL(cfg.Endpoint))
+		}
+		exporter, err = otlptracehttp.New(ctx, opts...)
+	default:
+		return nil, fmt.Errorf("unknown trace exporter %q, want none, stdout or otlp", cfg.Exporter)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(semconv.SchemaURL,
+		semconv.ServiceName(cfg.Service),
+		semconv.ServiceVersion(cfg.Version),
+	))
+	if err != nil {
+		return nil, err
+	}
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(tp)
+	return tp.Shutdown, nil
+}
@@ -0,0 +1,168 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"github.com/go-chi/chi/v5"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+)
+
+const parent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+var (
+	recorder     = tracetest.NewSpanRecorder()
+	recorderOnce sync.Once
+)
+
+// record returns the func listing the spans ended from here on. The global
+// tracer provider can only be swapped once for the tracers already made,
+// so every test shares the one recorder
+func record(t *testing.T) func() []sdktrace.ReadOnlySpan {
+	t.Helper()
+	recorderOnce.Do(func() {
+		otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	})
+	if _, err := Setup(context.Background(), Config{Exporter: "none"}); err != nil {
+		t.Fatal(err)
+	}
+	n := len(recorder.Ended())
+	return func() []sdktrace.ReadOnlySpan { return recorder.Ended()[n:] }
+}
+
+func TestHTTP(t *testing.T) {
+	tests := []struct {
+		name   string
+		req    func() *http.Request
+		status int
+
+		wantName   string
+		wantParent bool
+	}{
+		{
+			name:     "new trace",
+			req:      func() *http.Request { return httptest.NewRequest(http.MethodGet, "/widget/7", nil) },
+			status:   http.StatusOK,
+			wantName: "GET /widget/{id}",
+		},
+		{
+			name: "traceparent header",
+			req: func() *http.Request {
+				r := httptest.NewRequest(http.MethodPost, "/api/payment-intent", strings.NewReader("{}"))
+				r.Header.Set("traceparent", parent)
+				return r
+			},
+			status:     http.StatusOK,
+			wantName:   "POST /api/payment-intent",
+			wantParent: true,
+		},
+		{
+			name: "traceparent form field",
+			req: func() *http.Request {
+				form := url.Values{TraceparentField: {parent}, "payment_intent": {"pi_1"}}
+				r := httptest.NewRequest(http.MethodPost, "/payment-succeeded", strings.NewReader(form.Encode()))
+				r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+				return r
+			},
+			status:     http.StatusSeeOther,
+			wantName:   "POST /payment-succeeded",
+			wantParent: true,
+		},
+		{
+			name:     "unmatched",
+			req:      func() *http.Request { return httptest.NewRequest(http.MethodGet, "/nope", nil) },
+			status:   http.StatusNotFound,
+			wantName: "GET unmatched",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ended := record(t)
+
+			var form string
+			handler := func(w http.ResponseWriter, r *http.Request) {
+				form = r.PostFormValue("payment_intent")
+				w.WriteHeader(tt.status)
+			}
+			mux := chi.NewRouter()
+			mux.Use(HTTP)
+			mux.Get("/widget/{id}", handler)
+			mux.Post("/api/payment-intent", handler)
+			mux.Post("/payment-succeeded", handler)
+			mux.NotFound(handler)
+			mux.ServeHTTP(httptest.NewRecorder(), tt.req())
+
+			spans := ended()
+			if len(spans) != 1 {
+				t.Fatalf("got %d spans, want 1", len(spans))
+			}
+			span := spans[0]
+			if span.Name() != tt.wantName {
+				t.Errorf("span named %q, want %q", span.Name(), tt.wantName)
+			}
+			gotParent := span.Parent().TraceID().String() == "4bf92f3577b34da6a3ce929d0e0e4736"
+			if gotParent != tt.wantParent {
+				t.Errorf("parent %v, want a parent %v", span.Parent().TraceID(), tt.wantParent)
+			}
+			var status int64
+			for _, a := range span.Attributes() {
+				if a.Key == attribute.Key("http.response.status_code") {
+					status = a.Value.AsInt64()
+				}
+			}
+			if status != int64(tt.status) {
+				t.Errorf("status attribute %d, want %d", status, tt.status)
+			}
+			if tt.name == "traceparent form field" && form != "pi_1" {
+				t.Errorf("handler read the form as %q, want it left readable", form)
+			}
+		})
+	}
+}
+
+func TestTraceparent(t *testing.T) {
+	record(t)
+	if tp := Traceparent(context.Background()); tp != "" {
+		t.Errorf("Traceparent without a span = %q, want none", tp)
+	}
+
+	ctx, span := tracer.Start(context.Background(), "page")
+	defer span.End()
+	tp := Traceparent(ctx)
+	sc := span.SpanContext()
+	if want := "00-" + sc.TraceID().String() + "-" + sc.SpanID().String() + "-01"; tp != want {
+		t.Errorf("Traceparent = %q, want %q", tp, want)
+	}
+}
+
+func TestSetup(t *testing.T) {
+	// bind this package's tracer to the recorder before swapping providers
+	record(t)
+
+	var buf bytes.Buffer
+	shutdown, err := Setup(context.Background(), Config{Service: "test", Exporter: "stdout", Stdout: &buf})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, span := otel.Tracer("test").Start(context.Background(), "work")
+	span.End()
+	if err := shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), `"Name":"work"`) {
+		t.Errorf("stdout exporter wrote %q, want the span", buf.String())
+	}
+
+	if _, err := Setup(context.Background(), Config{Exporter: "zipkin"}); err == nil {
+		t.Error("Setup accepted an unknown exporter")
+	}
+}
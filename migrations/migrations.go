@@ -0,0 +1,22 @@
+// Package migrations holds the database schema as versioned sql files, in a
+// directory for each dialect. Each migration is a pair of files named
+// VERSION_name.up.sql and VERSION_name.down.sql, where VERSION is the time it
+// was written as YYYYMMDDhhmmss. Statements in a file end with a semicolon at
+// the end of a line
+package migrations
+
+import (
+	"embed"
+	"go-stripe/internal/driver"
+	"io/fs"
+)
+
+// FS holds the migration files
+//
+//go:embed mysql/*.sql postgres/*.sql sqlite/*.sql
+var FS embed.FS
+
+// For returns the migrations for databases that speak d
+func For(d driver.Dialect) (fs.FS, error) {
+	return fs.Sub(FS, string(d))
+}
@@ -0,0 +1,12 @@
+CREATE TABLE widgets (
+    id INT UNSIGNED NOT NULL AUTO_INCREMENT,
+    name VARCHAR(255) NOT NULL DEFAULT '',
+    description TEXT NULL,
+    inventory_level INT NOT NULL DEFAULT 0,
+    price INT NOT NULL DEFAULT 0,
+    created_at TIMESTAMP NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    PRIMARY KEY (id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+INSERT INTO widgets (name, description, inventory_level, price) VALUES ('widget', 'A very nice widget', 10, 1000000);
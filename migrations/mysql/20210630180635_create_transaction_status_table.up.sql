@@ -0,0 +1,14 @@
+CREATE TABLE transaction_statuses (
+    id INT UNSIGNED NOT NULL AUTO_INCREMENT,
+    name VARCHAR(255) NOT NULL,
+    created_at TIMESTAMP NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    PRIMARY KEY (id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+INSERT INTO transaction_statuses (id, name) VALUES
+    (1, 'Pending'),
+    (2, 'Cleared'),
+    (3, 'Declined'),
+    (4, 'Refunded'),
+    (5, 'Partially refunded');
@@ -0,0 +1,14 @@
+CREATE TABLE transactions (
+    id INT UNSIGNED NOT NULL AUTO_INCREMENT,
+    amount INT NOT NULL,
+    currency VARCHAR(255) NOT NULL,
+    last_four VARCHAR(255) NOT NULL,
+    bank_return_code VARCHAR(255) NOT NULL,
+    transaction_status_id INT UNSIGNED NOT NULL,
+    created_at TIMESTAMP NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    PRIMARY KEY (id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+ALTER TABLE transactions ADD CONSTRAINT transactions_transaction_statuses_id_fk FOREIGN KEY (transaction_status_id) REFERENCES transaction_statuses (id)
+    ON DELETE CASCADE ON UPDATE CASCADE;
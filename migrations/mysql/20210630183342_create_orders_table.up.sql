@@ -0,0 +1,17 @@
+CREATE TABLE orders (
+    id INT UNSIGNED NOT NULL AUTO_INCREMENT,
+    widget_id INT UNSIGNED NOT NULL,
+    transaction_id INT UNSIGNED NOT NULL,
+    status_id INT UNSIGNED NOT NULL,
+    quantity INT NOT NULL,
+    amount INT NOT NULL,
+    created_at TIMESTAMP NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    PRIMARY KEY (id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+ALTER TABLE orders ADD CONSTRAINT orders_widgets_id_fk FOREIGN KEY (widget_id) REFERENCES widgets (id)
+    ON DELETE CASCADE ON UPDATE CASCADE;
+
+ALTER TABLE orders ADD CONSTRAINT orders_transactions_id_fk FOREIGN KEY (transaction_id) REFERENCES transactions (id)
+    ON DELETE CASCADE ON UPDATE CASCADE;
@@ -0,0 +1,3 @@
+ALTER TABLE orders DROP FOREIGN KEY orders_statuses_id_fk;
+
+DROP TABLE IF EXISTS statuses;
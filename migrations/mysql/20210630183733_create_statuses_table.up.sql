@@ -0,0 +1,15 @@
+CREATE TABLE statuses (
+    id INT UNSIGNED NOT NULL AUTO_INCREMENT,
+    name VARCHAR(255) NOT NULL,
+    created_at TIMESTAMP NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    PRIMARY KEY (id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+INSERT INTO statuses (id, name) VALUES
+    (1, 'Cleared'),
+    (2, 'Refunded'),
+    (3, 'Cancelled');
+
+ALTER TABLE orders ADD CONSTRAINT orders_statuses_id_fk FOREIGN KEY (status_id) REFERENCES statuses (id)
+    ON DELETE CASCADE ON UPDATE CASCADE;
@@ -0,0 +1 @@
+ALTER TABLE widgets ADD COLUMN image VARCHAR(255) NOT NULL DEFAULT '';
@@ -0,0 +1,3 @@
+ALTER TABLE transactions
+    DROP COLUMN expiry_month,
+    DROP COLUMN expiry_year;
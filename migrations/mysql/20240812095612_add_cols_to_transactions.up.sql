@@ -0,0 +1,3 @@
+ALTER TABLE transactions
+    ADD COLUMN expiry_month INT NOT NULL DEFAULT 0,
+    ADD COLUMN expiry_year INT NOT NULL DEFAULT 0;
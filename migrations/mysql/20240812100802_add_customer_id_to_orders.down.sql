@@ -0,0 +1,3 @@
+ALTER TABLE orders DROP FOREIGN KEY orders_customers_id_fk;
+
+ALTER TABLE orders DROP COLUMN customer_id;
@@ -0,0 +1,4 @@
+ALTER TABLE orders ADD COLUMN customer_id INT UNSIGNED NOT NULL;
+
+ALTER TABLE orders ADD CONSTRAINT orders_customers_id_fk FOREIGN KEY (customer_id) REFERENCES customers (id)
+    ON DELETE CASCADE ON UPDATE CASCADE;
@@ -0,0 +1,3 @@
+ALTER TABLE transactions
+    DROP COLUMN payment_intent,
+    DROP COLUMN payment_method;
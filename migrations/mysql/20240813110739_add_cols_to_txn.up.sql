@@ -0,0 +1,3 @@
+ALTER TABLE transactions
+    ADD COLUMN payment_intent VARCHAR(255) NOT NULL DEFAULT '',
+    ADD COLUMN payment_method VARCHAR(255) NOT NULL DEFAULT '';
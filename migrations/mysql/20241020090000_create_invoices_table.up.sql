@@ -0,0 +1,12 @@
+CREATE TABLE invoices (
+    id INT UNSIGNED NOT NULL AUTO_INCREMENT,
+    order_id INT UNSIGNED NOT NULL,
+    invoice_number VARCHAR(255) NOT NULL DEFAULT '',
+    created_at TIMESTAMP NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    PRIMARY KEY (id),
+    UNIQUE KEY invoices_order_id_idx (order_id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+ALTER TABLE invoices ADD CONSTRAINT invoices_orders_id_fk FOREIGN KEY (order_id) REFERENCES orders (id)
+    ON DELETE CASCADE ON UPDATE CASCADE;
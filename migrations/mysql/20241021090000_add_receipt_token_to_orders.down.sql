@@ -0,0 +1,3 @@
+DROP INDEX orders_receipt_token_idx ON orders;
+
+ALTER TABLE orders DROP COLUMN receipt_token;
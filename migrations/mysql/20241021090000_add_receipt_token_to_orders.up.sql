@@ -0,0 +1,3 @@
+ALTER TABLE orders ADD COLUMN receipt_token VARCHAR(64) NULL;
+
+CREATE UNIQUE INDEX orders_receipt_token_idx ON orders (receipt_token);
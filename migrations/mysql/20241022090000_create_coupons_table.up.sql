@@ -0,0 +1,13 @@
+CREATE TABLE coupons (
+    id INT UNSIGNED NOT NULL AUTO_INCREMENT,
+    code VARCHAR(64) NOT NULL,
+    kind VARCHAR(16) NOT NULL DEFAULT 'percent',
+    value INT NOT NULL DEFAULT 0,
+    expires_at TIMESTAMP NULL,
+    max_uses INT NOT NULL DEFAULT 0,
+    times_used INT NOT NULL DEFAULT 0,
+    created_at TIMESTAMP NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    PRIMARY KEY (id),
+    UNIQUE KEY coupons_code_idx (code)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
@@ -0,0 +1,5 @@
+ALTER TABLE orders
+    DROP COLUMN coupon_code,
+    DROP COLUMN tax,
+    DROP COLUMN discount,
+    DROP COLUMN subtotal;
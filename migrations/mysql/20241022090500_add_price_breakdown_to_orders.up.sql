@@ -0,0 +1,7 @@
+ALTER TABLE orders
+    ADD COLUMN subtotal INT NOT NULL DEFAULT 0,
+    ADD COLUMN discount INT NOT NULL DEFAULT 0,
+    ADD COLUMN tax INT NOT NULL DEFAULT 0,
+    ADD COLUMN coupon_code VARCHAR(64) NULL;
+
+UPDATE orders SET subtotal = amount;
@@ -0,0 +1,6 @@
+-- deleting a status cascades to its transactions and their orders, so move
+-- held payments back to pending and voided ones to refunded first
+UPDATE transactions SET transaction_status_id = 1 WHERE transaction_status_id = 6;
+UPDATE transactions SET transaction_status_id = 4 WHERE transaction_status_id = 7;
+
+DELETE FROM transaction_statuses WHERE id IN (6, 7);
@@ -0,0 +1 @@
+INSERT INTO transaction_statuses (id, name) VALUES (6, 'Authorized'), (7, 'Voided');
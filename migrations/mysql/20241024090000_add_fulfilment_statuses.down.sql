@@ -0,0 +1,9 @@
+-- deleting a status cascades to its orders, so move packed, shipped and
+-- delivered orders back to cleared first
+UPDATE orders SET status_id = 1 WHERE status_id IN (4, 5, 6);
+
+ALTER TABLE orders
+    DROP COLUMN carrier,
+    DROP COLUMN tracking_number;
+
+DELETE FROM statuses WHERE id IN (4, 5, 6);
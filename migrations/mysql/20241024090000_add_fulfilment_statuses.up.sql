@@ -0,0 +1,5 @@
+INSERT INTO statuses (id, name) VALUES (4, 'Packed'), (5, 'Shipped'), (6, 'Delivered');
+
+ALTER TABLE orders
+    ADD COLUMN tracking_number VARCHAR(255) NULL,
+    ADD COLUMN carrier VARCHAR(64) NULL;
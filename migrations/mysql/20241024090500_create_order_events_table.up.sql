@@ -0,0 +1,19 @@
+CREATE TABLE order_events (
+    id INT UNSIGNED NOT NULL AUTO_INCREMENT,
+    order_id INT UNSIGNED NOT NULL,
+    from_status_id INT UNSIGNED NOT NULL,
+    to_status_id INT UNSIGNED NOT NULL,
+    tracking_number VARCHAR(255) NOT NULL DEFAULT '',
+    carrier VARCHAR(64) NOT NULL DEFAULT '',
+    note TEXT NULL,
+    user_id INT UNSIGNED NULL,
+    created_at TIMESTAMP NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    PRIMARY KEY (id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+ALTER TABLE order_events ADD CONSTRAINT order_events_orders_id_fk FOREIGN KEY (order_id) REFERENCES orders (id)
+    ON DELETE CASCADE ON UPDATE CASCADE;
+
+ALTER TABLE order_events ADD CONSTRAINT order_events_users_id_fk FOREIGN KEY (user_id) REFERENCES users (id)
+    ON DELETE SET NULL ON UPDATE CASCADE;
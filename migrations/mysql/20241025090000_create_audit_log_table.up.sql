@@ -0,0 +1,21 @@
+CREATE TABLE audit_log (
+    id INT UNSIGNED NOT NULL AUTO_INCREMENT,
+    action VARCHAR(64) NOT NULL,
+    user_id INT UNSIGNED NULL,
+    actor_email VARCHAR(255) NOT NULL DEFAULT '',
+    ip_address VARCHAR(64) NOT NULL DEFAULT '',
+    request_id VARCHAR(128) NOT NULL DEFAULT '',
+    entity_type VARCHAR(64) NOT NULL DEFAULT '',
+    entity_id INT NOT NULL DEFAULT 0,
+    before_json TEXT NULL,
+    after_json TEXT NULL,
+    created_at TIMESTAMP NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (id),
+    KEY audit_log_action_idx (action),
+    KEY audit_log_entity_type_entity_id_idx (entity_type, entity_id),
+    KEY audit_log_created_at_idx (created_at)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TRIGGER audit_log_no_update BEFORE UPDATE ON audit_log FOR EACH ROW SIGNAL SQLSTATE '45000' SET MESSAGE_TEXT = 'audit_log is append only';
+
+CREATE TRIGGER audit_log_no_delete BEFORE DELETE ON audit_log FOR EACH ROW SIGNAL SQLSTATE '45000' SET MESSAGE_TEXT = 'audit_log is append only';
@@ -0,0 +1,13 @@
+DROP INDEX customers_email_idx ON customers;
+
+ALTER TABLE transactions DROP FOREIGN KEY transactions_users_id_fk;
+
+ALTER TABLE transactions DROP FOREIGN KEY transactions_customers_id_fk;
+
+DROP INDEX transactions_source_idx ON transactions;
+
+ALTER TABLE transactions
+    DROP COLUMN memo,
+    DROP COLUMN user_id,
+    DROP COLUMN customer_id,
+    DROP COLUMN source;
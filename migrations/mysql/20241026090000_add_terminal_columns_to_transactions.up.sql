@@ -0,0 +1,15 @@
+ALTER TABLE transactions
+    ADD COLUMN source VARCHAR(20) NOT NULL DEFAULT 'checkout',
+    ADD COLUMN customer_id INT UNSIGNED NULL,
+    ADD COLUMN user_id INT UNSIGNED NULL,
+    ADD COLUMN memo VARCHAR(255) NOT NULL DEFAULT '';
+
+CREATE INDEX transactions_source_idx ON transactions (source);
+
+ALTER TABLE transactions ADD CONSTRAINT transactions_customers_id_fk FOREIGN KEY (customer_id) REFERENCES customers (id)
+    ON DELETE SET NULL ON UPDATE CASCADE;
+
+ALTER TABLE transactions ADD CONSTRAINT transactions_users_id_fk FOREIGN KEY (user_id) REFERENCES users (id)
+    ON DELETE SET NULL ON UPDATE CASCADE;
+
+CREATE INDEX customers_email_idx ON customers (email);
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS dispute_files;
+
+DROP TABLE IF EXISTS disputes;
@@ -0,0 +1,42 @@
+CREATE TABLE disputes (
+    id INT UNSIGNED NOT NULL AUTO_INCREMENT,
+    transaction_id INT UNSIGNED NULL,
+    stripe_dispute_id VARCHAR(64) NOT NULL,
+    charge_id VARCHAR(64) NOT NULL DEFAULT '',
+    payment_intent VARCHAR(255) NOT NULL DEFAULT '',
+    amount INT NOT NULL,
+    currency VARCHAR(8) NOT NULL,
+    reason VARCHAR(64) NOT NULL DEFAULT '',
+    status VARCHAR(64) NOT NULL,
+    evidence_due_by TIMESTAMP NULL,
+    has_evidence BOOL NOT NULL DEFAULT FALSE,
+    submission_count INT NOT NULL DEFAULT 0,
+    evidence TEXT NULL,
+    evidence_submitted_at TIMESTAMP NULL,
+    created_at TIMESTAMP NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    PRIMARY KEY (id),
+    UNIQUE KEY disputes_stripe_dispute_id_idx (stripe_dispute_id),
+    KEY disputes_status_evidence_due_by_idx (status, evidence_due_by)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+ALTER TABLE disputes ADD CONSTRAINT disputes_transactions_id_fk FOREIGN KEY (transaction_id) REFERENCES transactions (id)
+    ON DELETE SET NULL ON UPDATE CASCADE;
+
+CREATE TABLE dispute_files (
+    id INT UNSIGNED NOT NULL AUTO_INCREMENT,
+    dispute_id INT UNSIGNED NOT NULL,
+    stripe_file_id VARCHAR(64) NOT NULL,
+    field VARCHAR(64) NOT NULL,
+    filename VARCHAR(255) NOT NULL,
+    user_id INT UNSIGNED NULL,
+    created_at TIMESTAMP NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    PRIMARY KEY (id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+ALTER TABLE dispute_files ADD CONSTRAINT dispute_files_disputes_id_fk FOREIGN KEY (dispute_id) REFERENCES disputes (id)
+    ON DELETE CASCADE ON UPDATE CASCADE;
+
+ALTER TABLE dispute_files ADD CONSTRAINT dispute_files_users_id_fk FOREIGN KEY (user_id) REFERENCES users (id)
+    ON DELETE SET NULL ON UPDATE CASCADE;
@@ -0,0 +1,10 @@
+ALTER TABLE transactions DROP FOREIGN KEY transactions_payouts_id_fk;
+
+ALTER TABLE transactions
+    DROP COLUMN payout_id,
+    DROP COLUMN net,
+    DROP COLUMN fee;
+
+DROP TABLE IF EXISTS payout_lines;
+
+DROP TABLE IF EXISTS payouts;
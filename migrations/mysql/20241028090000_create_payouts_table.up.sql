@@ -0,0 +1,45 @@
+CREATE TABLE payouts (
+    id INT UNSIGNED NOT NULL AUTO_INCREMENT,
+    stripe_payout_id VARCHAR(64) NOT NULL,
+    amount INT NOT NULL,
+    currency VARCHAR(8) NOT NULL,
+    status VARCHAR(32) NOT NULL,
+    arrival_date TIMESTAMP NOT NULL,
+    created_at TIMESTAMP NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    PRIMARY KEY (id),
+    UNIQUE KEY payouts_stripe_payout_id_idx (stripe_payout_id),
+    KEY payouts_arrival_date_idx (arrival_date)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE payout_lines (
+    id INT UNSIGNED NOT NULL AUTO_INCREMENT,
+    payout_id INT UNSIGNED NOT NULL,
+    balance_transaction_id VARCHAR(64) NOT NULL,
+    category VARCHAR(32) NOT NULL,
+    stripe_type VARCHAR(64) NOT NULL,
+    source_id VARCHAR(64) NOT NULL DEFAULT '',
+    payment_intent VARCHAR(255) NOT NULL DEFAULT '',
+    transaction_id INT UNSIGNED NULL,
+    amount INT NOT NULL,
+    fee INT NOT NULL,
+    net INT NOT NULL,
+    description VARCHAR(255) NOT NULL DEFAULT '',
+    created_at TIMESTAMP NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (id),
+    UNIQUE KEY payout_lines_balance_transaction_id_idx (balance_transaction_id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+ALTER TABLE payout_lines ADD CONSTRAINT payout_lines_payouts_id_fk FOREIGN KEY (payout_id) REFERENCES payouts (id)
+    ON DELETE CASCADE ON UPDATE CASCADE;
+
+ALTER TABLE payout_lines ADD CONSTRAINT payout_lines_transactions_id_fk FOREIGN KEY (transaction_id) REFERENCES transactions (id)
+    ON DELETE SET NULL ON UPDATE CASCADE;
+
+ALTER TABLE transactions
+    ADD COLUMN fee INT NOT NULL DEFAULT 0,
+    ADD COLUMN net INT NOT NULL DEFAULT 0,
+    ADD COLUMN payout_id INT UNSIGNED NULL;
+
+ALTER TABLE transactions ADD CONSTRAINT transactions_payouts_id_fk FOREIGN KEY (payout_id) REFERENCES payouts (id)
+    ON DELETE SET NULL ON UPDATE CASCADE;
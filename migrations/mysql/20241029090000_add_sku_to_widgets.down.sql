@@ -0,0 +1,3 @@
+DROP INDEX widgets_sku_idx ON widgets;
+
+ALTER TABLE widgets DROP COLUMN sku;
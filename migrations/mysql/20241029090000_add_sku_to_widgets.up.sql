@@ -0,0 +1,3 @@
+ALTER TABLE widgets ADD COLUMN sku VARCHAR(64) NULL;
+
+CREATE UNIQUE INDEX widgets_sku_idx ON widgets (sku);
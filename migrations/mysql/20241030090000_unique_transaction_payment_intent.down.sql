@@ -0,0 +1,4 @@
+-- duplicates given up on the way up aren't restored
+ALTER TABLE transactions
+    DROP INDEX transactions_payment_intent_idx,
+    DROP COLUMN payment_intent_key;
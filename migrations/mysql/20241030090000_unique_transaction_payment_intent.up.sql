@@ -0,0 +1,20 @@
+-- a payment intent is recorded once. Transactions from before payment
+-- intents were kept have none, and are left out of the index. A payment
+-- recorded twice before this keeps its payment intent on the first
+-- transaction, and the rest give it up and say so in their memo
+UPDATE transactions t
+    INNER JOIN (
+        SELECT payment_intent, MIN(id) AS first_id
+        FROM transactions
+        WHERE payment_intent <> ''
+        GROUP BY payment_intent
+        HAVING COUNT(*) > 1
+    ) d ON (t.payment_intent = d.payment_intent AND t.id <> d.first_id)
+SET t.memo = LEFT(TRIM(CONCAT(t.memo, ' (duplicate of ', d.payment_intent, ')')), 255),
+    t.payment_intent = '';
+
+-- one statement, so mysql, which can't roll schema changes back, never
+-- leaves the column without its index
+ALTER TABLE transactions
+    ADD COLUMN payment_intent_key VARCHAR(255) GENERATED ALWAYS AS (NULLIF(payment_intent, '')) STORED,
+    ADD UNIQUE INDEX transactions_payment_intent_idx (payment_intent_key);
@@ -0,0 +1,5 @@
+-- deleting a status cascades to its orders, so cancel the orders that were
+-- never paid for first
+UPDATE orders SET status_id = 3 WHERE status_id = 7;
+
+DELETE FROM statuses WHERE id = 7;
@@ -0,0 +1 @@
+DROP TABLE coupon_reservations;
@@ -0,0 +1,15 @@
+-- a use of a coupon held for a checkout until its payment goes through, is
+-- given up or the hold expires
+CREATE TABLE coupon_reservations (
+    id INT UNSIGNED NOT NULL AUTO_INCREMENT,
+    coupon_id INT UNSIGNED NOT NULL,
+    payment_intent VARCHAR(255) NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    redeemed BOOL NOT NULL DEFAULT FALSE,
+    created_at TIMESTAMP NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    PRIMARY KEY (id),
+    UNIQUE KEY coupon_reservations_payment_intent_idx (payment_intent),
+    KEY coupon_reservations_coupon_id_idx (coupon_id, redeemed, expires_at),
+    CONSTRAINT coupon_reservations_coupons_id_fk FOREIGN KEY (coupon_id) REFERENCES coupons (id) ON DELETE CASCADE ON UPDATE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
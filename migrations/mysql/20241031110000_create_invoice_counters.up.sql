@@ -0,0 +1,12 @@
+-- the last invoice number issued each year. Numbering carries on from the
+-- invoices already issued, which were numbered by their id
+CREATE TABLE invoice_counters (
+    year INT NOT NULL,
+    last_number INT NOT NULL DEFAULT 0,
+    PRIMARY KEY (year)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+INSERT INTO invoice_counters (year, last_number)
+    SELECT CAST(SUBSTRING(invoice_number, 5, 4) AS UNSIGNED), MAX(id) FROM invoices
+    WHERE invoice_number <> ''
+    GROUP BY CAST(SUBSTRING(invoice_number, 5, 4) AS UNSIGNED);
@@ -0,0 +1,17 @@
+DROP TABLE payout_lines;
+DROP TABLE dispute_files;
+DROP TABLE disputes;
+DROP TABLE audit_log;
+DROP FUNCTION audit_log_append_only;
+DROP TABLE order_events;
+DROP TABLE tokens;
+DROP TABLE coupons;
+DROP TABLE invoices;
+DROP TABLE orders;
+DROP TABLE transactions;
+DROP TABLE payouts;
+DROP TABLE customers;
+DROP TABLE users;
+DROP TABLE widgets;
+DROP TABLE transaction_statuses;
+DROP TABLE statuses;
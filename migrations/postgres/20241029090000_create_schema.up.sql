@@ -0,0 +1,261 @@
+-- postgres starts from the schema the mysql migrations had built up to by
+-- this version, later changes are added as migrations of their own
+
+CREATE TABLE statuses (
+    id INTEGER GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+    name VARCHAR(255) NOT NULL,
+    created_at TIMESTAMPTZ NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMPTZ NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+INSERT INTO statuses (id, name) VALUES
+    (1, 'Cleared'),
+    (2, 'Refunded'),
+    (3, 'Cancelled'),
+    (4, 'Packed'),
+    (5, 'Shipped'),
+    (6, 'Delivered');
+
+CREATE TABLE transaction_statuses (
+    id INTEGER GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+    name VARCHAR(255) NOT NULL,
+    created_at TIMESTAMPTZ NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMPTZ NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+INSERT INTO transaction_statuses (id, name) VALUES
+    (1, 'Pending'),
+    (2, 'Cleared'),
+    (3, 'Declined'),
+    (4, 'Refunded'),
+    (5, 'Partially refunded'),
+    (6, 'Authorized'),
+    (7, 'Voided');
+
+CREATE TABLE widgets (
+    id INTEGER GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+    sku VARCHAR(64) NULL,
+    name VARCHAR(255) NOT NULL DEFAULT '',
+    description TEXT NULL,
+    inventory_level INTEGER NOT NULL DEFAULT 0,
+    price INTEGER NOT NULL DEFAULT 0,
+    image VARCHAR(255) NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMPTZ NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE UNIQUE INDEX widgets_sku_idx ON widgets (sku);
+
+INSERT INTO widgets (name, description, inventory_level, price) VALUES ('widget', 'A very nice widget', 10, 1000000);
+
+CREATE TABLE users (
+    id INTEGER GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+    first_name VARCHAR(255) NOT NULL,
+    last_name VARCHAR(255) NOT NULL,
+    email VARCHAR(255) NOT NULL,
+    password VARCHAR(60) NOT NULL,
+    created_at TIMESTAMPTZ NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMPTZ NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+INSERT INTO users (first_name, last_name, email, password) VALUES
+    ('Admin', 'User', 'admin@example.com', '$2a$12$VR1wDmweaF3ZTVgEHiJrNOSi8VcS4j0eamr96A/7iOe8vlum3O3/q');
+
+CREATE TABLE customers (
+    id INTEGER GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+    first_name VARCHAR(255) NOT NULL,
+    last_name VARCHAR(255) NOT NULL,
+    email VARCHAR(255) NOT NULL,
+    created_at TIMESTAMPTZ NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMPTZ NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX customers_email_idx ON customers (email);
+
+CREATE TABLE payouts (
+    id INTEGER GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+    stripe_payout_id VARCHAR(64) NOT NULL,
+    amount INTEGER NOT NULL,
+    currency VARCHAR(8) NOT NULL,
+    status VARCHAR(32) NOT NULL,
+    arrival_date TIMESTAMPTZ NOT NULL,
+    created_at TIMESTAMPTZ NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMPTZ NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE UNIQUE INDEX payouts_stripe_payout_id_idx ON payouts (stripe_payout_id);
+CREATE INDEX payouts_arrival_date_idx ON payouts (arrival_date);
+
+CREATE TABLE transactions (
+    id INTEGER GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+    amount INTEGER NOT NULL,
+    currency VARCHAR(255) NOT NULL,
+    last_four VARCHAR(255) NOT NULL,
+    bank_return_code VARCHAR(255) NOT NULL,
+    transaction_status_id INTEGER NOT NULL REFERENCES transaction_statuses (id) ON DELETE CASCADE ON UPDATE CASCADE,
+    expiry_month INTEGER NOT NULL DEFAULT 0,
+    expiry_year INTEGER NOT NULL DEFAULT 0,
+    payment_intent VARCHAR(255) NOT NULL DEFAULT '',
+    payment_method VARCHAR(255) NOT NULL DEFAULT '',
+    source VARCHAR(20) NOT NULL DEFAULT 'checkout',
+    customer_id INTEGER NULL REFERENCES customers (id) ON DELETE SET NULL ON UPDATE CASCADE,
+    user_id INTEGER NULL REFERENCES users (id) ON DELETE SET NULL ON UPDATE CASCADE,
+    memo VARCHAR(255) NOT NULL DEFAULT '',
+    fee INTEGER NOT NULL DEFAULT 0,
+    net INTEGER NOT NULL DEFAULT 0,
+    payout_id INTEGER NULL REFERENCES payouts (id) ON DELETE SET NULL ON UPDATE CASCADE,
+    created_at TIMESTAMPTZ NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMPTZ NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX transactions_source_idx ON transactions (source);
+
+CREATE TABLE orders (
+    id INTEGER GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+    widget_id INTEGER NOT NULL REFERENCES widgets (id) ON DELETE CASCADE ON UPDATE CASCADE,
+    transaction_id INTEGER NOT NULL REFERENCES transactions (id) ON DELETE CASCADE ON UPDATE CASCADE,
+    status_id INTEGER NOT NULL REFERENCES statuses (id) ON DELETE CASCADE ON UPDATE CASCADE,
+    customer_id INTEGER NOT NULL REFERENCES customers (id) ON DELETE CASCADE ON UPDATE CASCADE,
+    quantity INTEGER NOT NULL,
+    amount INTEGER NOT NULL,
+    subtotal INTEGER NOT NULL DEFAULT 0,
+    discount INTEGER NOT NULL DEFAULT 0,
+    tax INTEGER NOT NULL DEFAULT 0,
+    coupon_code VARCHAR(64) NULL,
+    receipt_token VARCHAR(64) NULL,
+    tracking_number VARCHAR(255) NULL,
+    carrier VARCHAR(64) NULL,
+    created_at TIMESTAMPTZ NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMPTZ NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE UNIQUE INDEX orders_receipt_token_idx ON orders (receipt_token);
+CREATE INDEX orders_transaction_id_idx ON orders (transaction_id);
+
+CREATE TABLE invoices (
+    id INTEGER GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+    order_id INTEGER NOT NULL REFERENCES orders (id) ON DELETE CASCADE ON UPDATE CASCADE,
+    invoice_number VARCHAR(255) NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMPTZ NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE UNIQUE INDEX invoices_order_id_idx ON invoices (order_id);
+
+CREATE TABLE coupons (
+    id INTEGER GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+    code VARCHAR(64) NOT NULL,
+    kind VARCHAR(16) NOT NULL DEFAULT 'percent',
+    value INTEGER NOT NULL DEFAULT 0,
+    expires_at TIMESTAMPTZ NULL,
+    max_uses INTEGER NOT NULL DEFAULT 0,
+    times_used INTEGER NOT NULL DEFAULT 0,
+    created_at TIMESTAMPTZ NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMPTZ NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE UNIQUE INDEX coupons_code_idx ON coupons (upper(code));
+
+CREATE TABLE tokens (
+    id INTEGER GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE ON UPDATE CASCADE,
+    name VARCHAR(255) NOT NULL,
+    email VARCHAR(255) NOT NULL,
+    token_hash BYTEA NOT NULL,
+    expiry TIMESTAMPTZ NOT NULL,
+    created_at TIMESTAMPTZ NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMPTZ NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+
+CREATE TABLE order_events (
+    id INTEGER GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+    order_id INTEGER NOT NULL REFERENCES orders (id) ON DELETE CASCADE ON UPDATE CASCADE,
+    from_status_id INTEGER NOT NULL,
+    to_status_id INTEGER NOT NULL,
+    tracking_number VARCHAR(255) NOT NULL DEFAULT '',
+    carrier VARCHAR(64) NOT NULL DEFAULT '',
+    note TEXT NULL,
+    user_id INTEGER NULL REFERENCES users (id) ON DELETE SET NULL ON UPDATE CASCADE,
+    created_at TIMESTAMPTZ NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMPTZ NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX order_events_order_id_idx ON order_events (order_id);
+
+CREATE TABLE audit_log (
+    id INTEGER GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+    action VARCHAR(64) NOT NULL,
+    user_id INTEGER NULL,
+    actor_email VARCHAR(255) NOT NULL DEFAULT '',
+    ip_address VARCHAR(64) NOT NULL DEFAULT '',
+    request_id VARCHAR(128) NOT NULL DEFAULT '',
+    entity_type VARCHAR(64) NOT NULL DEFAULT '',
+    entity_id INTEGER NOT NULL DEFAULT 0,
+    before_json TEXT NULL,
+    after_json TEXT NULL,
+    created_at TIMESTAMPTZ NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX audit_log_action_idx ON audit_log (action);
+CREATE INDEX audit_log_entity_type_entity_id_idx ON audit_log (entity_type, entity_id);
+CREATE INDEX audit_log_created_at_idx ON audit_log (created_at);
+
+CREATE FUNCTION audit_log_append_only() RETURNS trigger AS $$
+    BEGIN RAISE EXCEPTION 'audit_log is append only'; END
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER audit_log_no_update BEFORE UPDATE ON audit_log FOR EACH ROW EXECUTE FUNCTION audit_log_append_only();
+
+CREATE TRIGGER audit_log_no_delete BEFORE DELETE ON audit_log FOR EACH ROW EXECUTE FUNCTION audit_log_append_only();
+
+CREATE TABLE disputes (
+    id INTEGER GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+    transaction_id INTEGER NULL REFERENCES transactions (id) ON DELETE SET NULL ON UPDATE CASCADE,
+    stripe_dispute_id VARCHAR(64) NOT NULL,
+    charge_id VARCHAR(64) NOT NULL DEFAULT '',
+    payment_intent VARCHAR(255) NOT NULL DEFAULT '',
+    amount INTEGER NOT NULL,
+    currency VARCHAR(8) NOT NULL,
+    reason VARCHAR(64) NOT NULL DEFAULT '',
+    status VARCHAR(64) NOT NULL,
+    evidence_due_by TIMESTAMPTZ NULL,
+    has_evidence BOOLEAN NOT NULL DEFAULT FALSE,
+    submission_count INTEGER NOT NULL DEFAULT 0,
+    evidence TEXT NULL,
+    evidence_submitted_at TIMESTAMPTZ NULL,
+    created_at TIMESTAMPTZ NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMPTZ NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE UNIQUE INDEX disputes_stripe_dispute_id_idx ON disputes (stripe_dispute_id);
+CREATE INDEX disputes_status_evidence_due_by_idx ON disputes (status, evidence_due_by);
+
+CREATE TABLE dispute_files (
+    id INTEGER GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+    dispute_id INTEGER NOT NULL REFERENCES disputes (id) ON DELETE CASCADE ON UPDATE CASCADE,
+    stripe_file_id VARCHAR(64) NOT NULL,
+    field VARCHAR(64) NOT NULL,
+    filename VARCHAR(255) NOT NULL,
+    user_id INTEGER NULL REFERENCES users (id) ON DELETE SET NULL ON UPDATE CASCADE,
+    created_at TIMESTAMPTZ NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMPTZ NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE payout_lines (
+    id INTEGER GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+    payout_id INTEGER NOT NULL REFERENCES payouts (id) ON DELETE CASCADE ON UPDATE CASCADE,
+    balance_transaction_id VARCHAR(64) NOT NULL,
+    category VARCHAR(32) NOT NULL,
+    stripe_type VARCHAR(64) NOT NULL,
+    source_id VARCHAR(64) NOT NULL DEFAULT '',
+    payment_intent VARCHAR(255) NOT NULL DEFAULT '',
+    transaction_id INTEGER NULL REFERENCES transactions (id) ON DELETE SET NULL ON UPDATE CASCADE,
+    amount INTEGER NOT NULL,
+    fee INTEGER NOT NULL,
+    net INTEGER NOT NULL,
+    description VARCHAR(255) NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE UNIQUE INDEX payout_lines_balance_transaction_id_idx ON payout_lines (balance_transaction_id);
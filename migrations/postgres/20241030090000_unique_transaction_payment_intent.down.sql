@@ -0,0 +1 @@
+DROP INDEX transactions_payment_intent_idx;
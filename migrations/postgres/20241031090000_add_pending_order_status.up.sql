@@ -0,0 +1,3 @@
+-- orders waiting for the customer to finish paying, such as authenticating
+-- with their bank, aren't paid until the payment clears
+INSERT INTO statuses (id, name) VALUES (7, 'Pending');
@@ -0,0 +1,11 @@
+-- the last invoice number issued each year. Numbering carries on from the
+-- invoices already issued, which were numbered by their id
+CREATE TABLE invoice_counters (
+    year INTEGER NOT NULL PRIMARY KEY,
+    last_number INTEGER NOT NULL DEFAULT 0
+);
+
+INSERT INTO invoice_counters (year, last_number)
+    SELECT CAST(SUBSTR(invoice_number, 5, 4) AS INTEGER), MAX(id) FROM invoices
+    WHERE invoice_number <> ''
+    GROUP BY CAST(SUBSTR(invoice_number, 5, 4) AS INTEGER);
@@ -0,0 +1,11 @@
+-- a payment intent is recorded once. Transactions from before payment
+-- intents were kept have none, and are left out of the index. A payment
+-- recorded twice before this keeps its payment intent on the first
+-- transaction, and the rest give it up and say so in their memo
+UPDATE transactions
+SET memo = substr(trim(memo || ' (duplicate of ' || payment_intent || ')'), 1, 255),
+    payment_intent = ''
+WHERE payment_intent <> ''
+    AND EXISTS (SELECT 1 FROM transactions f WHERE f.payment_intent = transactions.payment_intent AND f.id < transactions.id);
+
+CREATE UNIQUE INDEX transactions_payment_intent_idx ON transactions (payment_intent) WHERE payment_intent <> '';
@@ -0,0 +1,15 @@
+-- a use of a coupon held for a checkout until its payment goes through, is
+-- given up or the hold expires
+CREATE TABLE coupon_reservations (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    coupon_id INTEGER NOT NULL REFERENCES coupons (id) ON DELETE CASCADE ON UPDATE CASCADE,
+    payment_intent VARCHAR(255) NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    redeemed BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at TIMESTAMP NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE UNIQUE INDEX coupon_reservations_payment_intent_idx ON coupon_reservations (payment_intent);
+
+CREATE INDEX coupon_reservations_coupon_id_idx ON coupon_reservations (coupon_id, redeemed, expires_at);